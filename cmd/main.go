@@ -1,20 +1,361 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	schema "supersolik/greed/migrations"
 	"supersolik/greed/pkg/greed"
 	"supersolik/greed/pkg/server"
+	"time"
 
 	"github.com/labstack/gommon/log"
 )
 
+const usage = `usage:
+  greed [serve]                                  start the web app (applies pending migrations, posts recurring transactions)
+  greed migrate [up] [-to N] [-allow-destructive] apply pending migrations
+  greed migrate down [-to N] [-allow-destructive] revert migrations, one step by default
+  greed migrate status                           list migrations and their state
+  greed migrate baseline N                       mark migrations up to N as applied without running them
+  greed export [-user NAME] [-format json|zip] [-o FILE]
+                                                 dump accounts, categories, transactions, budgets and recurring transactions of the user
+  greed import [-user NAME] [-replace] FILE      restore an export (json or zip), -replace drops the current data
+  greed verify [-user NAME] [-repair] [-keep-stored]
+                                                 check that stored account balances match the opening balance plus the transactions,
+                                                 -repair sets them from the transactions, -keep-stored moves the opening balance instead
+`
+
 func main() {
+	args := os.Args[1:]
+
+	if len(args) == 0 || args[0] == "serve" {
+		serve()
+		return
+	}
+
+	switch args[0] {
+	case "migrate":
+		if err := migrate(args[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+	case "export":
+		if err := export(args[1:]); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+	case "import":
+		if err := restore(args[1:]); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+	case "verify":
+		if err := verify(args[1:]); err != nil {
+			log.Fatalf("Verify failed: %v", err)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func serve() {
 	db, err := greed.ConnectDb()
 
 	if err != nil {
-		log.Fatalf("Failed to connect to db: %v", greed.GetDbUrl())
+		log.Fatalf("Failed to connect to db %v: %v", greed.GetDbUrl(), err)
 	}
 
+	// posts recurring transactions due while the app was down, then keeps checking every minute
+	go greed.RunRecurringScheduler(context.Background(), db, time.Minute)
+
 	e := server.BuildWebApp(db)
 
 	e.Logger.Fatal(e.Start("127.0.0.1:8080"))
 }
+
+func migrate(args []string) error {
+	command := "up"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("migrate "+command, flag.ExitOnError)
+	target := flags.Int("to", -1, "target schema version")
+	allowDestructive := flags.Bool("allow-destructive", false, "run migrations that drop data")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(args)
+
+	migrations, err := greed.LoadMigrations(schema.FS)
+	if err != nil {
+		return err
+	}
+
+	db, err := greed.OpenDb()
+	if err != nil {
+		return fmt.Errorf("failed to connect to db %v: %v", greed.GetDbUrl(), err)
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		to := *target
+		if to < 0 {
+			to = 0
+		}
+
+		applied, err := greed.MigrateUp(db, migrations, to, *allowDestructive)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Applied %v migrations", len(applied))
+	case "down":
+		current, err := greed.GetSchemaVersion(db)
+		if err != nil {
+			return err
+		}
+
+		to := *target
+		if to < 0 && current > 0 {
+			to = current - 1
+		}
+
+		reverted, err := greed.MigrateDown(db, migrations, to, *allowDestructive)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Reverted %v migrations", len(reverted))
+	case "status":
+		applied, err := greed.GetAppliedMigrations(db)
+		if err != nil {
+			return err
+		}
+
+		appliedByVersion := map[int]greed.AppliedMigration{}
+		for _, m := range applied {
+			appliedByVersion[m.Version] = m
+		}
+
+		for _, m := range migrations {
+			state := "pending"
+			if a, ok := appliedByVersion[m.Version]; ok {
+				state = "applied " + a.AppliedAt.Format(greed.DATETIME_DB_LAYOUT)
+			}
+			if m.Up.Destructive {
+				state += " (destructive)"
+			}
+			fmt.Printf("%v\t%v\t%v\n", m.Version, m.Name, state)
+		}
+	case "baseline":
+		if flags.NArg() != 1 {
+			return fmt.Errorf("baseline expects a schema version")
+		}
+
+		version, err := strconv.Atoi(flags.Arg(0))
+		if err != nil || version <= 0 || version > len(migrations) {
+			return fmt.Errorf("invalid schema version %v", flags.Arg(0))
+		}
+
+		if err := greed.BaselineMigrations(db, migrations, version); err != nil {
+			return err
+		}
+
+		log.Printf("Marked migrations up to %v as applied", version)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	return nil
+}
+
+// findUser picks the user by name, the name may be omitted when there is only one user
+func findUser(db *sql.DB, username string) (greed.User, error) {
+	if username != "" {
+		user, err := greed.GetUserByUsername(db, username)
+		if err != nil {
+			return user, fmt.Errorf("user %v: %v", username, err)
+		}
+		return user, nil
+	}
+
+	users, err := greed.GetUsers(db)
+	if err != nil {
+		return greed.User{}, err
+	}
+
+	if len(users) != 1 {
+		return greed.User{}, fmt.Errorf("there are %v users, pick one with -user", len(users))
+	}
+
+	return users[0], nil
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	username := flags.String("user", "", "username, may be omitted with a single user")
+	format := flags.String("format", "json", "json or zip of csv files")
+	output := flags.String("o", "", "output file, stdout by default")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(args)
+
+	if *format != "json" && *format != "zip" {
+		return fmt.Errorf("unknown format %v", *format)
+	}
+
+	// the export may go to stdout, keep the log lines out of it
+	log.SetOutput(os.Stderr)
+
+	db, err := greed.ConnectDb()
+	if err != nil {
+		return fmt.Errorf("failed to connect to db %v: %v", greed.GetDbUrl(), err)
+	}
+	defer db.Close()
+
+	user, err := findUser(db, *username)
+	if err != nil {
+		return err
+	}
+
+	data, err := greed.GetExport(db, user.Id)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "zip" {
+		return greed.WriteExportZip(w, data)
+	}
+
+	return greed.WriteExportJson(w, data)
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	username := flags.String("user", "", "username, may be omitted with a single user")
+	replace := flags.Bool("replace", false, "drop accounts, categories and transactions of the user first")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("import expects an export file")
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	export, err := greed.ReadExport(data)
+	if err != nil {
+		return err
+	}
+
+	db, err := greed.ConnectDb()
+	if err != nil {
+		return fmt.Errorf("failed to connect to db %v: %v", greed.GetDbUrl(), err)
+	}
+	defer db.Close()
+
+	user, err := findUser(db, *username)
+	if err != nil {
+		return err
+	}
+
+	if err := greed.RestoreExport(db, user.Id, export, *replace); err != nil {
+		return err
+	}
+
+	log.Printf(
+		"Restored %v accounts, %v categories, %v transactions, %v budgets, %v recurring transactions, %v reconciliations, %v rules and %v payees for %v",
+		len(export.Accounts), len(export.Categories), len(export.Transactions), len(export.Budgets), len(export.Recurring),
+		len(export.Reconciliations), len(export.Rules), len(export.Payees), user.Username,
+	)
+	return nil
+}
+
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	username := flags.String("user", "", "username, all users by default")
+	repair := flags.Bool("repair", false, "set the drifted balances from the transactions")
+	keepStored := flags.Bool("keep-stored", false, "with -repair keep the stored balances and move the opening balances instead")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(args)
+
+	db, err := greed.ConnectDb()
+	if err != nil {
+		return fmt.Errorf("failed to connect to db %v: %v", greed.GetDbUrl(), err)
+	}
+	defer db.Close()
+
+	users, err := greed.GetUsers(db)
+	if err != nil {
+		return err
+	}
+
+	if *username != "" {
+		user, err := findUser(db, *username)
+		if err != nil {
+			return err
+		}
+		users = []greed.User{user}
+	}
+
+	drifted := 0
+	for _, user := range users {
+		drifts, err := greed.VerifyBalances(db, user.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range drifts {
+			difference, err := d.Difference()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf(
+				"%v: account %v (%v) stores %v %v, the opening balance plus the transactions is %v, off by %v\n",
+				user.Username, d.Account.Id, d.Account.Name, d.Stored, d.Account.Currency, d.Account.Amount, difference,
+			)
+
+			if *repair {
+				account, err := greed.RepairBalance(db, user.Id, d, *keepStored)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf(
+					"%v: account %v (%v) repaired, opens with %v %v, balance %v %v\n",
+					user.Username, account.Id, account.Name, account.OpeningAmount, account.Currency, account.Amount, account.Currency,
+				)
+			}
+		}
+
+		drifted += len(drifts)
+	}
+
+	switch {
+	case drifted == 0:
+		log.Printf("All account balances match their transactions")
+	case *repair:
+		log.Printf("Repaired %v account balances", drifted)
+	default:
+		return fmt.Errorf("%v account balances drifted, rerun with -repair to fix them", drifted)
+	}
+
+	return nil
+}
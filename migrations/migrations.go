@@ -0,0 +1,11 @@
+// Package migrations embeds the sql schema migrations applied by greed.Migrate*.
+//
+// vN_name.sql upgrades the schema to version N, uN_name.sql reverts it back to N-1.
+// Every file runs in a single db transaction, so files must not contain BEGIN/COMMIT.
+// Files starting with "-- +destructive" drop data and run only when explicitly allowed.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
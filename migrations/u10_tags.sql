@@ -0,0 +1,3 @@
+-- +destructive
+DROP TABLE transaction_tags;
+DROP TABLE tags;
@@ -0,0 +1,2 @@
+-- +destructive
+DROP TABLE transaction_splits;
@@ -0,0 +1,3 @@
+-- +destructive
+ALTER TABLE categories DROP COLUMN archived;
+ALTER TABLE categories DROP COLUMN parent_id;
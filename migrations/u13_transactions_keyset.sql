@@ -0,0 +1,2 @@
+CREATE INDEX transactions_user_id ON transactions (user_id);
+DROP INDEX IF EXISTS transactions_user_id_created_at;
@@ -0,0 +1,4 @@
+-- +destructive
+ALTER TABLE transactions DROP COLUMN reconciliation_id;
+ALTER TABLE transactions DROP COLUMN cleared;
+DROP TABLE reconciliations;
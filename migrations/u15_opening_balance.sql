@@ -0,0 +1,3 @@
+-- +destructive
+ALTER TABLE accounts DROP COLUMN opening_date;
+ALTER TABLE accounts DROP COLUMN opening_amount;
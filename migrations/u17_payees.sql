@@ -0,0 +1,5 @@
+-- +destructive
+DROP INDEX transactions_payee_id;
+ALTER TABLE transactions DROP COLUMN payee_id;
+DROP TABLE payee_aliases;
+DROP TABLE payees;
@@ -0,0 +1,6 @@
+DROP TRIGGER transactions_user_id_update;
+DROP TRIGGER transactions_user_id_insert;
+DROP TRIGGER categories_user_id_update;
+DROP TRIGGER categories_user_id_insert;
+DROP TRIGGER accounts_user_id_update;
+DROP TRIGGER accounts_user_id_insert;
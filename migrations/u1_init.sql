@@ -1,3 +1,4 @@
-DROP TABLE IF EXISTS accounts;
-DROP TABLE IF EXISTS categories;
+-- +destructive
 DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS accounts;
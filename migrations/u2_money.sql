@@ -0,0 +1,50 @@
+-- +destructive
+-- amounts go back to REAL columns, the conversion report is dropped
+
+CREATE TABLE transactions_new (
+    id INTEGER PRIMARY KEY,
+    account_id INTEGER NOT NULL,
+    amount REAL NOT NULL,
+    category_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    description TEXT NOT NULL,
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id)
+);
+
+INSERT INTO transactions_new (id, account_id, amount, category_id, created_at, description)
+SELECT
+    transactions.id,
+    transactions.account_id,
+    transactions.amount / (CASE accounts.currency WHEN 'JPY' THEN 1.0 ELSE 100.0 END),
+    transactions.category_id,
+    transactions.created_at,
+    transactions.description
+FROM transactions
+JOIN accounts ON accounts.id = transactions.account_id;
+
+CREATE TABLE accounts_new (
+    id INTEGER PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    amount REAL NOT NULL,
+    currency TEXT NOT NULL,
+    description TEXT NOT NULL
+);
+
+INSERT INTO accounts_new (id, name, amount, currency, description)
+SELECT
+    id,
+    name,
+    amount / (CASE currency WHEN 'JPY' THEN 1.0 ELSE 100.0 END),
+    currency,
+    description
+FROM accounts;
+
+DROP TABLE transactions;
+DROP TABLE accounts;
+ALTER TABLE accounts_new RENAME TO accounts;
+ALTER TABLE transactions_new RENAME TO transactions;
+
+DROP TABLE IF EXISTS money_migration_report;
@@ -0,0 +1,51 @@
+-- +destructive
+-- ownership is dropped, data of all users is merged back into one
+
+DROP INDEX IF EXISTS accounts_user_id;
+DROP INDEX IF EXISTS categories_user_id;
+DROP INDEX IF EXISTS transactions_user_id;
+
+CREATE TABLE accounts_new (
+    id INTEGER PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    description TEXT NOT NULL
+);
+
+INSERT INTO accounts_new (id, name, amount, currency, description)
+SELECT id, name, amount, currency, description
+FROM accounts;
+
+CREATE TABLE categories_new (
+    id INTEGER PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL
+);
+
+INSERT INTO categories_new (id, name)
+SELECT id, name
+FROM categories;
+
+CREATE TABLE transactions_new (
+    id INTEGER PRIMARY KEY,
+    account_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    description TEXT NOT NULL,
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id)
+);
+
+INSERT INTO transactions_new (id, account_id, amount, category_id, created_at, description)
+SELECT id, account_id, amount, category_id, created_at, description
+FROM transactions;
+
+DROP TABLE transactions;
+DROP TABLE categories;
+DROP TABLE accounts;
+ALTER TABLE accounts_new RENAME TO accounts;
+ALTER TABLE categories_new RENAME TO categories;
+ALTER TABLE transactions_new RENAME TO transactions;
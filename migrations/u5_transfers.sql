@@ -0,0 +1,27 @@
+-- legs of transfers stay as regular transactions
+
+CREATE TABLE transactions_new (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER,
+    account_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    description TEXT NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id),
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id)
+);
+
+INSERT INTO transactions_new (id, user_id, account_id, amount, category_id, created_at, description)
+SELECT id, user_id, account_id, amount, category_id, created_at, description
+FROM transactions;
+
+DROP TABLE transactions;
+ALTER TABLE transactions_new RENAME TO transactions;
+DROP TABLE transfers;
+
+CREATE INDEX IF NOT EXISTS transactions_user_id ON transactions (user_id);
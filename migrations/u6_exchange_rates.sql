@@ -0,0 +1,3 @@
+-- +destructive
+ALTER TABLE users DROP COLUMN reporting_currency;
+DROP TABLE exchange_rates;
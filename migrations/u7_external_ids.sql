@@ -0,0 +1,3 @@
+-- +destructive
+DROP INDEX IF EXISTS transactions_account_external_id;
+ALTER TABLE transactions DROP COLUMN external_id;
@@ -0,0 +1,3 @@
+-- +destructive
+DROP TABLE recurring_skips;
+DROP TABLE recurring_transactions;
@@ -0,0 +1,22 @@
+-- free form labels of transactions across categories, like "vacation-2026" or "reimbursable"
+
+CREATE TABLE tags (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+);
+
+CREATE TABLE transaction_tags (
+    transaction_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (transaction_id, tag_id),
+    FOREIGN KEY (transaction_id)
+        REFERENCES transactions (id),
+    FOREIGN KEY (tag_id)
+        REFERENCES tags (id)
+);
+
+CREATE INDEX transaction_tags_tag_id ON transaction_tags (tag_id);
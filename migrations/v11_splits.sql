@@ -0,0 +1,16 @@
+-- a transaction covering several categories, like a supermarket receipt,
+-- has split lines summing to its amount, stats count the lines instead of the transaction
+
+CREATE TABLE transaction_splits (
+    id INTEGER PRIMARY KEY,
+    transaction_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    memo TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (transaction_id)
+        REFERENCES transactions (id),
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id)
+);
+
+CREATE INDEX transaction_splits_transaction_id ON transaction_splits (transaction_id);
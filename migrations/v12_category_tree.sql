@@ -0,0 +1,5 @@
+-- categories form a tree, stats and budgets of a category include its subcategories,
+-- categories still referenced are archived (hidden from the forms) instead of deleted
+
+ALTER TABLE categories ADD COLUMN parent_id INTEGER REFERENCES categories (id);
+ALTER TABLE categories ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
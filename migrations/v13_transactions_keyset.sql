@@ -0,0 +1,10 @@
+-- transactions are listed newest first and paged by (created_at, id) cursors,
+-- created_at is stored in UTC so that it sorts as text in the order of time;
+-- the index covers the plain column, the bundled sqlite picks an index on datetime(created_at)
+-- for the stats queries and sums the wrong column then, it also covers lookups by user_id alone
+
+UPDATE transactions SET created_at = strftime('%Y-%m-%dT%H:%M:%S+00:00', created_at)
+WHERE created_at != strftime('%Y-%m-%dT%H:%M:%S+00:00', created_at);
+
+CREATE INDEX transactions_user_id_created_at ON transactions (user_id, created_at DESC, id DESC);
+DROP INDEX transactions_user_id;
@@ -0,0 +1,25 @@
+-- accounts are reconciled against bank statements: transactions seen on the statement are cleared,
+-- reconciling locks the cleared transactions up to the statement date and records the difference
+-- between the statement and the cleared balance as an adjustment transaction
+
+CREATE TABLE reconciliations (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    account_id INTEGER NOT NULL,
+    -- the statement balance is at the end of this day
+    statement_date DATETIME NOT NULL,
+    statement_balance INTEGER NOT NULL,
+    adjustment_id INTEGER,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id),
+    FOREIGN KEY (adjustment_id)
+        REFERENCES transactions (id)
+);
+
+CREATE INDEX reconciliations_account_id ON reconciliations (account_id);
+
+ALTER TABLE transactions ADD COLUMN cleared INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN reconciliation_id INTEGER REFERENCES reconciliations (id);
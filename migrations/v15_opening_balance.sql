@@ -0,0 +1,14 @@
+-- account balances are derived from the opening balance plus the transactions,
+-- amount stays as the stored balance, it's refreshed from the ledger on every write
+-- so that greed verify can tell when it drifted
+-- existing accounts open with their first transaction, at the balance that keeps the current one
+
+ALTER TABLE accounts ADD COLUMN opening_amount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN opening_date DATETIME NOT NULL DEFAULT '1970-01-01T00:00:00+00:00';
+
+UPDATE accounts SET
+    opening_amount = amount - coalesce((SELECT sum(amount) FROM transactions WHERE transactions.account_id = accounts.id), 0),
+    opening_date = coalesce(
+        (SELECT strftime('%Y-%m-%dT%H:%M:%S+00:00', min(datetime(created_at))) FROM transactions WHERE transactions.account_id = accounts.id),
+        strftime('%Y-%m-%dT%H:%M:%S+00:00', 'now')
+    );
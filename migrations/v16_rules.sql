@@ -0,0 +1,32 @@
+-- rules categorise transactions: the conditions match the description, account, amount and weekday,
+-- the matching rules set the category, add tags and rewrite the description
+-- on create, on import and when re-applied to existing transactions
+
+CREATE TABLE rules (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    -- rules run in this order, the first rule setting a field wins
+    position INTEGER NOT NULL,
+    description_contains TEXT NOT NULL DEFAULT '',
+    description_regex TEXT NOT NULL DEFAULT '',
+    account_id INTEGER,
+    -- decimal strings compared in the currency of the transaction, null for no bound
+    amount_min TEXT,
+    amount_max TEXT,
+    -- bit 1 << weekday (sunday is 0), 0 for any day
+    weekdays INTEGER NOT NULL DEFAULT 0,
+    category_id INTEGER,
+    -- comma separated tag names
+    tags TEXT NOT NULL DEFAULT '',
+    set_description TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id),
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id)
+);
+
+CREATE INDEX rules_user_id ON rules (user_id, position);
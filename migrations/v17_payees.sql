@@ -0,0 +1,28 @@
+-- payees are the canonical merchants of transactions, the normalized name and aliases of a payee
+-- link the transactions with matching descriptions to it
+
+CREATE TABLE payees (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+);
+
+CREATE UNIQUE INDEX payees_user_id_name ON payees (user_id, name COLLATE NOCASE);
+
+CREATE TABLE payee_aliases (
+    id INTEGER PRIMARY KEY,
+    payee_id INTEGER NOT NULL,
+    -- normalized words matched against the normalized description
+    pattern TEXT NOT NULL,
+    FOREIGN KEY (payee_id)
+        REFERENCES payees (id)
+);
+
+CREATE INDEX payee_aliases_payee_id ON payee_aliases (payee_id);
+
+ALTER TABLE transactions ADD COLUMN payee_id INTEGER REFERENCES payees (id);
+
+CREATE INDEX transactions_payee_id ON transactions (payee_id);
@@ -0,0 +1,33 @@
+-- accounts, categories and transactions always belong to a user, sqlite can't add NOT NULL to a column in place
+-- and the rows v4 left unowned stay until the first user claims them on signup, so triggers refuse new ones
+-- like a NOT NULL column would
+
+CREATE TRIGGER accounts_user_id_insert BEFORE INSERT ON accounts WHEN NEW.user_id IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'NOT NULL constraint failed: accounts.user_id');
+END;
+
+CREATE TRIGGER accounts_user_id_update BEFORE UPDATE OF user_id ON accounts WHEN NEW.user_id IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'NOT NULL constraint failed: accounts.user_id');
+END;
+
+CREATE TRIGGER categories_user_id_insert BEFORE INSERT ON categories WHEN NEW.user_id IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'NOT NULL constraint failed: categories.user_id');
+END;
+
+CREATE TRIGGER categories_user_id_update BEFORE UPDATE OF user_id ON categories WHEN NEW.user_id IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'NOT NULL constraint failed: categories.user_id');
+END;
+
+CREATE TRIGGER transactions_user_id_insert BEFORE INSERT ON transactions WHEN NEW.user_id IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'NOT NULL constraint failed: transactions.user_id');
+END;
+
+CREATE TRIGGER transactions_user_id_update BEFORE UPDATE OF user_id ON transactions WHEN NEW.user_id IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'NOT NULL constraint failed: transactions.user_id');
+END;
@@ -0,0 +1,84 @@
+-- amounts are stored as integer minor units, exponent per currency matches greed.CurrencyExponents
+-- (JPY has no minor units, everything else has 2 fractional digits)
+
+CREATE TABLE IF NOT EXISTS money_migration_report (
+    table_name TEXT NOT NULL,
+    row_id INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    original_amount REAL NOT NULL,
+    stored_amount INTEGER NOT NULL
+);
+
+-- rows that can't be represented exactly are rounded half away from zero and reported in money_migration_report
+INSERT INTO money_migration_report (table_name, row_id, currency, original_amount, stored_amount)
+SELECT
+    'accounts',
+    accounts.id,
+    accounts.currency,
+    accounts.amount,
+    cast(round(accounts.amount * (CASE accounts.currency WHEN 'JPY' THEN 1 ELSE 100 END)) AS INTEGER)
+FROM accounts
+WHERE abs(
+    accounts.amount * (CASE accounts.currency WHEN 'JPY' THEN 1 ELSE 100 END)
+    - round(accounts.amount * (CASE accounts.currency WHEN 'JPY' THEN 1 ELSE 100 END))
+) > 1e-6;
+
+INSERT INTO money_migration_report (table_name, row_id, currency, original_amount, stored_amount)
+SELECT
+    'transactions',
+    transactions.id,
+    accounts.currency,
+    transactions.amount,
+    cast(round(transactions.amount * (CASE accounts.currency WHEN 'JPY' THEN 1 ELSE 100 END)) AS INTEGER)
+FROM transactions
+JOIN accounts ON accounts.id = transactions.account_id
+WHERE abs(
+    transactions.amount * (CASE accounts.currency WHEN 'JPY' THEN 1 ELSE 100 END)
+    - round(transactions.amount * (CASE accounts.currency WHEN 'JPY' THEN 1 ELSE 100 END))
+) > 1e-6;
+
+CREATE TABLE transactions_new (
+    id INTEGER PRIMARY KEY,
+    account_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    description TEXT NOT NULL,
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id)
+);
+
+INSERT INTO transactions_new (id, account_id, amount, category_id, created_at, description)
+SELECT
+    transactions.id,
+    transactions.account_id,
+    cast(round(transactions.amount * (CASE accounts.currency WHEN 'JPY' THEN 1 ELSE 100 END)) AS INTEGER),
+    transactions.category_id,
+    transactions.created_at,
+    transactions.description
+FROM transactions
+JOIN accounts ON accounts.id = transactions.account_id;
+
+CREATE TABLE accounts_new (
+    id INTEGER PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    description TEXT NOT NULL
+);
+
+INSERT INTO accounts_new (id, name, amount, currency, description)
+SELECT
+    id,
+    name,
+    cast(round(amount * (CASE currency WHEN 'JPY' THEN 1 ELSE 100 END)) AS INTEGER),
+    currency,
+    description
+FROM accounts;
+
+DROP TABLE transactions;
+DROP TABLE accounts;
+ALTER TABLE accounts_new RENAME TO accounts;
+ALTER TABLE transactions_new RENAME TO transactions;
@@ -0,0 +1,64 @@
+-- every account, category and transaction belongs to a user
+-- existing rows go to the first user, if there are no users yet they stay unowned (user_id is null)
+-- and are claimed by the first user on signup (greed.ClaimUnownedData)
+-- names are unique per user now
+
+CREATE TABLE accounts_new (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER,
+    name TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    description TEXT NOT NULL,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+);
+
+INSERT INTO accounts_new (id, user_id, name, amount, currency, description)
+SELECT id, (SELECT min(id) FROM users), name, amount, currency, description
+FROM accounts;
+
+CREATE TABLE categories_new (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER,
+    name TEXT NOT NULL,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+);
+
+INSERT INTO categories_new (id, user_id, name)
+SELECT id, (SELECT min(id) FROM users), name
+FROM categories;
+
+CREATE TABLE transactions_new (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER,
+    account_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    description TEXT NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id),
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id)
+);
+
+INSERT INTO transactions_new (id, user_id, account_id, amount, category_id, created_at, description)
+SELECT id, (SELECT min(id) FROM users), account_id, amount, category_id, created_at, description
+FROM transactions;
+
+DROP TABLE transactions;
+DROP TABLE categories;
+DROP TABLE accounts;
+ALTER TABLE accounts_new RENAME TO accounts;
+ALTER TABLE categories_new RENAME TO categories;
+ALTER TABLE transactions_new RENAME TO transactions;
+
+CREATE INDEX IF NOT EXISTS accounts_user_id ON accounts (user_id);
+CREATE INDEX IF NOT EXISTS categories_user_id ON categories (user_id);
+CREATE INDEX IF NOT EXISTS transactions_user_id ON transactions (user_id);
@@ -0,0 +1,15 @@
+-- a transfer links two transactions of the same user: a debit (negative amount) on the source account
+-- and a credit (positive amount) on the destination account, amounts may differ for currency exchanges
+-- legs of transfers are excluded from income/expense stats
+
+CREATE TABLE transfers (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+);
+
+ALTER TABLE transactions ADD COLUMN transfer_id INTEGER REFERENCES transfers (id);
+
+CREATE INDEX IF NOT EXISTS transfers_user_id ON transfers (user_id);
+CREATE INDEX IF NOT EXISTS transactions_transfer_id ON transactions (transfer_id);
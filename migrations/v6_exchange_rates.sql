@@ -0,0 +1,19 @@
+-- dated exchange rates per user: 1 unit of base currency = rate units of quote currency,
+-- a rate is valid from valid_from until the next rate of the same pair
+-- rates are stored as exact decimal strings
+
+CREATE TABLE exchange_rates (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    base TEXT NOT NULL,
+    quote TEXT NOT NULL,
+    rate TEXT NOT NULL,
+    valid_from DATETIME NOT NULL,
+    source TEXT NOT NULL,
+    UNIQUE (user_id, base, quote, valid_from),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+);
+
+-- stats of all currencies are converted into the reporting currency
+ALTER TABLE users ADD COLUMN reporting_currency TEXT NOT NULL DEFAULT 'USD';
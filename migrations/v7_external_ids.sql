@@ -0,0 +1,6 @@
+-- bank provided ids of imported transactions (OFX FITID, camt.053 AcctSvcrRef),
+-- a statement imported again doesn't create the same transactions twice
+
+ALTER TABLE transactions ADD COLUMN external_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_account_external_id ON transactions (account_id, external_id);
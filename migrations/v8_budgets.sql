@@ -0,0 +1,18 @@
+-- spending limit of a category in one currency per period (week, month or year),
+-- with rollover the unspent (or overspent) amount of past periods moves into the current one
+
+CREATE TABLE budgets (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    period TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    rollover INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    UNIQUE (user_id, category_id, currency, period),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id),
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id)
+);
@@ -0,0 +1,35 @@
+-- templates of transactions repeating on a schedule (every N days, weeks, months or years from starts_at),
+-- next_at is the next occurrence to post, NULL once the schedule has ended
+
+CREATE TABLE recurring_transactions (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    account_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    frequency TEXT NOT NULL,
+    every INTEGER NOT NULL DEFAULT 1,
+    starts_at DATETIME NOT NULL,
+    ends_at DATETIME,
+    next_at DATETIME,
+    paused INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id),
+    FOREIGN KEY (account_id)
+        REFERENCES accounts (id),
+    FOREIGN KEY (category_id)
+        REFERENCES categories (id)
+);
+
+CREATE INDEX recurring_transactions_next_at ON recurring_transactions (next_at);
+
+-- upcoming occurrences the user chose not to post
+CREATE TABLE recurring_skips (
+    recurring_id INTEGER NOT NULL,
+    occurs_at DATETIME NOT NULL,
+    PRIMARY KEY (recurring_id, occurs_at),
+    FOREIGN KEY (recurring_id)
+        REFERENCES recurring_transactions (id)
+);
@@ -0,0 +1,422 @@
+package greed
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrSessionExpired = errors.New("session expired")
+var ErrTokenRevoked = errors.New("api token revoked")
+
+type User struct {
+	Id           int64     `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	// stats of all currencies are converted into it
+	ReportingCurrency string `json:"reporting_currency"`
+}
+
+type Session struct {
+	Id        int64
+	UserId    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type ApiToken struct {
+	Id         int64      `json:"id"`
+	UserId     int64      `json:"-"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func parseNullDatetime(x sql.NullString) (*time.Time, error) {
+	if !x.Valid {
+		return nil, nil
+	}
+
+	parsed, err := ParseDbDatetime(x.String)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parsed, nil
+}
+
+func CountUsers[T DatabaseInterface](db T) (int64, error) {
+	var count int64
+
+	row := db.QueryRow("select count(*) from users")
+
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func CreateUser[T DatabaseInterface](db T, username string, passwordHash string) (User, error) {
+	user := User{
+		Username:          username,
+		PasswordHash:      passwordHash,
+		CreatedAt:         time.Now().UTC(),
+		ReportingCurrency: DefaultReportingCurrency,
+	}
+
+	result, err := db.Exec(
+		"insert into users (username, password_hash, created_at, reporting_currency) values (?, ?, ?, ?)",
+		user.Username, user.PasswordHash, user.CreatedAt.Format(DATETIME_DB_LAYOUT), user.ReportingCurrency,
+	)
+	if err != nil {
+		return user, fmt.Errorf("failed to create user %v: %v", username, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return user, fmt.Errorf("failed to get last inserted user id %v: %v", username, err)
+	}
+
+	user.Id = id
+	return user, nil
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	var createdAt string
+
+	if err := row.Scan(&u.Id, &u.Username, &u.PasswordHash, &createdAt, &u.ReportingCurrency); err != nil {
+		return u, err
+	}
+
+	parsedCreatedAt, err := ParseDbDatetime(createdAt)
+	if err != nil {
+		return u, err
+	}
+
+	u.CreatedAt = parsedCreatedAt
+	return u, nil
+}
+
+func GetUserById[T DatabaseInterface](db T, id int64) (User, error) {
+	row := db.QueryRow("select id, username, password_hash, created_at, reporting_currency from users where id = ?", id)
+	return scanUser(row)
+}
+
+func GetUserByUsername[T DatabaseInterface](db T, username string) (User, error) {
+	row := db.QueryRow("select id, username, password_hash, created_at, reporting_currency from users where username = ?", username)
+	return scanUser(row)
+}
+
+func GetUsers[T DatabaseInterface](db T) ([]User, error) {
+	var users []User
+
+	rows, err := db.Query("select id, username, password_hash, created_at, reporting_currency from users order by id")
+	if err != nil {
+		return nil, fmt.Errorf("fetch users failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("fetch users row failed: %v", err)
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+func CreateSession[T DatabaseInterface](db T, userId int64, tokenHash string, ttl time.Duration) (Session, error) {
+	now := time.Now().UTC()
+	session := Session{
+		UserId:    userId,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	result, err := db.Exec(
+		"insert into sessions (user_id, token_hash, created_at, expires_at) values (?, ?, ?, ?)",
+		session.UserId, tokenHash, session.CreatedAt.Format(DATETIME_DB_LAYOUT), session.ExpiresAt.Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return session, fmt.Errorf("failed to create session for user %v: %v", userId, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return session, fmt.Errorf("failed to get last inserted session id for user %v: %v", userId, err)
+	}
+
+	session.Id = id
+	return session, nil
+}
+
+// GetSessionUser returns the owner of a non expired session
+func GetSessionUser[T DatabaseInterface](db T, tokenHash string) (User, error) {
+	var u User
+	var createdAt, expiresAt string
+
+	row := db.QueryRow(
+		`
+		select users.id, users.username, users.password_hash, users.created_at, users.reporting_currency, sessions.expires_at
+		from sessions
+		join users on users.id = sessions.user_id
+		where sessions.token_hash = ?
+		`,
+		tokenHash,
+	)
+	if err := row.Scan(&u.Id, &u.Username, &u.PasswordHash, &createdAt, &u.ReportingCurrency, &expiresAt); err != nil {
+		return u, err
+	}
+
+	parsedExpiresAt, err := ParseDbDatetime(expiresAt)
+	if err != nil {
+		return u, err
+	}
+
+	if !parsedExpiresAt.After(time.Now()) {
+		return u, ErrSessionExpired
+	}
+
+	parsedCreatedAt, err := ParseDbDatetime(createdAt)
+	if err != nil {
+		return u, err
+	}
+
+	u.CreatedAt = parsedCreatedAt
+	return u, nil
+}
+
+func DeleteSession[T DatabaseInterface](db T, tokenHash string) error {
+	if _, err := db.Exec("delete from sessions where token_hash = ?", tokenHash); err != nil {
+		return fmt.Errorf("failed to delete session: %v", err)
+	}
+	return nil
+}
+
+func DeleteExpiredSessions[T DatabaseInterface](db T) (int64, error) {
+	result, err := db.Exec(
+		"delete from sessions where datetime(expires_at) <= datetime(?)",
+		time.Now().UTC().Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func CreateApiToken[T DatabaseInterface](db T, userId int64, name string, tokenHash string) (ApiToken, error) {
+	token := ApiToken{
+		UserId:    userId,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	result, err := db.Exec(
+		"insert into api_tokens (user_id, name, token_hash, created_at) values (?, ?, ?, ?)",
+		token.UserId, token.Name, tokenHash, token.CreatedAt.Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return token, fmt.Errorf("failed to create api token %v: %v", name, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return token, fmt.Errorf("failed to get last inserted api token id %v: %v", name, err)
+	}
+
+	token.Id = id
+	return token, nil
+}
+
+func GetApiTokens[T DatabaseInterface](db T, userId int64) ([]ApiToken, error) {
+	var tokens []ApiToken
+
+	rows, err := db.Query(
+		`
+		select id, user_id, name, created_at, last_used_at, revoked_at
+		from api_tokens
+		where user_id = ?
+		order by id desc
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch api tokens failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t ApiToken
+		var createdAt string
+		var lastUsedAt, revokedAt sql.NullString
+
+		if err := rows.Scan(&t.Id, &t.UserId, &t.Name, &createdAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, fmt.Errorf("fetch api tokens row failed: %v", err)
+		}
+
+		if t.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+			return nil, err
+		}
+
+		if t.LastUsedAt, err = parseNullDatetime(lastUsedAt); err != nil {
+			return nil, err
+		}
+
+		if t.RevokedAt, err = parseNullDatetime(revokedAt); err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during api tokens iteration: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// GetApiTokenUser returns the owner of a non revoked token and marks the token as used
+func GetApiTokenUser[T DatabaseInterface](db T, tokenHash string) (User, error) {
+	var u User
+	var tokenId int64
+	var createdAt string
+	var revokedAt sql.NullString
+
+	row := db.QueryRow(
+		`
+		select users.id, users.username, users.password_hash, users.created_at, users.reporting_currency, api_tokens.id, api_tokens.revoked_at
+		from api_tokens
+		join users on users.id = api_tokens.user_id
+		where api_tokens.token_hash = ?
+		`,
+		tokenHash,
+	)
+	if err := row.Scan(&u.Id, &u.Username, &u.PasswordHash, &createdAt, &u.ReportingCurrency, &tokenId, &revokedAt); err != nil {
+		return u, err
+	}
+
+	if revokedAt.Valid {
+		return u, ErrTokenRevoked
+	}
+
+	parsedCreatedAt, err := ParseDbDatetime(createdAt)
+	if err != nil {
+		return u, err
+	}
+	u.CreatedAt = parsedCreatedAt
+
+	if _, err := db.Exec(
+		"update api_tokens set last_used_at = ? where id = ?",
+		time.Now().UTC().Format(DATETIME_DB_LAYOUT), tokenId,
+	); err != nil {
+		return u, fmt.Errorf("failed to update api token %v usage: %v", tokenId, err)
+	}
+
+	return u, nil
+}
+
+func SetReportingCurrency[T DatabaseInterface](db T, userId int64, currency string) error {
+	if !IsSupportedCurrency(currency) {
+		return fmt.Errorf("%w: %v", ErrUnsupportedCurrency, currency)
+	}
+
+	if _, err := db.Exec("update users set reporting_currency = ? where id = ?", currency, userId); err != nil {
+		return fmt.Errorf("failed to set reporting currency of user %v: %v", userId, err)
+	}
+
+	return nil
+}
+
+func RevokeApiToken[T DatabaseInterface](db T, userId int64, tokenId int64) error {
+	result, err := db.Exec(
+		"update api_tokens set revoked_at = ? where id = ? and user_id = ? and revoked_at is null",
+		time.Now().UTC().Format(DATETIME_DB_LAYOUT), tokenId, userId,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api token %v: %v", tokenId, err)
+	}
+
+	rowsUpdated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows updated when revoking api token %v: %v", tokenId, err)
+	}
+
+	if rowsUpdated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ClaimUnownedData assigns rows created before users existed to the user, the first user claims them on signup
+func ClaimUnownedData[T DatabaseInterface](db T, userId int64) error {
+	for _, table := range []string{"accounts", "categories", "transactions"} {
+		if _, err := db.Exec(fmt.Sprintf("update %v set user_id = ? where user_id is null", table), userId); err != nil {
+			return fmt.Errorf("failed to claim unowned %v for user %v: %v", table, userId, err)
+		}
+	}
+
+	return nil
+}
+
+func CreateDefaultCategories[T DatabaseInterface](db T, userId int64) error {
+	for _, name := range DefaultCategories {
+		if _, err := db.Exec("insert into categories (user_id, name) values (?, ?)", userId, name); err != nil {
+			return fmt.Errorf("failed to create default category %v for user %v: %v", name, userId, err)
+		}
+	}
+	return nil
+}
+
+// SignupUser creates the user with the default categories in one db transaction,
+// the first user claims the rows created before users existed in the same one, so no user sees them unowned
+func SignupUser(db *sql.DB, username string, passwordHash string) (User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	usersCount, err := CountUsers(tx)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := CreateUser(tx, username, passwordHash)
+	if err != nil {
+		return user, err
+	}
+
+	if usersCount == 0 {
+		if err := ClaimUnownedData(tx, user.Id); err != nil {
+			return user, err
+		}
+	}
+
+	categories, err := GetCategories(tx, user.Id)
+	if err != nil {
+		return user, err
+	}
+
+	// claimed categories replace the default ones
+	if len(categories) == 0 {
+		if err := CreateDefaultCategories(tx, user.Id); err != nil {
+			return user, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
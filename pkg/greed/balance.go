@@ -0,0 +1,173 @@
+package greed
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// accountBalance is the balance of the account in minor units: the opening amount plus all of its transactions,
+// future dated ones included like the balance stored before opening balances, so the stored one doesn't go stale
+// as time passes, GetAccountBalance gives the balance at a point in time
+const accountBalance = `accounts.opening_amount + coalesce(
+	(select sum(transactions.amount) from transactions where transactions.account_id = accounts.id), 0
+)`
+
+// AccountDrift is an account whose stored balance doesn't match its ledger
+type AccountDrift struct {
+	Account Account `json:"account"`
+	// balance stored in the accounts table
+	Stored Money `json:"stored"`
+}
+
+// Difference is what the stored balance has on top of the ledger
+func (d AccountDrift) Difference() (Money, error) {
+	return d.Stored.Sub(d.Account.Amount)
+}
+
+// accountOpenedAt is when the opening amount starts to count: the opening date, or the first transaction
+// when there are transactions dated before it, they are part of the balance like the later ones
+func accountOpenedAt[T DatabaseInterface](db T, account Account) (time.Time, error) {
+	var first sql.NullString
+	row := db.QueryRow("select min(transactions.created_at) from transactions where transactions.account_id = ?", account.Id)
+	if err := row.Scan(&first); err != nil {
+		return time.Time{}, fmt.Errorf("failed to find the first transaction of account %v: %v", account.Id, err)
+	}
+
+	if !first.Valid {
+		return account.OpeningDate, nil
+	}
+
+	firstAt, err := ParseDbDatetime(first.String)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if firstAt.Before(account.OpeningDate) {
+		return firstAt, nil
+	}
+
+	return account.OpeningDate, nil
+}
+
+// GetAccountBalance is the balance of the account right before at:
+// the opening amount once the account is opened plus the earlier transactions
+func GetAccountBalance[T DatabaseInterface](db T, userId int64, accountId int64, at time.Time) (Money, error) {
+	account, err := GetAccountById(db, userId, accountId)
+	if err != nil {
+		return Money{}, fmt.Errorf("account %v of user %v: %w", accountId, userId, err)
+	}
+
+	var moved int64
+	row := db.QueryRow(
+		`
+		select coalesce(sum(transactions.amount), 0) from transactions
+		where transactions.account_id = ? and transactions.user_id = ? and datetime(transactions.created_at) < datetime(?)
+		`,
+		accountId, userId, at.UTC().Format(DATETIME_DB_LAYOUT),
+	)
+	if err := row.Scan(&moved); err != nil {
+		return Money{}, fmt.Errorf("failed to compute balance of account %v at %v: %v", accountId, at, err)
+	}
+
+	balance := NewMoney(moved, CurrencyExponent(account.Currency))
+
+	openedAt, err := accountOpenedAt(db, account)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if openedAt.Before(at) {
+		return balance.Add(account.OpeningAmount)
+	}
+
+	return balance, nil
+}
+
+// refreshAccountBalances stores the balances of the accounts computed from their ledgers
+func refreshAccountBalances[T DatabaseInterface](db T, userId int64, accountIds ...int64) error {
+	for _, accountId := range accountIds {
+		if _, err := db.Exec(
+			"update accounts set amount = "+accountBalance+" where accounts.id = ? and accounts.user_id = ?",
+			accountId, userId,
+		); err != nil {
+			return fmt.Errorf("failed to refresh balance of account %v: %v", accountId, err)
+		}
+	}
+
+	return nil
+}
+
+// VerifyBalances lists the accounts of the user whose stored balance drifted from the opening amount plus the transactions
+func VerifyBalances[T DatabaseInterface](db T, userId int64) ([]AccountDrift, error) {
+	var drifts []AccountDrift
+
+	rows, err := db.Query(
+		`
+		select `+accountColumns+`, accounts.amount
+		from accounts
+		where accounts.user_id = ? and accounts.amount != `+accountBalance+`
+		order by accounts.id
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("verify balances failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d AccountDrift
+		var stored int64
+
+		a, err := scanAccount(rows, &stored)
+		if err != nil {
+			return nil, fmt.Errorf("verify balances row failed: %v", err)
+		}
+
+		d.Account = a
+		d.Stored = NewMoney(stored, CurrencyExponent(a.Currency))
+		drifts = append(drifts, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during verify balances iteration: %v", err)
+	}
+
+	return drifts, nil
+}
+
+// RepairBalance fixes a drifted account and returns it repaired: the stored balance is set from the ledger,
+// or with keepStored the opening amount moves so that the ledger adds up to the stored balance
+func RepairBalance(db *sql.DB, userId int64, drift AccountDrift, keepStored bool) (Account, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Account{}, err
+	}
+	defer tx.Rollback()
+
+	if keepStored {
+		difference, err := drift.Difference()
+		if err != nil {
+			return Account{}, err
+		}
+
+		if _, err := tx.Exec(
+			"update accounts set opening_amount = opening_amount + ? where id = ? and user_id = ?",
+			difference.Minor, drift.Account.Id, userId,
+		); err != nil {
+			return Account{}, fmt.Errorf("failed to move opening amount of account %v: %v", drift.Account.Id, err)
+		}
+	}
+
+	if err := refreshAccountBalances(tx, userId, drift.Account.Id); err != nil {
+		return Account{}, err
+	}
+
+	account, err := GetAccountById(tx, userId, drift.Account.Id)
+	if err != nil {
+		return account, err
+	}
+
+	return account, tx.Commit()
+}
@@ -0,0 +1,129 @@
+package greed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetAccountBalance(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+
+	account := testAccount(t, db, user.Id, "USD", "1000", opened)
+	testTransaction(t, db, user.Id, account, "-100", food, opened.AddDate(0, 0, 1), "groceries")
+	testTransaction(t, db, user.Id, account, "50", food, opened.AddDate(0, 0, 2), "refund")
+	// dated before the account was opened, the opening amount counts from it on
+	testTransaction(t, db, user.Id, account, "-30", food, opened.AddDate(0, 0, -1), "backdated")
+
+	untouched := testAccount(t, db, user.Id, "EUR", "200", opened)
+
+	cases := []struct {
+		account Account
+		at      time.Time
+		balance string
+	}{
+		{account, opened.AddDate(0, 0, -2), "0.00"},
+		{account, opened.AddDate(0, 0, -1), "0.00"},
+		{account, opened.AddDate(0, 0, -1).Add(time.Hour), "970.00"},
+		{account, opened.Add(time.Hour), "970.00"},
+		{account, opened.AddDate(0, 0, 1).Add(time.Hour), "870.00"},
+		{account, opened.AddDate(1, 0, 0), "920.00"},
+		{untouched, opened.Add(-time.Hour), "0.00"},
+		{untouched, opened.Add(time.Hour), "200.00"},
+	}
+
+	for _, c := range cases {
+		balance, err := GetAccountBalance(db, user.Id, c.account.Id, c.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.String() != c.balance {
+			t.Errorf("balance of %v at %v = %v, want %v", c.account.Name, c.at, balance, c.balance)
+		}
+	}
+
+	// the balance after every transaction is the stored one
+	stored, err := GetAccountById(db, user.Id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Amount.String() != "920.00" {
+		t.Errorf("stored balance = %v, want 920.00", stored.Amount)
+	}
+
+	if _, err := GetAccountBalance(db, user.Id+1, account.Id, opened); err == nil {
+		t.Errorf("balance of an account of another user passed")
+	}
+}
+
+func TestVerifyAndRepairBalances(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+
+	account := testAccount(t, db, user.Id, "USD", "1000", opened)
+	testTransaction(t, db, user.Id, account, "-100", food, opened.AddDate(0, 0, 1), "groceries")
+	testAccount(t, db, user.Id, "EUR", "200", opened)
+
+	drifts, err := VerifyBalances(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("drifts of consistent accounts = %+v", drifts)
+	}
+
+	drift := func() AccountDrift {
+		t.Helper()
+
+		if _, err := db.Exec("update accounts set amount = amount + 5000 where id = ?", account.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		drifts, err := VerifyBalances(db, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(drifts) != 1 || drifts[0].Account.Id != account.Id {
+			t.Fatalf("drifts = %+v, want account %v", drifts, account.Id)
+		}
+
+		d := drifts[0]
+		difference, err := d.Difference()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Stored.String() != "950.00" || d.Account.Amount.String() != "900.00" || difference.String() != "50.00" {
+			t.Errorf("drift stores %v, ledger %v, off by %v, want 950.00, 900.00, 50.00", d.Stored, d.Account.Amount, difference)
+		}
+
+		return d
+	}
+
+	repaired, err := RepairBalance(db, user.Id, drift(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired.Amount.String() != "900.00" || repaired.OpeningAmount.String() != "1000.00" {
+		t.Errorf("repaired from the ledger to %v opening with %v, want 900.00 opening with 1000.00", repaired.Amount, repaired.OpeningAmount)
+	}
+
+	repaired, err = RepairBalance(db, user.Id, drift(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired.Amount.String() != "950.00" || repaired.OpeningAmount.String() != "1050.00" {
+		t.Errorf("repaired keeping the stored balance to %v opening with %v, want 950.00 opening with 1050.00", repaired.Amount, repaired.OpeningAmount)
+	}
+
+	drifts, err = VerifyBalances(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Errorf("drifts after the repairs = %+v", drifts)
+	}
+}
@@ -0,0 +1,354 @@
+package greed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var ErrInvalidBudget = errors.New("invalid budget")
+
+type BudgetPeriod string
+
+const (
+	BudgetWeekly  BudgetPeriod = "week"
+	BudgetMonthly BudgetPeriod = "month"
+	BudgetYearly  BudgetPeriod = "year"
+)
+
+var BudgetPeriods = []BudgetPeriod{BudgetWeekly, BudgetMonthly, BudgetYearly}
+
+// Range returns the period containing at, weeks start on monday, DateEnd is exclusive
+func (p BudgetPeriod) Range(at time.Time) DateRange {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch p {
+	case BudgetWeekly:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return DateRange{start, start.AddDate(0, 0, 7)}
+	case BudgetYearly:
+		start := time.Date(day.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return DateRange{start, start.AddDate(1, 0, 0)}
+	default:
+		start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return DateRange{start, start.AddDate(0, 1, 0)}
+	}
+}
+
+// Budget limits the spending of a category in one currency per period
+type Budget struct {
+	Id       int64        `json:"id"`
+	Category Category     `json:"category"`
+	Currency string       `json:"currency"`
+	Period   BudgetPeriod `json:"period"`
+	Amount   Money        `json:"amount"`
+	// unspent or overspent amount of the past periods moves into the current one
+	Rollover  bool      `json:"rollover"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (b *Budget) ToJson() ([]byte, error) {
+	return json.Marshal(b)
+}
+
+func (b *Budget) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, b)
+}
+
+// validate checks the period and currency and rescales the amount to the currency
+func (b *Budget) validate() error {
+	validPeriod := false
+	for _, p := range BudgetPeriods {
+		validPeriod = validPeriod || b.Period == p
+	}
+	if !validPeriod {
+		return fmt.Errorf("%w: unknown period %q", ErrInvalidBudget, b.Period)
+	}
+
+	if !IsSupportedCurrency(b.Currency) {
+		return fmt.Errorf("%w: %w: %v", ErrInvalidBudget, ErrUnsupportedCurrency, b.Currency)
+	}
+
+	amount, err := b.Amount.Rescale(CurrencyExponent(b.Currency))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBudget, err)
+	}
+
+	if amount.Sign() <= 0 {
+		return fmt.Errorf("%w: amount has to be positive", ErrInvalidBudget)
+	}
+
+	b.Amount = amount
+	return nil
+}
+
+const budgetSelect = `
+	select
+		budgets.id, categories.id, categories.name, budgets.currency, budgets.period,
+		budgets.amount, budgets.rollover, budgets.created_at
+	from budgets
+	join categories on categories.id = budgets.category_id
+`
+
+func scanBudget(row rowScanner) (Budget, error) {
+	var b Budget
+	var amount int64
+	var createdAt string
+
+	if err := row.Scan(
+		&b.Id, &b.Category.Id, &b.Category.Name, &b.Currency, &b.Period,
+		&amount, &b.Rollover, &createdAt,
+	); err != nil {
+		return b, err
+	}
+
+	b.Amount = NewMoney(amount, CurrencyExponent(b.Currency))
+
+	parsedCreatedAt, err := ParseDbDatetime(createdAt)
+	if err != nil {
+		return b, err
+	}
+
+	b.CreatedAt = parsedCreatedAt
+	return b, nil
+}
+
+func GetBudgets[T DatabaseInterface](db T, userId int64) ([]Budget, error) {
+	var budgets []Budget
+
+	rows, err := db.Query(budgetSelect+"where budgets.user_id = ? order by budgets.currency, categories.name", userId)
+	if err != nil {
+		return nil, fmt.Errorf("fetch budgets failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b, err := scanBudget(rows)
+		if err != nil {
+			return nil, fmt.Errorf("fetch budgets row failed: %v", err)
+		}
+		budgets = append(budgets, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during budgets iteration: %v", err)
+	}
+
+	return budgets, nil
+}
+
+func GetBudgetById[T DatabaseInterface](db T, userId int64, id int64) (Budget, error) {
+	row := db.QueryRow(budgetSelect+"where budgets.id = ? and budgets.user_id = ?", id, userId)
+
+	b, err := scanBudget(row)
+	if err != nil {
+		return b, fmt.Errorf("fetch budget %v failed: %w", id, err)
+	}
+
+	return b, nil
+}
+
+func CreateBudget[T DatabaseInterface](db T, userId int64, budget Budget) (Budget, error) {
+	if err := budget.validate(); err != nil {
+		return budget, err
+	}
+
+	if _, err := GetCategoryById(db, userId, budget.Category.Id); err != nil {
+		return budget, fmt.Errorf("category %v of user %v: %w", budget.Category.Id, userId, err)
+	}
+
+	budget.CreatedAt = time.Now().UTC()
+
+	result, err := db.Exec(
+		`
+		insert into budgets (user_id, category_id, currency, period, amount, rollover, created_at)
+		values (?, ?, ?, ?, ?, ?, ?)
+		`,
+		userId, budget.Category.Id, budget.Currency, budget.Period, budget.Amount.Minor, budget.Rollover,
+		budget.CreatedAt.Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return budget, fmt.Errorf("%w: failed to create budget, is there one for the category, currency and period already? %v", ErrInvalidBudget, err)
+	}
+
+	if budget.Id, err = result.LastInsertId(); err != nil {
+		return budget, fmt.Errorf("failed to get last inserted budget id: %v", err)
+	}
+
+	return GetBudgetById(db, userId, budget.Id)
+}
+
+func UpdateBudget[T DatabaseInterface](db T, userId int64, budget Budget) (Budget, error) {
+	if err := budget.validate(); err != nil {
+		return budget, err
+	}
+
+	if _, err := GetCategoryById(db, userId, budget.Category.Id); err != nil {
+		return budget, fmt.Errorf("category %v of user %v: %w", budget.Category.Id, userId, err)
+	}
+
+	if _, err := GetBudgetById(db, userId, budget.Id); err != nil {
+		return budget, err
+	}
+
+	if _, err := db.Exec(
+		`
+		update budgets set category_id = ?, currency = ?, period = ?, amount = ?, rollover = ?
+		where id = ? and user_id = ?
+		`,
+		budget.Category.Id, budget.Currency, budget.Period, budget.Amount.Minor, budget.Rollover,
+		budget.Id, userId,
+	); err != nil {
+		return budget, fmt.Errorf("%w: failed to update budget %v, is there one for the category, currency and period already? %v", ErrInvalidBudget, budget.Id, err)
+	}
+
+	return GetBudgetById(db, userId, budget.Id)
+}
+
+func DeleteBudget[T DatabaseInterface](db T, userId int64, budgetId int64) error {
+	if _, err := GetBudgetById(db, userId, budgetId); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("delete from budgets where id = ? and user_id = ?", budgetId, userId); err != nil {
+		return fmt.Errorf("failed to delete budget %v: %v", budgetId, err)
+	}
+
+	return nil
+}
+
+// BudgetProgress is the state of a budget in the period containing the given time
+type BudgetProgress struct {
+	Budget      Budget    `json:"budget"`
+	PeriodStart time.Time `json:"period_start"`
+	// exclusive
+	PeriodEnd time.Time `json:"period_end"`
+	// amount of the budget plus the rollover of the past periods
+	Limit     Money `json:"limit"`
+	Spent     Money `json:"spent"`
+	Remaining Money `json:"remaining"`
+	// spent extrapolated to the whole period at the current pace
+	Projected Money `json:"projected"`
+}
+
+func (p BudgetProgress) Overspent() bool {
+	return p.Remaining.Sign() < 0
+}
+
+func (p BudgetProgress) ProjectedOverspent() bool {
+	return p.Projected.Cmp(p.Limit) > 0
+}
+
+// SpentPercent is the spent share of the limit capped at 100
+func (p BudgetProgress) SpentPercent() int {
+	if p.Limit.Sign() <= 0 || p.Spent.Cmp(p.Limit) >= 0 {
+		return 100
+	}
+	return int(p.Spent.Minor * 100 / p.Limit.Minor)
+}
+
+// getBudgetSpent sums expenses and split lines of the category in the currency within [from, to), transfers excluded
+func getBudgetSpent[T DatabaseInterface](db T, userId int64, budget Budget, from time.Time, to time.Time) (Money, error) {
+	var spent int64
+
+	// the budget of a category covers its subcategories too
+	row := db.QueryRow(
+		`
+		with recursive budget_categories(id) as (
+			select ?
+			union
+			select categories.id from categories
+			join budget_categories on categories.parent_id = budget_categories.id
+			where categories.user_id = ?
+		)
+		select coalesce(sum(-transactions.amount), 0) from `+transactionLines+`
+		join accounts on accounts.id = transactions.account_id
+		where transactions.user_id = ? and transactions.category_id in (select id from budget_categories) and accounts.currency = ?
+			and transactions.amount < 0 and transactions.transfer_id is null
+			and datetime(transactions.created_at) >= datetime(?) and datetime(transactions.created_at) < datetime(?)
+		`,
+		budget.Category.Id, userId, userId, budget.Currency, from.Format(DATETIME_DB_LAYOUT), to.Format(DATETIME_DB_LAYOUT),
+	)
+	if err := row.Scan(&spent); err != nil {
+		return Money{}, fmt.Errorf("failed to sum spent of budget %v: %v", budget.Id, err)
+	}
+
+	return NewMoney(spent, CurrencyExponent(budget.Currency)), nil
+}
+
+func GetBudgetProgress[T DatabaseInterface](db T, userId int64, budget Budget, at time.Time) (BudgetProgress, error) {
+	period := budget.Period.Range(at)
+	progress := BudgetProgress{Budget: budget, PeriodStart: period.DateStart, PeriodEnd: period.DateEnd, Limit: budget.Amount}
+
+	spent, err := getBudgetSpent(db, userId, budget, period.DateStart, period.DateEnd)
+	if err != nil {
+		return progress, err
+	}
+	progress.Spent = spent
+
+	if budget.Rollover {
+		first := budget.Period.Range(budget.CreatedAt).DateStart
+
+		periods := int64(0)
+		for p := first; p.Before(period.DateStart); p = budget.Period.Range(p).DateEnd {
+			periods++
+		}
+
+		if periods > 0 {
+			spentBefore, err := getBudgetSpent(db, userId, budget, first, period.DateStart)
+			if err != nil {
+				return progress, err
+			}
+
+			budgeted := NewMoney(budget.Amount.Minor*periods, budget.Amount.Exponent)
+			rolledOver, err := budgeted.Sub(spentBefore)
+			if err != nil {
+				return progress, err
+			}
+
+			if progress.Limit, err = progress.Limit.Add(rolledOver); err != nil {
+				return progress, err
+			}
+		}
+	}
+
+	if progress.Remaining, err = progress.Limit.Sub(progress.Spent); err != nil {
+		return progress, err
+	}
+
+	// at least a day has to pass for the pace to mean anything
+	elapsed := at.Sub(period.DateStart)
+	if elapsed < 24*time.Hour {
+		elapsed = 24 * time.Hour
+	}
+	total := period.DateEnd.Sub(period.DateStart)
+	if elapsed > total {
+		elapsed = total
+	}
+
+	progress.Projected, err = progress.Spent.Convert(big.NewRat(int64(total/time.Second), int64(elapsed/time.Second)), budget.Amount.Exponent)
+
+	return progress, err
+}
+
+// GetBudgetsProgress returns the progress of every budget of the user at the given time
+func GetBudgetsProgress[T DatabaseInterface](db T, userId int64, at time.Time) ([]BudgetProgress, error) {
+	budgets, err := GetBudgets(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]BudgetProgress, 0, len(budgets))
+	for _, b := range budgets {
+		progress, err := GetBudgetProgress(db, userId, b, at)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, progress)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package greed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudgetPeriodRange(t *testing.T) {
+	// 2026-03-11 is a wednesday
+	at := time.Date(2026, 3, 11, 15, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		period BudgetPeriod
+		start  time.Time
+		end    time.Time
+	}{
+		{BudgetWeekly, time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{BudgetMonthly, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{BudgetYearly, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		r := c.period.Range(at)
+		if !r.DateStart.Equal(c.start) || !r.DateEnd.Equal(c.end) {
+			t.Errorf("%v range at %v = %v - %v, want %v - %v", c.period, at, r.DateStart, r.DateEnd, c.start, c.end)
+		}
+	}
+}
+
+func TestBudgetProgress(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	account := testAccount(t, db, user.Id, "USD", "5000", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	euros := testAccount(t, db, user.Id, "EUR", "100", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	invalid := []Budget{
+		{Category: food, Currency: "USD", Period: "day", Amount: NewMoney(1, 0)},
+		{Category: food, Currency: "XXX", Period: BudgetMonthly, Amount: NewMoney(1, 0)},
+		{Category: food, Currency: "USD", Period: BudgetMonthly, Amount: NewMoney(0, 0)},
+		{Category: food, Currency: "USD", Period: BudgetMonthly, Amount: NewMoney(1, 3)},
+	}
+
+	for _, b := range invalid {
+		if _, err := CreateBudget(db, user.Id, b); !errors.Is(err, ErrInvalidBudget) {
+			t.Errorf("create %+v = %v, want %v", b, err, ErrInvalidBudget)
+		}
+	}
+
+	budget, err := CreateBudget(db, user.Id, Budget{Category: food, Currency: "USD", Period: BudgetMonthly, Amount: mustParseMoney(t, "300", "USD")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateBudget(db, user.Id, budget); !errors.Is(err, ErrInvalidBudget) {
+		t.Errorf("second budget of the category = %v, want %v", err, ErrInvalidBudget)
+	}
+
+	// rollover counts the periods from the creation of the budget
+	budget.CreatedAt = time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	if _, err := db.Exec("update budgets set created_at = ? where id = ?", budget.CreatedAt.Format(DATETIME_DB_LAYOUT), budget.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// overspent by 50 in january, underspent by 100 in february
+	testTransaction(t, db, user.Id, account, "-350", food, time.Date(2026, 1, 12, 12, 0, 0, 0, time.UTC), "party")
+	testTransaction(t, db, user.Id, account, "-200", food, time.Date(2026, 2, 3, 12, 0, 0, 0, time.UTC), "groceries")
+	testTransaction(t, db, user.Id, account, "-60", food, time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC), "groceries")
+	testTransaction(t, db, user.Id, account, "-40", food, time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC), "groceries")
+
+	// income and other currencies don't count
+	testTransaction(t, db, user.Id, account, "1000", food, time.Date(2026, 3, 5, 12, 0, 0, 0, time.UTC), "refund")
+	testTransaction(t, db, user.Id, euros, "-30", food, time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC), "abroad")
+
+	cases := []struct {
+		name               string
+		rollover           bool
+		at                 time.Time
+		limit              string
+		spent              string
+		remaining          string
+		projected          string
+		overspent          bool
+		projectedOverspent bool
+	}{
+		{"overspent month", false, time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC), "300.00", "350.00", "-50.00", "571.05", true, true},
+		{"overspent month rolled over", true, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC), "250.00", "200.00", "50.00", "400.00", false, true},
+		{"month after the overspent one", false, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC), "300.00", "200.00", "100.00", "400.00", false, true},
+		{"underspent month rolled over", true, time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC), "350.00", "100.00", "250.00", "310.00", false, false},
+		{"month after the underspent one", false, time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC), "300.00", "100.00", "200.00", "310.00", false, true},
+		// the pace is taken over at least a day
+		{"first hour of the month", false, time.Date(2026, 3, 1, 1, 0, 0, 0, time.UTC), "300.00", "100.00", "200.00", "3100.00", false, true},
+	}
+
+	for _, c := range cases {
+		budget.Rollover = c.rollover
+		if budget, err = UpdateBudget(db, user.Id, budget); err != nil {
+			t.Fatal(err)
+		}
+
+		progress, err := GetBudgetProgress(db, user.Id, budget, c.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if progress.Limit.String() != c.limit || progress.Spent.String() != c.spent || progress.Remaining.String() != c.remaining || progress.Projected.String() != c.projected {
+			t.Errorf("%v: limit %v, spent %v, remaining %v, projected %v, want %v, %v, %v, %v",
+				c.name, progress.Limit, progress.Spent, progress.Remaining, progress.Projected, c.limit, c.spent, c.remaining, c.projected)
+		}
+		if progress.Overspent() != c.overspent || progress.ProjectedOverspent() != c.projectedOverspent {
+			t.Errorf("%v: overspent %v, projected overspent %v, want %v, %v", c.name, progress.Overspent(), progress.ProjectedOverspent(), c.overspent, c.projectedOverspent)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package greed
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidCategory = errors.New("invalid category")
+
+// longest category name in runes
+const MaxCategoryNameLength = 64
+
+// CategoryTreeItem is a category with the number of its ancestors
+type CategoryTreeItem struct {
+	Category Category
+	Depth    int
+}
+
+// ActiveCategories drops archived categories except the kept ones, the ones already used by the edited item
+func ActiveCategories(categories []Category, keep ...int64) []Category {
+	var result []Category
+	for _, c := range categories {
+		if !c.Archived || containsId(keep, c.Id) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// categoryParents maps categories to their parents, categories with an unknown parent are top level
+func categoryParents(categories []Category) map[int64]int64 {
+	known := map[int64]bool{}
+	for _, c := range categories {
+		known[c.Id] = true
+	}
+
+	parents := map[int64]int64{}
+	for _, c := range categories {
+		if c.ParentId != 0 && known[c.ParentId] {
+			parents[c.Id] = c.ParentId
+		}
+	}
+	return parents
+}
+
+// categoryAncestors returns the category followed by its parents up to the top level one
+func categoryAncestors(parents map[int64]int64, id int64) []int64 {
+	ancestors := []int64{id}
+	for parent, ok := parents[id]; ok && !containsId(ancestors, parent); parent, ok = parents[parent] {
+		ancestors = append(ancestors, parent)
+	}
+	return ancestors
+}
+
+// CategoryTree orders the categories depth first, children follow their parent
+func CategoryTree(categories []Category) []CategoryTreeItem {
+	parents := categoryParents(categories)
+
+	children := map[int64][]Category{}
+	var roots []Category
+	for _, c := range categories {
+		if parent, ok := parents[c.Id]; ok {
+			children[parent] = append(children[parent], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	var result []CategoryTreeItem
+	visited := map[int64]bool{}
+
+	var walk func(c Category, depth int)
+	walk = func(c Category, depth int) {
+		if visited[c.Id] {
+			return
+		}
+		visited[c.Id] = true
+
+		result = append(result, CategoryTreeItem{Category: c, Depth: depth})
+		for _, child := range children[c.Id] {
+			walk(child, depth+1)
+		}
+	}
+
+	for _, c := range roots {
+		walk(c, 0)
+	}
+
+	return result
+}
+
+func (c *Category) validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCategory)
+	}
+
+	if len([]rune(c.Name)) > MaxCategoryNameLength {
+		return fmt.Errorf("%w: name is longer than %v characters", ErrInvalidCategory, MaxCategoryNameLength)
+	}
+
+	return nil
+}
+
+// checkCategoryParent makes sure the parent belongs to the user and isn't the category or one of its subcategories
+func checkCategoryParent[T DatabaseInterface](db T, userId int64, c Category) error {
+	if c.ParentId == 0 {
+		return nil
+	}
+
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		return err
+	}
+
+	var parent *Category
+	for i := range categories {
+		if categories[i].Id == c.ParentId {
+			parent = &categories[i]
+		}
+	}
+
+	if parent == nil {
+		return fmt.Errorf("%w: parent category %v doesn't exist", ErrInvalidCategory, c.ParentId)
+	}
+
+	if parent.Id == c.Id {
+		return fmt.Errorf("%w: %v can't be its own parent", ErrInvalidCategory, c.Name)
+	}
+
+	if c.Id != 0 && containsId(categoryAncestors(categoryParents(categories), parent.Id), c.Id) {
+		return fmt.Errorf("%w: %v can't be a subcategory of its subcategory %v", ErrInvalidCategory, c.Name, parent.Name)
+	}
+
+	return nil
+}
+
+// categoryNameError explains the unique constraint on names
+func categoryNameError(c Category, err error) error {
+	if strings.Contains(strings.ToLower(err.Error()), "unique") {
+		return fmt.Errorf("%w: there is a category named %v already", ErrInvalidCategory, c.Name)
+	}
+	return fmt.Errorf("failed to save category %v: %v", c.Name, err)
+}
+
+func nullableId(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func CreateCategory[T DatabaseInterface](db T, userId int64, c Category) (Category, error) {
+	if err := c.validate(); err != nil {
+		return c, err
+	}
+
+	c.Id = 0
+	c.Archived = false
+
+	if err := checkCategoryParent(db, userId, c); err != nil {
+		return c, err
+	}
+
+	result, err := db.Exec(
+		"insert into categories (user_id, name, parent_id) values (?, ?, ?)",
+		userId, c.Name, nullableId(c.ParentId),
+	)
+	if err != nil {
+		return c, categoryNameError(c, err)
+	}
+
+	if c.Id, err = result.LastInsertId(); err != nil {
+		return c, fmt.Errorf("failed to get last inserted category id %v: %v", c.Name, err)
+	}
+
+	return c, nil
+}
+
+// UpdateCategory renames, moves and archives or restores the category
+func UpdateCategory[T DatabaseInterface](db T, userId int64, c Category) (Category, error) {
+	if err := c.validate(); err != nil {
+		return c, err
+	}
+
+	if _, err := GetCategoryById(db, userId, c.Id); err != nil {
+		return c, err
+	}
+
+	if err := checkCategoryParent(db, userId, c); err != nil {
+		return c, err
+	}
+
+	if _, err := db.Exec(
+		"update categories set name = ?, parent_id = ?, archived = ? where id = ? and user_id = ?",
+		c.Name, nullableId(c.ParentId), c.Archived, c.Id, userId,
+	); err != nil {
+		return c, categoryNameError(c, err)
+	}
+
+	return c, nil
+}
+
+// isCategoryUsed tells if transactions, split lines, budgets, recurring transactions or rules refer to the category
+func isCategoryUsed[T DatabaseInterface](db T, userId int64, categoryId int64) (bool, error) {
+	var used bool
+
+	row := db.QueryRow(
+		`
+		select
+			exists (select 1 from transactions where category_id = ? and user_id = ?)
+			or exists (
+				select 1 from transaction_splits
+				join transactions on transactions.id = transaction_splits.transaction_id
+				where transaction_splits.category_id = ? and transactions.user_id = ?
+			)
+			or exists (select 1 from budgets where category_id = ? and user_id = ?)
+			or exists (select 1 from recurring_transactions where category_id = ? and user_id = ?)
+			or exists (select 1 from rules where category_id = ? and user_id = ?)
+		`,
+		categoryId, userId, categoryId, userId, categoryId, userId, categoryId, userId, categoryId, userId,
+	)
+	if err := row.Scan(&used); err != nil {
+		return false, fmt.Errorf("failed to check usage of category %v: %v", categoryId, err)
+	}
+
+	return used, nil
+}
+
+// DeleteCategory deletes an unused category, a used one is archived instead,
+// subcategories of a deleted category move to its parent
+func DeleteCategory[T DatabaseInterface](db T, userId int64, categoryId int64) (archived bool, err error) {
+	c, err := GetCategoryById(db, userId, categoryId)
+	if err != nil {
+		return false, err
+	}
+
+	used, err := isCategoryUsed(db, userId, categoryId)
+	if err != nil {
+		return false, err
+	}
+
+	if used {
+		if _, err := db.Exec("update categories set archived = 1 where id = ? and user_id = ?", categoryId, userId); err != nil {
+			return false, fmt.Errorf("failed to archive category %v: %v", categoryId, err)
+		}
+		return true, nil
+	}
+
+	if _, err := db.Exec(
+		"update categories set parent_id = ? where parent_id = ? and user_id = ?",
+		nullableId(c.ParentId), categoryId, userId,
+	); err != nil {
+		return false, fmt.Errorf("failed to move subcategories of category %v: %v", categoryId, err)
+	}
+
+	if _, err := db.Exec("delete from categories where id = ? and user_id = ?", categoryId, userId); err != nil {
+		return false, fmt.Errorf("failed to delete category %v: %v", categoryId, err)
+	}
+
+	return false, nil
+}
+
+// MergeCategory moves everything of the category into the other one and deletes it in one db transaction,
+// budgets clashing with a budget of the other category are dropped
+func MergeCategory(db *sql.DB, userId int64, fromId int64, intoId int64) (Category, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Category{}, err
+	}
+	defer tx.Rollback()
+
+	from, err := GetCategoryById(tx, userId, fromId)
+	if err != nil {
+		return from, err
+	}
+
+	into, err := GetCategoryById(tx, userId, intoId)
+	if err != nil {
+		return into, err
+	}
+
+	if from.Id == into.Id {
+		return into, fmt.Errorf("%w: can't merge %v into itself", ErrInvalidCategory, from.Name)
+	}
+
+	if into.Archived {
+		return into, fmt.Errorf("%w: can't merge into the archived %v", ErrInvalidCategory, into.Name)
+	}
+
+	categories, err := GetCategories(tx, userId)
+	if err != nil {
+		return into, err
+	}
+
+	// a subcategory merged into one of its parents takes the place of the merged one
+	if containsId(categoryAncestors(categoryParents(categories), into.Id), from.Id) {
+		into.ParentId = from.ParentId
+		if _, err := tx.Exec("update categories set parent_id = ? where id = ?", nullableId(into.ParentId), into.Id); err != nil {
+			return into, fmt.Errorf("failed to move category %v: %v", into.Id, err)
+		}
+	}
+
+	if _, err := tx.Exec(
+		`
+		delete from budgets where category_id = ? and user_id = ? and exists (
+			select 1 from budgets other
+			where other.category_id = ? and other.user_id = budgets.user_id
+				and other.currency = budgets.currency and other.period = budgets.period
+		)
+		`,
+		from.Id, userId, into.Id,
+	); err != nil {
+		return into, fmt.Errorf("failed to drop clashing budgets of category %v: %v", from.Id, err)
+	}
+
+	for _, query := range []string{
+		"update transactions set category_id = ? where category_id = ? and user_id = ?",
+		"update budgets set category_id = ? where category_id = ? and user_id = ?",
+		"update recurring_transactions set category_id = ? where category_id = ? and user_id = ?",
+		"update rules set category_id = ? where category_id = ? and user_id = ?",
+		"update categories set parent_id = ? where parent_id = ? and user_id = ?",
+	} {
+		if _, err := tx.Exec(query, into.Id, from.Id, userId); err != nil {
+			return into, fmt.Errorf("failed to merge category %v into %v: %v", from.Id, into.Id, err)
+		}
+	}
+
+	if _, err := tx.Exec(
+		`
+		update transaction_splits set category_id = ?
+		where category_id = ? and transaction_id in (select id from transactions where user_id = ?)
+		`,
+		into.Id, from.Id, userId,
+	); err != nil {
+		return into, fmt.Errorf("failed to merge split lines of category %v into %v: %v", from.Id, into.Id, err)
+	}
+
+	if _, err := tx.Exec("delete from categories where id = ? and user_id = ?", from.Id, userId); err != nil {
+		return into, fmt.Errorf("failed to delete merged category %v: %v", from.Id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return into, err
+	}
+
+	return into, nil
+}
+
+// rollUpCategoriesSpent nests the spending of subcategories under their parents,
+// the value of a category includes its subcategories, categories are sorted by value
+func rollUpCategoriesSpent(categories []Category, currency string, spent []CategorySpent) ([]CategorySpent, error) {
+	parents := categoryParents(categories)
+
+	byId := map[int64]Category{}
+	for _, c := range categories {
+		byId[c.Id] = c
+	}
+
+	totals := map[int64]*CategorySpent{}
+	for _, cs := range spent {
+		for _, id := range categoryAncestors(parents, cs.Category.Id) {
+			total, ok := totals[id]
+			if !ok {
+				category, known := byId[id]
+				if !known {
+					category = cs.Category
+				}
+				total = &CategorySpent{Category: category, Value: CurrencyAmount{Currency: currency, Amount: ZeroMoney(currency)}}
+				totals[id] = total
+			}
+			amount, err := total.Value.Amount.Add(cs.Value.Amount)
+			if err != nil {
+				return nil, err
+			}
+			total.Value.Amount = amount
+		}
+	}
+
+	children := map[int64][]int64{}
+	var roots []int64
+	for id := range totals {
+		if parent, ok := parents[id]; ok {
+			children[parent] = append(children[parent], id)
+		} else {
+			roots = append(roots, id)
+		}
+	}
+
+	var nest func(ids []int64) []CategorySpent
+	nest = func(ids []int64) []CategorySpent {
+		var result []CategorySpent
+		for _, id := range ids {
+			cs := *totals[id]
+			cs.Subcategories = nest(children[id])
+			result = append(result, cs)
+		}
+
+		sort.SliceStable(result, func(i, j int) bool {
+			if cmp := result[i].Value.Amount.Cmp(result[j].Value.Amount); cmp != 0 {
+				return cmp > 0
+			}
+			return result[i].Category.Id < result[j].Category.Id
+		})
+		return result
+	}
+
+	return nest(roots), nil
+}
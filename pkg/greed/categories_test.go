@@ -0,0 +1,204 @@
+package greed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testSubcategory(t *testing.T, db DatabaseInterface, userId int64, name string, parent Category) Category {
+	t.Helper()
+
+	c, err := CreateCategory(db, userId, Category{Name: name, ParentId: parent.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestCategoryHierarchy(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	groceries := testSubcategory(t, db, user.Id, "Groceries", food)
+	organic := testSubcategory(t, db, user.Id, "Organic", groceries)
+
+	invalid := []struct {
+		name     string
+		category Category
+	}{
+		{"own parent", Category{Id: food.Id, Name: food.Name, ParentId: food.Id}},
+		{"parent is a subcategory", Category{Id: food.Id, Name: food.Name, ParentId: organic.Id}},
+		{"unknown parent", Category{Id: groceries.Id, Name: groceries.Name, ParentId: organic.Id + 100}},
+		{"taken name", Category{Id: organic.Id, Name: " Groceries ", ParentId: groceries.Id}},
+		{"no name", Category{Id: organic.Id, Name: " ", ParentId: groceries.Id}},
+	}
+
+	for _, c := range invalid {
+		if _, err := UpdateCategory(db, user.Id, c.category); !errors.Is(err, ErrInvalidCategory) {
+			t.Errorf("%v: update = %v, want %v", c.name, err, ErrInvalidCategory)
+		}
+	}
+
+	categories, err := GetCategories(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	depths := map[int64]int{}
+	position := map[int64]int{}
+	for i, item := range CategoryTree(categories) {
+		depths[item.Category.Id] = item.Depth
+		position[item.Category.Id] = i
+	}
+	if depths[food.Id] != 0 || depths[groceries.Id] != 1 || depths[organic.Id] != 2 {
+		t.Errorf("depths of food, groceries, organic = %v, %v, %v, want 0, 1, 2", depths[food.Id], depths[groceries.Id], depths[organic.Id])
+	}
+	if position[groceries.Id] != position[food.Id]+1 || position[organic.Id] != position[food.Id]+2 {
+		t.Errorf("subcategories don't follow their parent: %v", position)
+	}
+}
+
+func TestDeleteCategory(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	groceries := testSubcategory(t, db, user.Id, "Groceries", food)
+	organic := testSubcategory(t, db, user.Id, "Organic", groceries)
+	account := testAccount(t, db, user.Id, "USD", "100", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	testTransaction(t, db, user.Id, account, "-10", organic, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), "veggies")
+
+	// the used category is archived and stays
+	if archived, err := DeleteCategory(db, user.Id, organic.Id); err != nil || !archived {
+		t.Fatalf("delete of a used category archived %v, %v", archived, err)
+	}
+
+	// the unused one is gone and its subcategories move up
+	if archived, err := DeleteCategory(db, user.Id, groceries.Id); err != nil || archived {
+		t.Fatalf("delete of an unused category archived %v, %v", archived, err)
+	}
+
+	organic, err := GetCategoryById(db, user.Id, organic.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !organic.Archived || organic.ParentId != food.Id {
+		t.Errorf("archived subcategory = %+v, want archived under %v", organic, food.Id)
+	}
+
+	if _, err := GetCategoryById(db, user.Id, groceries.Id); err == nil {
+		t.Errorf("deleted category still exists")
+	}
+
+	categories, err := GetCategories(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active := ActiveCategories(categories); len(active) != len(categories)-1 {
+		t.Errorf("%v active of %v categories, want the archived one dropped", len(active), len(categories))
+	}
+	if active := ActiveCategories(categories, organic.Id); len(active) != len(categories) {
+		t.Errorf("%v active of %v categories, want the kept archived one", len(active), len(categories))
+	}
+}
+
+func TestMergeCategory(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	beauty := testCategory(t, db, user.Id, "💆 Beauty and Health")
+	groceries := testSubcategory(t, db, user.Id, "Groceries", food)
+	organic := testSubcategory(t, db, user.Id, "Organic", groceries)
+	account := testAccount(t, db, user.Id, "USD", "1000", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	shop := testTransaction(t, db, user.Id, account, "-50", groceries, createdAt, "supermarket")
+	pharmacy := testTransaction(t, db, user.Id, account, "-30", beauty, createdAt, "pharmacy")
+	if _, err := SetTransactionSplits(db, user.Id, pharmacy.Id, []Split{
+		{Category: beauty, Amount: mustParseMoney(t, "-20", "USD")},
+		{Category: groceries, Amount: mustParseMoney(t, "-10", "USD")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	budget := func(category Category, period BudgetPeriod, amount string) Budget {
+		t.Helper()
+
+		b, err := CreateBudget(db, user.Id, Budget{Category: category, Currency: "USD", Period: period, Amount: mustParseMoney(t, amount, "USD")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	kept := budget(food, BudgetMonthly, "500")
+	clashing := budget(groceries, BudgetMonthly, "300")
+	moved := budget(groceries, BudgetWeekly, "70")
+
+	if _, err := MergeCategory(db, user.Id, groceries.Id, groceries.Id); !errors.Is(err, ErrInvalidCategory) {
+		t.Errorf("merge into itself = %v, want %v", err, ErrInvalidCategory)
+	}
+
+	archived := testSubcategory(t, db, user.Id, "Old", food)
+	archived.Archived = true
+	if _, err := UpdateCategory(db, user.Id, archived); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MergeCategory(db, user.Id, groceries.Id, archived.Id); !errors.Is(err, ErrInvalidCategory) {
+		t.Errorf("merge into an archived category = %v, want %v", err, ErrInvalidCategory)
+	}
+
+	if _, err := MergeCategory(db, user.Id, groceries.Id, food.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetCategoryById(db, user.Id, groceries.Id); err == nil {
+		t.Errorf("merged category still exists")
+	}
+
+	if shop, err := GetTransactionById(db, user.Id, shop.Id); err != nil || shop.Category.Id != food.Id {
+		t.Errorf("merged transaction = %+v, %v", shop, err)
+	}
+
+	pharmacy, err := GetTransactionById(db, user.Id, pharmacy.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pharmacy.Category.Id != beauty.Id || len(pharmacy.Splits) != 2 || pharmacy.Splits[1].Category.Id != food.Id {
+		t.Errorf("merged split lines = %+v", pharmacy.Splits)
+	}
+
+	// the subcategory of the merged category moves under the other one
+	if organic, err := GetCategoryById(db, user.Id, organic.Id); err != nil || organic.ParentId != food.Id {
+		t.Errorf("subcategory of the merged category = %+v, %v", organic, err)
+	}
+
+	// the monthly budget clashes with the one of food and is dropped, the weekly one moves
+	budgets, err := GetBudgets(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[int64]Budget{}
+	for _, b := range budgets {
+		ids[b.Id] = b
+	}
+	if len(budgets) != 2 || ids[kept.Id].Amount.String() != "500.00" || ids[moved.Id].Category.Id != food.Id {
+		t.Errorf("budgets after the merge = %+v", budgets)
+	}
+	if _, ok := ids[clashing.Id]; ok {
+		t.Errorf("clashing budget %v is kept", clashing.Id)
+	}
+
+	// a category merged into its subcategory hands it its place
+	if _, err := MergeCategory(db, user.Id, food.Id, organic.Id); err != nil {
+		t.Fatal(err)
+	}
+	if organic, err := GetCategoryById(db, user.Id, organic.Id); err != nil || organic.ParentId != 0 {
+		t.Errorf("category after its parent merged into it = %+v, %v", organic, err)
+	}
+
+	if account, err := GetAccountById(db, user.Id, account.Id); err != nil || account.Amount.String() != "920.00" {
+		t.Errorf("account after the merges = %+v, %v", account, err)
+	}
+}
@@ -40,3 +40,46 @@ var SupportedCurrencies = []string{
 	"JPY",
 	"RSD",
 }
+
+// number of fractional digits (minor units) used to store amounts per currency
+var CurrencyExponents = map[string]uint8{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"RSD": 2,
+}
+
+const DefaultCurrencyExponent uint8 = 2
+
+const DefaultReportingCurrency = "USD"
+
+func IsSupportedCurrency(currency string) bool {
+	for _, c := range SupportedCurrencies {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
+
+func CurrencyExponent(currency string) uint8 {
+	if exponent, ok := CurrencyExponents[currency]; ok {
+		return exponent
+	}
+	return DefaultCurrencyExponent
+}
+
+// categories every new user starts with, same as seeded by migrations/v1_init.sql
+var DefaultCategories = []string{
+	"🗑️ Other",
+	"💰 Finance",
+	"🍖 Food and drinks",
+	"🍱 Eating out",
+	"🏠 Rent and Housing",
+	"🎮 Fun",
+	"🧭 Travel",
+	"🧾 Bills and Taxes",
+	"💆 Beauty and Health",
+	"💱 Exchange",
+}
@@ -0,0 +1,94 @@
+package greed
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TransactionCursor points at the last transaction of a page, the next page starts right after it,
+// so transactions added while paging don't shift the pages
+type TransactionCursor struct {
+	CreatedAt time.Time
+	Id        int64
+	// relevance of the transaction in searches ranked by description, nil when it didn't match by description
+	Rank *float64
+}
+
+// String encodes the cursor for query params, clients should treat it as opaque
+func (c TransactionCursor) String() string {
+	value := fmt.Sprintf("%d:%d", c.CreatedAt.Unix(), c.Id)
+	if c.Rank != nil {
+		value += ":" + strconv.FormatFloat(*c.Rank, 'g', -1, 64)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// ParseTransactionCursor decodes the cursor made by TransactionCursor.String
+func ParseTransactionCursor(s string) (TransactionCursor, error) {
+	var cursor TransactionCursor
+
+	value, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, s)
+	}
+
+	parts := strings.Split(string(value), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, s)
+	}
+
+	createdAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, s)
+	}
+
+	if cursor.Id, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, s)
+	}
+
+	if len(parts) == 3 {
+		rank, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, s)
+		}
+		cursor.Rank = &rank
+	}
+
+	cursor.CreatedAt = time.Unix(createdAt, 0).UTC()
+	return cursor, nil
+}
+
+// transactionCursor points at the transaction as listed by GetTransactions
+func transactionCursor(t Transaction) TransactionCursor {
+	return TransactionCursor{CreatedAt: t.CreatedAt, Id: t.Id, Rank: t.rank}
+}
+
+// after matches the transactions listed after the cursor, newest first and by id within the same second
+func (c TransactionCursor) after() sq.Sqlizer {
+	return sq.Expr(
+		"(transactions.created_at, transactions.id) < (?, ?)",
+		c.CreatedAt.UTC().Format(DATETIME_DB_LAYOUT), c.Id,
+	)
+}
+
+// afterRanked matches the transactions listed after the cursor in searches ranked by description,
+// the matches go first by rank and the rest after them by date
+func (c TransactionCursor) afterRanked() sq.Sqlizer {
+	if c.Rank == nil {
+		return sq.And{sq.Expr("fts.rank is null"), c.after()}
+	}
+
+	return sq.Or{
+		sq.Expr("fts.rank is null"),
+		sq.Expr("fts.rank > ?", *c.Rank),
+		sq.And{sq.Expr("fts.rank = ?", *c.Rank), c.after()},
+	}
+}
@@ -0,0 +1,103 @@
+package greed
+
+import (
+	"database/sql"
+	"path/filepath"
+	schema "supersolik/greed/migrations"
+	"testing"
+	"time"
+)
+
+// openTestDb opens an empty db file removed after the test
+func openTestDb(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("libsql", "file://"+filepath.Join(t.TempDir(), "greed.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// newTestDb migrates a fresh db file and signs up a user with the default categories
+func newTestDb(t *testing.T) (*sql.DB, User) {
+	t.Helper()
+
+	db := openTestDb(t)
+
+	migrations, err := LoadMigrations(schema.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(db, migrations, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, testUser(t, db, "alice")
+}
+
+// testUser signs up one more user with the default categories
+func testUser(t *testing.T, db *sql.DB, username string) User {
+	t.Helper()
+
+	user, err := SignupUser(db, username, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func testCategory(t *testing.T, db *sql.DB, userId int64, name string) Category {
+	t.Helper()
+
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range categories {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	t.Fatalf("no category %q", name)
+	return Category{}
+}
+
+func testAccount(t *testing.T, db *sql.DB, userId int64, currency string, openingAmount string, openingDate time.Time) Account {
+	t.Helper()
+
+	account, err := CreateAccount(db, userId, currency+" account", mustParseMoney(t, openingAmount, currency), openingDate, currency, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+func testTransaction(t *testing.T, db *sql.DB, userId int64, account Account, amount string, category Category, createdAt time.Time, description string) Transaction {
+	t.Helper()
+
+	transaction, err := CreateTransactionWithRecalc(db, userId, account, mustParseMoney(t, amount, account.Currency), category, createdAt, description)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transaction
+}
+
+func mustParseMoney(t *testing.T, x string, currency string) Money {
+	t.Helper()
+
+	m, err := ParseCurrencyMoney(x, currency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
@@ -0,0 +1,1556 @@
+package greed
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportVersion is bumped whenever the export document changes incompatibly
+const ExportVersion = 1
+
+var ErrInvalidExport = errors.New("invalid export")
+var ErrUserHasData = errors.New("user already has accounts or transactions")
+
+type ExportAccount struct {
+	Id       int64  `json:"id"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Amount   Money  `json:"amount"`
+	// balance before the exported transactions, amount = opening_amount + sum of transactions
+	OpeningAmount Money `json:"opening_amount"`
+	// nil in exports made before accounts had one, the restore opens the account with its first transaction then
+	OpeningDate *time.Time `json:"opening_date"`
+	Description string     `json:"description"`
+}
+
+type ExportTransaction struct {
+	Id          int64     `json:"id"`
+	AccountId   int64     `json:"account_id"`
+	CategoryId  int64     `json:"category_id"`
+	Amount      Money     `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+	Description string    `json:"description"`
+	// both legs of a transfer share the id
+	TransferId int64    `json:"transfer_id,omitempty"`
+	ExternalId string   `json:"external_id,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// lines of a split transaction, they add up to the amount
+	Splits  []ExportSplit `json:"splits,omitempty"`
+	Cleared bool          `json:"cleared,omitempty"`
+	// reconciled transactions are cleared and locked
+	ReconciliationId int64 `json:"reconciliation_id,omitempty"`
+	PayeeId          int64 `json:"payee_id,omitempty"`
+}
+
+type ExportSplit struct {
+	CategoryId int64  `json:"category_id"`
+	Amount     Money  `json:"amount"`
+	Memo       string `json:"memo,omitempty"`
+}
+
+type ExportBudget struct {
+	Id         int64        `json:"id"`
+	CategoryId int64        `json:"category_id"`
+	Currency   string       `json:"currency"`
+	Period     BudgetPeriod `json:"period"`
+	Amount     Money        `json:"amount"`
+	Rollover   bool         `json:"rollover"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+type ExportRecurring struct {
+	Id          int64              `json:"id"`
+	AccountId   int64              `json:"account_id"`
+	CategoryId  int64              `json:"category_id"`
+	Amount      Money              `json:"amount"`
+	Description string             `json:"description"`
+	Frequency   RecurringFrequency `json:"frequency"`
+	Every       int                `json:"every"`
+	StartsAt    time.Time          `json:"starts_at"`
+	EndsAt      *time.Time         `json:"ends_at"`
+	NextAt      *time.Time         `json:"next_at"`
+	Paused      bool               `json:"paused"`
+}
+
+type ExportReconciliation struct {
+	Id               int64     `json:"id"`
+	AccountId        int64     `json:"account_id"`
+	StatementDate    time.Time `json:"statement_date"`
+	StatementBalance Money     `json:"statement_balance"`
+	// id of the adjustment transaction, 0 when the statement matched
+	AdjustmentId int64     `json:"adjustment_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type ExportRule struct {
+	Id                  int64  `json:"id"`
+	Name                string `json:"name"`
+	Position            int    `json:"position"`
+	DescriptionContains string `json:"description_contains"`
+	DescriptionRegex    string `json:"description_regex"`
+	// 0 for any account
+	AccountId int64          `json:"account_id,omitempty"`
+	AmountMin *Money         `json:"amount_min"`
+	AmountMax *Money         `json:"amount_max"`
+	Weekdays  []time.Weekday `json:"weekdays"`
+	// 0 keeps the category
+	CategoryId  int64     `json:"category_id,omitempty"`
+	Tags        []string  `json:"tags"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ExportPayee struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export is the whole data of a user, ids are only meaningful within the document
+type Export struct {
+	Version         int                    `json:"version"`
+	ExportedAt      time.Time              `json:"exported_at"`
+	Categories      []Category             `json:"categories"`
+	Accounts        []ExportAccount        `json:"accounts"`
+	Transactions    []ExportTransaction    `json:"transactions"`
+	Budgets         []ExportBudget         `json:"budgets"`
+	Recurring       []ExportRecurring      `json:"recurring"`
+	Reconciliations []ExportReconciliation `json:"reconciliations"`
+	Rules           []ExportRule           `json:"rules"`
+	Payees          []ExportPayee          `json:"payees"`
+}
+
+func GetExport[T DatabaseInterface](db T, userId int64) (Export, error) {
+	export := Export{
+		Version:      ExportVersion,
+		ExportedAt:   time.Now().UTC(),
+		Categories:   []Category{},
+		Accounts:     []ExportAccount{},
+		Transactions: []ExportTransaction{},
+		Budgets:      []ExportBudget{},
+		Recurring:    []ExportRecurring{},
+		// accounts fill it in
+		Reconciliations: []ExportReconciliation{},
+		Rules:           []ExportRule{},
+		Payees:          []ExportPayee{},
+	}
+
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		return export, err
+	}
+	export.Categories = append(export.Categories, categories...)
+
+	accounts, err := GetAccounts(db, userId)
+	if err != nil {
+		return export, err
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Id < accounts[j].Id })
+
+	currencies := map[int64]string{}
+	for _, a := range accounts {
+		currencies[a.Id] = a.Currency
+	}
+
+	rows, err := db.Query(
+		`
+		select id, account_id, category_id, amount, created_at, description, transfer_id, external_id, cleared, reconciliation_id,
+			payee_id
+		from transactions where user_id = ? order by id
+		`,
+		userId,
+	)
+	if err != nil {
+		return export, fmt.Errorf("fetch transactions for export failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t ExportTransaction
+		var amount int64
+		var createdAt string
+		var transferId, reconciliationId, payeeId sql.NullInt64
+		var externalId sql.NullString
+
+		if err := rows.Scan(
+			&t.Id, &t.AccountId, &t.CategoryId, &amount, &createdAt, &t.Description, &transferId, &externalId,
+			&t.Cleared, &reconciliationId, &payeeId,
+		); err != nil {
+			return export, fmt.Errorf("fetch transactions row for export failed: %v", err)
+		}
+
+		if t.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+			return export, err
+		}
+
+		t.Amount = NewMoney(amount, CurrencyExponent(currencies[t.AccountId]))
+		t.TransferId = transferId.Int64
+		t.ExternalId = externalId.String
+		t.ReconciliationId = reconciliationId.Int64
+		t.PayeeId = payeeId.Int64
+
+		export.Transactions = append(export.Transactions, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return export, fmt.Errorf("error during transactions iteration: %v", err)
+	}
+
+	tags, err := getExportTags(db, userId)
+	if err != nil {
+		return export, err
+	}
+
+	splits, err := getExportSplits(db, userId, currencies)
+	if err != nil {
+		return export, err
+	}
+
+	for i := range export.Transactions {
+		export.Transactions[i].Tags = tags[export.Transactions[i].Id]
+		export.Transactions[i].Splits = splits[export.Transactions[i].Id]
+	}
+
+	budgets, err := GetBudgets(db, userId)
+	if err != nil {
+		return export, err
+	}
+
+	for _, b := range budgets {
+		export.Budgets = append(export.Budgets, ExportBudget{
+			Id:         b.Id,
+			CategoryId: b.Category.Id,
+			Currency:   b.Currency,
+			Period:     b.Period,
+			Amount:     b.Amount,
+			Rollover:   b.Rollover,
+			CreatedAt:  b.CreatedAt,
+		})
+	}
+
+	recurring, err := GetRecurringTransactions(db, userId)
+	if err != nil {
+		return export, err
+	}
+
+	for _, r := range recurring {
+		export.Recurring = append(export.Recurring, ExportRecurring{
+			Id:          r.Id,
+			AccountId:   r.Account.Id,
+			CategoryId:  r.Category.Id,
+			Amount:      r.Amount,
+			Description: r.Description,
+			Frequency:   r.Frequency,
+			Every:       r.Every,
+			StartsAt:    r.StartsAt,
+			EndsAt:      r.EndsAt,
+			NextAt:      r.NextAt,
+			Paused:      r.Paused,
+		})
+	}
+
+	rules, err := GetRules(db, userId)
+	if err != nil {
+		return export, err
+	}
+
+	for _, r := range rules {
+		rule := ExportRule{
+			Id:                  r.Id,
+			Name:                r.Name,
+			Position:            r.Position,
+			DescriptionContains: r.DescriptionContains,
+			DescriptionRegex:    r.DescriptionRegex,
+			AmountMin:           r.AmountMin,
+			AmountMax:           r.AmountMax,
+			Weekdays:            r.Weekdays,
+			Tags:                r.Tags,
+			Description:         r.Description,
+			CreatedAt:           r.CreatedAt,
+		}
+		if r.Account != nil {
+			rule.AccountId = r.Account.Id
+		}
+		if r.Category != nil {
+			rule.CategoryId = r.Category.Id
+		}
+		export.Rules = append(export.Rules, rule)
+	}
+
+	payees, err := GetPayees(db, userId)
+	if err != nil {
+		return export, err
+	}
+
+	for _, p := range payees {
+		aliases := p.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+
+		export.Payees = append(export.Payees, ExportPayee{Id: p.Id, Name: p.Name, Aliases: aliases, CreatedAt: p.CreatedAt})
+	}
+
+	for _, a := range accounts {
+		reconciliations, err := GetReconciliations(db, userId, a.Id)
+		if err != nil {
+			return export, err
+		}
+
+		// oldest first so that a restore keeps the order of the ids
+		for i := len(reconciliations) - 1; i >= 0; i-- {
+			r := reconciliations[i]
+			export.Reconciliations = append(export.Reconciliations, ExportReconciliation{
+				Id:               r.Id,
+				AccountId:        r.AccountId,
+				StatementDate:    r.StatementDate,
+				StatementBalance: r.StatementBalance,
+				AdjustmentId:     r.AdjustmentId,
+				CreatedAt:        r.CreatedAt,
+			})
+		}
+
+		openingDate := a.OpeningDate
+		export.Accounts = append(export.Accounts, ExportAccount{
+			Id:            a.Id,
+			Name:          a.Name,
+			Currency:      a.Currency,
+			Amount:        a.Amount,
+			OpeningAmount: a.OpeningAmount,
+			OpeningDate:   &openingDate,
+			Description:   a.Description,
+		})
+	}
+
+	return export, nil
+}
+
+// getExportTags returns tag names of all transactions of the user by transaction id
+func getExportTags[T DatabaseInterface](db T, userId int64) (map[int64][]string, error) {
+	tags := map[int64][]string{}
+
+	rows, err := db.Query(
+		`
+		select transaction_tags.transaction_id, tags.name
+		from transaction_tags join tags on tags.id = transaction_tags.tag_id
+		where tags.user_id = ? order by tags.name
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch tags for export failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionId int64
+		var name string
+		if err := rows.Scan(&transactionId, &name); err != nil {
+			return nil, fmt.Errorf("fetch tags row for export failed: %v", err)
+		}
+		tags[transactionId] = append(tags[transactionId], name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during tags iteration: %v", err)
+	}
+
+	return tags, nil
+}
+
+// getExportSplits returns split lines of all transactions of the user by transaction id
+func getExportSplits[T DatabaseInterface](db T, userId int64, currencies map[int64]string) (map[int64][]ExportSplit, error) {
+	splits := map[int64][]ExportSplit{}
+
+	rows, err := db.Query(
+		`
+		select transaction_splits.transaction_id, transactions.account_id, transaction_splits.category_id,
+			transaction_splits.amount, transaction_splits.memo
+		from transaction_splits join transactions on transactions.id = transaction_splits.transaction_id
+		where transactions.user_id = ? order by transaction_splits.id
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch splits for export failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionId, accountId, amount int64
+		var s ExportSplit
+		if err := rows.Scan(&transactionId, &accountId, &s.CategoryId, &amount, &s.Memo); err != nil {
+			return nil, fmt.Errorf("fetch splits row for export failed: %v", err)
+		}
+		s.Amount = NewMoney(amount, CurrencyExponent(currencies[accountId]))
+		splits[transactionId] = append(splits[transactionId], s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during splits iteration: %v", err)
+	}
+
+	return splits, nil
+}
+
+func invalidExport(format string, args ...any) error {
+	return fmt.Errorf("%w: %v", ErrInvalidExport, fmt.Sprintf(format, args...))
+}
+
+// Validate checks references between the records, transfer legs and that account balances add up,
+// amounts are rescaled to the currencies of their accounts
+func (e *Export) Validate() error {
+	if e.Version != ExportVersion {
+		return invalidExport("unsupported version %v, expected %v", e.Version, ExportVersion)
+	}
+
+	categories := map[int64]bool{}
+	for _, c := range e.Categories {
+		if categories[c.Id] {
+			return invalidExport("duplicate category id %v", c.Id)
+		}
+		if c.Name == "" {
+			return invalidExport("category %v has no name", c.Id)
+		}
+		categories[c.Id] = true
+	}
+
+	parents := categoryParents(e.Categories)
+	for _, c := range e.Categories {
+		if c.ParentId != 0 && !categories[c.ParentId] {
+			return invalidExport("category %v: unknown parent category %v", c.Id, c.ParentId)
+		}
+
+		// the chain of parents ends at a top level category unless it loops
+		ancestors := categoryAncestors(parents, c.Id)
+		if _, ok := parents[ancestors[len(ancestors)-1]]; ok {
+			return invalidExport("category %v is its own parent through its parent categories", c.Id)
+		}
+	}
+
+	accounts := map[int64]*ExportAccount{}
+	balances := map[int64]Money{}
+
+	for i := range e.Accounts {
+		a := &e.Accounts[i]
+
+		if accounts[a.Id] != nil {
+			return invalidExport("duplicate account id %v", a.Id)
+		}
+		if !IsSupportedCurrency(a.Currency) {
+			return invalidExport("account %v: unsupported currency %v", a.Id, a.Currency)
+		}
+
+		var err error
+		if a.Amount, err = a.Amount.Rescale(CurrencyExponent(a.Currency)); err != nil {
+			return invalidExport("account %v: %v", a.Id, err)
+		}
+		if a.OpeningAmount, err = a.OpeningAmount.Rescale(CurrencyExponent(a.Currency)); err != nil {
+			return invalidExport("account %v: %v", a.Id, err)
+		}
+
+		accounts[a.Id] = a
+		balances[a.Id] = a.OpeningAmount
+	}
+
+	payees := map[int64]bool{}
+	var validated []Payee
+	for i := range e.Payees {
+		p := &e.Payees[i]
+
+		if payees[p.Id] {
+			return invalidExport("duplicate payee id %v", p.Id)
+		}
+		payees[p.Id] = true
+
+		payee := Payee{Id: p.Id, Name: p.Name, Aliases: p.Aliases}
+		if err := payee.validate(); err != nil {
+			return invalidExport("payee %v: %v", p.Id, err)
+		}
+		if err := payeeConflict(validated, payee); err != nil {
+			return invalidExport("payee %v: %v", p.Id, err)
+		}
+		validated = append(validated, payee)
+
+		p.Name = payee.Name
+		p.Aliases = payee.Aliases
+	}
+
+	// account of each transaction
+	transactions := map[int64]int64{}
+	transfers := map[int64][]ExportTransaction{}
+
+	for i := range e.Transactions {
+		t := &e.Transactions[i]
+
+		if _, ok := transactions[t.Id]; ok {
+			return invalidExport("duplicate transaction id %v", t.Id)
+		}
+		transactions[t.Id] = t.AccountId
+
+		account := accounts[t.AccountId]
+		if account == nil {
+			return invalidExport("transaction %v: unknown account %v", t.Id, t.AccountId)
+		}
+		if !categories[t.CategoryId] {
+			return invalidExport("transaction %v: unknown category %v", t.Id, t.CategoryId)
+		}
+		if t.CreatedAt.IsZero() {
+			return invalidExport("transaction %v: no created_at", t.Id)
+		}
+
+		var err error
+		if t.Amount, err = t.Amount.Rescale(CurrencyExponent(account.Currency)); err != nil {
+			return invalidExport("transaction %v: %v", t.Id, err)
+		}
+
+		if t.Tags, err = NormalizeTagNames(t.Tags); err != nil {
+			return invalidExport("transaction %v: %v", t.Id, err)
+		}
+
+		if len(t.Splits) > 0 && t.TransferId != 0 {
+			return invalidExport("transaction %v: legs of transfers can't be split", t.Id)
+		}
+
+		if t.PayeeId != 0 && !payees[t.PayeeId] {
+			return invalidExport("transaction %v: unknown payee %v", t.Id, t.PayeeId)
+		}
+		if t.PayeeId != 0 && t.TransferId != 0 {
+			return invalidExport("transaction %v: legs of transfers have no payee", t.Id)
+		}
+
+		splits := make([]Split, 0, len(t.Splits))
+		for _, s := range t.Splits {
+			if !categories[s.CategoryId] {
+				return invalidExport("transaction %v: split of unknown category %v", t.Id, s.CategoryId)
+			}
+			splits = append(splits, Split{Category: Category{Id: s.CategoryId}, Amount: s.Amount, Memo: s.Memo})
+		}
+
+		if splits, err = ValidateSplits(t.Amount, account.Currency, splits); err != nil {
+			return invalidExport("transaction %v: %v", t.Id, err)
+		}
+
+		for j := range splits {
+			t.Splits[j].Amount = splits[j].Amount
+		}
+
+		if balances[t.AccountId], err = balances[t.AccountId].Add(t.Amount); err != nil {
+			return invalidExport("transaction %v: %v", t.Id, err)
+		}
+
+		if t.TransferId != 0 {
+			transfers[t.TransferId] = append(transfers[t.TransferId], *t)
+		}
+	}
+
+	for id, legs := range transfers {
+		if len(legs) != 2 {
+			return invalidExport("transfer %v has %v legs, expected 2", id, len(legs))
+		}
+		if legs[0].AccountId == legs[1].AccountId {
+			return invalidExport("transfer %v: both legs are on account %v", id, legs[0].AccountId)
+		}
+		if legs[0].Amount.Sign()*legs[1].Amount.Sign() >= 0 {
+			return invalidExport("transfer %v needs a debit and a credit leg", id)
+		}
+	}
+
+	// account of each reconciliation
+	reconciliations := map[int64]int64{}
+	for i := range e.Reconciliations {
+		r := &e.Reconciliations[i]
+
+		if _, ok := reconciliations[r.Id]; ok {
+			return invalidExport("duplicate reconciliation id %v", r.Id)
+		}
+
+		account := accounts[r.AccountId]
+		if account == nil {
+			return invalidExport("reconciliation %v: unknown account %v", r.Id, r.AccountId)
+		}
+		if r.StatementDate.IsZero() {
+			return invalidExport("reconciliation %v: no statement_date", r.Id)
+		}
+
+		var err error
+		if r.StatementBalance, err = r.StatementBalance.Rescale(CurrencyExponent(account.Currency)); err != nil {
+			return invalidExport("reconciliation %v: %v", r.Id, err)
+		}
+
+		if accountId, ok := transactions[r.AdjustmentId]; r.AdjustmentId != 0 && (!ok || accountId != r.AccountId) {
+			return invalidExport("reconciliation %v: adjustment %v isn't a transaction of account %v", r.Id, r.AdjustmentId, r.AccountId)
+		}
+
+		reconciliations[r.Id] = r.AccountId
+	}
+
+	for _, t := range e.Transactions {
+		if t.ReconciliationId == 0 {
+			continue
+		}
+		if accountId, ok := reconciliations[t.ReconciliationId]; !ok || accountId != t.AccountId {
+			return invalidExport("transaction %v: unknown reconciliation %v of account %v", t.Id, t.ReconciliationId, t.AccountId)
+		}
+		if !t.Cleared {
+			return invalidExport("transaction %v is reconciled but not cleared", t.Id)
+		}
+	}
+
+	budgets := map[string]bool{}
+	for i := range e.Budgets {
+		b := &e.Budgets[i]
+
+		if !categories[b.CategoryId] {
+			return invalidExport("budget %v: unknown category %v", b.Id, b.CategoryId)
+		}
+
+		budget := Budget{Currency: b.Currency, Period: b.Period, Amount: b.Amount}
+		if err := budget.validate(); err != nil {
+			return invalidExport("budget %v: %v", b.Id, err)
+		}
+		b.Amount = budget.Amount
+
+		key := fmt.Sprintf("%v|%v|%v", b.CategoryId, b.Currency, b.Period)
+		if budgets[key] {
+			return invalidExport("budget %v: another budget has the same category, currency and period", b.Id)
+		}
+		budgets[key] = true
+	}
+
+	for i := range e.Recurring {
+		r := &e.Recurring[i]
+
+		account := accounts[r.AccountId]
+		if account == nil {
+			return invalidExport("recurring transaction %v: unknown account %v", r.Id, r.AccountId)
+		}
+		if !categories[r.CategoryId] {
+			return invalidExport("recurring transaction %v: unknown category %v", r.Id, r.CategoryId)
+		}
+
+		recurring := RecurringTransaction{
+			Account:   Account{Currency: account.Currency},
+			Amount:    r.Amount,
+			Frequency: r.Frequency,
+			Every:     r.Every,
+			StartsAt:  r.StartsAt,
+			EndsAt:    r.EndsAt,
+		}
+		if err := recurring.validate(); err != nil {
+			return invalidExport("recurring transaction %v: %v", r.Id, err)
+		}
+		r.Amount = recurring.Amount
+	}
+
+	for i := range e.Rules {
+		r := &e.Rules[i]
+
+		rule := Rule{
+			Name:                r.Name,
+			DescriptionContains: r.DescriptionContains,
+			DescriptionRegex:    r.DescriptionRegex,
+			AmountMin:           r.AmountMin,
+			AmountMax:           r.AmountMax,
+			Weekdays:            r.Weekdays,
+			Tags:                r.Tags,
+			Description:         r.Description,
+		}
+		if r.AccountId != 0 {
+			if accounts[r.AccountId] == nil {
+				return invalidExport("rule %v: unknown account %v", r.Id, r.AccountId)
+			}
+			rule.Account = &Account{Id: r.AccountId}
+		}
+		if r.CategoryId != 0 {
+			if !categories[r.CategoryId] {
+				return invalidExport("rule %v: unknown category %v", r.Id, r.CategoryId)
+			}
+			rule.Category = &Category{Id: r.CategoryId}
+		}
+
+		if err := rule.validate(); err != nil {
+			return invalidExport("rule %v: %v", r.Id, err)
+		}
+		r.Name = rule.Name
+		r.Tags = rule.Tags
+	}
+
+	for _, a := range e.Accounts {
+		if balances[a.Id].Cmp(a.Amount) != 0 {
+			return invalidExport(
+				"account %v: opening amount %v plus transactions is %v, not %v",
+				a.Id, a.OpeningAmount, balances[a.Id], a.Amount,
+			)
+		}
+	}
+
+	return nil
+}
+
+// RestoreExport validates the export and replaces accounts, categories, transactions, budgets, recurring transactions,
+// reconciliations, rules and payees of the user with it,
+// the user must have no accounts and transactions unless replace is set
+func RestoreExport(db *sql.DB, userId int64, export Export, replace bool) error {
+	if err := export.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !replace {
+		var count int64
+		row := tx.QueryRow(
+			"select (select count(*) from accounts where user_id = ?) + (select count(*) from transactions where user_id = ?)",
+			userId, userId,
+		)
+		if err := row.Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUserHasData
+		}
+	}
+
+	if _, err := tx.Exec(
+		"delete from recurring_skips where recurring_id in (select id from recurring_transactions where user_id = ?)",
+		userId,
+	); err != nil {
+		return fmt.Errorf("failed to clear recurring skips of user %v: %v", userId, err)
+	}
+
+	if _, err := tx.Exec(
+		"delete from transaction_splits where transaction_id in (select id from transactions where user_id = ?)",
+		userId,
+	); err != nil {
+		return fmt.Errorf("failed to clear transaction splits of user %v: %v", userId, err)
+	}
+
+	if _, err := tx.Exec(
+		"delete from transaction_tags where tag_id in (select id from tags where user_id = ?)",
+		userId,
+	); err != nil {
+		return fmt.Errorf("failed to clear transaction tags of user %v: %v", userId, err)
+	}
+
+	if _, err := tx.Exec(
+		"delete from payee_aliases where payee_id in (select id from payees where user_id = ?)",
+		userId,
+	); err != nil {
+		return fmt.Errorf("failed to clear payee aliases of user %v: %v", userId, err)
+	}
+
+	for _, table := range []string{
+		"rules", "recurring_transactions", "budgets", "tags", "transactions", "reconciliations", "transfers", "accounts", "categories",
+		"payees",
+	} {
+		if _, err := tx.Exec(fmt.Sprintf("delete from %v where user_id = ?", table), userId); err != nil {
+			return fmt.Errorf("failed to clear %v of user %v: %v", table, userId, err)
+		}
+	}
+
+	insert := func(query string, args ...any) (int64, error) {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	categoryIds := map[int64]int64{}
+	for _, c := range export.Categories {
+		if categoryIds[c.Id], err = insert("insert into categories (user_id, name) values (?, ?)", userId, c.Name); err != nil {
+			return fmt.Errorf("failed to restore category %v: %v", c.Id, err)
+		}
+	}
+
+	// parents are set once all categories have their new ids
+	for _, c := range export.Categories {
+		var parentId any
+		if c.ParentId != 0 {
+			parentId = categoryIds[c.ParentId]
+		}
+
+		if _, err := tx.Exec(
+			"update categories set parent_id = ?, archived = ? where id = ?",
+			parentId, c.Archived, categoryIds[c.Id],
+		); err != nil {
+			return fmt.Errorf("failed to restore parent of category %v: %v", c.Id, err)
+		}
+	}
+
+	// accounts of exports written before opening dates open with their first transaction
+	openingDates := map[int64]time.Time{}
+	for _, t := range export.Transactions {
+		if first, ok := openingDates[t.AccountId]; !ok || t.CreatedAt.Before(first) {
+			openingDates[t.AccountId] = t.CreatedAt
+		}
+	}
+
+	accountIds := map[int64]int64{}
+	for _, a := range export.Accounts {
+		openingDate, ok := openingDates[a.Id]
+		if a.OpeningDate != nil {
+			openingDate = *a.OpeningDate
+		} else if !ok {
+			openingDate = time.Now()
+		}
+
+		if accountIds[a.Id], err = insert(
+			`
+			insert into accounts (user_id, name, amount, opening_amount, opening_date, currency, description)
+			values (?, ?, ?, ?, ?, ?, ?)
+			`,
+			userId, a.Name, a.Amount.Minor, a.OpeningAmount.Minor, openingDate.UTC().Format(DATETIME_DB_LAYOUT),
+			a.Currency, a.Description,
+		); err != nil {
+			return fmt.Errorf("failed to restore account %v: %v", a.Id, err)
+		}
+	}
+
+	// adjustments are set once the transactions are restored
+	reconciliationIds := map[int64]int64{}
+	for _, r := range export.Reconciliations {
+		if reconciliationIds[r.Id], err = insert(
+			`
+			insert into reconciliations (user_id, account_id, statement_date, statement_balance, created_at)
+			values (?, ?, ?, ?, ?)
+			`,
+			userId, accountIds[r.AccountId], r.StatementDate.UTC().Format(DATETIME_DB_LAYOUT), r.StatementBalance.Minor,
+			r.CreatedAt.UTC().Format(DATETIME_DB_LAYOUT),
+		); err != nil {
+			return fmt.Errorf("failed to restore reconciliation %v: %v", r.Id, err)
+		}
+	}
+
+	payeeIds := map[int64]int64{}
+	for _, p := range export.Payees {
+		createdAt := p.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+
+		if payeeIds[p.Id], err = insert(
+			"insert into payees (user_id, name, created_at) values (?, ?, ?)",
+			userId, p.Name, createdAt.UTC().Format(DATETIME_DB_LAYOUT),
+		); err != nil {
+			return fmt.Errorf("failed to restore payee %v: %v", p.Id, err)
+		}
+
+		for _, pattern := range p.Aliases {
+			if _, err := insert(
+				"insert into payee_aliases (payee_id, pattern) values (?, ?)", payeeIds[p.Id], pattern,
+			); err != nil {
+				return fmt.Errorf("failed to restore aliases of payee %v: %v", p.Id, err)
+			}
+		}
+	}
+
+	transactionIds := map[int64]int64{}
+	transferIds := map[int64]int64{}
+	tagIds := map[string]int64{}
+	for _, t := range export.Transactions {
+		var transferId, externalId, reconciliationId, payeeId any
+
+		if t.TransferId != 0 {
+			if _, ok := transferIds[t.TransferId]; !ok {
+				if transferIds[t.TransferId], err = insert("insert into transfers (user_id) values (?)", userId); err != nil {
+					return fmt.Errorf("failed to restore transfer %v: %v", t.TransferId, err)
+				}
+			}
+			transferId = transferIds[t.TransferId]
+		}
+
+		if t.ExternalId != "" {
+			externalId = t.ExternalId
+		}
+
+		if t.ReconciliationId != 0 {
+			reconciliationId = reconciliationIds[t.ReconciliationId]
+		}
+
+		if t.PayeeId != 0 {
+			payeeId = payeeIds[t.PayeeId]
+		}
+
+		transactionId, err := insert(
+			`
+			insert into transactions (
+				user_id, account_id, amount, category_id, created_at, description, transfer_id, external_id,
+				cleared, reconciliation_id, payee_id
+			)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`,
+			userId, accountIds[t.AccountId], t.Amount.Minor, categoryIds[t.CategoryId],
+			t.CreatedAt.UTC().Format(DATETIME_DB_LAYOUT), t.Description, transferId, externalId,
+			t.Cleared, reconciliationId, payeeId,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to restore transaction %v: %v", t.Id, err)
+		}
+		transactionIds[t.Id] = transactionId
+
+		for _, name := range t.Tags {
+			if _, ok := tagIds[name]; !ok {
+				if tagIds[name], err = insert("insert into tags (user_id, name) values (?, ?)", userId, name); err != nil {
+					return fmt.Errorf("failed to restore tag %q: %v", name, err)
+				}
+			}
+
+			if _, err := insert(
+				"insert into transaction_tags (transaction_id, tag_id) values (?, ?)",
+				transactionId, tagIds[name],
+			); err != nil {
+				return fmt.Errorf("failed to restore tags of transaction %v: %v", t.Id, err)
+			}
+		}
+
+		for _, s := range t.Splits {
+			if _, err := insert(
+				"insert into transaction_splits (transaction_id, category_id, amount, memo) values (?, ?, ?, ?)",
+				transactionId, categoryIds[s.CategoryId], s.Amount.Minor, s.Memo,
+			); err != nil {
+				return fmt.Errorf("failed to restore splits of transaction %v: %v", t.Id, err)
+			}
+		}
+	}
+
+	for _, r := range export.Reconciliations {
+		if r.AdjustmentId == 0 {
+			continue
+		}
+
+		if _, err := tx.Exec(
+			"update reconciliations set adjustment_id = ? where id = ?",
+			transactionIds[r.AdjustmentId], reconciliationIds[r.Id],
+		); err != nil {
+			return fmt.Errorf("failed to restore adjustment of reconciliation %v: %v", r.Id, err)
+		}
+	}
+
+	for _, b := range export.Budgets {
+		createdAt := b.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+
+		if _, err := insert(
+			`
+			insert into budgets (user_id, category_id, currency, period, amount, rollover, created_at)
+			values (?, ?, ?, ?, ?, ?, ?)
+			`,
+			userId, categoryIds[b.CategoryId], b.Currency, b.Period, b.Amount.Minor, b.Rollover,
+			createdAt.UTC().Format(DATETIME_DB_LAYOUT),
+		); err != nil {
+			return fmt.Errorf("failed to restore budget %v: %v", b.Id, err)
+		}
+	}
+
+	for _, r := range export.Recurring {
+		if _, err := insert(
+			`
+			insert into recurring_transactions (
+				user_id, account_id, category_id, amount, description, frequency, every,
+				starts_at, ends_at, next_at, paused, created_at
+			)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`,
+			userId, accountIds[r.AccountId], categoryIds[r.CategoryId], r.Amount.Minor, r.Description, r.Frequency, r.Every,
+			r.StartsAt.Format(DATETIME_DB_LAYOUT), formatNullableDatetime(r.EndsAt), formatNullableDatetime(r.NextAt),
+			r.Paused, time.Now().UTC().Format(DATETIME_DB_LAYOUT),
+		); err != nil {
+			return fmt.Errorf("failed to restore recurring transaction %v: %v", r.Id, err)
+		}
+	}
+
+	for i, r := range export.Rules {
+		var accountId, categoryId any
+		if r.AccountId != 0 {
+			accountId = accountIds[r.AccountId]
+		}
+		if r.CategoryId != 0 {
+			categoryId = categoryIds[r.CategoryId]
+		}
+
+		position := r.Position
+		if position <= 0 {
+			position = i + 1
+		}
+
+		createdAt := r.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+
+		if _, err := insert(
+			`
+			insert into rules (
+				user_id, name, position, description_contains, description_regex, account_id, amount_min, amount_max,
+				weekdays, category_id, tags, set_description, created_at
+			)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`,
+			userId, r.Name, position, r.DescriptionContains, r.DescriptionRegex, accountId,
+			nullableMoney(r.AmountMin), nullableMoney(r.AmountMax), weekdaysMask(r.Weekdays),
+			categoryId, strings.Join(r.Tags, ","), r.Description, createdAt.UTC().Format(DATETIME_DB_LAYOUT),
+		); err != nil {
+			return fmt.Errorf("failed to restore rule %v: %v", r.Id, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReadExport reads either a json document or a zip of csv files
+func ReadExport(data []byte) (Export, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return ReadExportZip(data)
+	}
+
+	var export Export
+	if err := json.Unmarshal(data, &export); err != nil {
+		return export, invalidExport("failed to parse json: %v", err)
+	}
+
+	return export, nil
+}
+
+func WriteExportJson(w io.Writer, export Export) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+type exportManifest struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+var exportCsvHeaders = map[string][]string{
+	"categories.csv": {"id", "name", "parent_id", "archived"},
+	"accounts.csv":   {"id", "name", "currency", "amount", "opening_amount", "opening_date", "description"},
+	"transactions.csv": {
+		"id", "account_id", "category_id", "amount", "created_at", "description", "transfer_id", "external_id", "tags",
+		"cleared", "reconciliation_id", "payee_id",
+	},
+	"splits.csv":  {"transaction_id", "category_id", "amount", "memo"},
+	"budgets.csv": {"id", "category_id", "currency", "period", "amount", "rollover", "created_at"},
+	"recurring.csv": {
+		"id", "account_id", "category_id", "amount", "description", "frequency", "every",
+		"starts_at", "ends_at", "next_at", "paused",
+	},
+	"reconciliations.csv": {"id", "account_id", "statement_date", "statement_balance", "adjustment_id", "created_at"},
+	"rules.csv": {
+		"id", "name", "position", "description_contains", "description_regex", "account_id", "amount_min", "amount_max",
+		"weekdays", "category_id", "tags", "description", "created_at",
+	},
+	"payees.csv": {"id", "name", "aliases", "created_at"},
+}
+
+// optionalExportFiles may be missing in archives written before they were added
+var optionalExportFiles = map[string]bool{
+	"splits.csv": true, "budgets.csv": true, "recurring.csv": true, "reconciliations.csv": true, "rules.csv": true,
+	"payees.csv": true,
+}
+
+// optionalExportColumns may be missing in files written before they were added
+var optionalExportColumns = map[string]map[string]bool{
+	"accounts.csv":     {"opening_date": true},
+	"categories.csv":   {"parent_id": true, "archived": true},
+	"transactions.csv": {"tags": true, "cleared": true, "reconciliation_id": true, "payee_id": true},
+}
+
+func formatExportDatetime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func writeZipCsv(archive *zip.Writer, name string, records [][]string) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(exportCsvHeaders[name]); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write %v: %v", name, err)
+	}
+
+	return nil
+}
+
+func formatExportMoney(m *Money) string {
+	if m == nil {
+		return ""
+	}
+	return m.String()
+}
+
+func formatExportWeekdays(weekdays []time.Weekday) string {
+	days := make([]string, 0, len(weekdays))
+	for _, d := range weekdays {
+		days = append(days, strconv.Itoa(int(d)))
+	}
+	return strings.Join(days, ",")
+}
+
+func formatExportId(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// WriteExportZip writes manifest.json with the version and a csv file per table
+func WriteExportZip(w io.Writer, export Export) error {
+	archive := zip.NewWriter(w)
+
+	manifest, err := archive.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(manifest).Encode(exportManifest{Version: export.Version, ExportedAt: export.ExportedAt}); err != nil {
+		return err
+	}
+
+	var categories [][]string
+	for _, c := range export.Categories {
+		categories = append(categories, []string{formatExportId(c.Id), c.Name, formatExportId(c.ParentId), strconv.FormatBool(c.Archived)})
+	}
+
+	var accounts [][]string
+	for _, a := range export.Accounts {
+		accounts = append(accounts, []string{
+			formatExportId(a.Id), a.Name, a.Currency, a.Amount.String(), a.OpeningAmount.String(),
+			formatExportDatetime(a.OpeningDate), a.Description,
+		})
+	}
+
+	var transactions [][]string
+	for _, t := range export.Transactions {
+		transactions = append(transactions, []string{
+			formatExportId(t.Id), formatExportId(t.AccountId), formatExportId(t.CategoryId), t.Amount.String(),
+			t.CreatedAt.Format(time.RFC3339), t.Description, formatExportId(t.TransferId), t.ExternalId,
+			strings.Join(t.Tags, ","), strconv.FormatBool(t.Cleared), formatExportId(t.ReconciliationId),
+			formatExportId(t.PayeeId),
+		})
+	}
+
+	var splits [][]string
+	for _, t := range export.Transactions {
+		for _, s := range t.Splits {
+			splits = append(splits, []string{
+				formatExportId(t.Id), formatExportId(s.CategoryId), s.Amount.String(), s.Memo,
+			})
+		}
+	}
+
+	var budgets [][]string
+	for _, b := range export.Budgets {
+		budgets = append(budgets, []string{
+			formatExportId(b.Id), formatExportId(b.CategoryId), b.Currency, string(b.Period), b.Amount.String(),
+			strconv.FormatBool(b.Rollover), b.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	var recurring [][]string
+	for _, r := range export.Recurring {
+		recurring = append(recurring, []string{
+			formatExportId(r.Id), formatExportId(r.AccountId), formatExportId(r.CategoryId), r.Amount.String(), r.Description,
+			string(r.Frequency), strconv.Itoa(r.Every), r.StartsAt.Format(time.RFC3339),
+			formatExportDatetime(r.EndsAt), formatExportDatetime(r.NextAt), strconv.FormatBool(r.Paused),
+		})
+	}
+
+	var reconciliations [][]string
+	for _, r := range export.Reconciliations {
+		reconciliations = append(reconciliations, []string{
+			formatExportId(r.Id), formatExportId(r.AccountId), r.StatementDate.Format(time.RFC3339), r.StatementBalance.String(),
+			formatExportId(r.AdjustmentId), r.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	var rules [][]string
+	for _, r := range export.Rules {
+		rules = append(rules, []string{
+			formatExportId(r.Id), r.Name, strconv.Itoa(r.Position), r.DescriptionContains, r.DescriptionRegex,
+			formatExportId(r.AccountId), formatExportMoney(r.AmountMin), formatExportMoney(r.AmountMax),
+			formatExportWeekdays(r.Weekdays), formatExportId(r.CategoryId), strings.Join(r.Tags, ","), r.Description,
+			r.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	var payees [][]string
+	for _, p := range export.Payees {
+		payees = append(payees, []string{
+			formatExportId(p.Id), p.Name, strings.Join(p.Aliases, ","), p.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	for name, records := range map[string][][]string{
+		"categories.csv":      categories,
+		"accounts.csv":        accounts,
+		"transactions.csv":    transactions,
+		"splits.csv":          splits,
+		"budgets.csv":         budgets,
+		"recurring.csv":       recurring,
+		"reconciliations.csv": reconciliations,
+		"rules.csv":           rules,
+		"payees.csv":          payees,
+	} {
+		if err := writeZipCsv(archive, name, records); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// readZipCsv returns the records of the file as maps by the header columns
+func readZipCsv(archive *zip.Reader, name string) ([]map[string]string, error) {
+	file, err := archive.Open(name)
+	if err != nil && optionalExportFiles[name] {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, invalidExport("no %v in the archive", name)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, invalidExport("failed to parse %v: %v", name, err)
+	}
+
+	if len(records) == 0 {
+		return nil, invalidExport("%v has no header", name)
+	}
+
+	header := records[0]
+	for _, column := range exportCsvHeaders[name] {
+		if columnIndex(header, column) < 0 && !optionalExportColumns[name][column] {
+			return nil, invalidExport("%v has no %v column", name, column)
+		}
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// exportCsvParser keeps the first parsing error to check once per file
+type exportCsvParser struct {
+	name string
+	line int
+	err  error
+}
+
+func (p *exportCsvParser) fail(column string, value string) {
+	if p.err == nil {
+		p.err = invalidExport("%v line %v: invalid %v %q", p.name, p.line, column, value)
+	}
+}
+
+func (p *exportCsvParser) id(row map[string]string, column string) int64 {
+	if row[column] == "" {
+		return 0
+	}
+
+	id, err := strconv.ParseInt(row[column], 10, 64)
+	if err != nil {
+		p.fail(column, row[column])
+	}
+	return id
+}
+
+func (p *exportCsvParser) money(row map[string]string, column string) Money {
+	m, err := parseMoneyExact(row[column])
+	if err != nil {
+		p.fail(column, row[column])
+	}
+	return m
+}
+
+func (p *exportCsvParser) datetime(row map[string]string, column string) time.Time {
+	t, err := time.Parse(time.RFC3339, row[column])
+	if err != nil {
+		p.fail(column, row[column])
+	}
+	return t
+}
+
+// optionalDatetime is nil for an empty column
+func (p *exportCsvParser) optionalDatetime(row map[string]string, column string) *time.Time {
+	if row[column] == "" {
+		return nil
+	}
+
+	t := p.datetime(row, column)
+	return &t
+}
+
+// optionalMoney is nil for an empty column
+func (p *exportCsvParser) optionalMoney(row map[string]string, column string) *Money {
+	if row[column] == "" {
+		return nil
+	}
+
+	m := p.money(row, column)
+	return &m
+}
+
+func (p *exportCsvParser) weekdays(row map[string]string, column string) []time.Weekday {
+	weekdays := []time.Weekday{}
+	for _, day := range strings.Split(row[column], ",") {
+		if day == "" {
+			continue
+		}
+
+		d, err := strconv.Atoi(day)
+		if err != nil {
+			p.fail(column, row[column])
+		}
+		weekdays = append(weekdays, time.Weekday(d))
+	}
+	return weekdays
+}
+
+func (p *exportCsvParser) tags(row map[string]string, column string) []string {
+	tags, err := ParseTagNames(row[column])
+	if err != nil {
+		p.fail(column, row[column])
+	}
+	return tags
+}
+
+func ReadExportZip(data []byte) (Export, error) {
+	var export Export
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return export, invalidExport("failed to open zip: %v", err)
+	}
+
+	manifestFile, err := archive.Open("manifest.json")
+	if err != nil {
+		return export, invalidExport("no manifest.json in the archive")
+	}
+	defer manifestFile.Close()
+
+	var manifest exportManifest
+	if err := json.NewDecoder(manifestFile).Decode(&manifest); err != nil {
+		return export, invalidExport("failed to parse manifest.json: %v", err)
+	}
+
+	export.Version = manifest.Version
+	export.ExportedAt = manifest.ExportedAt
+
+	files := map[string][]map[string]string{}
+	for name := range exportCsvHeaders {
+		if files[name], err = readZipCsv(archive, name); err != nil {
+			return export, err
+		}
+	}
+
+	p := exportCsvParser{name: "categories.csv"}
+	for i, row := range files[p.name] {
+		p.line = i + 2
+		export.Categories = append(export.Categories, Category{
+			Id:       p.id(row, "id"),
+			Name:     row["name"],
+			ParentId: p.id(row, "parent_id"),
+			Archived: row["archived"] == "true",
+		})
+	}
+
+	p.name = "accounts.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+
+		// only archives written before opening dates have no column, a blank date is invalid
+		var openingDate *time.Time
+		if _, ok := row["opening_date"]; ok {
+			date := p.datetime(row, "opening_date")
+			openingDate = &date
+		}
+
+		export.Accounts = append(export.Accounts, ExportAccount{
+			Id:            p.id(row, "id"),
+			Name:          row["name"],
+			Currency:      row["currency"],
+			Amount:        p.money(row, "amount"),
+			OpeningAmount: p.money(row, "opening_amount"),
+			OpeningDate:   openingDate,
+			Description:   row["description"],
+		})
+	}
+
+	p.name = "transactions.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+		export.Transactions = append(export.Transactions, ExportTransaction{
+			Id:          p.id(row, "id"),
+			AccountId:   p.id(row, "account_id"),
+			CategoryId:  p.id(row, "category_id"),
+			Amount:      p.money(row, "amount"),
+			CreatedAt:   p.datetime(row, "created_at"),
+			Description: row["description"],
+			TransferId:  p.id(row, "transfer_id"),
+			ExternalId:  row["external_id"],
+			Tags:        p.tags(row, "tags"),
+			Cleared:     row["cleared"] == "true",
+			// reconciliations.csv and payees.csv are read later, Validate checks the references
+			ReconciliationId: p.id(row, "reconciliation_id"),
+			PayeeId:          p.id(row, "payee_id"),
+		})
+	}
+
+	transactionIndex := map[int64]int{}
+	for i, t := range export.Transactions {
+		transactionIndex[t.Id] = i
+	}
+
+	p.name = "splits.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+
+		transactionId := p.id(row, "transaction_id")
+		index, ok := transactionIndex[transactionId]
+		if !ok {
+			p.fail("transaction_id", row["transaction_id"])
+			continue
+		}
+
+		export.Transactions[index].Splits = append(export.Transactions[index].Splits, ExportSplit{
+			CategoryId: p.id(row, "category_id"),
+			Amount:     p.money(row, "amount"),
+			Memo:       row["memo"],
+		})
+	}
+
+	p.name = "budgets.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+		export.Budgets = append(export.Budgets, ExportBudget{
+			Id:         p.id(row, "id"),
+			CategoryId: p.id(row, "category_id"),
+			Currency:   row["currency"],
+			Period:     BudgetPeriod(row["period"]),
+			Amount:     p.money(row, "amount"),
+			Rollover:   row["rollover"] == "true",
+			CreatedAt:  p.datetime(row, "created_at"),
+		})
+	}
+
+	p.name = "recurring.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+
+		every, err := strconv.Atoi(row["every"])
+		if err != nil {
+			p.fail("every", row["every"])
+		}
+
+		export.Recurring = append(export.Recurring, ExportRecurring{
+			Id:          p.id(row, "id"),
+			AccountId:   p.id(row, "account_id"),
+			CategoryId:  p.id(row, "category_id"),
+			Amount:      p.money(row, "amount"),
+			Description: row["description"],
+			Frequency:   RecurringFrequency(row["frequency"]),
+			Every:       every,
+			StartsAt:    p.datetime(row, "starts_at"),
+			EndsAt:      p.optionalDatetime(row, "ends_at"),
+			NextAt:      p.optionalDatetime(row, "next_at"),
+			Paused:      row["paused"] == "true",
+		})
+	}
+
+	p.name = "reconciliations.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+		export.Reconciliations = append(export.Reconciliations, ExportReconciliation{
+			Id:               p.id(row, "id"),
+			AccountId:        p.id(row, "account_id"),
+			StatementDate:    p.datetime(row, "statement_date"),
+			StatementBalance: p.money(row, "statement_balance"),
+			AdjustmentId:     p.id(row, "adjustment_id"),
+			CreatedAt:        p.datetime(row, "created_at"),
+		})
+	}
+
+	p.name = "rules.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+
+		position, err := strconv.Atoi(row["position"])
+		if err != nil {
+			p.fail("position", row["position"])
+		}
+
+		export.Rules = append(export.Rules, ExportRule{
+			Id:                  p.id(row, "id"),
+			Name:                row["name"],
+			Position:            position,
+			DescriptionContains: row["description_contains"],
+			DescriptionRegex:    row["description_regex"],
+			AccountId:           p.id(row, "account_id"),
+			AmountMin:           p.optionalMoney(row, "amount_min"),
+			AmountMax:           p.optionalMoney(row, "amount_max"),
+			Weekdays:            p.weekdays(row, "weekdays"),
+			CategoryId:          p.id(row, "category_id"),
+			Tags:                p.tags(row, "tags"),
+			Description:         row["description"],
+			CreatedAt:           p.datetime(row, "created_at"),
+		})
+	}
+
+	p.name = "payees.csv"
+	for i, row := range files[p.name] {
+		p.line = i + 2
+
+		aliases := []string{}
+		for _, alias := range strings.Split(row["aliases"], ",") {
+			if alias != "" {
+				aliases = append(aliases, alias)
+			}
+		}
+
+		export.Payees = append(export.Payees, ExportPayee{
+			Id:        p.id(row, "id"),
+			Name:      row["name"],
+			Aliases:   aliases,
+			CreatedAt: p.datetime(row, "created_at"),
+		})
+	}
+
+	return export, p.err
+}
@@ -0,0 +1,232 @@
+package greed
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testExport is a consistent export with ids that don't exist in a fresh db
+func testExport(t *testing.T) Export {
+	t.Helper()
+
+	usd := func(x string) Money { return mustParseMoney(t, x, "USD") }
+	opened := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return Export{
+		Version:    ExportVersion,
+		ExportedAt: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		Categories: []Category{
+			{Id: 10, Name: "Food"},
+			{Id: 11, Name: "Groceries", ParentId: 10},
+			{Id: 12, Name: "Transfers"},
+		},
+		Accounts: []ExportAccount{
+			{Id: 20, Name: "Checking", Currency: "USD", Amount: usd("850"), OpeningAmount: usd("1000"), OpeningDate: &opened},
+			{Id: 21, Name: "Savings", Currency: "USD", Amount: usd("100"), OpeningAmount: usd("0"), OpeningDate: &opened},
+		},
+		Payees: []ExportPayee{{Id: 40, Name: "Corner Shop", Aliases: []string{"CORNER SHOP LTD"}}},
+		Transactions: []ExportTransaction{
+			{
+				Id: 50, AccountId: 20, CategoryId: 11, Amount: usd("-50"), CreatedAt: opened.AddDate(0, 0, 4),
+				Description: "Corner shop", ExternalId: "b1", Tags: []string{" Weekly", "food", "weekly"}, PayeeId: 40, Cleared: true, ReconciliationId: 60,
+				Splits: []ExportSplit{{CategoryId: 10, Amount: usd("-20")}, {CategoryId: 11, Amount: usd("-30"), Memo: "veggies"}},
+			},
+			{Id: 51, AccountId: 20, CategoryId: 12, Amount: usd("-100"), CreatedAt: opened.AddDate(0, 0, 5), TransferId: 30},
+			{Id: 52, AccountId: 21, CategoryId: 12, Amount: usd("100"), CreatedAt: opened.AddDate(0, 0, 5), TransferId: 30},
+		},
+		Reconciliations: []ExportReconciliation{
+			{Id: 60, AccountId: 20, StatementDate: opened.AddDate(0, 0, 4), StatementBalance: usd("950"), AdjustmentId: 50},
+		},
+		Budgets: []ExportBudget{{Id: 70, CategoryId: 10, Currency: "USD", Period: BudgetMonthly, Amount: usd("300")}},
+		Recurring: []ExportRecurring{
+			{
+				Id: 80, AccountId: 20, CategoryId: 10, Amount: usd("-10"), Description: "Coffee",
+				Frequency: RecurringMonthly, Every: 1, StartsAt: opened.AddDate(0, 1, 0),
+			},
+		},
+		Rules: []ExportRule{
+			{Id: 90, Name: "Shop", DescriptionContains: "shop", AccountId: 20, CategoryId: 11, Weekdays: []time.Weekday{time.Saturday}},
+		},
+	}
+}
+
+func TestExportValidate(t *testing.T) {
+	export := testExport(t)
+	if err := export.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tags are normalized
+	if strings.Join(export.Transactions[0].Tags, ",") != "weekly,food" {
+		t.Errorf("tags = %v, want weekly,food", export.Transactions[0].Tags)
+	}
+
+	cases := []struct {
+		name   string
+		change func(e *Export)
+	}{
+		{"unsupported version", func(e *Export) { e.Version = ExportVersion + 1 }},
+		{"duplicate category", func(e *Export) { e.Categories[1].Id = 10 }},
+		{"unknown parent category", func(e *Export) { e.Categories[1].ParentId = 13 }},
+		{"category loop", func(e *Export) { e.Categories[0].ParentId = 11 }},
+		{"unsupported currency", func(e *Export) { e.Accounts[1].Currency = "XXX" }},
+		{"unknown account", func(e *Export) { e.Transactions[0].AccountId = 22 }},
+		{"unknown category", func(e *Export) { e.Transactions[0].CategoryId = 13 }},
+		{"unknown payee", func(e *Export) { e.Transactions[0].PayeeId = 41 }},
+		{"reconciled but not cleared", func(e *Export) { e.Transactions[0].Cleared = false }},
+		{"reconciliation of another account", func(e *Export) { e.Reconciliations[0].AccountId = 21 }},
+		{"adjustment of another account", func(e *Export) { e.Reconciliations[0].AdjustmentId = 52 }},
+		{"inexact amount", func(e *Export) { e.Transactions[1].Amount = NewMoney(-100001, 3) }},
+		{"splits don't add up", func(e *Export) { e.Transactions[0].Splits[0].Amount = NewMoney(-2500, 2) }},
+		{"split transfer leg", func(e *Export) { e.Transactions[1].Splits = e.Transactions[0].Splits }},
+		{"transfer with one leg", func(e *Export) { e.Transactions[2].TransferId = 31 }},
+		{"transfer within an account", func(e *Export) { e.Transactions[2].AccountId, e.Accounts[0].Amount = 20, NewMoney(95000, 2) }},
+		{"duplicate budget", func(e *Export) {
+			e.Budgets = append(e.Budgets, ExportBudget{Id: 71, CategoryId: 10, Currency: "USD", Period: BudgetMonthly, Amount: NewMoney(1, 2)})
+		}},
+		{"recurring without a start", func(e *Export) { e.Recurring[0].StartsAt = time.Time{} }},
+		{"rule of unknown account", func(e *Export) { e.Rules[0].AccountId = 22 }},
+		{"balance doesn't add up", func(e *Export) { e.Accounts[0].Amount = NewMoney(85001, 2) }},
+	}
+
+	for _, c := range cases {
+		export := testExport(t)
+		c.change(&export)
+
+		if err := export.Validate(); !errors.Is(err, ErrInvalidExport) {
+			t.Errorf("%v: validate = %v, want %v", c.name, err, ErrInvalidExport)
+		}
+	}
+}
+
+// checkRestored compares the restored data with testExport through the new ids
+func checkRestored(t *testing.T, restored Export) {
+	t.Helper()
+
+	// rescales the amounts read from json as well
+	if err := restored.Validate(); err != nil {
+		t.Fatalf("restored data is inconsistent: %v", err)
+	}
+
+	categories := map[string]Category{}
+	for _, c := range restored.Categories {
+		categories[c.Name] = c
+	}
+	if len(restored.Categories) != 3 || categories["Groceries"].ParentId != categories["Food"].Id || categories["Food"].Id == 0 {
+		t.Fatalf("restored categories = %+v", restored.Categories)
+	}
+
+	accounts := map[string]ExportAccount{}
+	for _, a := range restored.Accounts {
+		accounts[a.Name] = a
+	}
+	checking, savings := accounts["Checking"], accounts["Savings"]
+	if len(restored.Accounts) != 2 || checking.Amount.String() != "850.00" || savings.Amount.String() != "100.00" {
+		t.Fatalf("restored accounts = %+v", restored.Accounts)
+	}
+
+	if len(restored.Transactions) != 3 || len(restored.Payees) != 1 || len(restored.Reconciliations) != 1 {
+		t.Fatalf("restored %v transactions, %v payees, %v reconciliations", len(restored.Transactions), len(restored.Payees), len(restored.Reconciliations))
+	}
+
+	shop, out, in := restored.Transactions[0], restored.Transactions[1], restored.Transactions[2]
+	reconciliation := restored.Reconciliations[0]
+
+	if shop.AccountId != checking.Id || shop.CategoryId != categories["Groceries"].Id || shop.ExternalId != "b1" ||
+		shop.PayeeId != restored.Payees[0].Id {
+		t.Errorf("restored transaction = %+v", shop)
+	}
+	if shop.ReconciliationId != reconciliation.Id || !shop.Cleared {
+		t.Errorf("restored transaction isn't reconciled by %v: %+v", reconciliation.Id, shop)
+	}
+	if strings.Join(shop.Tags, ",") != "food,weekly" {
+		t.Errorf("restored tags = %v", shop.Tags)
+	}
+	if len(shop.Splits) != 2 || shop.Splits[0].CategoryId != categories["Food"].Id || shop.Splits[1].CategoryId != categories["Groceries"].Id ||
+		shop.Splits[1].Amount.String() != "-30.00" || shop.Splits[1].Memo != "veggies" {
+		t.Errorf("restored splits = %+v", shop.Splits)
+	}
+
+	if out.TransferId == 0 || out.TransferId != in.TransferId || out.AccountId != checking.Id || in.AccountId != savings.Id {
+		t.Errorf("restored transfer legs = %+v, %+v", out, in)
+	}
+
+	if reconciliation.AccountId != checking.Id || reconciliation.AdjustmentId != shop.Id {
+		t.Errorf("restored reconciliation = %+v", reconciliation)
+	}
+
+	if len(restored.Budgets) != 1 || restored.Budgets[0].CategoryId != categories["Food"].Id {
+		t.Errorf("restored budgets = %+v", restored.Budgets)
+	}
+
+	if len(restored.Recurring) != 1 || restored.Recurring[0].AccountId != checking.Id || restored.Recurring[0].CategoryId != categories["Food"].Id {
+		t.Errorf("restored recurring transactions = %+v", restored.Recurring)
+	}
+
+	if len(restored.Rules) != 1 || restored.Rules[0].AccountId != checking.Id || restored.Rules[0].CategoryId != categories["Groceries"].Id {
+		t.Errorf("restored rules = %+v", restored.Rules)
+	}
+}
+
+func TestRestoreExport(t *testing.T) {
+	db, user := newTestDb(t)
+
+	if err := RestoreExport(db, user.Id, testExport(t), false); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := GetExport(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, restored)
+
+	if err := RestoreExport(db, user.Id, testExport(t), false); !errors.Is(err, ErrUserHasData) {
+		t.Errorf("restore over existing data = %v, want %v", err, ErrUserHasData)
+	}
+
+	// an invalid export keeps the data
+	invalid := testExport(t)
+	invalid.Accounts[0].Amount = NewMoney(1, 2)
+	if err := RestoreExport(db, user.Id, invalid, true); !errors.Is(err, ErrInvalidExport) {
+		t.Errorf("restore of an invalid export = %v, want %v", err, ErrInvalidExport)
+	}
+
+	var buffer bytes.Buffer
+	if err := WriteExportZip(&buffer, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	fromZip, err := ReadExport(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreExport(db, user.Id, fromZip, true); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, err := GetExport(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, replaced)
+
+	buffer.Reset()
+	if err := WriteExportJson(&buffer, replaced); err != nil {
+		t.Fatal(err)
+	}
+
+	fromJson, err := ReadExport(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, fromJson)
+
+	if _, err := ReadExport([]byte("{")); !errors.Is(err, ErrInvalidExport) {
+		t.Errorf("broken json = %v, want %v", err, ErrInvalidExport)
+	}
+}
@@ -0,0 +1,184 @@
+package greed
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/labstack/gommon/log"
+)
+
+// transactionsFts tells if transactions_fts is there and in sync, searches fall back to LIKE without it
+var transactionsFts atomic.Bool
+
+// markers of the matched parts in snippets, control characters that descriptions hardly ever have
+const (
+	snippetMatchStart = "\x02"
+	snippetMatchEnd   = "\x03"
+)
+
+// words around the match kept in snippets
+const snippetWords = 12
+
+var transactionsFtsTriggers = []string{
+	`create trigger if not exists transactions_fts_insert after insert on transactions begin
+		insert into transactions_fts (rowid, description) values (new.id, new.description);
+	end`,
+	`create trigger if not exists transactions_fts_delete after delete on transactions begin
+		insert into transactions_fts (transactions_fts, rowid, description) values ('delete', old.id, old.description);
+	end`,
+	`create trigger if not exists transactions_fts_update after update of description on transactions begin
+		insert into transactions_fts (transactions_fts, rowid, description) values ('delete', old.id, old.description);
+		insert into transactions_fts (rowid, description) values (new.id, new.description);
+	end`,
+}
+
+// SetupTransactionsFts creates the full-text index of transaction descriptions kept in sync by triggers,
+// the index is rebuilt when the triggers were missing, e.g. on the first run or after the transactions table was recreated;
+// it isn't a migration since sqlite builds without FTS5 can't have it, those keep searching with LIKE
+func SetupTransactionsFts(db *sql.DB) error {
+	transactionsFts.Store(false)
+
+	if _, err := db.Exec(
+		`create virtual table if not exists transactions_fts using fts5(
+			description, content = 'transactions', content_rowid = 'id', tokenize = 'unicode61 remove_diacritics 2'
+		)`,
+	); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			log.Printf("FTS5 is unavailable, searching transactions with LIKE: %v", err)
+			return nil
+		}
+		return fmt.Errorf("failed to create transactions_fts: %v", err)
+	}
+
+	var triggers int
+	row := db.QueryRow("select count(*) from sqlite_master where type = 'trigger' and name like 'transactions\\_fts\\_%' escape '\\'")
+	if err := row.Scan(&triggers); err != nil {
+		return fmt.Errorf("failed to check transactions_fts triggers: %v", err)
+	}
+
+	if triggers != len(transactionsFtsTriggers) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, trigger := range transactionsFtsTriggers {
+			if _, err := tx.Exec(trigger); err != nil {
+				return fmt.Errorf("failed to create transactions_fts trigger: %v", err)
+			}
+		}
+
+		if _, err := tx.Exec("insert into transactions_fts (transactions_fts) values ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to rebuild transactions_fts: %v", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		log.Printf("Rebuilt the full-text index of transactions")
+	}
+
+	transactionsFts.Store(true)
+	return nil
+}
+
+// isFtsText tells if the term has words for the full-text index, punctuation only terms are matched with LIKE
+func isFtsText(text string) bool {
+	return strings.IndexFunc(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) >= 0
+}
+
+// ftsPhrase matches the words of the text as a phrase, the last word as a prefix
+func ftsPhrase(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"*`
+}
+
+// ftsMatches matches transactions with descriptions containing the text
+func ftsMatches(text string) sq.Sqlizer {
+	return sq.Expr("transactions.id in (select rowid from transactions_fts where transactions_fts match ?)", ftsPhrase(text))
+}
+
+// ftsRankQuery joins the phrases of the terms that rank the results, empty when there are none
+func ftsRankQuery(terms []QueryTerm) string {
+	var phrases []string
+	for _, t := range terms {
+		if !t.Negated && (t.Field == QueryText || t.Field == QueryDescription) && isFtsText(t.Text) {
+			phrases = append(phrases, ftsPhrase(t.Text))
+		}
+	}
+	return strings.Join(phrases, " ")
+}
+
+// TextPart is a piece of a text, Match marks the pieces matching the search
+type TextPart struct {
+	Text  string
+	Match bool
+}
+
+// parseSnippet splits the snippet at the match markers
+func parseSnippet(snippet string) []TextPart {
+	var parts []TextPart
+
+	for snippet != "" {
+		before, rest, found := strings.Cut(snippet, snippetMatchStart)
+		if before != "" {
+			parts = append(parts, TextPart{Text: before})
+		}
+		if !found {
+			break
+		}
+
+		match, after, _ := strings.Cut(rest, snippetMatchEnd)
+		if match != "" {
+			parts = append(parts, TextPart{Text: match, Match: true})
+		}
+		snippet = after
+	}
+
+	return parts
+}
+
+// highlightText marks the case insensitive occurrences of the texts, it highlights the LIKE search
+func highlightText(text string, needles []string) []TextPart {
+	lower := strings.ToLower(text)
+	// lower casing may change the length of some characters, the offsets wouldn't match then
+	if len(lower) != len(text) {
+		return nil
+	}
+
+	matched := make([]bool, len(text))
+	for _, needle := range needles {
+		needle = strings.ToLower(needle)
+		if needle == "" {
+			continue
+		}
+
+		for offset := 0; ; {
+			i := strings.Index(lower[offset:], needle)
+			if i < 0 {
+				break
+			}
+			for j := offset + i; j < offset+i+len(needle); j++ {
+				matched[j] = true
+			}
+			offset += i + len(needle)
+		}
+	}
+
+	var parts []TextPart
+	for i := 0; i < len(text); {
+		j := i
+		for j < len(text) && matched[j] == matched[i] {
+			j++
+		}
+		parts = append(parts, TextPart{Text: text[i:j], Match: matched[i]})
+		i = j
+	}
+
+	return parts
+}
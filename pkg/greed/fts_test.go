@@ -0,0 +1,154 @@
+package greed
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSnippet(t *testing.T) {
+	parts := parseSnippet("…cup of \x02coffee\x03 at the \x02coffee\x03")
+
+	want := []TextPart{{"…cup of ", false}, {"coffee", true}, {" at the ", false}, {"coffee", true}}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts = %+v, want %+v", parts, want)
+	}
+}
+
+func TestHighlightText(t *testing.T) {
+	parts := highlightText("Coffee and COFFEE beans", []string{"coffee", "bean"})
+
+	want := []TextPart{{"Coffee", true}, {" and ", false}, {"COFFEE", true}, {" ", false}, {"bean", true}, {"s", false}}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts = %+v, want %+v", parts, want)
+	}
+}
+
+// searchDescriptions returns the descriptions of the transactions found by the query in their order
+func searchDescriptions(t *testing.T, db DatabaseInterface, userId int64, search string) []string {
+	t.Helper()
+
+	filter := TransactionFilterDefault()
+	filter.Search = search
+
+	transactions, err := GetTransactions(db, userId, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descriptions := []string{}
+	for _, transaction := range transactions {
+		descriptions = append(descriptions, transaction.Description)
+	}
+	return descriptions
+}
+
+// pageDescriptions pages through the transactions found by the query one at a time
+func pageDescriptions(t *testing.T, db DatabaseInterface, userId int64, search string) []string {
+	t.Helper()
+
+	filter := TransactionFilter{Search: search, PageSize: 1}
+
+	descriptions := []string{}
+	for i := 0; i < 10; i++ {
+		transactions, err := GetTransactions(db, userId, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transactions) == 0 {
+			return descriptions
+		}
+		descriptions = append(descriptions, transactions[0].Description)
+		filter = filter.NextPage(transactions[0])
+	}
+	t.Fatalf("search %q doesn't end after %q", search, descriptions)
+	return nil
+}
+
+func TestSearchTransactions(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	account := testAccount(t, db, user.Id, "USD", "1000", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 12, 0, 0, 0, time.UTC) }
+
+	// the newest transactions come first without ranking
+	testTransaction(t, db, user.Id, account, "-1", food, day(1), "Coffee")
+	testTransaction(t, db, user.Id, account, "-1", food, day(2), "Coffee beans from the shop around the corner")
+	testTransaction(t, db, user.Id, account, "-1", food, day(3), "Café au lait")
+	testTransaction(t, db, user.Id, account, "-1", food, day(4), "Groceries")
+	testTransaction(t, db, user.Id, account, "-1", food, day(5), "100% juice")
+
+	// LIKE matches anywhere in the description and keeps the date order
+	if found := searchDescriptions(t, db, user.Id, "offee"); !reflect.DeepEqual(found, []string{"Coffee beans from the shop around the corner", "Coffee"}) {
+		t.Errorf("like search = %q", found)
+	}
+	if found := pageDescriptions(t, db, user.Id, "offee"); !reflect.DeepEqual(found, []string{"Coffee beans from the shop around the corner", "Coffee"}) {
+		t.Errorf("like search by pages = %q", found)
+	}
+
+	if err := SetupTransactionsFts(db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { transactionsFts.Store(false) })
+
+	if !transactionsFts.Load() {
+		t.Skip("sqlite has no FTS5")
+	}
+
+	// added after the index is built
+	testTransaction(t, db, user.Id, account, "-1", food, day(6), "Cafe")
+
+	cases := []struct {
+		search string
+		found  []string
+	}{
+		// the short description is the better match
+		{"coffee", []string{"Coffee", "Coffee beans from the shop around the corner"}},
+		// words match by prefix and without diacritics
+		{"groc", []string{"Groceries"}},
+		{"cafe", []string{"Cafe", "Café au lait"}},
+		{"description:cafe -lait", []string{"Cafe"}},
+		// the index doesn't match inside words
+		{"offee", []string{}},
+		// punctuation only terms fall back to LIKE
+		{"%", []string{"100% juice"}},
+	}
+
+	for _, c := range cases {
+		if found := searchDescriptions(t, db, user.Id, c.search); !reflect.DeepEqual(found, c.found) {
+			t.Errorf("search %q = %q, want %q", c.search, found, c.found)
+		}
+		// the cursors keep the order of the ranking
+		if found := pageDescriptions(t, db, user.Id, c.search); !reflect.DeepEqual(found, c.found) {
+			t.Errorf("search %q by pages = %q, want %q", c.search, found, c.found)
+		}
+	}
+
+	filter := TransactionFilterDefault()
+	filter.Search = "coffee"
+	transactions, err := GetTransactions(db, user.Id, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []TextPart{{"Coffee", true}}; len(transactions) == 0 || !reflect.DeepEqual(transactions[0].Highlight, want) {
+		t.Errorf("highlight = %+v, want %+v", transactions, want)
+	}
+
+	// the triggers keep the index in sync with changed descriptions
+	groceries, err := GetTransactions(db, user.Id, TransactionFilter{Search: "groceries", PageSize: 1})
+	if err != nil || len(groceries) != 1 {
+		t.Fatalf("groceries = %+v, %v", groceries, err)
+	}
+	groceries[0].Description = "Supermarket"
+	if _, err := UpdateTransactionWithRecalc(db, user.Id, groceries[0]); err != nil {
+		t.Fatal(err)
+	}
+	if found := searchDescriptions(t, db, user.Id, "groceries"); len(found) != 0 {
+		t.Errorf("search of the old description = %q", found)
+	}
+	if found := searchDescriptions(t, db, user.Id, "supermarket"); !reflect.DeepEqual(found, []string{"Supermarket"}) {
+		t.Errorf("search of the new description = %q", found)
+	}
+}
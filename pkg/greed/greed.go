@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/big"
+	"net/url"
 	"os"
-	"sort"
 	"strings"
+	schema "supersolik/greed/migrations"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
@@ -32,7 +32,8 @@ func GetDbUrl() string {
 	return url
 }
 
-func ConnectDb() (*sql.DB, error) {
+// OpenDb connects to the db without touching the schema
+func OpenDb() (*sql.DB, error) {
 	url := GetDbUrl()
 
 	db, err := sql.Open("libsql", url)
@@ -51,18 +52,57 @@ func ConnectDb() (*sql.DB, error) {
 	return db, nil
 }
 
+// ConnectDb connects to the db, baselines a db set up before migrations were tracked,
+// applies pending non destructive migrations and sets up the full-text search
+func ConnectDb() (*sql.DB, error) {
+	db, err := OpenDb()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := LoadMigrations(schema.FS)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	baselined, err := BaselinePreRunnerSchema(db, migrations)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if baselined {
+		log.Printf("DB created before migrations were tracked, baselined at version %v\n", preRunnerVersion)
+	}
+
+	if _, err := MigrateUp(db, migrations, 0, false); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := SetupTransactionsFts(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
 type Jsonable interface {
 	ToJson() ([]byte, error)
 	FromJson([]byte) error
 }
 
 type Account struct {
-	Id              int64  `json:"id"`
-	Name            string `json:"name"`
-	Amount          *big.Float
-	SerialzedAmount float64 `json:"amount"`
-	Currency        string  `json:"currency"`
-	Description     string  `json:"description"`
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+	// current balance, the opening amount plus all the transactions
+	Amount Money `json:"amount"`
+	// balance on the opening date before any transaction
+	OpeningAmount Money     `json:"opening_amount"`
+	OpeningDate   time.Time `json:"opening_date"`
+	Currency      string    `json:"currency"`
+	Description   string    `json:"description"`
 }
 
 func (a *Account) ToJson() ([]byte, error) {
@@ -74,21 +114,75 @@ func (a *Account) FromJson(jsonData []byte) error {
 		return err
 	}
 
-	a.Amount = big.NewFloat(a.SerialzedAmount)
+	amount, err := a.Amount.Rescale(CurrencyExponent(a.Currency))
+	if err != nil {
+		return err
+	}
+
+	openingAmount, err := a.OpeningAmount.Rescale(CurrencyExponent(a.Currency))
+	if err != nil {
+		return err
+	}
+
+	a.Amount = amount
+	a.OpeningAmount = openingAmount
 	return nil
 }
 
+// accountColumns are read by scanAccount
+const accountColumns = "accounts.id, accounts.name, " + accountBalance + `,
+	accounts.opening_amount, accounts.opening_date, accounts.currency, accounts.description`
+
+// scanAccount reads accountColumns followed by the extra columns
+func scanAccount(row rowScanner, extra ...any) (Account, error) {
+	var a Account
+	var amount, openingAmount int64
+	var openingDate string
+
+	dest := append([]any{&a.Id, &a.Name, &amount, &openingAmount, &openingDate, &a.Currency, &a.Description}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return a, err
+	}
+
+	var err error
+	if a.OpeningDate, err = ParseDbDatetime(openingDate); err != nil {
+		return a, err
+	}
+
+	// minor units -> Money
+	a.Amount = NewMoney(amount, CurrencyExponent(a.Currency))
+	a.OpeningAmount = NewMoney(openingAmount, CurrencyExponent(a.Currency))
+	return a, nil
+}
+
 // repr of Transaction for rendering
 type Transaction struct {
-	Id              int64   `json:"id"`
-	Account         Account `json:"account"`
-	Amount          *big.Float
-	SerialzedAmount float64   `json:"amount"`
-	Category        Category  `json:"category"`
-	CreatedAt       time.Time `json:"created_at"`
-	Description     string    `json:"description"`
+	Id          int64     `json:"id"`
+	Account     Account   `json:"account"`
+	Amount      Money     `json:"amount"`
+	Category    Category  `json:"category"`
+	CreatedAt   time.Time `json:"created_at"`
+	Description string    `json:"description"`
+	// nil unless the description matches a payee or one was picked
+	Payee *Payee `json:"payee"`
+	// 0 unless the transaction is a leg of a transfer
+	TransferId int64 `json:"transfer_id,omitempty"`
+	Tags       []Tag `json:"tags"`
+	// empty unless the amount is split across categories
+	Splits []Split `json:"splits"`
+	// seen on a bank statement
+	Cleared bool `json:"cleared"`
+	// 0 unless the transaction is reconciled, reconciled transactions can't be changed
+	ReconciliationId int64 `json:"reconciliation_id,omitempty"`
+	// snippet of the description with the parts matching the search marked, empty without a search
+	Highlight []TextPart `json:"-"`
+	// relevance in searches ranked by description, kept in the cursor of the next page
+	rank *float64
 }
 
+var ErrTransferLeg = errors.New("transaction is a part of a transfer, change the transfer instead")
+var ErrAccountNotEmpty = errors.New("account has transactions, delete or move them first")
+
 func (t *Transaction) ToJson() ([]byte, error) {
 	return json.Marshal(t)
 }
@@ -98,13 +192,22 @@ func (t *Transaction) FromJson(jsonData []byte) error {
 		return err
 	}
 
-	t.Amount = big.NewFloat(t.SerialzedAmount)
+	amount, err := t.Amount.Rescale(CurrencyExponent(t.Account.Currency))
+	if err != nil {
+		return err
+	}
+
+	t.Amount = amount
 	return nil
 }
 
 type Category struct {
 	Id   int64  `json:"id"`
 	Name string `json:"name"`
+	// 0 for top level categories
+	ParentId int64 `json:"parent_id,omitempty"`
+	// archived categories keep their transactions but aren't offered in the forms
+	Archived bool `json:"archived,omitempty"`
 }
 
 func (c *Category) ToJson() ([]byte, error) {
@@ -115,11 +218,14 @@ func (c *Category) FromJson(jsonData []byte) error {
 	return json.Unmarshal(jsonData, c)
 }
 
-func GetAccounts[T DatabaseInterface](db T) ([]Account, error) {
+func GetAccounts[T DatabaseInterface](db T, userId int64) ([]Account, error) {
 	// An albums slice to hold data from returned rows.
 	var accounts []Account
 
-	rows, err := db.Query("select * from accounts order by id desc")
+	rows, err := db.Query(
+		"select "+accountColumns+" from accounts where accounts.user_id = ? order by accounts.id desc",
+		userId,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("fetch accounts failed: %v", err)
 	}
@@ -127,13 +233,10 @@ func GetAccounts[T DatabaseInterface](db T) ([]Account, error) {
 
 	// Loop through rows, using Scan to assign column data to struct fields.
 	for rows.Next() {
-		var a Account
-		var amount float64
-		if err := rows.Scan(&a.Id, &a.Name, &amount, &a.Currency, &a.Description); err != nil {
+		a, err := scanAccount(rows)
+		if err != nil {
 			return nil, fmt.Errorf("fetch accounts row failed: %v", err)
 		}
-		// float64 -> bigFloat
-		a.Amount = big.NewFloat(amount)
 		accounts = append(accounts, a)
 	}
 
@@ -144,10 +247,10 @@ func GetAccounts[T DatabaseInterface](db T) ([]Account, error) {
 	return accounts, nil
 }
 
-func CountAccounts[T DatabaseInterface](db T) (int64, error) {
+func CountAccounts[T DatabaseInterface](db T, userId int64) (int64, error) {
 	var count int64
 
-	row := db.QueryRow("select count(*) from accounts")
+	row := db.QueryRow("select count(*) from accounts where user_id = ?", userId)
 
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -156,23 +259,37 @@ func CountAccounts[T DatabaseInterface](db T) (int64, error) {
 	return count, nil
 }
 
+// CreateAccount creates the account with the amount as its balance on the opening date
 func CreateAccount[T DatabaseInterface](
 	db T,
+	userId int64,
 	name string,
-	amount *big.Float,
+	amount Money,
+	openingDate time.Time,
 	currency string,
 	description string,
 ) (Account, error) {
+	amount, err := amount.Rescale(CurrencyExponent(currency))
+	if err != nil {
+		return Account{}, fmt.Errorf("invalid amount for account %v: %v", name, err)
+	}
+
 	account := Account{
-		Name:        name,
-		Amount:      amount,
-		Currency:    currency,
-		Description: description,
+		Name:          name,
+		Amount:        amount,
+		OpeningAmount: amount,
+		OpeningDate:   openingDate.UTC().Truncate(time.Second),
+		Currency:      currency,
+		Description:   description,
 	}
 
 	result, err := db.Exec(
-		"insert into accounts (name, amount, currency, description) values (?, ?, ?, ?)",
-		account.Name, account.Amount.String(), account.Currency, account.Description,
+		`
+		insert into accounts (user_id, name, amount, opening_amount, opening_date, currency, description)
+		values (?, ?, ?, ?, ?, ?, ?)
+		`,
+		userId, account.Name, account.Amount.Minor, account.OpeningAmount.Minor, account.OpeningDate.Format(DATETIME_DB_LAYOUT),
+		account.Currency, account.Description,
 	)
 
 	if err != nil {
@@ -190,10 +307,37 @@ func CreateAccount[T DatabaseInterface](
 	return account, nil
 }
 
-func UpdateAccount[T DatabaseInterface](db T, account Account) (int64, error) {
+// UpdateAccount saves the account, the balance follows the opening amount and date,
+// they can't change once the account is reconciled
+func UpdateAccount[T DatabaseInterface](db T, userId int64, account Account) (int64, error) {
+	openingAmount, err := account.OpeningAmount.Rescale(CurrencyExponent(account.Currency))
+	if err != nil {
+		return 0, fmt.Errorf("invalid opening amount for account %v: %v", account, err)
+	}
+
+	old, err := GetAccountById(db, userId, account.Id)
+	if err != nil {
+		return 0, fmt.Errorf("account %v of user %v: %w", account.Id, userId, err)
+	}
+
+	if openingAmount.Minor != old.OpeningAmount.Minor ||
+		account.OpeningDate.UTC().Format(DATETIME_DB_LAYOUT) != old.OpeningDate.UTC().Format(DATETIME_DB_LAYOUT) {
+		until, err := reconciledUntil(db, userId, account.Id)
+		if err != nil {
+			return 0, err
+		}
+		if !until.IsZero() {
+			return 0, fmt.Errorf("%w: account %v is reconciled up to %v", ErrReconciled, account.Id, until.Format(time.DateOnly))
+		}
+	}
+
 	result, err := db.Exec(
-		"update accounts set name = ?, amount = ?, currency = ?, description = ? where accounts.id = ?",
-		account.Name, account.Amount.String(), account.Currency, account.Description, account.Id,
+		`
+		update accounts set name = ?, opening_amount = ?, opening_date = ?, currency = ?, description = ?
+		where accounts.id = ? and accounts.user_id = ?
+		`,
+		account.Name, openingAmount.Minor, account.OpeningDate.UTC().Format(DATETIME_DB_LAYOUT), account.Currency,
+		account.Description, account.Id, userId,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to update account %v: %v", account, err)
@@ -212,32 +356,69 @@ func UpdateAccount[T DatabaseInterface](db T, account Account) (int64, error) {
 		return rowsUpdated, fmt.Errorf("account %v update affected more than 1 row", account)
 	}
 
-	return rowsUpdated, nil
+	return rowsUpdated, refreshAccountBalances(db, userId, account.Id)
 }
 
-func GetAccountById[T DatabaseInterface](db T, id int64) (Account, error) {
-	// An album to hold data from the returned row.
-	a := Account{Id: id}
-
-	var amount float64
+func GetAccountById[T DatabaseInterface](db T, userId int64, id int64) (Account, error) {
+	row := db.QueryRow("select "+accountColumns+" from accounts where accounts.id = ? and accounts.user_id = ?", id, userId)
 
-	row := db.QueryRow("select name, amount, currency, description from accounts where id = ?", id)
-	if err := row.Scan(&a.Name, &amount, &a.Currency, &a.Description); err != nil {
-		return a, err
+	a, err := scanAccount(row)
+	if err != nil {
+		return Account{Id: id}, err
 	}
 
-	a.Amount = big.NewFloat(amount)
-
 	return a, nil
 }
 
-func DeleteAccount[T DatabaseInterface](db T, accountId int64) error {
-	result, err := db.Exec(
+// DeleteAccount deletes the account with its schedules, rules and reconciliations in one db transaction,
+// accounts with transactions are refused, so transfers, tags and splits never lose their account
+func DeleteAccount(db *sql.DB, userId int64, accountId int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var transactions int64
+	row := tx.QueryRow("select count(*) from transactions where account_id = ? and user_id = ?", accountId, userId)
+	if err := row.Scan(&transactions); err != nil {
+		return fmt.Errorf("failed to count transactions of account %v: %v", accountId, err)
+	}
+
+	if transactions > 0 {
+		return fmt.Errorf("%w: account %v has %v", ErrAccountNotEmpty, accountId, transactions)
+	}
+
+	// schedules of the account can't post anymore
+	if _, err := tx.Exec(
+		`
+		delete from recurring_skips where recurring_id in (
+			select id from recurring_transactions where account_id = ? and user_id = ?
+		)
+		`,
+		accountId, userId,
+	); err != nil {
+		return fmt.Errorf("failed to delete recurring skips of account %v: %v", accountId, err)
+	}
+
+	if _, err := tx.Exec("delete from recurring_transactions where account_id = ? and user_id = ?", accountId, userId); err != nil {
+		return fmt.Errorf("failed to delete recurring transactions of account %v: %v", accountId, err)
+	}
+
+	if _, err := tx.Exec("delete from rules where account_id = ? and user_id = ?", accountId, userId); err != nil {
+		return fmt.Errorf("failed to delete rules of account %v: %v", accountId, err)
+	}
+
+	if _, err := tx.Exec("delete from reconciliations where account_id = ? and user_id = ?", accountId, userId); err != nil {
+		return fmt.Errorf("failed to delete reconciliations of account %v: %v", accountId, err)
+	}
+
+	result, err := tx.Exec(
 		`
 		delete from accounts
-		where accounts.id = ?
+		where accounts.id = ? and accounts.user_id = ?
 		`,
-		accountId,
+		accountId, userId,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to delete account %v: %v", accountId, err)
@@ -254,7 +435,8 @@ func DeleteAccount[T DatabaseInterface](db T, accountId int64) error {
 	case rowsUpdated > 2:
 		return fmt.Errorf("account %v delete affected more than 1 row", accountId)
 	}
-	return nil
+
+	return tx.Commit()
 }
 
 type DateRange struct {
@@ -263,24 +445,33 @@ type DateRange struct {
 }
 
 type TransactionFilter struct {
-	Page          uint64
-	PageSize      uint64
+	// the page starts after the cursor, nil for the first page
+	Cursor   *TransactionCursor
+	PageSize uint64
+	// query in the language of ParseQuery
 	Search        string
 	DateRange     DateRange
 	FilterExpense bool
 	FilterIncome  bool
+	// transactions must have all of the tags
+	Tags []string
+	// 0 for transactions of all accounts
+	AccountId int64
+	// skips reconciled transactions
+	Unreconciled bool
 }
 
 const DefaultPageSize uint64 = 15
 
-func (f TransactionFilter) NextPage() TransactionFilter {
-	f.Page += 1
+// NextPage continues after the last transaction of the current page
+func (f TransactionFilter) NextPage(last Transaction) TransactionFilter {
+	cursor := transactionCursor(last)
+	f.Cursor = &cursor
 	return f
 }
 
 func TransactionFilterDefault() TransactionFilter {
 	return TransactionFilter{
-		Page:     0,
 		PageSize: DefaultPageSize, // TODO: bump
 	}
 }
@@ -288,11 +479,25 @@ func TransactionFilterDefault() TransactionFilter {
 func (f TransactionFilter) BuildQueryParams() string {
 	var params []string
 
-	params = append(params, fmt.Sprintf("page=%d", f.Page))
+	if f.Cursor != nil {
+		params = append(params, fmt.Sprintf("cursor=%s", f.Cursor))
+	}
 	params = append(params, fmt.Sprintf("size=%d", f.PageSize))
 
 	if f.Search != "" {
-		params = append(params, fmt.Sprintf("search=%s", f.Search))
+		params = append(params, fmt.Sprintf("search=%s", url.QueryEscape(f.Search)))
+	}
+
+	if f.FilterExpense {
+		params = append(params, "expense=true")
+	}
+
+	if f.FilterIncome {
+		params = append(params, "income=true")
+	}
+
+	if len(f.Tags) > 0 {
+		params = append(params, fmt.Sprintf("tags=%s", url.QueryEscape(strings.Join(f.Tags, ","))))
 	}
 
 	if !f.DateRange.DateStart.IsZero() {
@@ -306,7 +511,7 @@ func (f TransactionFilter) BuildQueryParams() string {
 	return "?" + strings.Join(params, "&")
 }
 
-func GetTransactions[T DatabaseInterface](db T, filter TransactionFilter) ([]Transaction, error) {
+func GetTransactions[T DatabaseInterface](db T, userId int64, filter TransactionFilter) ([]Transaction, error) {
 	log.Printf("Querying transactions with filter=%v", filter)
 
 	query := sq.
@@ -314,15 +519,28 @@ func GetTransactions[T DatabaseInterface](db T, filter TransactionFilter) ([]Tra
 			"transactions.id as transaction_id",
 			"accounts.id as account_id",
 			"accounts.name as account_name",
+			"accounts.currency as account_currency",
 			"transactions.amount as amount",
 			"categories.id as category_id",
 			"categories.name as category_name",
 			"transactions.created_at as created_at",
 			"transactions.description as transaction_description",
+			"transactions.transfer_id as transfer_id",
+			"transactions.cleared as cleared",
+			"transactions.reconciliation_id as reconciliation_id",
+			"payees.id as payee_id",
+			"payees.name as payee_name",
+			"payees.created_at as payee_created_at",
 		).
 		From("transactions").
 		Join("accounts ON transactions.account_id = accounts.id").
-		LeftJoin("categories on transactions.category_id = categories.id")
+		LeftJoin("categories on transactions.category_id = categories.id").
+		LeftJoin("payees on transactions.payee_id = payees.id").
+		Where(sq.Eq{"transactions.user_id": userId})
+
+	// words searched in descriptions rank the results and their snippets are highlighted
+	var rankQuery string
+	var highlighted []string
 
 	if filter.FilterExpense {
 		query = query.Where(
@@ -340,13 +558,66 @@ func GetTransactions[T DatabaseInterface](db T, filter TransactionFilter) ([]Tra
 		)
 	}
 
+	if len(filter.Tags) > 0 {
+		query = query.Where(taggedWithAll(userId, filter.Tags))
+	}
+
+	if filter.AccountId != 0 {
+		query = query.Where(sq.Eq{"transactions.account_id": filter.AccountId})
+	}
+
+	if filter.Unreconciled {
+		query = query.Where(sq.Eq{"transactions.reconciliation_id": nil})
+	}
+
 	if filter.Search != "" {
-		likeTerm := fmt.Sprint("%", filter.Search, "%")
-		query = query.Where(sq.Or{
-			sq.Like{"account_name": likeTerm},
-			sq.Like{"transaction_description": likeTerm},
-			sq.Like{"category_name": likeTerm},
-		})
+		terms, err := ParseQuery(filter.Search)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(terms) > 0 {
+			query = query.Where(queryCondition(userId, terms))
+		}
+
+		for _, t := range terms {
+			if !t.Negated && (t.Field == QueryText || t.Field == QueryDescription) {
+				highlighted = append(highlighted, t.Text)
+			}
+		}
+
+		if transactionsFts.Load() {
+			rankQuery = ftsRankQuery(terms)
+		}
+	}
+
+	if rankQuery != "" {
+		query = query.
+			Column("fts.snippet").
+			Column("fts.rank").
+			LeftJoin(
+				fmt.Sprintf(
+					`(
+						select rowid, bm25(transactions_fts) as rank,
+							snippet(transactions_fts, 0, '%v', '%v', '…', %v) as snippet
+						from transactions_fts where transactions_fts match ?
+					) as fts on fts.rowid = transactions.id`,
+					snippetMatchStart, snippetMatchEnd, snippetWords,
+				),
+				rankQuery,
+			).
+			// the best matches first, transactions matching by account or category only go after them
+			OrderBy("fts.rank is null", "fts.rank asc")
+	} else {
+		query = query.Column("null").Column("null")
+	}
+
+	if filter.Cursor != nil {
+		if rankQuery != "" {
+			query = query.Where(filter.Cursor.afterRanked())
+		} else {
+			query = query.Where(filter.Cursor.after())
+		}
 	}
 
 	if !filter.DateRange.DateStart.IsZero() {
@@ -366,10 +637,11 @@ func GetTransactions[T DatabaseInterface](db T, filter TransactionFilter) ([]Tra
 		)
 	}
 
-	query = query.OrderBy("datetime(transactions.created_at) desc")
+	// ids order transactions of the same second so that cursors don't skip any of them
+	query = query.OrderBy("transactions.created_at desc", "transactions.id desc")
 
 	if filter.PageSize > 0 {
-		query = query.Limit(filter.PageSize).Offset(filter.Page * filter.PageSize)
+		query = query.Limit(filter.PageSize)
 	}
 
 	sql, args, err := query.ToSql()
@@ -393,17 +665,40 @@ func GetTransactions[T DatabaseInterface](db T, filter TransactionFilter) ([]Tra
 		var a Account
 		var c Category
 
-		var amount float64
+		var amount int64
 		var createdAt string
-		if err := rows.Scan(&t.Id, &a.Id, &a.Name, &amount, &c.Id, &c.Name, &createdAt, &t.Description); err != nil {
+		var transferId, reconciliationId *int64
+		var payeeId *int64
+		var payeeName, payeeCreatedAt *string
+		var snippet *string
+		if err := rows.Scan(
+			&t.Id, &a.Id, &a.Name, &a.Currency, &amount, &c.Id, &c.Name, &createdAt, &t.Description, &transferId,
+			&t.Cleared, &reconciliationId, &payeeId, &payeeName, &payeeCreatedAt, &snippet, &t.rank,
+		); err != nil {
 			return nil, fmt.Errorf("fetch transactions row failed: %v", err)
 		}
-		// float64 -> bigFloat
-		t.Amount = big.NewFloat(amount)
+
+		if t.Payee, err = scanTransactionPayee(payeeId, payeeName, payeeCreatedAt); err != nil {
+			return nil, err
+		}
+
+		if snippet != nil {
+			t.Highlight = parseSnippet(*snippet)
+		} else if len(highlighted) > 0 {
+			t.Highlight = highlightText(t.Description, highlighted)
+		}
+		if transferId != nil {
+			t.TransferId = *transferId
+		}
+		if reconciliationId != nil {
+			t.ReconciliationId = *reconciliationId
+		}
+		// minor units -> Money
+		t.Amount = NewMoney(amount, CurrencyExponent(a.Currency))
 		t.Account = a
 		t.Category = c
 
-		parsedCreatedAt, err := time.Parse(DATETIME_DB_LAYOUT, createdAt)
+		parsedCreatedAt, err := ParseDbDatetime(createdAt)
 
 		if err != nil {
 			return transactions, err
@@ -417,13 +712,21 @@ func GetTransactions[T DatabaseInterface](db T, filter TransactionFilter) ([]Tra
 		return nil, fmt.Errorf("error during transactions iteration: %v", err)
 	}
 
+	if err := fillTransactionsTags(db, userId, transactions); err != nil {
+		return nil, err
+	}
+
+	if err := fillTransactionsSplits(db, userId, transactions); err != nil {
+		return nil, err
+	}
+
 	return transactions, nil
 }
 
-func CountTransactions[T DatabaseInterface](db T) (int64, error) {
+func CountTransactions[T DatabaseInterface](db T, userId int64) (int64, error) {
 	var count int64
 
-	row := db.QueryRow("select count(*) from transactions")
+	row := db.QueryRow("select count(*) from transactions where user_id = ?", userId)
 
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -431,7 +734,7 @@ func CountTransactions[T DatabaseInterface](db T) (int64, error) {
 
 	return count, nil
 }
-func GetTransactionById[T DatabaseInterface](db T, id int64) (Transaction, error) {
+func GetTransactionById[T DatabaseInterface](db T, userId int64, id int64) (Transaction, error) {
 	// An album to hold data from the returned row.
 	t := Transaction{Id: id}
 	var a Account
@@ -439,33 +742,55 @@ func GetTransactionById[T DatabaseInterface](db T, id int64) (Transaction, error
 	var categoryId sql.NullInt64
 	var categoryName sql.NullString
 
-	var amount float64
+	var amount int64
 	var createdAt string
+	var transferId, reconciliationId sql.NullInt64
 
 	query := `
 		select
 			accounts.id as account_id,
 			accounts.name as account_name,
+			accounts.currency as account_currency,
 			transactions.amount,
 			categories.id as category_id,
 			categories.name as category_name,
 			transactions.created_at,
-			transactions.description
+			transactions.description,
+			transactions.transfer_id,
+			transactions.cleared,
+			transactions.reconciliation_id,
+			payees.id,
+			payees.name,
+			payees.created_at
 		from
 			transactions
 		join accounts on transactions.account_id = accounts.id
 		left join categories on transactions.category_id = categories.id
-		where transactions.id = ?;
+		left join payees on transactions.payee_id = payees.id
+		where transactions.id = ? and transactions.user_id = ?;
 	`
-	row := db.QueryRow(query, id)
-	if err := row.Scan(&a.Id, &a.Name, &amount, &categoryId, &categoryName, &createdAt, &t.Description); err != nil {
-		return t, fmt.Errorf("fetch transactions row failed: %v", err)
+	var payeeId *int64
+	var payeeName, payeeCreatedAt *string
+
+	row := db.QueryRow(query, id, userId)
+	if err := row.Scan(
+		&a.Id, &a.Name, &a.Currency, &amount, &categoryId, &categoryName, &createdAt, &t.Description, &transferId,
+		&t.Cleared, &reconciliationId, &payeeId, &payeeName, &payeeCreatedAt,
+	); err != nil {
+		return t, fmt.Errorf("fetch transactions row failed: %w", err)
 	}
-	// float64 -> bigFloat
-	t.Amount = big.NewFloat(amount)
+
+	var err error
+	if t.Payee, err = scanTransactionPayee(payeeId, payeeName, payeeCreatedAt); err != nil {
+		return t, err
+	}
+	t.TransferId = transferId.Int64
+	t.ReconciliationId = reconciliationId.Int64
+	// minor units -> Money
+	t.Amount = NewMoney(amount, CurrencyExponent(a.Currency))
 	t.Account = a
 
-	parsedCreatedAt, err := time.Parse(DATETIME_DB_LAYOUT, createdAt)
+	parsedCreatedAt, err := ParseDbDatetime(createdAt)
 
 	if err != nil {
 		return t, err
@@ -477,13 +802,49 @@ func GetTransactionById[T DatabaseInterface](db T, id int64) (Transaction, error
 		t.Category = Category{Id: categoryId.Int64, Name: categoryName.String}
 	}
 
+	tags, err := getTransactionsTags(db, userId, []int64{id})
+	if err != nil {
+		return t, err
+	}
+
+	t.Tags = tags[id]
+	if t.Tags == nil {
+		t.Tags = []Tag{}
+	}
+
+	splits, err := getTransactionsSplits(db, userId, []int64{id})
+	if err != nil {
+		return t, err
+	}
+
+	t.Splits = splits[id]
+	if t.Splits == nil {
+		t.Splits = []Split{}
+	}
+
 	return t, nil
 }
 
+// checkTransactionOwnership makes sure that account and category of the transaction belong to the user,
+// returns the stored account, its currency is the one amounts are scaled to
+func checkTransactionOwnership[T DatabaseInterface](db T, userId int64, transaction Transaction) (Account, error) {
+	account, err := GetAccountById(db, userId, transaction.Account.Id)
+	if err != nil {
+		return account, fmt.Errorf("account %v of user %v: %w", transaction.Account.Id, userId, err)
+	}
+
+	if _, err := GetCategoryById(db, userId, transaction.Category.Id); err != nil {
+		return account, fmt.Errorf("category %v of user %v: %w", transaction.Category.Id, userId, err)
+	}
+
+	return account, nil
+}
+
 func CreateTransaction[T DatabaseInterface](
 	db T,
+	userId int64,
 	account Account,
-	amount *big.Float,
+	amount Money,
 	category Category,
 	createdAt time.Time,
 	description string,
@@ -496,12 +857,33 @@ func CreateTransaction[T DatabaseInterface](
 		Description: description,
 	}
 
+	account, err := checkTransactionOwnership(db, userId, transaction)
+	if err != nil {
+		return transaction, err
+	}
+
+	// the amount follows the currency of the stored account, not of the one passed in
+	transaction.Account = account
+	if transaction.Amount, err = amount.Rescale(CurrencyExponent(account.Currency)); err != nil {
+		return transaction, fmt.Errorf("invalid amount for transaction on account %v: %v", account.Id, err)
+	}
+
+	if err := checkDateUnreconciled(db, userId, account.Id, createdAt); err != nil {
+		return transaction, err
+	}
+
+	// the payee follows the description, callers picking one set it afterwards
+	if transaction.Payee, err = ResolvePayee(db, userId, description); err != nil {
+		return transaction, err
+	}
+
 	result, err := db.Exec(
 		`
-		insert into transactions (account_id, amount, category_id, created_at, description) 
-		values (?, ?, ?, ?, ?)
+		insert into transactions (user_id, account_id, amount, category_id, created_at, description, payee_id) 
+		values (?, ?, ?, ?, ?, ?, ?)
 		`,
-		transaction.Account.Id, transaction.Amount.String(), transaction.Category.Id, transaction.CreatedAt.Format(DATETIME_DB_LAYOUT), transaction.Description,
+		userId, transaction.Account.Id, transaction.Amount.Minor, transaction.Category.Id, transaction.CreatedAt.UTC().Format(DATETIME_DB_LAYOUT), transaction.Description,
+		transactionPayeeId(transaction),
 	)
 	if err != nil {
 		return transaction, fmt.Errorf("failed to create transaction %v: %v", transaction, err)
@@ -515,24 +897,20 @@ func CreateTransaction[T DatabaseInterface](
 	return transaction, nil
 }
 
-func CreateTransactionWithRecalc(
-	db *sql.DB,
+// CreateTransactionWithRecalc creates the transaction and refreshes the stored account balance,
+// both are written at once within the caller's db transaction
+func CreateTransactionWithRecalc[T DatabaseInterface](
+	db T,
+	userId int64,
 	account Account,
-	amount *big.Float,
+	amount Money,
 	category Category,
 	createdAt time.Time,
 	description string,
 ) (Transaction, error) {
-	var transaction Transaction
-
-	tx, err := db.Begin()
-	if err != nil {
-		return transaction, err
-	}
-	defer tx.Rollback()
-
-	transaction, err = CreateTransaction(
-		tx,
+	transaction, err := CreateTransaction(
+		db,
+		userId,
 		account,
 		amount,
 		category,
@@ -544,28 +922,36 @@ func CreateTransactionWithRecalc(
 		return transaction, err
 	}
 
-	account, err = GetAccountById(tx, transaction.Account.Id)
+	if err := refreshAccountBalances(db, userId, transaction.Account.Id); err != nil {
+		return transaction, err
+	}
 
-	account.Amount.Add(account.Amount, transaction.Amount)
+	return transaction, nil
+}
 
-	if _, err := UpdateAccount(tx, account); err != nil {
-		return transaction, err
+// UpdateTransaction stores every field of the transaction, a nil payee unlinks it
+func UpdateTransaction[T DatabaseInterface](db T, userId int64, transaction Transaction) (int64, error) {
+	account, err := checkTransactionOwnership(db, userId, transaction)
+	if err != nil {
+		return 0, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return transaction, err
+	amount, err := transaction.Amount.Rescale(CurrencyExponent(account.Currency))
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount for transaction %v: %v", transaction, err)
 	}
 
-	return transaction, nil
-}
+	if err := checkMoveUnreconciled(db, userId, transaction); err != nil {
+		return 0, err
+	}
 
-func UpdateTransaction[T DatabaseInterface](db T, transaction Transaction) (int64, error) {
 	result, err := db.Exec(
 		`
-		update transactions set account_id = ?, amount = ?, category_id = ?, created_at = ?, description = ?
-		where transactions.id = ?
+		update transactions set account_id = ?, amount = ?, category_id = ?, created_at = ?, description = ?, payee_id = ?
+		where transactions.id = ? and transactions.user_id = ?
 		`,
-		transaction.Account.Id, transaction.Amount.String(), transaction.Category.Id, transaction.CreatedAt.Format(DATETIME_DB_LAYOUT), transaction.Description, transaction.Id,
+		transaction.Account.Id, amount.Minor, transaction.Category.Id, transaction.CreatedAt.UTC().Format(DATETIME_DB_LAYOUT), transaction.Description,
+		transactionPayeeId(transaction), transaction.Id, userId,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to update transaction %v: %v", transaction, err)
@@ -584,22 +970,27 @@ func UpdateTransaction[T DatabaseInterface](db T, transaction Transaction) (int6
 	return rowsUpdated, nil
 }
 
-func UpdateTransactionWithRecalc(db *sql.DB, transaction Transaction) (int64, error) {
-	tx, err := db.Begin()
+// UpdateTransactionWithRecalc updates the transaction and refreshes the stored balances of the accounts it moved between,
+// both are written at once within the caller's db transaction
+func UpdateTransactionWithRecalc[T DatabaseInterface](db T, userId int64, transaction Transaction) (int64, error) {
+	oldTransaction, err := GetTransactionById(db, userId, transaction.Id)
+
 	if err != nil {
 		return 0, err
-	}
-	defer tx.Rollback()
 
-	oldTransaction, err := GetTransactionById(tx, transaction.Id)
+	}
 
-	if err != nil {
-		return 0, err
+	if oldTransaction.TransferId != 0 {
+		return 0, fmt.Errorf("%w: transaction %v, transfer %v", ErrTransferLeg, oldTransaction.Id, oldTransaction.TransferId)
+	}
 
+	if oldTransaction.ReconciliationId != 0 {
+		return 0, fmt.Errorf("%w: transaction %v", ErrReconciled, oldTransaction.Id)
 	}
 
 	rowsUpdated, err := UpdateTransaction(
-		tx,
+		db,
+		userId,
 		transaction,
 	)
 
@@ -607,29 +998,35 @@ func UpdateTransactionWithRecalc(db *sql.DB, transaction Transaction) (int64, er
 		return rowsUpdated, err
 	}
 
-	account, err := GetAccountById(tx, transaction.Account.Id)
-
-	account.Amount.Sub(account.Amount, oldTransaction.Amount)
-	account.Amount.Add(account.Amount, transaction.Amount)
-
-	if _, err := UpdateAccount(tx, account); err != nil {
-		return rowsUpdated, err
-	}
-
-	if err := tx.Commit(); err != nil {
+	// the transaction may have moved to another account
+	if err := refreshAccountBalances(db, userId, oldTransaction.Account.Id, transaction.Account.Id); err != nil {
 		return rowsUpdated, err
 	}
 
 	return rowsUpdated, nil
 }
 
-func DeleteTransaction[T DatabaseInterface](db T, transactionId int64) error {
+func DeleteTransaction[T DatabaseInterface](db T, userId int64, transactionId int64) error {
+	if _, err := db.Exec(
+		`
+		delete from transaction_tags
+		where transaction_id in (select id from transactions where id = ? and user_id = ?)
+		`,
+		transactionId, userId,
+	); err != nil {
+		return fmt.Errorf("failed to delete tags of transaction %v: %v", transactionId, err)
+	}
+
+	if err := deleteTransactionSplits(db, userId, transactionId); err != nil {
+		return err
+	}
+
 	result, err := db.Exec(
 		`
 		delete from transactions
-		where transactions.id = ?
+		where transactions.id = ? and transactions.user_id = ?
 		`,
-		transactionId,
+		transactionId, userId,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to delete transaction %v: %v", transactionId, err)
@@ -646,30 +1043,35 @@ func DeleteTransaction[T DatabaseInterface](db T, transactionId int64) error {
 	case rowsUpdated > 2:
 		return fmt.Errorf("transaction %v delete affected more than 1 row", transactionId)
 	}
-	return nil
+	return deleteUnusedTags(db, userId)
 }
 
-func DeleteTransactionWithRecalc(db *sql.DB, transactionId int64) error {
+func DeleteTransactionWithRecalc(db *sql.DB, userId int64, transactionId int64) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	transaction, err := GetTransactionById(tx, transactionId)
+	transaction, err := GetTransactionById(tx, userId, transactionId)
 
 	if err != nil {
 		return err
 	}
 
-	if err := DeleteTransaction(tx, transactionId); err != nil {
-		return err
+	if transaction.TransferId != 0 {
+		return fmt.Errorf("%w: transaction %v, transfer %v", ErrTransferLeg, transaction.Id, transaction.TransferId)
 	}
 
-	account, err := GetAccountById(tx, transaction.Account.Id)
-	account.Amount.Sub(account.Amount, transaction.Amount)
+	if transaction.ReconciliationId != 0 {
+		return fmt.Errorf("%w: transaction %v", ErrReconciled, transaction.Id)
+	}
+
+	if err := DeleteTransaction(tx, userId, transactionId); err != nil {
+		return err
+	}
 
-	if _, err := UpdateAccount(tx, account); err != nil {
+	if err := refreshAccountBalances(tx, userId, transaction.Account.Id); err != nil {
 		return err
 	}
 
@@ -679,11 +1081,11 @@ func DeleteTransactionWithRecalc(db *sql.DB, transactionId int64) error {
 
 	return nil
 }
-func GetCategories[T DatabaseInterface](db T) ([]Category, error) {
+func GetCategories[T DatabaseInterface](db T, userId int64) ([]Category, error) {
 	// An albums slice to hold data from returned rows.
 	var categories []Category
 
-	rows, err := db.Query("select * from categories order by id asc")
+	rows, err := db.Query("select id, name, parent_id, archived from categories where user_id = ? order by id asc", userId)
 	if err != nil {
 		return nil, fmt.Errorf("fetch categories failed: %v", err)
 	}
@@ -692,9 +1094,11 @@ func GetCategories[T DatabaseInterface](db T) ([]Category, error) {
 	// Loop through rows, using Scan to assign column data to struct fields.
 	for rows.Next() {
 		var c Category
-		if err := rows.Scan(&c.Id, &c.Name); err != nil {
+		var parentId sql.NullInt64
+		if err := rows.Scan(&c.Id, &c.Name, &parentId, &c.Archived); err != nil {
 			return nil, fmt.Errorf("fetch categories row failed: %v", err)
 		}
+		c.ParentId = parentId.Int64
 		categories = append(categories, c)
 	}
 
@@ -705,38 +1109,67 @@ func GetCategories[T DatabaseInterface](db T) ([]Category, error) {
 	return categories, nil
 }
 
+func GetCategoryById[T DatabaseInterface](db T, userId int64, id int64) (Category, error) {
+	c := Category{Id: id}
+	var parentId sql.NullInt64
+
+	row := db.QueryRow("select name, parent_id, archived from categories where id = ? and user_id = ?", id, userId)
+	if err := row.Scan(&c.Name, &parentId, &c.Archived); err != nil {
+		return c, err
+	}
+
+	c.ParentId = parentId.Int64
+	return c, nil
+}
+
 type CurrencyAmount struct {
-	Currency string
-	Amount   *big.Float
+	Currency string `json:"currency"`
+	Amount   Money  `json:"amount"`
 }
 
 type CashFlow struct {
-	Value    CurrencyAmount
-	Positive bool
+	Value    CurrencyAmount `json:"value"`
+	Positive bool           `json:"positive"`
 }
 
 type CategorySpent struct {
-	Category Category
-	Value    CurrencyAmount
+	Category Category `json:"category"`
+	// includes the subcategories
+	Value         CurrencyAmount  `json:"value"`
+	Subcategories []CategorySpent `json:"subcategories,omitempty"`
 }
 
 type Stats struct {
 	Balance         []CurrencyAmount
 	CashFlow        []CashFlow
 	CategoriesSpent []Pair[string, []CategorySpent]
+	TagsSpent       []Pair[string, []TagSpent]
+	PayeesSpent     []Pair[string, []PayeeSpent]
+	// all of the above in the reporting currency
+	Converted ConvertedStats
+	// budgets in their current period
+	Budgets []BudgetProgress
+	// monthly, the last two months are compared
+	CashFlowTrend   []CashFlowSeries
+	CategoriesTrend []Pair[string, []CategorySeries]
+	// charted over the date range, the cash flow per month
+	CashFlowByMonth []CashFlowSeries
+	BalanceTrend    []AccountBalanceSeries
+	BalanceBucket   TrendBucket
 }
 
-func GetBalance[T DatabaseInterface](db T) ([]CurrencyAmount, error) {
+func GetBalance[T DatabaseInterface](db T, userId int64) ([]CurrencyAmount, error) {
 
 	var result []CurrencyAmount
 
 	sql := `
-	select sum(abs(accounts.amount)) as total_amount, accounts.currency as currency
+	select sum(abs(` + accountBalance + `)) as total_amount, accounts.currency as currency
 	from accounts 
+	where accounts.user_id = ?
 	group by currency
 	`
 
-	rows, err := db.Query(sql)
+	rows, err := db.Query(sql, userId)
 	if err != nil {
 		return nil, fmt.Errorf("fetch balances failed: %v", err)
 	}
@@ -744,14 +1177,14 @@ func GetBalance[T DatabaseInterface](db T) ([]CurrencyAmount, error) {
 	// Loop through rows, using Scan to assign column data to struct fields.
 	for rows.Next() {
 		var ca CurrencyAmount
-		var amount float64
+		var amount int64
 
 		if err := rows.Scan(&amount, &ca.Currency); err != nil {
 			return nil, fmt.Errorf("fetch balances row failed: %v", err)
 		}
 
-		// float64 -> bigFloat
-		ca.Amount = big.NewFloat(amount)
+		// minor units -> Money
+		ca.Amount = NewMoney(amount, CurrencyExponent(ca.Currency))
 
 		result = append(result, ca)
 
@@ -763,9 +1196,15 @@ func GetBalance[T DatabaseInterface](db T) ([]CurrencyAmount, error) {
 	return result, nil
 }
 
-func GetExpensesByCategory[T DatabaseInterface](db T, dateRange DateRange) ([]Pair[string, []CategorySpent], error) {
+func GetExpensesByCategory[T DatabaseInterface](db T, userId int64, dateRange DateRange) ([]Pair[string, []CategorySpent], error) {
 	var result []Pair[string, []CategorySpent]
 
+	// subcategories are rolled up into their parents
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
 	query := sq.
 		Select(
 			"categories.id",
@@ -773,10 +1212,13 @@ func GetExpensesByCategory[T DatabaseInterface](db T, dateRange DateRange) ([]Pa
 			"sum(abs(transactions.amount)) as total_amount",
 			"accounts.currency as currency",
 		).
-		From("transactions").
+		From(transactionLines).
 		Join("categories on categories.id = transactions.category_id").
 		Join("accounts on accounts.id = transactions.account_id").
-		Where(sq.Lt{"transactions.amount": 0})
+		Where(sq.Eq{"transactions.user_id": userId}).
+		Where(sq.Lt{"transactions.amount": 0}).
+		// money moved between own accounts is neither spent nor earned
+		Where(sq.Eq{"transactions.transfer_id": nil})
 
 	if !dateRange.DateStart.IsZero() {
 		query = query.Where(
@@ -818,27 +1260,24 @@ func GetExpensesByCategory[T DatabaseInterface](db T, dateRange DateRange) ([]Pa
 	// Loop through rows, using Scan to assign column data to struct fields.
 	for rows.Next() {
 		var cs CategorySpent
-		var amount float64
+		var amount int64
 
 		if err := rows.Scan(&cs.Category.Id, &cs.Category.Name, &amount, &cs.Value.Currency); err != nil {
 			return nil, fmt.Errorf("fetch categories spent row failed: %v", err)
 		}
 
-		// float64 -> bigFloat
-		cs.Value.Amount = big.NewFloat(amount)
+		// minor units -> Money
+		cs.Value.Amount = NewMoney(amount, CurrencyExponent(cs.Value.Currency))
 
 		key := cs.Value.Currency
 
 		if key != prevKey {
 			if len(groupChunk) > 0 {
-				sort.SliceStable(groupChunk, func(i, j int) bool {
-					return groupChunk[i].Value.Amount.Cmp(groupChunk[j].Value.Amount) > 0
-				})
-
-				result = append(result, Pair[string, []CategorySpent]{
-					First:  prevKey,
-					Second: groupChunk,
-				})
+				rolledUp, err := rollUpCategoriesSpent(categories, prevKey, groupChunk)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, Pair[string, []CategorySpent]{First: prevKey, Second: rolledUp})
 				groupChunk = nil
 			}
 		}
@@ -848,13 +1287,11 @@ func GetExpensesByCategory[T DatabaseInterface](db T, dateRange DateRange) ([]Pa
 	}
 
 	if len(groupChunk) > 0 {
-		sort.SliceStable(groupChunk, func(i, j int) bool {
-			return groupChunk[i].Value.Amount.Cmp(groupChunk[j].Value.Amount) > 0
-		})
-		result = append(result, Pair[string, []CategorySpent]{
-			First:  prevKey,
-			Second: groupChunk,
-		})
+		rolledUp, err := rollUpCategoriesSpent(categories, prevKey, groupChunk)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Pair[string, []CategorySpent]{First: prevKey, Second: rolledUp})
 	}
 
 	if err := rows.Err(); err != nil {
@@ -864,14 +1301,16 @@ func GetExpensesByCategory[T DatabaseInterface](db T, dateRange DateRange) ([]Pa
 	return result, nil
 }
 
-func GetCashFlow[T DatabaseInterface](db T, dateRange DateRange) ([]CashFlow, error) {
+func GetCashFlow[T DatabaseInterface](db T, userId int64, dateRange DateRange) ([]CashFlow, error) {
 	query := sq.
 		Select(
 			"sum(transactions.amount) as cash_flow",
 			"accounts.currency as currency",
 		).
-		From("transactions").
-		Join("accounts on accounts.id = transactions.account_id")
+		From(transactionLines).
+		Join("accounts on accounts.id = transactions.account_id").
+		Where(sq.Eq{"transactions.user_id": userId}).
+		Where(sq.Eq{"transactions.transfer_id": nil})
 
 	if !dateRange.DateStart.IsZero() {
 		query = query.Where(
@@ -910,21 +1349,20 @@ func GetCashFlow[T DatabaseInterface](db T, dateRange DateRange) ([]CashFlow, er
 	defer rows.Close()
 	for rows.Next() {
 		var ca CurrencyAmount
-		var cashFlow float64
+		var cashFlow int64
 
 		if err := rows.Scan(&cashFlow, &ca.Currency); err != nil {
 			return nil, fmt.Errorf("fetch cash flow row failed: %v", err)
 		}
 
-		// float64 -> bigFloat
-
-		ca.Amount = big.NewFloat(cashFlow)
+		// minor units -> Money
+		ca.Amount = NewMoney(cashFlow, CurrencyExponent(ca.Currency))
 
 		// check the sign
 		isPositive := ca.Amount.Sign() >= 0
 
 		// convert to abs value for repr
-		ca.Amount.Abs(ca.Amount)
+		ca.Amount = ca.Amount.Abs()
 
 		result = append(result, CashFlow{Value: ca, Positive: isPositive})
 	}
@@ -0,0 +1,292 @@
+package greed
+
+import (
+	"database/sql"
+	"errors"
+	schema "supersolik/greed/migrations"
+	"testing"
+	"time"
+)
+
+func TestUserDataIsolation(t *testing.T) {
+	db, alice := newTestDb(t)
+	bob := testUser(t, db, "bob")
+
+	food := testCategory(t, db, alice.Id, "🍖 Food and drinks")
+	account := testAccount(t, db, alice.Id, "USD", "100", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	transaction := testTransaction(t, db, alice.Id, account, "-10", food, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), "groceries")
+
+	bobFood := testCategory(t, db, bob.Id, "🍖 Food and drinks")
+	bobAccount := testAccount(t, db, bob.Id, "USD", "50", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	// bob's account has the same name as alice's, names are unique per user
+	if bobAccount.Name != account.Name || bobFood.Id == food.Id {
+		t.Fatalf("bob's account %+v and category %+v", bobAccount, bobFood)
+	}
+
+	reads := []struct {
+		name string
+		read func() error
+	}{
+		{"account", func() error { _, err := GetAccountById(db, bob.Id, account.Id); return err }},
+		{"category", func() error { _, err := GetCategoryById(db, bob.Id, food.Id); return err }},
+		{"transaction", func() error { _, err := GetTransactionById(db, bob.Id, transaction.Id); return err }},
+	}
+
+	for _, r := range reads {
+		if err := r.read(); err == nil {
+			t.Errorf("bob read the %v of alice", r.name)
+		}
+	}
+
+	accounts, err := GetAccounts(db, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Id != bobAccount.Id {
+		t.Errorf("accounts of bob = %+v", accounts)
+	}
+
+	transactions, err := GetTransactions(db, bob.Id, TransactionFilterDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 0 {
+		t.Errorf("transactions of bob = %+v", transactions)
+	}
+
+	changes := []struct {
+		name   string
+		change func() error
+	}{
+		{"update account", func() error {
+			changed := account
+			changed.Name = "mine"
+			_, err := UpdateAccount(db, bob.Id, changed)
+			return err
+		}},
+		{"delete account", func() error { return DeleteAccount(db, bob.Id, account.Id) }},
+		{"add transaction to account", func() error {
+			_, err := CreateTransactionWithRecalc(db, bob.Id, account, mustParseMoney(t, "-1", "USD"), bobFood, transaction.CreatedAt, "")
+			return err
+		}},
+		{"add transaction with category", func() error {
+			_, err := CreateTransactionWithRecalc(db, bob.Id, bobAccount, mustParseMoney(t, "-1", "USD"), food, transaction.CreatedAt, "")
+			return err
+		}},
+		{"update transaction", func() error {
+			changed := transaction
+			changed.Account, changed.Category = bobAccount, bobFood
+			_, err := UpdateTransactionWithRecalc(db, bob.Id, changed)
+			return err
+		}},
+		{"delete transaction", func() error { return DeleteTransactionWithRecalc(db, bob.Id, transaction.Id) }},
+		{"tag transaction", func() error { _, err := SetTransactionTags(db, bob.Id, transaction.Id, []string{"mine"}); return err }},
+		{"split transaction", func() error {
+			_, err := SetTransactionSplits(db, bob.Id, transaction.Id, []Split{
+				{Category: bobFood, Amount: mustParseMoney(t, "-5", "USD")},
+				{Category: bobFood, Amount: mustParseMoney(t, "-5", "USD")},
+			})
+			return err
+		}},
+		{"clear transaction", func() error { _, err := SetTransactionCleared(db, bob.Id, transaction.Id, true); return err }},
+		{"unlink payee", func() error { return SetTransactionPayee(db, bob.Id, transaction.Id, 0) }},
+		{"transfer from account", func() error {
+			_, err := CreateTransfer(db, bob.Id, Transfer{
+				FromAccount: account, ToAccount: bobAccount, FromAmount: mustParseMoney(t, "5", "USD"), ToAmount: mustParseMoney(t, "5", "USD"),
+				Category: bobFood, CreatedAt: transaction.CreatedAt,
+			})
+			return err
+		}},
+		{"reconcile account", func() error {
+			_, err := ReconcileAccount(db, bob.Id, account.Id, transaction.CreatedAt, mustParseMoney(t, "0", "USD"), bobFood)
+			return err
+		}},
+	}
+
+	for _, c := range changes {
+		if err := c.change(); err == nil {
+			t.Errorf("bob could %v of alice", c.name)
+		}
+	}
+
+	unchanged, err := GetAccountById(db, alice.Id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.Name != account.Name || unchanged.Amount.String() != "90.00" {
+		t.Errorf("account of alice changed into %+v", unchanged)
+	}
+
+	stored, err := GetTransactionById(db, alice.Id, transaction.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Account.Id != account.Id || stored.Category.Id != food.Id || stored.Amount.String() != "-10.00" ||
+		len(stored.Tags) != 0 || len(stored.Splits) != 0 || stored.Cleared {
+		t.Errorf("transaction of alice changed into %+v", stored)
+	}
+
+	if bobAccount, err = GetAccountById(db, bob.Id, bobAccount.Id); err != nil || bobAccount.Amount.String() != "50.00" {
+		t.Errorf("account of bob = %+v, %v", bobAccount, err)
+	}
+}
+
+func TestTransactionAmountFollowsStoredCurrency(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	yen := testAccount(t, db, user.Id, "JPY", "10000", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// the caller's copy of the account claims another currency
+	stale := yen
+	stale.Currency = "USD"
+
+	if _, err := CreateTransactionWithRecalc(db, user.Id, stale, mustParseMoney(t, "-4.50", "USD"), food, createdAt, "ramen"); err == nil {
+		t.Errorf("fractional yen stored")
+	}
+
+	transaction, err := CreateTransactionWithRecalc(db, user.Id, stale, mustParseMoney(t, "-450", "USD"), food, createdAt, "ramen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Account.Currency != "JPY" || transaction.Amount.String() != "-450" {
+		t.Errorf("transaction = %v %v, want -450 JPY", transaction.Amount, transaction.Account.Currency)
+	}
+
+	transaction.Account = stale
+	transaction.Amount = mustParseMoney(t, "-5.25", "USD")
+	if _, err := UpdateTransactionWithRecalc(db, user.Id, transaction); err == nil {
+		t.Errorf("fractional yen stored by an update")
+	}
+
+	stored, err := GetAccountById(db, user.Id, yen.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Amount.String() != "9550" {
+		t.Errorf("balance = %v, want 9550", stored.Amount)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	opened := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	used := testAccount(t, db, user.Id, "USD", "100", opened)
+	empty := testAccount(t, db, user.Id, "EUR", "0", opened)
+	testTransaction(t, db, user.Id, used, "-10", food, opened.AddDate(0, 0, 1), "groceries")
+
+	for _, account := range []Account{used, empty} {
+		if _, err := CreateRecurringTransaction(db, user.Id, RecurringTransaction{
+			Account: account, Category: food, Amount: mustParseMoney(t, "-1", account.Currency),
+			Frequency: RecurringMonthly, Every: 1, StartsAt: opened.AddDate(0, 1, 0),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := DeleteAccount(db, user.Id, used.Id); !errors.Is(err, ErrAccountNotEmpty) {
+		t.Errorf("delete of an account with transactions = %v, want %v", err, ErrAccountNotEmpty)
+	}
+
+	if err := DeleteAccount(db, user.Id, empty.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetAccountById(db, user.Id, empty.Id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted account = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	// the refused delete kept the schedule of the used account, the other one went with its account
+	recurring, err := GetRecurringTransactions(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recurring) != 1 || recurring[0].Account.Id != used.Id {
+		t.Errorf("recurring transactions = %+v, want the one of account %v", recurring, used.Id)
+	}
+}
+
+func TestSignupClaimsUnownedData(t *testing.T) {
+	db := openTestDb(t)
+
+	migrations, err := LoadMigrations(schema.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// data entered before users existed
+	if _, err := MigrateUp(db, migrations, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert into accounts (name, amount, currency, description) values ('Cash', 90.5, 'USD', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(
+		"insert into transactions (account_id, amount, category_id, created_at, description) values (1, -9.5, 3, '2024-01-01 12:00:00+00:00', 'lunch')",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(db, migrations, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("insert into categories (name) values ('Unowned')"); err == nil {
+		t.Errorf("category without a user inserted")
+	}
+
+	alice, err := SignupUser(db, "alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := GetAccounts(db, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Name != "Cash" {
+		t.Errorf("accounts of the first user = %+v", accounts)
+	}
+
+	transactions, err := GetTransactions(db, alice.Id, TransactionFilterDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].Amount.String() != "-9.50" {
+		t.Errorf("transactions of the first user = %+v", transactions)
+	}
+
+	// the claimed categories replace the default ones
+	categories, err := GetCategories(db, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != len(DefaultCategories) {
+		t.Errorf("first user has %v categories, want %v", len(categories), len(DefaultCategories))
+	}
+
+	bob, err := SignupUser(db, "bob", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts, err := GetAccounts(db, bob.Id); err != nil || len(accounts) != 0 {
+		t.Errorf("accounts of the second user = %+v, %v", accounts, err)
+	}
+	if categories, err := GetCategories(db, bob.Id); err != nil || len(categories) != len(DefaultCategories) {
+		t.Errorf("second user has %v categories, %v, want %v", len(categories), err, len(DefaultCategories))
+	}
+
+	var unowned int64
+	if err := db.QueryRow(
+		"select (select count(*) from accounts where user_id is null) + (select count(*) from transactions where user_id is null)",
+	).Scan(&unowned); err != nil {
+		t.Fatal(err)
+	}
+	if unowned != 0 {
+		t.Errorf("%v rows left unowned", unowned)
+	}
+}
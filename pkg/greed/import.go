@@ -0,0 +1,497 @@
+package greed
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrInvalidImport = errors.New("invalid import")
+
+// ImportDateLayouts are tried in order when the mapping has no date layout,
+// ambiguous day/month layouts have to be chosen explicitly
+var ImportDateLayouts = []string{
+	time.DateOnly,
+	time.RFC3339,
+	time.DateTime,
+	"2006-01-02 15:04",
+	"2006/01/02",
+	"02.01.2006",
+	"02.01.2006 15:04",
+	"02.01.2006 15:04:05",
+}
+
+// CsvStatement is a bank statement export, records don't include the header
+type CsvStatement struct {
+	Header  []string
+	Records [][]string
+}
+
+// ImportMapping binds csv columns (by header name) to transaction fields,
+// empty column name means the field is not mapped
+type ImportMapping struct {
+	Date        string `json:"date"`
+	Amount      string `json:"amount"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	// bank provided transaction id, makes re-imports idempotent
+	ExternalId string `json:"external_id"`
+	// go time layout of the date column, ImportDateLayouts are tried if empty
+	DateLayout string `json:"date_layout"`
+	// amounts like "1.234,56" instead of "1,234.56"
+	DecimalComma bool `json:"decimal_comma"`
+	// used when category isn't mapped or there is no category with such name
+	DefaultCategoryId int64 `json:"default_category_id"`
+}
+
+// ImportRow is a parsed statement line ready to become a transaction,
+// rows with Error can't be imported
+type ImportRow struct {
+	// csv line or number of the statement entry
+	Line        int       `json:"line"`
+	ExternalId  string    `json:"external_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Amount      Money     `json:"amount"`
+	Description string    `json:"description"`
+	Category    Category  `json:"category"`
+	// added by the rules
+	Tags      []string `json:"tags"`
+	Duplicate bool     `json:"duplicate"`
+	Error     string   `json:"error,omitempty"`
+	// the category comes from the statement, the rules keep it
+	categorized bool
+}
+
+// detectCsvDelimiter picks the most frequent of the common delimiters in the header line
+func detectCsvDelimiter(data []byte) rune {
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+
+	delimiter, best := ',', 0
+	for _, d := range []rune{',', ';', '\t'} {
+		if n := bytes.Count(firstLine, []byte(string(d))); n > best {
+			delimiter, best = d, n
+		}
+	}
+
+	return delimiter
+}
+
+// ReadCsvStatement reads the whole csv export, the first line has to be the header
+func ReadCsvStatement(r io.Reader) (CsvStatement, error) {
+	var statement CsvStatement
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return statement, fmt.Errorf("failed to read csv: %v", err)
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = detectCsvDelimiter(data)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return statement, fmt.Errorf("%w: failed to parse csv: %v", ErrInvalidImport, err)
+	}
+
+	if len(records) == 0 {
+		return statement, fmt.Errorf("%w: csv is empty", ErrInvalidImport)
+	}
+
+	statement.Header = records[0]
+	for i := range statement.Header {
+		statement.Header[i] = strings.TrimSpace(statement.Header[i])
+	}
+
+	for _, record := range records[1:] {
+		// blank lines at the end of exports
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		statement.Records = append(statement.Records, record)
+	}
+
+	return statement, nil
+}
+
+// GuessImportMapping maps columns by the common header names
+func GuessImportMapping(header []string) ImportMapping {
+	var mapping ImportMapping
+
+	guesses := []struct {
+		field *string
+		names []string
+	}{
+		{&mapping.Date, []string{"date", "booking date", "transaction date", "posted", "datum"}},
+		{&mapping.Amount, []string{"amount", "sum", "value", "betrag"}},
+		{&mapping.Description, []string{"description", "memo", "details", "payee", "name", "reference", "purpose"}},
+		{&mapping.Category, []string{"category"}},
+		{&mapping.ExternalId, []string{"id", "transaction id", "fitid"}},
+	}
+
+	for _, g := range guesses {
+		for _, name := range g.names {
+			for _, column := range header {
+				if *g.field == "" && strings.EqualFold(strings.TrimSpace(column), name) {
+					*g.field = column
+				}
+			}
+		}
+	}
+
+	return mapping
+}
+
+func columnIndex(header []string, column string) int {
+	for i, name := range header {
+		if name == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizeImportAmount strips currency formatting, e.g. "1 234,56" or "1,234.56" into "1234.56"
+func normalizeImportAmount(x string, decimalComma bool) string {
+	thousands, decimal := ",", "."
+	if decimalComma {
+		thousands, decimal = ".", ","
+	}
+
+	s := strings.TrimSpace(x)
+	s = strings.NewReplacer(" ", "", "\u00a0", "", "'", "", thousands, "").Replace(s)
+	return strings.Replace(s, decimal, ".", 1)
+}
+
+func parseImportDate(x string, layout string) (time.Time, error) {
+	s := strings.TrimSpace(x)
+
+	layouts := ImportDateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+
+	for _, l := range layouts {
+		if parsed, err := time.Parse(l, s); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q", x)
+}
+
+// ParseCsvStatement turns statement records into import rows for the account,
+// categories are matched by name ignoring case
+func ParseCsvStatement(
+	statement CsvStatement,
+	mapping ImportMapping,
+	account Account,
+	categories []Category,
+) ([]ImportRow, error) {
+	columns := map[string]int{}
+	required := map[string]bool{"date": true, "amount": true}
+
+	for field, column := range map[string]string{
+		"date":        mapping.Date,
+		"amount":      mapping.Amount,
+		"description": mapping.Description,
+		"category":    mapping.Category,
+		"external_id": mapping.ExternalId,
+	} {
+		if column == "" {
+			if required[field] {
+				return nil, fmt.Errorf("%w: %v column is not mapped", ErrInvalidImport, field)
+			}
+			columns[field] = -1
+			continue
+		}
+
+		if columns[field] = columnIndex(statement.Header, column); columns[field] < 0 {
+			return nil, fmt.Errorf("%w: csv has no %q column", ErrInvalidImport, column)
+		}
+	}
+
+	var defaultCategory Category
+	categoriesByName := map[string]Category{}
+	for _, c := range categories {
+		categoriesByName[strings.ToLower(c.Name)] = c
+		if c.Id == mapping.DefaultCategoryId {
+			defaultCategory = c
+		}
+	}
+
+	if defaultCategory.Id == 0 {
+		return nil, fmt.Errorf("%w: default category %v not found", ErrInvalidImport, mapping.DefaultCategoryId)
+	}
+
+	field := func(record []string, name string) string {
+		if i := columns[name]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := make([]ImportRow, 0, len(statement.Records))
+
+	for i, record := range statement.Records {
+		row := ImportRow{
+			Line:        i + 2,
+			ExternalId:  field(record, "external_id"),
+			Description: field(record, "description"),
+			Category:    defaultCategory,
+		}
+
+		if c, ok := categoriesByName[strings.ToLower(field(record, "category"))]; ok {
+			row.Category = c
+			row.categorized = true
+		}
+
+		createdAt, err := parseImportDate(field(record, "date"), mapping.DateLayout)
+		if err != nil {
+			row.Error = err.Error()
+		}
+		row.CreatedAt = createdAt
+
+		amount, err := ParseCurrencyMoney(normalizeImportAmount(field(record, "amount"), mapping.DecimalComma), account.Currency)
+		if err != nil && row.Error == "" {
+			row.Error = err.Error()
+		}
+		row.Amount = amount
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func importDedupKey(createdAt time.Time, amount Money, description string) string {
+	return fmt.Sprintf("%v|%v|%v", createdAt.UTC().Format(time.DateOnly), amount.Minor, strings.ToLower(strings.TrimSpace(description)))
+}
+
+// getExternalIds returns the bank provided ids of the account transactions
+func getExternalIds[T DatabaseInterface](db T, userId int64, accountId int64) (map[string]bool, error) {
+	ids := map[string]bool{}
+
+	result, err := db.Query(
+		"select external_id from transactions where user_id = ? and account_id = ? and external_id is not null",
+		userId, accountId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch external ids of account %v: %v", accountId, err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var id string
+		if err := result.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan external id: %v", err)
+		}
+		ids[id] = true
+	}
+
+	return ids, result.Err()
+}
+
+// MarkDuplicates flags rows already imported by their external id, other rows are matched with
+// existing transactions of the account by date, amount and description, each existing transaction
+// matches at most one row so repeated purchases within a day still get imported
+func MarkDuplicates[T DatabaseInterface](db T, userId int64, accountId int64, rows []ImportRow) error {
+	externalIds, err := getExternalIds(db, userId, accountId)
+	if err != nil {
+		return err
+	}
+
+	var from, to time.Time
+	for i, row := range rows {
+		if row.Error != "" {
+			continue
+		}
+
+		if row.ExternalId != "" {
+			// the same id twice within the statement is a duplicate as well
+			rows[i].Duplicate = externalIds[row.ExternalId]
+			externalIds[row.ExternalId] = true
+		}
+
+		if from.IsZero() || row.CreatedAt.Before(from) {
+			from = row.CreatedAt
+		}
+		if row.CreatedAt.After(to) {
+			to = row.CreatedAt
+		}
+	}
+
+	if from.IsZero() {
+		return nil
+	}
+
+	from = from.Truncate(24 * time.Hour)
+	to = to.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+	result, err := db.Query(
+		`
+		select created_at, amount, description, external_id is not null from transactions
+		where user_id = ? and account_id = ? and datetime(created_at) >= datetime(?) and datetime(created_at) < datetime(?)
+		`,
+		userId, accountId, from.Format(DATETIME_DB_LAYOUT), to.Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to fetch transactions of account %v for dedup: %v", accountId, err)
+	}
+	defer result.Close()
+
+	// rows with an external id are only matched with transactions entered by hand,
+	// an identical imported transaction has a different id and isn't the same one
+	existing := map[string]int{}
+	existingManual := map[string]int{}
+
+	for result.Next() {
+		var createdAt, description string
+		var amount int64
+		var imported bool
+
+		if err := result.Scan(&createdAt, &amount, &description, &imported); err != nil {
+			return fmt.Errorf("failed to scan transaction for dedup: %v", err)
+		}
+
+		parsedCreatedAt, err := ParseDbDatetime(createdAt)
+		if err != nil {
+			return err
+		}
+
+		key := importDedupKey(parsedCreatedAt, Money{Minor: amount}, description)
+		existing[key]++
+		if !imported {
+			existingManual[key]++
+		}
+	}
+
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	for i := range rows {
+		if rows[i].Error != "" || rows[i].Duplicate {
+			continue
+		}
+
+		counts := existing
+		if rows[i].ExternalId != "" {
+			counts = existingManual
+		}
+
+		key := importDedupKey(rows[i].CreatedAt, rows[i].Amount, rows[i].Description)
+		if counts[key] > 0 {
+			counts[key]--
+			rows[i].Duplicate = true
+		}
+	}
+
+	return nil
+}
+
+// PreviewCsvImport parses the statement, applies the rules and marks the duplicates without storing anything
+func PreviewCsvImport[T DatabaseInterface](
+	db T,
+	userId int64,
+	accountId int64,
+	statement CsvStatement,
+	mapping ImportMapping,
+) (Account, []ImportRow, error) {
+	account, err := GetAccountById(db, userId, accountId)
+	if err != nil {
+		return account, nil, fmt.Errorf("account %v of user %v: %w", accountId, userId, err)
+	}
+
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		return account, nil, err
+	}
+
+	rows, err := ParseCsvStatement(statement, mapping, account, categories)
+	if err != nil {
+		return account, nil, err
+	}
+
+	if err := categorizeImportRows(db, userId, account, rows); err != nil {
+		return account, nil, err
+	}
+
+	if err := MarkDuplicates(db, userId, account.Id, rows); err != nil {
+		return account, nil, err
+	}
+
+	return account, rows, nil
+}
+
+// ImportTransactions creates a transaction per row and updates the account balance once,
+// either all rows are stored or none, rows with an already imported external id are skipped
+func ImportTransactions(db *sql.DB, userId int64, accountId int64, rows []ImportRow) (int, error) {
+	for _, row := range rows {
+		if row.Error != "" {
+			return 0, fmt.Errorf("%w: line %v: %v", ErrInvalidImport, row.Line, row.Error)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	account, err := GetAccountById(tx, userId, accountId)
+	if err != nil {
+		return 0, fmt.Errorf("account %v of user %v: %w", accountId, userId, err)
+	}
+
+	externalIds, err := getExternalIds(tx, userId, account.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+
+	for _, row := range rows {
+		if row.ExternalId != "" && externalIds[row.ExternalId] {
+			continue
+		}
+
+		transaction, err := CreateTransaction(tx, userId, account, row.Amount, row.Category, row.CreatedAt, row.Description)
+		if err != nil {
+			return 0, fmt.Errorf("line %v: %w", row.Line, err)
+		}
+
+		if len(row.Tags) > 0 {
+			if _, err := SetTransactionTags(tx, userId, transaction.Id, row.Tags); err != nil {
+				return 0, fmt.Errorf("line %v: %w", row.Line, err)
+			}
+		}
+
+		if row.ExternalId != "" {
+			if _, err := tx.Exec("update transactions set external_id = ? where id = ?", row.ExternalId, transaction.Id); err != nil {
+				return 0, fmt.Errorf("failed to set external id of transaction %v: %v", transaction.Id, err)
+			}
+			externalIds[row.ExternalId] = true
+		}
+
+		imported++
+	}
+
+	if err := refreshAccountBalances(tx, userId, account.Id); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return imported, nil
+}
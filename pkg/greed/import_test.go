@@ -0,0 +1,197 @@
+package greed
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadCsvStatement(t *testing.T) {
+	statement, err := ReadCsvStatement(strings.NewReader(
+		"\xef\xbb\xbfDatum; Betrag ;Purpose\n" +
+			"10.03.2026;-1.234,56;\"Rent; March\"\n" +
+			"11.03.2026;12,00;Refund\n" +
+			"\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(statement.Header, "|") != "Datum|Betrag|Purpose" {
+		t.Errorf("header = %q", statement.Header)
+	}
+
+	if len(statement.Records) != 2 || statement.Records[0][2] != "Rent; March" {
+		t.Errorf("records = %q", statement.Records)
+	}
+
+	if _, err := ReadCsvStatement(strings.NewReader("")); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("empty csv = %v, want %v", err, ErrInvalidImport)
+	}
+
+	if _, err := ReadCsvStatement(strings.NewReader("date,amount\n\"2026-03-10,1\n")); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("unterminated quote = %v, want %v", err, ErrInvalidImport)
+	}
+}
+
+func TestGuessImportMapping(t *testing.T) {
+	mapping := GuessImportMapping([]string{"Booking Date", "Payee", "Memo", "Amount", "FITID", "Balance"})
+
+	if mapping.Date != "Booking Date" || mapping.Amount != "Amount" || mapping.ExternalId != "FITID" || mapping.Category != "" {
+		t.Errorf("mapping = %+v", mapping)
+	}
+
+	// description prefers memo over payee, names are tried in order
+	if mapping.Description != "Memo" {
+		t.Errorf("description is mapped to %q, want Memo", mapping.Description)
+	}
+}
+
+func TestParseCsvStatement(t *testing.T) {
+	account := Account{Id: 1, Currency: "EUR"}
+	categories := []Category{{Id: 1, Name: "🍖 Food and drinks"}, {Id: 2, Name: "Rent"}}
+
+	statement := CsvStatement{
+		Header: []string{"Date", "Amount", "Text", "Category", "Id"},
+		Records: [][]string{
+			{"10.03.2026", "-1.234,56", " Rent March ", "rent", "a1"},
+			{"11.03.2026", "1 000", "Salary", "Income", ""},
+			{"2026-03-12", "-5,00", "Coffee", "", ""},
+			{"12.03.2026", "-0,005", "Fee", "", ""},
+			{"13.03.2026"},
+		},
+	}
+
+	mapping := ImportMapping{
+		Date:              "Date",
+		Amount:            "Amount",
+		Description:       "Text",
+		Category:          "Category",
+		ExternalId:        "Id",
+		DateLayout:        "02.01.2006",
+		DecimalComma:      true,
+		DefaultCategoryId: 1,
+	}
+
+	rows, err := ParseCsvStatement(statement, mapping, account, categories)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 5 {
+		t.Fatalf("parsed %v rows, want 5", len(rows))
+	}
+
+	first := rows[0]
+	if first.Line != 2 || first.ExternalId != "a1" || first.Description != "Rent March" || first.Error != "" {
+		t.Errorf("first row = %+v", first)
+	}
+	if !first.CreatedAt.Equal(time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)) || first.Amount.String() != "-1234.56" {
+		t.Errorf("first row at %v of %v, want 2026-03-10 of -1234.56", first.CreatedAt, first.Amount)
+	}
+	if first.Category.Id != 2 || !first.categorized {
+		t.Errorf("category %q isn't matched ignoring case: %+v", "rent", first.Category)
+	}
+
+	// unknown categories fall back to the default one
+	if rows[1].Amount.String() != "1000.00" || rows[1].Category.Id != 1 || rows[1].categorized {
+		t.Errorf("second row = %+v", rows[1])
+	}
+
+	for _, i := range []int{2, 3, 4} {
+		if rows[i].Error == "" {
+			t.Errorf("row of line %v is valid: %+v", rows[i].Line, rows[i])
+		}
+	}
+
+	invalid := []ImportMapping{
+		{Amount: "Amount", DefaultCategoryId: 1},
+		{Date: "Date", Amount: "Sum", DefaultCategoryId: 1},
+		{Date: "Date", Amount: "Amount", DefaultCategoryId: 3},
+	}
+
+	for _, m := range invalid {
+		if _, err := ParseCsvStatement(statement, m, account, categories); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("mapping %+v = %v, want %v", m, err, ErrInvalidImport)
+		}
+	}
+}
+
+func TestImportTransactions(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	account := testAccount(t, db, user.Id, "USD", "100", opened)
+
+	// entered by hand before the statement was imported
+	testTransaction(t, db, user.Id, account, "-4.50", food, time.Date(2026, 3, 10, 9, 30, 0, 0, time.UTC), "Coffee")
+
+	statement, err := ReadCsvStatement(strings.NewReader(
+		"date,amount,description,id\n" +
+			"2026-03-10,-4.50,coffee,b1\n" +
+			"2026-03-10,-4.50,Coffee,b2\n" +
+			"2026-03-11,-20.00,Groceries,b3\n" +
+			"2026-03-12,-20.00,Groceries,b3\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping := GuessImportMapping(statement.Header)
+	mapping.DefaultCategoryId = food.Id
+
+	_, rows, err := PreviewCsvImport(db, user.Id, account.Id, statement, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the coffee entered by hand matches one of the two imported ones, the repeated bank id is a duplicate
+	duplicates := []bool{true, false, false, true}
+	for i, row := range rows {
+		if row.Duplicate != duplicates[i] {
+			t.Errorf("row of line %v is duplicate %v, want %v", row.Line, row.Duplicate, duplicates[i])
+		}
+	}
+
+	var fresh []ImportRow
+	for _, row := range rows {
+		if !row.Duplicate {
+			fresh = append(fresh, row)
+		}
+	}
+
+	imported, err := ImportTransactions(db, user.Id, account.Id, fresh)
+	if err != nil || imported != 2 {
+		t.Fatalf("imported %v rows, %v, want 2", imported, err)
+	}
+
+	stored, err := GetAccountById(db, user.Id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Amount.String() != "71.00" {
+		t.Errorf("balance after the import = %v, want 71.00", stored.Amount)
+	}
+
+	// a re-import of the same statement skips the known bank ids
+	if imported, err := ImportTransactions(db, user.Id, account.Id, fresh); err != nil || imported != 0 {
+		t.Errorf("re-imported %v rows, %v, want 0", imported, err)
+	}
+
+	_, rows, err = PreviewCsvImport(db, user.Id, account.Id, statement, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if !row.Duplicate {
+			t.Errorf("row of line %v isn't a duplicate after the import", row.Line)
+		}
+	}
+
+	invalid := []ImportRow{{Line: 2, Error: "invalid date"}}
+	if _, err := ImportTransactions(db, user.Id, account.Id, invalid); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("import of an invalid row = %v, want %v", err, ErrInvalidImport)
+	}
+}
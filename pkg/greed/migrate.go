@@ -0,0 +1,390 @@
+package greed
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+var ErrDestructiveMigration = errors.New("migration drops data, it has to be allowed explicitly")
+var ErrUntrackedSchema = errors.New("database has tables but no recorded migrations")
+
+const destructiveMarker = "-- +destructive"
+
+var migrationFileRe = regexp.MustCompile(`^([vu])(\d+)_(.+)\.sql$`)
+
+type MigrationStep struct {
+	Sql         string
+	Destructive bool
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      MigrationStep
+	// empty when the migration can't be reverted
+	Down MigrationStep
+}
+
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// LoadMigrations reads vN_name.sql (up) and uN_name.sql (down) files sorted by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[2])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %v", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %v: %v", entry.Name(), err)
+		}
+
+		step := MigrationStep{
+			Sql:         string(content),
+			Destructive: strings.HasPrefix(strings.TrimSpace(string(content)), destructiveMarker),
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+
+		if match[1] == "v" {
+			if m.Up.Sql != "" {
+				return nil, fmt.Errorf("duplicate up migration for version %v", version)
+			}
+			m.Name = match[3]
+			m.Up = step
+		} else {
+			if m.Down.Sql != "" {
+				return nil, fmt.Errorf("duplicate down migration for version %v", version)
+			}
+			m.Down = step
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up.Sql == "" {
+			return nil, fmt.Errorf("migration %v has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be sequential, missing version %v", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	return nil
+}
+
+func GetAppliedMigrations(db *sql.DB) ([]AppliedMigration, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("select version, name, applied_at from schema_migrations order by version asc")
+	if err != nil {
+		return nil, fmt.Errorf("fetch applied migrations failed: %v", err)
+	}
+	defer rows.Close()
+
+	var applied []AppliedMigration
+
+	for rows.Next() {
+		var m AppliedMigration
+		var appliedAt string
+
+		if err := rows.Scan(&m.Version, &m.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("fetch applied migrations row failed: %v", err)
+		}
+
+		if m.AppliedAt, err = ParseDbDatetime(appliedAt); err != nil {
+			return nil, err
+		}
+
+		applied = append(applied, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during applied migrations iteration: %v", err)
+	}
+
+	return applied, nil
+}
+
+// GetSchemaVersion returns the latest applied migration version, 0 for empty database
+func GetSchemaVersion(db *sql.DB) (int, error) {
+	applied, err := GetAppliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(applied) == 0 {
+		return 0, nil
+	}
+
+	return applied[len(applied)-1].Version, nil
+}
+
+// hasUntrackedSchema detects databases created before migrations were tracked
+func hasUntrackedSchema(db *sql.DB) (bool, error) {
+	var count int64
+
+	row := db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = 'accounts'")
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// preRunnerVersion is the schema of dbs set up from v1_init.sql by hand before migrations were tracked
+const preRunnerVersion = 1
+
+// isPreRunnerSchema tells a db set up from v1_init.sql by hand apart from other untracked schemas:
+// its accounts, categories and transactions still keep REAL amounts
+func isPreRunnerSchema(db *sql.DB) (bool, error) {
+	var tables, realAmounts int64
+
+	row := db.QueryRow(
+		"select count(*) from sqlite_master where type = 'table' and name in ('accounts', 'categories', 'transactions')",
+	)
+	if err := row.Scan(&tables); err != nil {
+		return false, err
+	}
+	if tables != 3 {
+		return false, nil
+	}
+
+	row = db.QueryRow(`select
+		(select count(*) from pragma_table_info('accounts') where name = 'amount' and upper(type) = 'REAL') +
+		(select count(*) from pragma_table_info('transactions') where name = 'amount' and upper(type) = 'REAL')`,
+	)
+	if err := row.Scan(&realAmounts); err != nil {
+		return false, err
+	}
+
+	return realAmounts == 2, nil
+}
+
+// BaselinePreRunnerSchema marks v1 as applied on an untracked db set up from v1_init.sql by hand,
+// so the later migrations can run on it, any other db is left alone
+func BaselinePreRunnerSchema(db *sql.DB, migrations []Migration) (bool, error) {
+	current, err := GetSchemaVersion(db)
+	if err != nil || current != 0 {
+		return false, err
+	}
+
+	preRunner, err := isPreRunnerSchema(db)
+	if err != nil || !preRunner {
+		return false, err
+	}
+
+	if err := BaselineMigrations(db, migrations, preRunnerVersion); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func applyMigrationStep(db *sql.DB, m Migration, step MigrationStep, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(step.Sql); err != nil {
+		return fmt.Errorf("migration %v_%v failed: %v", m.Version, m.Name, err)
+	}
+
+	if up {
+		_, err = tx.Exec(
+			"insert into schema_migrations (version, name, applied_at) values (?, ?, ?)",
+			m.Version, m.Name, time.Now().UTC().Format(DATETIME_DB_LAYOUT),
+		)
+	} else {
+		_, err = tx.Exec("delete from schema_migrations where version = ?", m.Version)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to record migration %v_%v: %v", m.Version, m.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+// MigrateUp applies pending migrations up to the target version (0 means the latest),
+// refuses to start if any of them is destructive and allowDestructive is false
+func MigrateUp(db *sql.DB, migrations []Migration, target int, allowDestructive bool) ([]Migration, error) {
+	current, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == 0 {
+		untracked, err := hasUntrackedSchema(db)
+		if err != nil {
+			return nil, err
+		}
+		if untracked {
+			return nil, fmt.Errorf("%w, mark the existing schema version with `greed migrate baseline <version>`", ErrUntrackedSchema)
+		}
+	}
+
+	if target == 0 && len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current && m.Version <= target {
+			pending = append(pending, m)
+		}
+	}
+
+	if !allowDestructive {
+		for _, m := range pending {
+			if m.Up.Destructive {
+				return nil, fmt.Errorf("%w: %v_%v", ErrDestructiveMigration, m.Version, m.Name)
+			}
+		}
+	}
+
+	var applied []Migration
+	for _, m := range pending {
+		log.Printf("Applying migration %v_%v", m.Version, m.Name)
+
+		if err := applyMigrationStep(db, m, m.Up, true); err != nil {
+			return applied, err
+		}
+
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts applied migrations down to the target version (exclusive)
+func MigrateDown(db *sql.DB, migrations []Migration, target int, allowDestructive bool) ([]Migration, error) {
+	current, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if target < 0 || target > current {
+		return nil, fmt.Errorf("invalid target version %v, current version is %v", target, current)
+	}
+
+	var pending []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= current && m.Version > target {
+			pending = append(pending, m)
+		}
+	}
+
+	for _, m := range pending {
+		if m.Down.Sql == "" {
+			return nil, fmt.Errorf("migration %v_%v can't be reverted", m.Version, m.Name)
+		}
+		if m.Down.Destructive && !allowDestructive {
+			return nil, fmt.Errorf("%w: revert of %v_%v", ErrDestructiveMigration, m.Version, m.Name)
+		}
+	}
+
+	var reverted []Migration
+	for _, m := range pending {
+		log.Printf("Reverting migration %v_%v", m.Version, m.Name)
+
+		if err := applyMigrationStep(db, m, m.Down, false); err != nil {
+			return reverted, err
+		}
+
+		reverted = append(reverted, m)
+	}
+
+	return reverted, nil
+}
+
+// BaselineMigrations marks migrations up to version as applied without running them,
+// for databases created before migrations were tracked
+func BaselineMigrations(db *sql.DB, migrations []Migration, version int) error {
+	current, err := GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current != 0 {
+		return fmt.Errorf("database already has migrations recorded up to version %v", current)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+
+		if _, err := tx.Exec(
+			"insert into schema_migrations (version, name, applied_at) values (?, ?, ?)",
+			m.Version, m.Name, time.Now().UTC().Format(DATETIME_DB_LAYOUT),
+		); err != nil {
+			return fmt.Errorf("failed to record migration %v_%v: %v", m.Version, m.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
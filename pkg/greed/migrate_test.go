@@ -0,0 +1,304 @@
+package greed
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	schema "supersolik/greed/migrations"
+	"testing"
+	"testing/fstest"
+)
+
+// testMigrations creates accounts, adds notes to them and drops the notes again, the last one is destructive
+func testMigrations(t *testing.T) []Migration {
+	t.Helper()
+
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"v1_init.sql":       {Data: []byte("CREATE TABLE accounts (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+		"u1_init.sql":       {Data: []byte("-- +destructive\nDROP TABLE accounts;")},
+		"v2_notes.sql":      {Data: []byte("CREATE TABLE notes (id INTEGER PRIMARY KEY, text TEXT NOT NULL);")},
+		"u2_notes.sql":      {Data: []byte("DROP TABLE notes;")},
+		"v3_drop_notes.sql": {Data: []byte("-- +destructive\nDROP TABLE notes;")},
+		"readme.txt":        {Data: []byte("not a migration")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return migrations
+}
+
+func testSchemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return version
+}
+
+func hasTable(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = ?", name).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	return count > 0
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations := testMigrations(t)
+
+	if len(migrations) != 3 {
+		t.Fatalf("loaded %v migrations, want 3", len(migrations))
+	}
+
+	for i, name := range []string{"init", "notes", "drop_notes"} {
+		if migrations[i].Version != i+1 || migrations[i].Name != name {
+			t.Errorf("migration %v = %v_%v, want %v_%v", i, migrations[i].Version, migrations[i].Name, i+1, name)
+		}
+	}
+
+	if migrations[0].Up.Destructive || !migrations[0].Down.Destructive || !migrations[2].Up.Destructive {
+		t.Errorf("destructive markers weren't read: %+v", migrations)
+	}
+
+	if migrations[2].Down.Sql != "" {
+		t.Errorf("migration without a down file can be reverted")
+	}
+
+	invalid := []fstest.MapFS{
+		{"v1_init.sql": {Data: []byte("select 1;")}, "v3_gap.sql": {Data: []byte("select 1;")}},
+		{"v1_init.sql": {Data: []byte("select 1;")}, "u2_orphan.sql": {Data: []byte("select 1;")}},
+		{"v0_zero.sql": {Data: []byte("select 1;")}},
+	}
+
+	for _, fsys := range invalid {
+		if _, err := LoadMigrations(fsys); err == nil {
+			t.Errorf("migrations %v loaded", fsys)
+		}
+	}
+
+	// the bundled migrations are sequential and each of them can be reverted
+	bundled, err := LoadMigrations(schema.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range bundled {
+		if m.Down.Sql == "" {
+			t.Errorf("bundled migration %v_%v has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db := openTestDb(t)
+	migrations := testMigrations(t)
+
+	applied, err := MigrateUp(db, migrations, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || testSchemaVersion(t, db) != 2 || !hasTable(t, db, "notes") {
+		t.Fatalf("migrated up to 2: applied %v, version %v", len(applied), testSchemaVersion(t, db))
+	}
+
+	// nothing is applied when a pending migration is destructive
+	if _, err := MigrateUp(db, migrations, 0, false); !errors.Is(err, ErrDestructiveMigration) {
+		t.Errorf("destructive migration up = %v, want %v", err, ErrDestructiveMigration)
+	}
+	if testSchemaVersion(t, db) != 2 || !hasTable(t, db, "notes") {
+		t.Errorf("refused migration changed the schema")
+	}
+
+	if applied, err = MigrateUp(db, migrations, 0, true); err != nil || len(applied) != 1 {
+		t.Fatalf("allowed destructive migration up: applied %v, %v", len(applied), err)
+	}
+	if testSchemaVersion(t, db) != 3 || hasTable(t, db, "notes") {
+		t.Errorf("migrated up to 3: version %v", testSchemaVersion(t, db))
+	}
+
+	if applied, err = MigrateUp(db, migrations, 0, false); err != nil || len(applied) != 0 {
+		t.Errorf("migrating an up to date db: applied %v, %v", len(applied), err)
+	}
+
+	if _, err := MigrateDown(db, migrations, 2, true); err == nil {
+		t.Errorf("reverted a migration without a down file")
+	}
+
+	if _, err := MigrateDown(db, migrations, 4, false); err == nil {
+		t.Errorf("migrated down to a version above the current one")
+	}
+
+	// a db at version 2 is reverted to version 1, the revert of 1 drops data
+	db = openTestDb(t)
+	if _, err := MigrateUp(db, migrations, 2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := MigrateDown(db, migrations, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || testSchemaVersion(t, db) != 1 || hasTable(t, db, "notes") {
+		t.Errorf("migrated down to 1: reverted %v, version %v", len(reverted), testSchemaVersion(t, db))
+	}
+
+	if _, err := MigrateDown(db, migrations, 0, false); !errors.Is(err, ErrDestructiveMigration) {
+		t.Errorf("destructive migration down = %v, want %v", err, ErrDestructiveMigration)
+	}
+	if !hasTable(t, db, "accounts") {
+		t.Errorf("refused revert dropped the accounts")
+	}
+
+	if _, err := MigrateDown(db, migrations, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if testSchemaVersion(t, db) != 0 || hasTable(t, db, "accounts") {
+		t.Errorf("migrated down to 0: version %v", testSchemaVersion(t, db))
+	}
+}
+
+func TestBaselineMigrations(t *testing.T) {
+	db := openTestDb(t)
+	migrations := testMigrations(t)
+
+	// a db created before the migrations were tracked
+	if _, err := db.Exec("CREATE TABLE accounts (id INTEGER PRIMARY KEY, name TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, text TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(db, migrations, 0, true); !errors.Is(err, ErrUntrackedSchema) {
+		t.Fatalf("migrating an untracked schema = %v, want %v", err, ErrUntrackedSchema)
+	}
+
+	if err := BaselineMigrations(db, migrations, 2); err != nil {
+		t.Fatal(err)
+	}
+	if testSchemaVersion(t, db) != 2 {
+		t.Errorf("baselined version = %v, want 2", testSchemaVersion(t, db))
+	}
+
+	if err := BaselineMigrations(db, migrations, 3); err == nil {
+		t.Errorf("baselined a db with recorded migrations")
+	}
+
+	applied, err := MigrateUp(db, migrations, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != 3 || hasTable(t, db, "notes") {
+		t.Errorf("migrated up after the baseline: %+v", applied)
+	}
+}
+
+func TestBundledMigrations(t *testing.T) {
+	db := openTestDb(t)
+
+	migrations, err := LoadMigrations(schema.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(db, migrations, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateDown(db, migrations, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if testSchemaVersion(t, db) != 0 || hasTable(t, db, "accounts") {
+		t.Errorf("bundled migrations reverted to version %v", testSchemaVersion(t, db))
+	}
+
+	if _, err := MigrateUp(db, migrations, 0, false); err != nil {
+		t.Fatalf("bundled migrations up after a full revert: %v", err)
+	}
+}
+
+func TestConnectDbBaselinesPreRunnerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "greed.sqlite")
+	t.Setenv("DB_URL", "file://"+path)
+
+	// a db set up from v1_init.sql by hand, with data in it
+	init, err := fs.ReadFile(schema.FS, "v1_init.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, err := OpenDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(string(init)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec("insert into accounts (name, amount, currency, description) values ('Cash', 12.34, 'USD', '')"); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db, err := ConnectDb()
+	if err != nil {
+		t.Fatalf("connecting to a db set up before migrations were tracked: %v", err)
+	}
+	defer db.Close()
+	t.Cleanup(func() { transactionsFts.Store(false) })
+
+	migrations, err := LoadMigrations(schema.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := testSchemaVersion(t, db); version != migrations[len(migrations)-1].Version {
+		t.Errorf("version after connecting = %v, want %v", version, migrations[len(migrations)-1].Version)
+	}
+
+	var amount int64
+	if err := db.QueryRow("select amount from accounts where name = 'Cash'").Scan(&amount); err != nil {
+		t.Fatal(err)
+	}
+	if amount != 1234 {
+		t.Errorf("migrated amount = %v, want 1234", amount)
+	}
+}
+
+func TestBaselinePreRunnerSchema(t *testing.T) {
+	migrations, err := LoadMigrations(schema.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// untracked tables that aren't the hand made v1 schema still need an explicit baseline
+	db := openTestDb(t)
+	if _, err := db.Exec("CREATE TABLE accounts (id INTEGER PRIMARY KEY, name TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	baselined, err := BaselinePreRunnerSchema(db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baselined || testSchemaVersion(t, db) != 0 {
+		t.Errorf("baselined an unknown untracked schema")
+	}
+	if _, err := MigrateUp(db, migrations, 0, false); !errors.Is(err, ErrUntrackedSchema) {
+		t.Errorf("migrating an unknown untracked schema = %v, want %v", err, ErrUntrackedSchema)
+	}
+
+	// a tracked db is left alone
+	db = openTestDb(t)
+	if _, err := MigrateUp(db, migrations, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if baselined, err := BaselinePreRunnerSchema(db, migrations); err != nil || baselined {
+		t.Errorf("baselined a tracked db: %v, %v", baselined, err)
+	}
+}
@@ -0,0 +1,346 @@
+package greed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact decimal amount stored as integer minor units,
+// e.g. Money{Minor: -200019, Exponent: 2} is -2000.19
+type Money struct {
+	Minor    int64
+	Exponent uint8
+}
+
+var ErrInexactMoney = errors.New("amount can't be represented exactly")
+
+var ErrMoneyOverflow = errors.New("amount is out of range")
+
+// MaxMoneyExponent is the most fractional digits an amount can have, 10^18 is the biggest power of ten in int64
+const MaxMoneyExponent = 18
+
+func NewMoney(minor int64, exponent uint8) Money {
+	return Money{Minor: minor, Exponent: exponent}
+}
+
+// ZeroMoney returns zero amount in minor units of the currency
+func ZeroMoney(currency string) Money {
+	return Money{Exponent: CurrencyExponent(currency)}
+}
+
+// ParseMoney parses decimal string like "-2000.19" into minor units with the given exponent,
+// fails if the value has more fractional digits than exponent allows
+func ParseMoney(x string, exponent uint8) (Money, error) {
+	m, err := parseMoneyExact(x)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return m.Rescale(exponent)
+}
+
+// ParseCurrencyMoney parses decimal string into minor units of the currency
+func ParseCurrencyMoney(x string, currency string) (Money, error) {
+	return ParseMoney(x, CurrencyExponent(currency))
+}
+
+// ParseExactMoney parses decimal string of an amount without a currency, like a bound compared to amounts in any currency
+func ParseExactMoney(x string) (Money, error) {
+	return parseMoneyExact(x)
+}
+
+// parseMoneyExact keeps as many fractional digits as provided in x
+func parseMoneyExact(x string) (Money, error) {
+	s := strings.TrimSpace(x)
+
+	if s == "" {
+		return Money{}, fmt.Errorf("failed to parse amount %q: empty value", x)
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	intPart, fracPart, _ := strings.Cut(s, ".")
+
+	if intPart == "" && fracPart == "" {
+		return Money{}, fmt.Errorf("failed to parse amount %q: no digits", x)
+	}
+
+	// trailing zeros don't affect the value
+	fracPart = strings.TrimRight(fracPart, "0")
+
+	if len(fracPart) > MaxMoneyExponent {
+		return Money{}, fmt.Errorf("failed to parse amount %q: more than %v fractional digits", x, MaxMoneyExponent)
+	}
+
+	digits := intPart + fracPart
+	if digits == "" {
+		digits = "0"
+	}
+
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return Money{}, fmt.Errorf("failed to parse amount %q: unexpected character %q", x, r)
+		}
+	}
+
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return Money{}, fmt.Errorf("%w: %q", ErrMoneyOverflow, x)
+		}
+		return Money{}, fmt.Errorf("failed to parse amount %q: %v", x, err)
+	}
+
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Minor: minor, Exponent: uint8(len(fracPart))}, nil
+}
+
+func pow10(n uint8) (int64, error) {
+	if n > MaxMoneyExponent {
+		return 0, fmt.Errorf("%w: 10^%v doesn't fit into minor units", ErrMoneyOverflow, n)
+	}
+
+	result := int64(1)
+	for i := uint8(0); i < n; i++ {
+		result *= 10
+	}
+	return result, nil
+}
+
+// Rescale converts m into the given exponent, fails if precision would be lost
+func (m Money) Rescale(exponent uint8) (Money, error) {
+	switch {
+	case exponent == m.Exponent:
+		return m, nil
+	case exponent > m.Exponent:
+		factor, err := pow10(exponent - m.Exponent)
+		if err != nil {
+			return m, err
+		}
+		minor := m.Minor * factor
+		if minor/factor != m.Minor {
+			return m, fmt.Errorf("%w: %v with exponent %v", ErrMoneyOverflow, m, exponent)
+		}
+		return Money{Minor: minor, Exponent: exponent}, nil
+	default:
+		factor, err := pow10(m.Exponent - exponent)
+		if err != nil {
+			return m, err
+		}
+		if m.Minor%factor != 0 {
+			return m, fmt.Errorf("%w: %v with %v fractional digits", ErrInexactMoney, m, exponent)
+		}
+		return Money{Minor: m.Minor / factor, Exponent: exponent}, nil
+	}
+}
+
+// align brings both values to the common (bigger) exponent, fails when the other one overflows in it
+func align(a, b Money) (Money, Money, error) {
+	var err error
+	if a.Exponent > b.Exponent {
+		b, err = b.Rescale(a.Exponent)
+	} else if b.Exponent > a.Exponent {
+		a, err = a.Rescale(b.Exponent)
+	}
+	return a, b, err
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	a, b, err := align(m, other)
+	if err != nil {
+		return m, err
+	}
+
+	minor := a.Minor + b.Minor
+	if (b.Minor > 0 && minor < a.Minor) || (b.Minor < 0 && minor > a.Minor) {
+		return m, fmt.Errorf("%w: %v + %v", ErrMoneyOverflow, m, other)
+	}
+
+	return Money{Minor: minor, Exponent: a.Exponent}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	a, b, err := align(m, other)
+	if err != nil {
+		return m, err
+	}
+
+	minor := a.Minor - b.Minor
+	if (b.Minor < 0 && minor < a.Minor) || (b.Minor > 0 && minor > a.Minor) {
+		return m, fmt.Errorf("%w: %v - %v", ErrMoneyOverflow, m, other)
+	}
+
+	return Money{Minor: minor, Exponent: a.Exponent}, nil
+}
+
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Exponent: m.Exponent}
+}
+
+func (m Money) Abs() Money {
+	if m.Minor < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+func (m Money) Sign() int {
+	switch {
+	case m.Minor < 0:
+		return -1
+	case m.Minor > 0:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// rat is the exact value of m
+func (m Money) rat() *big.Rat {
+	// exponents are at most MaxMoneyExponent
+	denom, _ := pow10(m.Exponent)
+	return new(big.Rat).SetFrac(big.NewInt(m.Minor), big.NewInt(denom))
+}
+
+// Cmp returns -1, 0 or +1 the same way as big.Float.Cmp, it is exact in any exponents
+func (m Money) Cmp(other Money) int {
+	if m.Exponent == other.Exponent {
+		switch {
+		case m.Minor < other.Minor:
+			return -1
+		case m.Minor > other.Minor:
+			return 1
+		}
+		return 0
+	}
+
+	return m.rat().Cmp(other.rat())
+}
+
+// Ratio returns m / other as a decimal string rounded to precision fractional digits,
+// trailing zeros are trimmed, empty string when other is zero
+func (m Money) Ratio(other Money, precision int) string {
+	if other.IsZero() {
+		return ""
+	}
+
+	ratio := new(big.Rat).Quo(m.rat(), other.rat())
+
+	result := ratio.FloatString(precision)
+	if strings.Contains(result, ".") {
+		result = strings.TrimRight(strings.TrimRight(result, "0"), ".")
+	}
+
+	return result
+}
+
+// Convert multiplies m by rate and rounds the result half away from zero to the exponent,
+// fails when the result doesn't fit into minor units
+func (m Money) Convert(rate *big.Rat, exponent uint8) (Money, error) {
+	factor, err := pow10(exponent)
+	if err != nil {
+		return Money{}, err
+	}
+
+	value := m.rat()
+	value.Mul(value, rate)
+	value.Mul(value, new(big.Rat).SetInt64(factor))
+
+	// |value| + 1/2 truncated, sign restored afterwards
+	half := big.NewRat(1, 2)
+	abs := new(big.Rat).Abs(value)
+	abs.Add(abs, half)
+	minor := new(big.Int).Quo(abs.Num(), abs.Denom())
+
+	if value.Sign() < 0 {
+		minor.Neg(minor)
+	}
+
+	if !minor.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %v converted with rate %v", ErrMoneyOverflow, m, rate.FloatString(6))
+	}
+
+	return Money{Minor: minor.Int64(), Exponent: exponent}, nil
+}
+
+func (m Money) String() string {
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+	}
+
+	digits := strconv.FormatUint(absInt64(minor), 10)
+
+	if m.Exponent == 0 {
+		return sign + digits
+	}
+
+	exp := int(m.Exponent)
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func absInt64(x int64) uint64 {
+	if x < 0 {
+		return uint64(-(x + 1)) + 1
+	}
+	return uint64(x)
+}
+
+// MarshalJSON writes the amount as an exact decimal number literal
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts both number and string literals, the exponent is
+// taken from the provided fractional digits, use Rescale to match the currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var raw string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	} else {
+		raw = string(data)
+	}
+
+	if strings.ContainsAny(raw, "eE") {
+		return fmt.Errorf("failed to parse amount %q: exponent notation is not supported", raw)
+	}
+
+	parsed, err := parseMoneyExact(raw)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,188 @@
+package greed
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		x        string
+		exponent uint8
+		money    Money
+		ok       bool
+	}{
+		{"-2000.19", 2, NewMoney(-200019, 2), true},
+		{"+12.5", 2, NewMoney(1250, 2), true},
+		{" 7 ", 0, NewMoney(7, 0), true},
+		{".5", 1, NewMoney(5, 1), true},
+		{"100.", 2, NewMoney(10000, 2), true},
+		// trailing zeros don't make the amount inexact
+		{"1.2300", 2, NewMoney(123, 2), true},
+		{"1.005", 2, Money{}, false},
+		{"", 2, Money{}, false},
+		{"-", 2, Money{}, false},
+		{"1,5", 2, Money{}, false},
+		{"1e3", 2, Money{}, false},
+		{"9223372036854775808", 0, Money{}, false},
+		{"0.1234567890123456789", 2, Money{}, false},
+		{"0." + strings.Repeat("1", 255), 2, Money{}, false},
+		{"0." + strings.Repeat("0", 300) + "1", 2, Money{}, false},
+	}
+
+	for _, c := range cases {
+		money, err := ParseMoney(c.x, c.exponent)
+		if (err == nil) != c.ok {
+			t.Errorf("ParseMoney(%q, %v) error = %v, want ok %v", c.x, c.exponent, err, c.ok)
+			continue
+		}
+		if c.ok && money != c.money {
+			t.Errorf("ParseMoney(%q, %v) = %#v, want %#v", c.x, c.exponent, money, c.money)
+		}
+	}
+
+	money, err := ParseExactMoney("0.000000000000000001")
+	if err != nil || money != NewMoney(1, MaxMoneyExponent) {
+		t.Errorf("ParseExactMoney of 18 fractional digits = %#v, %v", money, err)
+	}
+}
+
+func TestMoneyRescale(t *testing.T) {
+	cases := []struct {
+		money    Money
+		exponent uint8
+		result   Money
+		err      error
+	}{
+		{NewMoney(125, 1), 2, NewMoney(1250, 2), nil},
+		{NewMoney(1250, 2), 0, Money{}, ErrInexactMoney},
+		{NewMoney(1200, 2), 0, NewMoney(12, 0), nil},
+		{NewMoney(-1200, 2), 1, NewMoney(-120, 1), nil},
+		{NewMoney(100, 0), MaxMoneyExponent, Money{}, ErrMoneyOverflow},
+		{NewMoney(1, 0), MaxMoneyExponent, NewMoney(1000000000000000000, MaxMoneyExponent), nil},
+		{NewMoney(1, 0), MaxMoneyExponent + 1, Money{}, ErrMoneyOverflow},
+		{NewMoney(1, 0), math.MaxUint8, Money{}, ErrMoneyOverflow},
+		{NewMoney(1, math.MaxUint8), 0, Money{}, ErrMoneyOverflow},
+	}
+
+	for _, c := range cases {
+		result, err := c.money.Rescale(c.exponent)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%#v.Rescale(%v) error = %v, want %v", c.money, c.exponent, err, c.err)
+			continue
+		}
+		if c.err == nil && result != c.result {
+			t.Errorf("%#v.Rescale(%v) = %#v, want %#v", c.money, c.exponent, result, c.result)
+		}
+	}
+}
+
+func TestMoneyAddSub(t *testing.T) {
+	sum, err := NewMoney(1050, 2).Add(NewMoney(5, 3))
+	if err != nil || sum != NewMoney(10505, 3) {
+		t.Errorf("10.50 + 0.005 = %#v, %v", sum, err)
+	}
+
+	difference, err := NewMoney(1050, 2).Sub(NewMoney(2, 0))
+	if err != nil || difference != NewMoney(850, 2) {
+		t.Errorf("10.50 - 2 = %#v, %v", difference, err)
+	}
+
+	tiny, err := ParseExactMoney("0.000000000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 100 has no room for 18 fractional digits
+	if sum, err := NewMoney(100, 0).Add(tiny); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("100 + 1e-18 = %v, %v, want an overflow", sum, err)
+	}
+
+	if sum, err := NewMoney(math.MaxInt64, 2).Add(NewMoney(1, 2)); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("max + 0.01 = %v, %v, want an overflow", sum, err)
+	}
+
+	if difference, err := NewMoney(math.MinInt64, 2).Sub(NewMoney(1, 2)); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("min - 0.01 = %v, %v, want an overflow", difference, err)
+	}
+}
+
+func TestMoneyCmp(t *testing.T) {
+	tiny, err := ParseExactMoney("0.000000000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		a   Money
+		b   Money
+		cmp int
+	}{
+		{NewMoney(1050, 2), NewMoney(105, 1), 0},
+		{NewMoney(-1, 0), NewMoney(1, 2), -1},
+		// amounts that don't fit into a common exponent are still compared exactly
+		{NewMoney(100, 0), tiny, 1},
+		{tiny.Neg(), NewMoney(-100, 0), 1},
+		{NewMoney(math.MaxInt64, 0), NewMoney(math.MaxInt64, 2), 1},
+	}
+
+	for _, c := range cases {
+		if cmp := c.a.Cmp(c.b); cmp != c.cmp {
+			t.Errorf("%v.Cmp(%v) = %v, want %v", c.a, c.b, cmp, c.cmp)
+		}
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	cases := []struct {
+		money    Money
+		rate     *big.Rat
+		exponent uint8
+		result   Money
+	}{
+		{NewMoney(10000, 2), big.NewRat(117, 1), 2, NewMoney(1170000, 2)},
+		// rounded half away from zero
+		{NewMoney(1, 2), big.NewRat(1, 2), 2, NewMoney(1, 2)},
+		{NewMoney(-1, 2), big.NewRat(1, 2), 2, NewMoney(-1, 2)},
+		{NewMoney(1, 2), big.NewRat(1, 3), 2, NewMoney(0, 2)},
+		{NewMoney(100, 0), big.NewRat(1, 117), 2, NewMoney(85, 2)},
+		{NewMoney(12345, 2), big.NewRat(1, 1), 0, NewMoney(123, 0)},
+	}
+
+	for _, c := range cases {
+		result, err := c.money.Convert(c.rate, c.exponent)
+		if err != nil || result != c.result {
+			t.Errorf("%v.Convert(%v, %v) = %#v, %v, want %#v", c.money, c.rate, c.exponent, result, err, c.result)
+		}
+	}
+
+	if result, err := NewMoney(math.MaxInt64, 0).Convert(big.NewRat(2, 1), 0); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("max converted with rate 2 = %v, %v, want an overflow", result, err)
+	}
+
+	if result, err := NewMoney(1, 0).Convert(big.NewRat(1, 1), MaxMoneyExponent+1); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("1 converted into %v fractional digits = %v, %v, want an overflow", MaxMoneyExponent+1, result, err)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := []struct {
+		money Money
+		x     string
+	}{
+		{NewMoney(-200019, 2), "-2000.19"},
+		{NewMoney(5, 2), "0.05"},
+		{NewMoney(-5, 3), "-0.005"},
+		{NewMoney(42, 0), "42"},
+		{NewMoney(math.MinInt64, 0), "-9223372036854775808"},
+	}
+
+	for _, c := range cases {
+		if x := c.money.String(); x != c.x {
+			t.Errorf("%#v.String() = %v, want %v", c.money, x, c.x)
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package greed
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrInvalidPayee = errors.New("invalid payee")
+
+// longest payee name in runes
+const MaxPayeeLength = 128
+
+// Payee is the canonical merchant of transactions, descriptions containing the words
+// of its name or of one of its aliases link to it
+type Payee struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+	// normalized patterns besides the name, "mcdonalds" matches "MCDONALDS 1234 BGD"
+	Aliases   []string  `json:"aliases,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PayeeSpent struct {
+	Payee        Payee          `json:"payee"`
+	Value        CurrencyAmount `json:"value"`
+	Transactions int            `json:"transactions"`
+}
+
+func (p *Payee) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *Payee) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+// NormalizePayeeText lowercases the text and keeps its words, numbers like card digits and
+// store ids are dropped so that "MCDONALDS 1234 BGD" reads "mcdonalds bgd"
+func NormalizePayeeText(text string) string {
+	return strings.Join(descriptionTokens(text), " ")
+}
+
+// patterns are the normalized name and aliases of the payee
+func (p Payee) patterns() []string {
+	return append([]string{NormalizePayeeText(p.Name)}, p.Aliases...)
+}
+
+// validate trims the name, normalizes the aliases and drops the repeats
+func (p *Payee) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidPayee)
+	}
+
+	if len([]rune(p.Name)) > MaxPayeeLength {
+		return fmt.Errorf("%w: name is longer than %v characters", ErrInvalidPayee, MaxPayeeLength)
+	}
+
+	name := NormalizePayeeText(p.Name)
+	if name == "" {
+		return fmt.Errorf("%w: name %q has no words", ErrInvalidPayee, p.Name)
+	}
+
+	var aliases []string
+	seen := map[string]bool{name: true}
+	for _, alias := range p.Aliases {
+		if strings.TrimSpace(alias) == "" {
+			continue
+		}
+
+		pattern := NormalizePayeeText(alias)
+		if pattern == "" {
+			return fmt.Errorf("%w: alias %q has no words", ErrInvalidPayee, alias)
+		}
+
+		if !seen[pattern] {
+			seen[pattern] = true
+			aliases = append(aliases, pattern)
+		}
+	}
+	p.Aliases = aliases
+
+	return nil
+}
+
+// matchLength is the length of the longest pattern of the payee found in the normalized text, 0 without a match
+func (p Payee) matchLength(normalized string) int {
+	best := 0
+	text := " " + normalized + " "
+
+	for _, pattern := range p.patterns() {
+		if pattern != "" && len(pattern) > best && strings.Contains(text, " "+pattern+" ") {
+			best = len(pattern)
+		}
+	}
+
+	return best
+}
+
+// MatchPayee finds the payee of the description, the payee with the longest matching pattern wins
+// so that "amazon prime" beats "amazon", nil when no payee matches
+func MatchPayee(payees []Payee, description string) *Payee {
+	normalized := NormalizePayeeText(description)
+	if normalized == "" {
+		return nil
+	}
+
+	var result *Payee
+	best := 0
+	for i := range payees {
+		if length := payees[i].matchLength(normalized); length > best {
+			best = length
+			result = &payees[i]
+		}
+	}
+
+	return result
+}
+
+func GetPayees[T DatabaseInterface](db T, userId int64) ([]Payee, error) {
+	var payees []Payee
+
+	rows, err := db.Query(
+		"select id, name, created_at from payees where user_id = ? order by name collate nocase, id",
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch payees failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Payee
+		var createdAt string
+
+		if err := rows.Scan(&p.Id, &p.Name, &createdAt); err != nil {
+			return nil, fmt.Errorf("fetch payees row failed: %v", err)
+		}
+
+		if p.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+			return nil, err
+		}
+
+		payees = append(payees, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during payees iteration: %v", err)
+	}
+
+	if err := fillPayeesAliases(db, userId, payees); err != nil {
+		return nil, err
+	}
+
+	return payees, nil
+}
+
+// fillPayeesAliases loads the aliases of the payees in one query
+func fillPayeesAliases[T DatabaseInterface](db T, userId int64, payees []Payee) error {
+	if len(payees) == 0 {
+		return nil
+	}
+
+	index := map[int64]int{}
+	for i := range payees {
+		index[payees[i].Id] = i
+	}
+
+	rows, err := db.Query(
+		`
+		select payee_aliases.payee_id, payee_aliases.pattern
+		from payee_aliases join payees on payees.id = payee_aliases.payee_id
+		where payees.user_id = ? order by payee_aliases.id
+		`,
+		userId,
+	)
+	if err != nil {
+		return fmt.Errorf("fetch payee aliases failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var payeeId int64
+		var pattern string
+
+		if err := rows.Scan(&payeeId, &pattern); err != nil {
+			return fmt.Errorf("fetch payee aliases row failed: %v", err)
+		}
+
+		if i, ok := index[payeeId]; ok {
+			payees[i].Aliases = append(payees[i].Aliases, pattern)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error during payee aliases iteration: %v", err)
+	}
+
+	return nil
+}
+
+func GetPayeeById[T DatabaseInterface](db T, userId int64, id int64) (Payee, error) {
+	p := Payee{Id: id}
+	var createdAt string
+
+	if err := db.QueryRow(
+		"select name, created_at from payees where id = ? and user_id = ?", id, userId,
+	).Scan(&p.Name, &createdAt); err != nil {
+		return p, fmt.Errorf("fetch payee %v failed: %w", id, err)
+	}
+
+	var err error
+	if p.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+		return p, err
+	}
+
+	payees := []Payee{p}
+	if err := fillPayeesAliases(db, userId, payees); err != nil {
+		return p, err
+	}
+
+	return payees[0], nil
+}
+
+// GetPayeeByName finds the payee by its name ignoring case, sql.ErrNoRows when there is none
+func GetPayeeByName[T DatabaseInterface](db T, userId int64, name string) (Payee, error) {
+	var id int64
+
+	if err := db.QueryRow(
+		"select id from payees where user_id = ? and name = ? collate nocase", userId, strings.TrimSpace(name),
+	).Scan(&id); err != nil {
+		return Payee{}, fmt.Errorf("fetch payee %q failed: %w", name, err)
+	}
+
+	return GetPayeeById(db, userId, id)
+}
+
+// payeeConflict finds another payee with the name or a pattern of the payee
+func payeeConflict(payees []Payee, payee Payee) error {
+	for _, other := range payees {
+		if other.Id == payee.Id {
+			continue
+		}
+
+		if strings.EqualFold(other.Name, payee.Name) {
+			return fmt.Errorf("%w: payee %q already exists", ErrInvalidPayee, other.Name)
+		}
+
+		for _, pattern := range payee.patterns() {
+			for _, otherPattern := range other.patterns() {
+				if pattern == otherPattern {
+					return fmt.Errorf("%w: %q already matches payee %q", ErrInvalidPayee, pattern, other.Name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPayeeConflicts makes sure that no other payee of the user has the name or a pattern of the payee
+func checkPayeeConflicts[T DatabaseInterface](db T, userId int64, payee Payee) error {
+	payees, err := GetPayees(db, userId)
+	if err != nil {
+		return err
+	}
+
+	return payeeConflict(payees, payee)
+}
+
+func setPayeeAliases[T DatabaseInterface](db T, payee Payee) error {
+	if _, err := db.Exec("delete from payee_aliases where payee_id = ?", payee.Id); err != nil {
+		return fmt.Errorf("failed to delete aliases of payee %v: %v", payee.Id, err)
+	}
+
+	for _, pattern := range payee.Aliases {
+		if _, err := db.Exec(
+			"insert into payee_aliases (payee_id, pattern) values (?, ?)", payee.Id, pattern,
+		); err != nil {
+			return fmt.Errorf("failed to add alias %q to payee %v: %v", pattern, payee.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// CreatePayee creates the payee and links the transactions without a payee matching it
+func CreatePayee[T DatabaseInterface](db T, userId int64, payee Payee) (Payee, error) {
+	if err := payee.validate(); err != nil {
+		return payee, err
+	}
+
+	if err := checkPayeeConflicts(db, userId, payee); err != nil {
+		return payee, err
+	}
+
+	payee.CreatedAt = time.Now().UTC()
+
+	result, err := db.Exec(
+		"insert into payees (user_id, name, created_at) values (?, ?, ?)",
+		userId, payee.Name, payee.CreatedAt.Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return payee, fmt.Errorf("failed to create payee %v: %v", payee.Name, err)
+	}
+
+	if payee.Id, err = result.LastInsertId(); err != nil {
+		return payee, fmt.Errorf("failed to get last inserted payee id: %v", err)
+	}
+
+	if err := setPayeeAliases(db, payee); err != nil {
+		return payee, err
+	}
+
+	if _, err := LinkPayees(db, userId); err != nil {
+		return payee, err
+	}
+
+	return GetPayeeById(db, userId, payee.Id)
+}
+
+// UpdatePayee renames the payee and replaces its aliases, the transactions already linked keep the payee
+// and the transactions without a payee matching the new aliases are linked
+func UpdatePayee[T DatabaseInterface](db T, userId int64, payee Payee) (Payee, error) {
+	if err := payee.validate(); err != nil {
+		return payee, err
+	}
+
+	if _, err := GetPayeeById(db, userId, payee.Id); err != nil {
+		return payee, err
+	}
+
+	if err := checkPayeeConflicts(db, userId, payee); err != nil {
+		return payee, err
+	}
+
+	if _, err := db.Exec(
+		"update payees set name = ? where id = ? and user_id = ?", payee.Name, payee.Id, userId,
+	); err != nil {
+		return payee, fmt.Errorf("failed to update payee %v: %v", payee.Id, err)
+	}
+
+	if err := setPayeeAliases(db, payee); err != nil {
+		return payee, err
+	}
+
+	if _, err := LinkPayees(db, userId); err != nil {
+		return payee, err
+	}
+
+	return GetPayeeById(db, userId, payee.Id)
+}
+
+// DeletePayee unlinks the transactions of the payee and deletes it with its aliases
+func DeletePayee[T DatabaseInterface](db T, userId int64, payeeId int64) error {
+	if _, err := GetPayeeById(db, userId, payeeId); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(
+		"update transactions set payee_id = null where payee_id = ? and user_id = ?", payeeId, userId,
+	); err != nil {
+		return fmt.Errorf("failed to unlink transactions of payee %v: %v", payeeId, err)
+	}
+
+	if _, err := db.Exec("delete from payee_aliases where payee_id = ?", payeeId); err != nil {
+		return fmt.Errorf("failed to delete aliases of payee %v: %v", payeeId, err)
+	}
+
+	if _, err := db.Exec("delete from payees where id = ? and user_id = ?", payeeId, userId); err != nil {
+		return fmt.Errorf("failed to delete payee %v: %v", payeeId, err)
+	}
+
+	return nil
+}
+
+// GetOrCreatePayee finds the payee by its name ignoring case or creates it
+func GetOrCreatePayee[T DatabaseInterface](db T, userId int64, name string) (Payee, error) {
+	payee, err := GetPayeeByName(db, userId, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CreatePayee(db, userId, Payee{Name: name})
+	}
+
+	return payee, err
+}
+
+// ResolvePayee finds the payee matching the description, nil when none of the payees of the user matches it
+func ResolvePayee[T DatabaseInterface](db T, userId int64, description string) (*Payee, error) {
+	payees, err := GetPayees(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if p := MatchPayee(payees, description); p != nil {
+		// transactions carry their payee without the aliases
+		payee := *p
+		payee.Aliases = nil
+		return &payee, nil
+	}
+
+	return nil, nil
+}
+
+// LinkPayees links the transactions without a payee to the payees matching their descriptions,
+// legs of transfers and reconciled transactions stay without one. Returns the number of linked transactions
+func LinkPayees[T DatabaseInterface](db T, userId int64) (int, error) {
+	payees, err := GetPayees(db, userId)
+	if err != nil || len(payees) == 0 {
+		return 0, err
+	}
+
+	rows, err := db.Query(
+		`
+		select id, description from transactions
+		where user_id = ? and payee_id is null and transfer_id is null and reconciliation_id is null
+		`,
+		userId,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("fetch unlinked transactions failed: %v", err)
+	}
+
+	links := map[int64][]int64{}
+	for rows.Next() {
+		var id int64
+		var description string
+
+		if err := rows.Scan(&id, &description); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("fetch unlinked transactions row failed: %v", err)
+		}
+
+		if p := MatchPayee(payees, description); p != nil {
+			links[p.Id] = append(links[p.Id], id)
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error during unlinked transactions iteration: %v", err)
+	}
+
+	linked := 0
+	for payeeId, ids := range links {
+		query, args, err := sq.
+			Update("transactions").
+			Set("payee_id", payeeId).
+			Where(sq.Eq{"id": ids, "user_id": userId}).
+			ToSql()
+		if err != nil {
+			return linked, err
+		}
+
+		if _, err := db.Exec(query, args...); err != nil {
+			return linked, fmt.Errorf("failed to link transactions to payee %v: %v", payeeId, err)
+		}
+		linked += len(ids)
+	}
+
+	return linked, nil
+}
+
+// SetTransactionPayee links the transaction to the payee, 0 unlinks it, reconciled transactions keep theirs
+func SetTransactionPayee[T DatabaseInterface](db T, userId int64, transactionId int64, payeeId int64) error {
+	transaction, err := GetTransactionById(db, userId, transactionId)
+	if err != nil {
+		return err
+	}
+
+	if transaction.ReconciliationId != 0 {
+		return fmt.Errorf("%w: transaction %v", ErrReconciled, transactionId)
+	}
+
+	var payee any
+	if payeeId != 0 {
+		if _, err := GetPayeeById(db, userId, payeeId); err != nil {
+			return err
+		}
+		payee = payeeId
+	}
+
+	if _, err := db.Exec(
+		"update transactions set payee_id = ? where id = ? and user_id = ?", payee, transactionId, userId,
+	); err != nil {
+		return fmt.Errorf("failed to set payee of transaction %v: %v", transactionId, err)
+	}
+
+	return nil
+}
+
+// scanTransactionPayee reads the payee columns of a transaction, nil without a payee
+func scanTransactionPayee(id *int64, name *string, createdAt *string) (*Payee, error) {
+	if id == nil || name == nil || createdAt == nil {
+		return nil, nil
+	}
+
+	parsedCreatedAt, err := ParseDbDatetime(*createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Payee{Id: *id, Name: *name, CreatedAt: parsedCreatedAt}, nil
+}
+
+// transactionPayeeId is the payee_id column of the transaction
+func transactionPayeeId(t Transaction) any {
+	if t.Payee == nil || t.Payee.Id == 0 {
+		return nil
+	}
+	return t.Payee.Id
+}
+
+// SearchPayees lists up to limit payees whose name contains the search ignoring case for autocompletion,
+// names starting with it come first, then the most used payees
+func SearchPayees[T DatabaseInterface](db T, userId int64, search string, limit int) ([]Payee, error) {
+	search = strings.ToLower(strings.TrimSpace(search))
+
+	payees, err := GetPayees(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[int64]int{}
+	rows, err := db.Query(
+		"select payee_id, count(*) from transactions where user_id = ? and payee_id is not null group by payee_id",
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch payees usage failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var payeeId int64
+		var count int
+
+		if err := rows.Scan(&payeeId, &count); err != nil {
+			return nil, fmt.Errorf("fetch payees usage row failed: %v", err)
+		}
+		usage[payeeId] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during payees usage iteration: %v", err)
+	}
+
+	result := []Payee{}
+	for _, p := range payees {
+		if strings.Contains(strings.ToLower(p.Name), search) {
+			result = append(result, p)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		iPrefix := strings.HasPrefix(strings.ToLower(result[i].Name), search)
+		jPrefix := strings.HasPrefix(strings.ToLower(result[j].Name), search)
+		if iPrefix != jPrefix {
+			return iPrefix
+		}
+		return usage[result[i].Id] > usage[result[j].Id]
+	})
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// GetExpensesByPayee sums expenses by payee grouped by currency like GetExpensesByCategory,
+// transactions without a payee are left out
+func GetExpensesByPayee[T DatabaseInterface](db T, userId int64, dateRange DateRange) ([]Pair[string, []PayeeSpent], error) {
+	var result []Pair[string, []PayeeSpent]
+
+	// the libsql driver reads the sums as 0 when text columns come before them
+	query := sq.
+		Select(
+			"payees.id",
+			"sum(abs(transactions.amount)) as total_amount",
+			"count(*)",
+			"accounts.currency as currency",
+			"payees.name",
+			"payees.created_at",
+		).
+		From("transactions").
+		Join("payees on payees.id = transactions.payee_id").
+		Join("accounts on accounts.id = transactions.account_id").
+		Where(sq.Eq{"transactions.user_id": userId}).
+		Where(sq.Lt{"transactions.amount": 0}).
+		Where(sq.Eq{"transactions.transfer_id": nil})
+
+	if !dateRange.DateStart.IsZero() {
+		query = query.Where(sq.GtOrEq{"datetime(transactions.created_at)": dateRange.DateStart.UTC()})
+	}
+
+	if !dateRange.DateEnd.IsZero() {
+		// DateRange.DateEnd is exclusive
+		query = query.Where(sq.Lt{"datetime(transactions.created_at)": dateRange.DateEnd.UTC()})
+	}
+
+	query = query.
+		GroupBy("payees.id", "currency").
+		OrderBy("currency asc", "total_amount desc")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("fetch payees total spent failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ps PayeeSpent
+		var amount int64
+		var createdAt string
+
+		if err := rows.Scan(&ps.Payee.Id, &amount, &ps.Transactions, &ps.Value.Currency, &ps.Payee.Name, &createdAt); err != nil {
+			return nil, fmt.Errorf("fetch payees spent row failed: %v", err)
+		}
+
+		if ps.Payee.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+			return nil, err
+		}
+
+		ps.Value.Amount = NewMoney(amount, CurrencyExponent(ps.Value.Currency))
+
+		if len(result) == 0 || result[len(result)-1].First != ps.Value.Currency {
+			result = append(result, Pair[string, []PayeeSpent]{First: ps.Value.Currency})
+		}
+		last := &result[len(result)-1]
+		last.Second = append(last.Second, ps)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during payees spent iteration: %v", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package greed
+
+import "testing"
+
+func TestMatchPayee(t *testing.T) {
+	payees := []Payee{
+		{Id: 1, Name: "McDonalds", Aliases: []string{"mcd"}},
+		{Id: 2, Name: "Amazon"},
+		{Id: 3, Name: "Amazon Prime", Aliases: []string{"prime video"}},
+	}
+
+	cases := []struct {
+		description string
+		payeeId     int64
+	}{
+		{"MCDONALDS 1234 BGD", 1},
+		{"mcdonalds", 1},
+		{"McDonalds, Belgrade #12", 1},
+		{"MCD*4411", 1},
+		{"mcdonaldsx", 0},
+		{"AMAZON MKTPLACE", 2},
+		{"amazon prime 12/24", 3},
+		{"PRIME VIDEO", 3},
+		{"prime", 0},
+		{"", 0},
+		{"1234", 0},
+	}
+
+	for _, c := range cases {
+		var got int64
+		if p := MatchPayee(payees, c.description); p != nil {
+			got = p.Id
+		}
+		if got != c.payeeId {
+			t.Errorf("MatchPayee(%q) = %v, want %v", c.description, got, c.payeeId)
+		}
+	}
+}
+
+func TestPayeeValidate(t *testing.T) {
+	p := Payee{Name: "  McDonalds ", Aliases: []string{"MCD 1234", "mcd", " ", "McDonalds"}}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Name != "McDonalds" || len(p.Aliases) != 1 || p.Aliases[0] != "mcd" {
+		t.Errorf("validate() = %q %q, want \"McDonalds\" [\"mcd\"]", p.Name, p.Aliases)
+	}
+
+	for _, invalid := range []Payee{{Name: ""}, {Name: "1234"}, {Name: "Shop", Aliases: []string{"#1"}}} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("validate(%+v) passed", invalid)
+		}
+	}
+}
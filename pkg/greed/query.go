@@ -0,0 +1,410 @@
+package greed
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// QueryField is what a term of the search query matches, free text matches account, description and category
+type QueryField string
+
+const (
+	QueryText        QueryField = ""
+	QueryAccount     QueryField = "account"
+	QueryCategory    QueryField = "category"
+	QueryDescription QueryField = "description"
+	QueryTag         QueryField = "tag"
+	QueryCurrency    QueryField = "currency"
+	QueryAmount      QueryField = "amount"
+	QueryAfter       QueryField = "after"
+	QueryBefore      QueryField = "before"
+)
+
+var QueryFields = []QueryField{
+	QueryAccount, QueryCategory, QueryDescription, QueryTag, QueryCurrency, QueryAmount, QueryAfter, QueryBefore,
+}
+
+// short names of the fields
+var queryFieldAliases = map[string]QueryField{
+	"acc":  QueryAccount,
+	"cat":  QueryCategory,
+	"desc": QueryDescription,
+}
+
+// comparisons of amount terms, longer ones first so that <= isn't read as <
+var queryOps = []string{"<=", ">=", "<", ">", "="}
+
+// QueryTerm is a single condition of the search query like -tag:work or amount:<-1000
+type QueryTerm struct {
+	Negated bool
+	Field   QueryField
+	// text of text fields, normalized tag name of tags, upper case code of currencies
+	Text string
+	// comparison and value of amount terms
+	Op     string
+	Amount Money
+	// day of after and before terms, after includes it, before doesn't
+	Date time.Time
+}
+
+func queryError(position int, format string, args ...any) error {
+	return fmt.Errorf("%w at %v: %v", ErrInvalidQuery, position, fmt.Sprintf(format, args...))
+}
+
+// queryLexer walks the query by runes, positions in errors start from 1
+type queryLexer struct {
+	runes []rune
+	pos   int
+}
+
+func (l *queryLexer) done() bool {
+	return l.pos >= len(l.runes)
+}
+
+func (l *queryLexer) peek() rune {
+	return l.runes[l.pos]
+}
+
+func (l *queryLexer) skipSpaces() {
+	for !l.done() && unicode.IsSpace(l.peek()) {
+		l.pos++
+	}
+}
+
+// word reads until a space or, when stopAtColon is set, a colon
+func (l *queryLexer) word(stopAtColon bool) string {
+	start := l.pos
+	for !l.done() && !unicode.IsSpace(l.peek()) && !(stopAtColon && l.peek() == ':') {
+		l.pos++
+	}
+	return string(l.runes[start:l.pos])
+}
+
+// quoted reads a "quoted value", \" and \\ escape quotes and backslashes
+func (l *queryLexer) quoted() (string, error) {
+	start := l.pos
+	l.pos++
+
+	var value strings.Builder
+	for !l.done() {
+		r := l.peek()
+		l.pos++
+
+		switch {
+		case r == '"':
+			return value.String(), nil
+		case r == '\\' && !l.done() && (l.peek() == '"' || l.peek() == '\\'):
+			value.WriteRune(l.peek())
+			l.pos++
+		default:
+			value.WriteRune(r)
+		}
+	}
+
+	return "", queryError(start+1, "quote isn't closed")
+}
+
+// value reads a quoted or a plain value
+func (l *queryLexer) value() (string, error) {
+	if !l.done() && l.peek() == '"' {
+		return l.quoted()
+	}
+	return l.word(false), nil
+}
+
+func isQueryFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func queryFieldNames() string {
+	names := make([]string, 0, len(QueryFields))
+	for _, f := range QueryFields {
+		names = append(names, string(f))
+	}
+	return strings.Join(names, ", ")
+}
+
+// ParseQuery reads the search query, e.g. account:"Visa RSD" cat:food amount:<-1000 after:2024-01-01 "mcdonalds" -tag:work,
+// all the terms have to match, - excludes the transactions matching the term
+func ParseQuery(query string) ([]QueryTerm, error) {
+	l := queryLexer{runes: []rune(query)}
+	var terms []QueryTerm
+
+	for l.skipSpaces(); !l.done(); l.skipSpaces() {
+		start := l.pos + 1
+		var term QueryTerm
+
+		if l.peek() == '-' {
+			term.Negated = true
+			l.pos++
+			if l.done() || unicode.IsSpace(l.peek()) {
+				return nil, queryError(start, "nothing to exclude after -")
+			}
+		}
+
+		if l.peek() == '"' {
+			text, err := l.quoted()
+			if err != nil {
+				return nil, err
+			}
+			if text == "" {
+				return nil, queryError(start, "empty quotes")
+			}
+			term.Text = text
+			terms = append(terms, term)
+			continue
+		}
+
+		word := l.word(true)
+
+		// a word like 12:30 is free text, only letters make a field name
+		if l.done() || l.peek() != ':' || !isQueryFieldName(word) {
+			term.Text = word + l.word(false)
+			terms = append(terms, term)
+			continue
+		}
+		l.pos++
+
+		name := strings.ToLower(word)
+		term.Field = QueryField(name)
+		if alias, ok := queryFieldAliases[name]; ok {
+			term.Field = alias
+		}
+
+		known := false
+		for _, f := range QueryFields {
+			known = known || term.Field == f
+		}
+		if !known {
+			return nil, queryError(start, "unknown field %q, use one of %v", word, queryFieldNames())
+		}
+
+		valueStart := l.pos + 1
+		value, err := l.value()
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(value) == "" {
+			return nil, queryError(valueStart, "%v needs a value", term.Field)
+		}
+
+		if err := term.setValue(value); err != nil {
+			return nil, queryError(valueStart, "%v", err)
+		}
+
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+// setValue checks and keeps the value of the field term
+func (t *QueryTerm) setValue(value string) error {
+	switch t.Field {
+	case QueryTag:
+		name, err := NormalizeTagName(value)
+		if err != nil {
+			return err
+		}
+		t.Text = name
+	case QueryCurrency:
+		t.Text = strings.ToUpper(value)
+		if !IsSupportedCurrency(t.Text) {
+			return fmt.Errorf("%w: %v", ErrUnsupportedCurrency, value)
+		}
+	case QueryAmount:
+		t.Op = "="
+		for _, op := range queryOps {
+			if strings.HasPrefix(value, op) {
+				t.Op = op
+				value = value[len(op):]
+				break
+			}
+		}
+
+		amount, err := parseMoneyExact(value)
+		if err != nil {
+			return fmt.Errorf("amount %q isn't a number", value)
+		}
+		if _, err := amount.Rescale(comparedAmountExponent(amount)); err != nil {
+			return fmt.Errorf("amount %q is too big", value)
+		}
+		t.Amount = amount
+	case QueryAfter, QueryBefore:
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return fmt.Errorf("%v date %q isn't YYYY-MM-DD", t.Field, value)
+		}
+		t.Date = date
+	default:
+		t.Text = value
+	}
+
+	return nil
+}
+
+// notCondition negates the condition, the negated condition must not be null
+type notCondition struct {
+	sq.Sqlizer
+}
+
+func (n notCondition) ToSql() (string, []any, error) {
+	sql, args, err := n.Sqlizer.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("not (%v)", sql), args, nil
+}
+
+// errorCondition fails the query it is part of
+type errorCondition struct {
+	err error
+}
+
+func (e errorCondition) ToSql() (string, []any, error) {
+	return "", nil, e.err
+}
+
+// likeContains matches the text anywhere in the column, % and _ of the text are matched literally
+func likeContains(column string, text string) sq.Sqlizer {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+	return sq.Expr(fmt.Sprintf(`coalesce(%v, '') like ? escape '\'`, column), "%"+escaped+"%")
+}
+
+// categoryMatches matches transactions of the categories named like the text, their subcategories
+// and split transactions with such lines
+func categoryMatches(userId int64, text string) sq.Sqlizer {
+	matched, args, _ := likeContains("name", text).ToSql()
+	categories := `(
+		with recursive matched_categories(id) as (
+			select id from categories where user_id = ? and ` + matched + `
+			union
+			select categories.id from categories
+			join matched_categories on categories.parent_id = matched_categories.id
+		)
+		select id from matched_categories
+	)`
+
+	args = append([]any{userId}, args...)
+	return sq.Or{
+		sq.Expr("coalesce(transactions.category_id in "+categories+", 0)", args...),
+		sq.Expr(
+			"exists (select 1 from transaction_splits where transaction_splits.transaction_id = transactions.id and transaction_splits.category_id in "+categories+")",
+			args...,
+		),
+	}
+}
+
+// comparedAmountExponent is the number of fractional digits amounts in any currency are compared with the amount in
+func comparedAmountExponent(amount Money) uint8 {
+	exponent := amount.Exponent
+	if DefaultCurrencyExponent > exponent {
+		exponent = DefaultCurrencyExponent
+	}
+	for _, e := range CurrencyExponents {
+		if e > exponent {
+			exponent = e
+		}
+	}
+	return exponent
+}
+
+// amountMatches compares amounts in different currencies by scaling them to the same number of fractional digits
+func amountMatches(op string, amount Money) sq.Sqlizer {
+	exponent := comparedAmountExponent(amount)
+	scaled, err := amount.Rescale(exponent)
+	if err != nil {
+		return errorCondition{err}
+	}
+
+	currencies := make([]string, 0, len(CurrencyExponents))
+	for currency := range CurrencyExponents {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var scale strings.Builder
+	scale.WriteString("case accounts.currency")
+	for _, currency := range currencies {
+		factor, err := pow10(exponent - CurrencyExponents[currency])
+		if err != nil {
+			return errorCondition{err}
+		}
+		fmt.Fprintf(&scale, " when '%v' then %v", currency, factor)
+	}
+	factor, err := pow10(exponent - DefaultCurrencyExponent)
+	if err != nil {
+		return errorCondition{err}
+	}
+	fmt.Fprintf(&scale, " else %v end", factor)
+
+	return sq.Expr(fmt.Sprintf("transactions.amount * (%v) %v ?", scale.String(), op), scaled.Minor)
+}
+
+// descriptionMatches uses the full-text index when there is one, it matches words by prefix, LIKE matches anywhere
+func descriptionMatches(text string) sq.Sqlizer {
+	if transactionsFts.Load() && isFtsText(text) {
+		return ftsMatches(text)
+	}
+	return likeContains("transactions.description", text)
+}
+
+// condition turns the term into a condition on transactions joined with their accounts and categories
+func (t QueryTerm) condition(userId int64) sq.Sqlizer {
+	var condition sq.Sqlizer
+
+	switch t.Field {
+	case QueryAccount:
+		condition = likeContains("accounts.name", t.Text)
+	case QueryCategory:
+		condition = categoryMatches(userId, t.Text)
+	case QueryDescription:
+		condition = descriptionMatches(t.Text)
+	case QueryTag:
+		condition = taggedWithAll(userId, []string{t.Text})
+	case QueryCurrency:
+		condition = sq.Eq{"accounts.currency": t.Text}
+	case QueryAmount:
+		condition = amountMatches(t.Op, t.Amount)
+	case QueryAfter:
+		condition = sq.GtOrEq{"datetime(transactions.created_at)": t.Date.UTC()}
+	case QueryBefore:
+		condition = sq.Lt{"datetime(transactions.created_at)": t.Date.UTC()}
+	default:
+		condition = sq.Or{
+			likeContains("accounts.name", t.Text),
+			descriptionMatches(t.Text),
+			likeContains("categories.name", t.Text),
+		}
+	}
+
+	if t.Negated {
+		return notCondition{condition}
+	}
+	return condition
+}
+
+// queryCondition requires all the terms to match
+func queryCondition(userId int64, terms []QueryTerm) sq.Sqlizer {
+	conditions := sq.And{}
+	for _, t := range terms {
+		conditions = append(conditions, t.condition(userId))
+	}
+	return conditions
+}
@@ -0,0 +1,143 @@
+package greed
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	cases := []struct {
+		query string
+		terms []QueryTerm
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"coffee", []QueryTerm{{Text: "coffee"}}},
+		{"coffee  beans", []QueryTerm{{Text: "coffee"}, {Text: "beans"}}},
+		{`"coffee beans"`, []QueryTerm{{Text: "coffee beans"}}},
+		{`"say \"hi\" \\o"`, []QueryTerm{{Text: `say "hi" \o`}}},
+		{"-coffee", []QueryTerm{{Negated: true, Text: "coffee"}}},
+		{`-"coffee beans"`, []QueryTerm{{Negated: true, Text: "coffee beans"}}},
+		{"12:30", []QueryTerm{{Text: "12:30"}}},
+		{"a-b", []QueryTerm{{Text: "a-b"}}},
+		{`account:"Visa RSD"`, []QueryTerm{{Field: QueryAccount, Text: "Visa RSD"}}},
+		{"acc:visa", []QueryTerm{{Field: QueryAccount, Text: "visa"}}},
+		{"cat:food", []QueryTerm{{Field: QueryCategory, Text: "food"}}},
+		{"Category:food", []QueryTerm{{Field: QueryCategory, Text: "food"}}},
+		{"desc:lunch", []QueryTerm{{Field: QueryDescription, Text: "lunch"}}},
+		{"description:a:b", []QueryTerm{{Field: QueryDescription, Text: "a:b"}}},
+		{"tag:Work", []QueryTerm{{Field: QueryTag, Text: "work"}}},
+		{"-tag:work", []QueryTerm{{Negated: true, Field: QueryTag, Text: "work"}}},
+		{"currency:rsd", []QueryTerm{{Field: QueryCurrency, Text: "RSD"}}},
+		{"amount:12.5", []QueryTerm{{Field: QueryAmount, Op: "=", Amount: NewMoney(125, 1)}}},
+		{"amount:=12", []QueryTerm{{Field: QueryAmount, Op: "=", Amount: NewMoney(12, 0)}}},
+		{"amount:<-1000", []QueryTerm{{Field: QueryAmount, Op: "<", Amount: NewMoney(-1000, 0)}}},
+		{"amount:<=-1000", []QueryTerm{{Field: QueryAmount, Op: "<=", Amount: NewMoney(-1000, 0)}}},
+		{"amount:>0.99", []QueryTerm{{Field: QueryAmount, Op: ">", Amount: NewMoney(99, 2)}}},
+		{"amount:>=5", []QueryTerm{{Field: QueryAmount, Op: ">=", Amount: NewMoney(5, 0)}}},
+		{"after:2024-01-01", []QueryTerm{{Field: QueryAfter, Date: date("2024-01-01")}}},
+		{"before:2024-02-29", []QueryTerm{{Field: QueryBefore, Date: date("2024-02-29")}}},
+		{
+			`account:"Visa RSD" cat:food amount:<-1000 after:2024-01-01 "mcdonalds" -tag:work`,
+			[]QueryTerm{
+				{Field: QueryAccount, Text: "Visa RSD"},
+				{Field: QueryCategory, Text: "food"},
+				{Field: QueryAmount, Op: "<", Amount: NewMoney(-1000, 0)},
+				{Field: QueryAfter, Date: date("2024-01-01")},
+				{Text: "mcdonalds"},
+				{Negated: true, Field: QueryTag, Text: "work"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		terms, err := ParseQuery(c.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %v", c.query, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(terms, c.terms) {
+			t.Errorf("ParseQuery(%q) = %+v, expected %+v", c.query, terms, c.terms)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	cases := []struct {
+		query string
+		// the error has to contain it
+		message string
+	}{
+		{`"coffee`, "at 1: quote isn't closed"},
+		{`cat:"food`, "at 5: quote isn't closed"},
+		{`""`, "at 1: empty quotes"},
+		{"-", "at 1: nothing to exclude after -"},
+		{"coffee - beans", "at 8: nothing to exclude after -"},
+		{"foo:bar", `at 1: unknown field "foo", use one of account, category`},
+		{"cat:", "at 5: category needs a value"},
+		{"cat: food", "at 5: category needs a value"},
+		{`cat:""`, "at 5: category needs a value"},
+		{"amount:<", `at 8: amount "" isn't a number`},
+		{"amount:abc", `at 8: amount "abc" isn't a number`},
+		{"amount:=<5", `amount "<5" isn't a number`},
+		{"after:yesterday", `at 7: after date "yesterday" isn't YYYY-MM-DD`},
+		{"before:2024-13-01", `before date "2024-13-01" isn't YYYY-MM-DD`},
+		{"currency:xyz", "unsupported currency: xyz"},
+		{"tag:a,b", "can't contain commas"},
+		{"coffee -tag:", "at 13: tag needs a value"},
+		{"☕ foo:x", "at 3: unknown field"},
+	}
+
+	for _, c := range cases {
+		_, err := ParseQuery(c.query)
+		if err == nil {
+			t.Errorf("ParseQuery(%q) didn't fail", c.query)
+			continue
+		}
+
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseQuery(%q) failed with %v, expected ErrInvalidQuery", c.query, err)
+		}
+
+		if !strings.Contains(err.Error(), c.message) {
+			t.Errorf("ParseQuery(%q) failed with %q, expected %q in it", c.query, err.Error(), c.message)
+		}
+	}
+}
+
+func TestQueryCondition(t *testing.T) {
+	terms, err := ParseQuery(`-"a%b" amount:<-10.5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sql, args, err := queryCondition(1, terms).ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(sql, "(not ((coalesce(accounts.name, '') like ? escape '\\'") {
+		t.Errorf("unexpected sql of a negated text: %v", sql)
+	}
+
+	if !strings.Contains(sql, "transactions.amount * (case accounts.currency when 'EUR' then 1 when 'GBP' then 1 when 'JPY' then 100") {
+		t.Errorf("unexpected sql of an amount: %v", sql)
+	}
+
+	expected := []any{`%a\%b%`, `%a\%b%`, `%a\%b%`, int64(-1050)}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("args = %v, expected %v", args, expected)
+	}
+}
@@ -0,0 +1,567 @@
+package greed
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+var ErrInvalidRate = errors.New("invalid exchange rate")
+
+// ExchangeRate says that 1 unit of Base is worth Rate units of Quote starting from ValidFrom
+type ExchangeRate struct {
+	Id    int64  `json:"id"`
+	Base  string `json:"base"`
+	Quote string `json:"quote"`
+	// exact positive decimal, e.g. "0.9137"
+	Rate      string    `json:"rate"`
+	ValidFrom time.Time `json:"valid_from"`
+	Source    string    `json:"source"`
+}
+
+var rateRe = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+// ParseRate parses a positive decimal rate
+func ParseRate(x string) (*big.Rat, error) {
+	s := strings.TrimSpace(x)
+
+	if !rateRe.MatchString(s) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRate, x)
+	}
+
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRate, x)
+	}
+
+	return rate, nil
+}
+
+func (r *ExchangeRate) validate() error {
+	r.Base = strings.ToUpper(strings.TrimSpace(r.Base))
+	r.Quote = strings.ToUpper(strings.TrimSpace(r.Quote))
+
+	for _, currency := range []string{r.Base, r.Quote} {
+		if !IsSupportedCurrency(currency) {
+			return fmt.Errorf("%w: %v", ErrUnsupportedCurrency, currency)
+		}
+	}
+
+	if r.Base == r.Quote {
+		return fmt.Errorf("%w: base and quote currencies are the same", ErrInvalidRate)
+	}
+
+	if r.ValidFrom.IsZero() {
+		return fmt.Errorf("%w: valid_from is required", ErrInvalidRate)
+	}
+
+	if _, err := ParseRate(r.Rate); err != nil {
+		return err
+	}
+
+	r.Rate = strings.TrimSpace(r.Rate)
+	r.ValidFrom = r.ValidFrom.UTC()
+	return nil
+}
+
+// RateProvider supplies exchange rates to be stored by ImportRates
+type RateProvider interface {
+	// stored as the source of the imported rates
+	Name() string
+	FetchRates() ([]ExchangeRate, error)
+}
+
+// ManualRateProvider provides rates entered by the user
+type ManualRateProvider struct {
+	Rates []ExchangeRate
+}
+
+func (p ManualRateProvider) Name() string {
+	return "manual"
+}
+
+func (p ManualRateProvider) FetchRates() ([]ExchangeRate, error) {
+	return p.Rates, nil
+}
+
+// CsvRateProvider reads rates from csv with the header containing date, base, quote and rate columns,
+// dates are either 2006-01-02 or RFC3339
+type CsvRateProvider struct {
+	Reader io.Reader
+}
+
+func (p CsvRateProvider) Name() string {
+	return "file"
+}
+
+func (p CsvRateProvider) FetchRates() ([]ExchangeRate, error) {
+	reader := csv.NewReader(p.Reader)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read csv header: %v", ErrInvalidRate, err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"date", "base", "quote", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: csv has no %v column", ErrInvalidRate, name)
+		}
+	}
+
+	var rates []ExchangeRate
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read csv line %v: %v", ErrInvalidRate, line, err)
+		}
+
+		validFrom, err := parseRateDate(record[columns["date"]])
+		if err != nil {
+			return nil, fmt.Errorf("%w: csv line %v: %v", ErrInvalidRate, line, err)
+		}
+
+		rates = append(rates, ExchangeRate{
+			Base:      record[columns["base"]],
+			Quote:     record[columns["quote"]],
+			Rate:      record[columns["rate"]],
+			ValidFrom: validFrom,
+		})
+	}
+
+	return rates, nil
+}
+
+func parseRateDate(x string) (time.Time, error) {
+	s := strings.TrimSpace(x)
+
+	if parsed, err := time.Parse(time.DateOnly, s); err == nil {
+		return parsed, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid date %q", x)
+	}
+
+	return parsed, nil
+}
+
+// ImportRates validates and stores all rates of the provider, a rate of the same pair
+// and valid_from replaces the stored one, nothing is stored if any rate is invalid
+func ImportRates[T DatabaseInterface](db T, userId int64, provider RateProvider) (int, error) {
+	rates, err := provider.FetchRates()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range rates {
+		if err := rates[i].validate(); err != nil {
+			return 0, fmt.Errorf("rate #%v: %w", i+1, err)
+		}
+	}
+
+	for _, r := range rates {
+		if _, err := db.Exec(
+			`
+			insert into exchange_rates (user_id, base, quote, rate, valid_from, source)
+			values (?, ?, ?, ?, ?, ?)
+			on conflict (user_id, base, quote, valid_from) do update set rate = excluded.rate, source = excluded.source
+			`,
+			userId, r.Base, r.Quote, r.Rate, r.ValidFrom.Format(DATETIME_DB_LAYOUT), provider.Name(),
+		); err != nil {
+			return 0, fmt.Errorf("failed to store rate %v/%v: %v", r.Base, r.Quote, err)
+		}
+	}
+
+	return len(rates), nil
+}
+
+func GetExchangeRates[T DatabaseInterface](db T, userId int64) ([]ExchangeRate, error) {
+	var rates []ExchangeRate
+
+	rows, err := db.Query(
+		`
+		select id, base, quote, rate, valid_from, source
+		from exchange_rates
+		where user_id = ?
+		order by datetime(valid_from) desc, base asc, quote asc
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch exchange rates failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r ExchangeRate
+		var validFrom string
+
+		if err := rows.Scan(&r.Id, &r.Base, &r.Quote, &r.Rate, &validFrom, &r.Source); err != nil {
+			return nil, fmt.Errorf("fetch exchange rates row failed: %v", err)
+		}
+
+		if r.ValidFrom, err = ParseDbDatetime(validFrom); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during exchange rates iteration: %v", err)
+	}
+
+	return rates, nil
+}
+
+func DeleteExchangeRate[T DatabaseInterface](db T, userId int64, rateId int64) error {
+	result, err := db.Exec("delete from exchange_rates where id = ? and user_id = ?", rateId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete exchange rate %v: %v", rateId, err)
+	}
+
+	rowsDeleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows deleted for exchange rate %v: %v", rateId, err)
+	}
+
+	if rowsDeleted == 0 {
+		return fmt.Errorf("delete for exchange rate %v: %w", rateId, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+type datedRate struct {
+	validFrom time.Time
+	rate      *big.Rat
+}
+
+// RateTable looks up the rate valid at a given time, inverse pairs are used when the direct one is missing
+type RateTable struct {
+	// sorted by validFrom asc
+	rates map[[2]string][]datedRate
+}
+
+func NewRateTable(rates []ExchangeRate) (RateTable, error) {
+	table := RateTable{rates: map[[2]string][]datedRate{}}
+
+	for _, r := range rates {
+		rate, err := ParseRate(r.Rate)
+		if err != nil {
+			return table, err
+		}
+
+		pair := [2]string{r.Base, r.Quote}
+		table.rates[pair] = append(table.rates[pair], datedRate{validFrom: r.ValidFrom, rate: rate})
+	}
+
+	for _, pairRates := range table.rates {
+		sort.Slice(pairRates, func(i, j int) bool {
+			return pairRates[i].validFrom.Before(pairRates[j].validFrom)
+		})
+	}
+
+	return table, nil
+}
+
+func LoadRateTable[T DatabaseInterface](db T, userId int64) (RateTable, error) {
+	rates, err := GetExchangeRates(db, userId)
+	if err != nil {
+		return RateTable{}, err
+	}
+
+	return NewRateTable(rates)
+}
+
+func (t RateTable) lookup(base, quote string, at time.Time) *big.Rat {
+	pairRates := t.rates[[2]string{base, quote}]
+
+	// first rate that starts after at, the previous one is valid at at
+	i := sort.Search(len(pairRates), func(i int) bool {
+		return pairRates[i].validFrom.After(at)
+	})
+
+	if i == 0 {
+		return nil
+	}
+
+	return pairRates[i-1].rate
+}
+
+// Rate returns how many units of quote 1 unit of base is worth at the given time
+func (t RateTable) Rate(base, quote string, at time.Time) (*big.Rat, bool) {
+	if base == quote {
+		return big.NewRat(1, 1), true
+	}
+
+	if rate := t.lookup(base, quote, at); rate != nil {
+		return rate, true
+	}
+
+	if inverse := t.lookup(quote, base, at); inverse != nil {
+		return new(big.Rat).Inv(inverse), true
+	}
+
+	return nil, false
+}
+
+// Convert converts the amount in currency into the target currency with the rate valid at the given time,
+// false when there is no such rate
+func (t RateTable) Convert(amount Money, currency string, target string, at time.Time) (Money, bool, error) {
+	rate, ok := t.Rate(currency, target, at)
+	if !ok {
+		return Money{}, false, nil
+	}
+
+	converted, err := amount.Convert(rate, CurrencyExponent(target))
+	return converted, true, err
+}
+
+// ConvertedAmount is a total of amounts in different currencies converted into one currency
+type ConvertedAmount struct {
+	Value CurrencyAmount `json:"value"`
+	// currencies without a rate valid at the time of the amount, such amounts aren't included
+	MissingRates []string `json:"missing_rates,omitempty"`
+}
+
+func (c *ConvertedAmount) add(table RateTable, amount Money, currency string, at time.Time) error {
+	converted, ok, err := table.Convert(amount, currency, c.Value.Currency, at)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		for _, missing := range c.MissingRates {
+			if missing == currency {
+				return nil
+			}
+		}
+		c.MissingRates = append(c.MissingRates, currency)
+		return nil
+	}
+
+	total, err := c.Value.Amount.Add(converted)
+	if err != nil {
+		return err
+	}
+
+	c.Value.Amount = total
+	return nil
+}
+
+func newConvertedAmount(currency string) ConvertedAmount {
+	return ConvertedAmount{Value: CurrencyAmount{Currency: currency, Amount: ZeroMoney(currency)}}
+}
+
+type ConvertedStats struct {
+	// net worth, signed sum of all account balances at the current rates
+	Balance         ConvertedAmount          `json:"balance"`
+	CashFlow        ConvertedAmount          `json:"cash_flow"`
+	CategoriesSpent []ConvertedCategorySpent `json:"categories"`
+}
+
+type ConvertedCategorySpent struct {
+	Category Category `json:"category"`
+	// includes the subcategories
+	Spent         ConvertedAmount          `json:"spent"`
+	Subcategories []ConvertedCategorySpent `json:"subcategories,omitempty"`
+}
+
+// statsTransaction is a transaction or a split line of it counted in income/expense stats
+type statsTransaction struct {
+	amount    Money
+	currency  string
+	category  Category
+	createdAt time.Time
+}
+
+func getStatsTransactions[T DatabaseInterface](db T, userId int64, dateRange DateRange) ([]statsTransaction, error) {
+	query := sq.
+		Select(
+			"transactions.amount",
+			"accounts.currency",
+			"categories.id",
+			"categories.name",
+			"transactions.created_at",
+		).
+		From(transactionLines).
+		Join("accounts on accounts.id = transactions.account_id").
+		Join("categories on categories.id = transactions.category_id").
+		Where(sq.Eq{"transactions.user_id": userId}).
+		Where(sq.Eq{"transactions.transfer_id": nil})
+
+	if !dateRange.DateStart.IsZero() {
+		query = query.Where(sq.GtOrEq{"datetime(transactions.created_at)": dateRange.DateStart.UTC()})
+	}
+
+	if !dateRange.DateEnd.IsZero() {
+		// DateRange.DateEnd is exclusive
+		query = query.Where(sq.Lt{"datetime(transactions.created_at)": dateRange.DateEnd.UTC()})
+	}
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("fetch stats transactions failed: %v", err)
+	}
+	defer rows.Close()
+
+	var transactions []statsTransaction
+
+	for rows.Next() {
+		var t statsTransaction
+		var amount int64
+		var createdAt string
+
+		if err := rows.Scan(&amount, &t.currency, &t.category.Id, &t.category.Name, &createdAt); err != nil {
+			return nil, fmt.Errorf("fetch stats transactions row failed: %v", err)
+		}
+
+		t.amount = NewMoney(amount, CurrencyExponent(t.currency))
+
+		if t.createdAt, err = ParseDbDatetime(createdAt); err != nil {
+			return nil, err
+		}
+
+		transactions = append(transactions, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during stats transactions iteration: %v", err)
+	}
+
+	return transactions, nil
+}
+
+// GetConvertedStats converts balances, cash flow and category spending into the currency,
+// every transaction is converted with the rate valid at its created_at, balances with the current rates
+func GetConvertedStats[T DatabaseInterface](db T, userId int64, currency string, dateRange DateRange) (ConvertedStats, error) {
+	stats := ConvertedStats{
+		Balance:  newConvertedAmount(currency),
+		CashFlow: newConvertedAmount(currency),
+	}
+
+	table, err := LoadRateTable(db, userId)
+	if err != nil {
+		return stats, err
+	}
+
+	accounts, err := GetAccounts(db, userId)
+	if err != nil {
+		return stats, err
+	}
+
+	now := time.Now().UTC()
+	for _, a := range accounts {
+		if err := stats.Balance.add(table, a.Amount, a.Currency, now); err != nil {
+			return stats, err
+		}
+	}
+
+	transactions, err := getStatsTransactions(db, userId, dateRange)
+	if err != nil {
+		return stats, err
+	}
+
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		return stats, err
+	}
+
+	parents := categoryParents(categories)
+
+	byId := map[int64]Category{}
+	for _, c := range categories {
+		byId[c.Id] = c
+	}
+
+	spentByCategory := map[int64]*ConvertedCategorySpent{}
+
+	for _, t := range transactions {
+		if err := stats.CashFlow.add(table, t.amount, t.currency, t.createdAt); err != nil {
+			return stats, err
+		}
+
+		if t.amount.Sign() >= 0 {
+			continue
+		}
+
+		// spending of subcategories rolls up into their parents
+		for _, id := range categoryAncestors(parents, t.category.Id) {
+			spent, ok := spentByCategory[id]
+			if !ok {
+				category, known := byId[id]
+				if !known {
+					category = t.category
+				}
+				spent = &ConvertedCategorySpent{Category: category, Spent: newConvertedAmount(currency)}
+				spentByCategory[id] = spent
+			}
+
+			if err := spent.Spent.add(table, t.amount.Abs(), t.currency, t.createdAt); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	children := map[int64][]int64{}
+	var roots []int64
+	for id := range spentByCategory {
+		if parent, ok := parents[id]; ok {
+			children[parent] = append(children[parent], id)
+		} else {
+			roots = append(roots, id)
+		}
+	}
+
+	var nest func(ids []int64) []ConvertedCategorySpent
+	nest = func(ids []int64) []ConvertedCategorySpent {
+		result := []ConvertedCategorySpent{}
+		for _, id := range ids {
+			spent := *spentByCategory[id]
+			if subcategories := nest(children[id]); len(subcategories) > 0 {
+				spent.Subcategories = subcategories
+			}
+			result = append(result, spent)
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			a, b := result[i], result[j]
+			if cmp := a.Spent.Value.Amount.Cmp(b.Spent.Value.Amount); cmp != 0 {
+				return cmp > 0
+			}
+			return a.Category.Id < b.Category.Id
+		})
+		return result
+	}
+
+	stats.CategoriesSpent = nest(roots)
+
+	return stats, nil
+}
@@ -0,0 +1,161 @@
+package greed
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateTable(t *testing.T) {
+	january := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	table, err := NewRateTable([]ExchangeRate{
+		{Base: "EUR", Quote: "USD", Rate: "1.2", ValidFrom: february},
+		{Base: "EUR", Quote: "USD", Rate: "1.1", ValidFrom: january},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		base  string
+		quote string
+		at    time.Time
+		rate  string
+		ok    bool
+	}{
+		{"EUR", "USD", january.Add(-time.Second), "", false},
+		{"EUR", "USD", january, "11/10", true},
+		{"EUR", "USD", february.Add(-time.Second), "11/10", true},
+		{"EUR", "USD", february, "6/5", true},
+		// the inverse pair is used when the direct one is missing
+		{"USD", "EUR", february.AddDate(0, 1, 0), "5/6", true},
+		{"USD", "USD", january.Add(-time.Second), "1/1", true},
+		{"GBP", "USD", february, "", false},
+	}
+
+	for _, c := range cases {
+		rate, ok := table.Rate(c.base, c.quote, c.at)
+		if ok != c.ok || (ok && rate.String() != c.rate) {
+			t.Errorf("rate %v/%v at %v = %v %v, want %v %v", c.base, c.quote, c.at, rate, ok, c.rate, c.ok)
+		}
+	}
+
+	if converted, ok, err := table.Convert(mustParseMoney(t, "10", "USD"), "USD", "EUR", february); err != nil || !ok || converted.String() != "8.33" {
+		t.Errorf("10 USD in EUR = %v %v %v, want 8.33", converted, ok, err)
+	}
+	if converted, ok, err := table.Convert(mustParseMoney(t, "-0.05", "EUR"), "EUR", "JPY", february); err != nil || ok {
+		t.Errorf("EUR in JPY without a rate = %v %v", converted, err)
+	}
+
+	if _, err := NewRateTable([]ExchangeRate{{Base: "EUR", Quote: "USD", Rate: "-1", ValidFrom: january}}); !errors.Is(err, ErrInvalidRate) {
+		t.Errorf("table with a negative rate = %v, want %v", err, ErrInvalidRate)
+	}
+}
+
+func TestImportRates(t *testing.T) {
+	db, user := newTestDb(t)
+
+	csv := "date,base,quote,rate\n2026-01-01,eur,usd,1.1\n2026-02-01T00:00:00Z,EUR,USD,1.2\n"
+	if count, err := ImportRates(db, user.Id, CsvRateProvider{Reader: strings.NewReader(csv)}); err != nil || count != 2 {
+		t.Fatalf("imported %v rates, %v, want 2", count, err)
+	}
+
+	invalid := []ExchangeRate{
+		{Base: "EUR", Quote: "EUR", Rate: "1", ValidFrom: time.Now()},
+		{Base: "EUR", Quote: "USD", Rate: "1,2", ValidFrom: time.Now()},
+		{Base: "EUR", Quote: "USD", Rate: "1.2"},
+		{Base: "EUR", Quote: "XXX", Rate: "1.2", ValidFrom: time.Now()},
+	}
+
+	for _, r := range invalid {
+		// the valid rate in front isn't stored either
+		rates := []ExchangeRate{{Base: "GBP", Quote: "USD", Rate: "1.3", ValidFrom: time.Now()}, r}
+		if _, err := ImportRates(db, user.Id, ManualRateProvider{Rates: rates}); !errors.Is(err, ErrInvalidRate) && !errors.Is(err, ErrUnsupportedCurrency) {
+			t.Errorf("import of %+v = %v, want %v", r, err, ErrInvalidRate)
+		}
+	}
+
+	// a rate of the same pair and date replaces the stored one
+	if _, err := ImportRates(db, user.Id, ManualRateProvider{Rates: []ExchangeRate{
+		{Base: "EUR", Quote: "USD", Rate: "1.25", ValidFrom: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rates, err := GetExchangeRates(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored []string
+	for _, r := range rates {
+		stored = append(stored, r.Base+r.Quote+" "+r.Rate+" "+r.Source)
+	}
+	sort.Strings(stored)
+	if want := []string{"EURUSD 1.1 file", "EURUSD 1.25 manual"}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored rates = %v, want %v", stored, want)
+	}
+}
+
+func TestGetConvertedStats(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	finance := testCategory(t, db, user.Id, "💰 Finance")
+
+	opened := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	usd := testAccount(t, db, user.Id, "USD", "1000", opened)
+	eur := testAccount(t, db, user.Id, "EUR", "100", opened)
+	gbp := testAccount(t, db, user.Id, "GBP", "10", opened)
+
+	if _, err := ImportRates(db, user.Id, ManualRateProvider{Rates: []ExchangeRate{
+		{Base: "EUR", Quote: "USD", Rate: "1.1", ValidFrom: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Base: "EUR", Quote: "USD", Rate: "1.2", ValidFrom: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every transaction is converted with the rate of its day
+	testTransaction(t, db, user.Id, eur, "-10", food, time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC), "january dinner")
+	testTransaction(t, db, user.Id, eur, "-10", food, time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC), "february dinner")
+	testTransaction(t, db, user.Id, usd, "-5", food, time.Date(2026, 2, 16, 12, 0, 0, 0, time.UTC), "lunch")
+	testTransaction(t, db, user.Id, usd, "100", finance, time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC), "salary")
+	testTransaction(t, db, user.Id, gbp, "-1", finance, time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC), "fee")
+
+	stats, err := GetConvertedStats(db, user.Id, "USD", DateRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// balances use the current rate, the pound has no rate at all
+	if stats.Balance.Value.Amount.String() != "1191.00" || !reflect.DeepEqual(stats.Balance.MissingRates, []string{"GBP"}) {
+		t.Errorf("balance = %+v, want 1191.00 without GBP", stats.Balance)
+	}
+	if stats.CashFlow.Value.Amount.String() != "72.00" || !reflect.DeepEqual(stats.CashFlow.MissingRates, []string{"GBP"}) {
+		t.Errorf("cash flow = %+v, want 72.00 without GBP", stats.CashFlow)
+	}
+
+	if len(stats.CategoriesSpent) != 2 {
+		t.Fatalf("categories spent = %+v", stats.CategoriesSpent)
+	}
+	if spent := stats.CategoriesSpent[0]; spent.Category.Id != food.Id || spent.Spent.Value.Amount.String() != "28.00" || len(spent.Spent.MissingRates) != 0 {
+		t.Errorf("food spent = %+v, want 28.00", spent)
+	}
+	if spent := stats.CategoriesSpent[1]; spent.Category.Id != finance.Id || !spent.Spent.Value.Amount.IsZero() || len(spent.Spent.MissingRates) != 1 {
+		t.Errorf("finance spent = %+v, want nothing without GBP", spent)
+	}
+
+	// the date range leaves out january
+	stats, err = GetConvertedStats(db, user.Id, "EUR", DateRange{DateStart: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CashFlow.Value.Amount.String() != "69.16" {
+		t.Errorf("cash flow in EUR since february = %v, want 69.16", stats.CashFlow.Value.Amount)
+	}
+}
@@ -0,0 +1,359 @@
+package greed
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrReconciled = errors.New("transaction is reconciled, it can't be changed")
+var ErrInvalidReconciliation = errors.New("invalid reconciliation")
+
+// description of the transactions recording the difference found by reconciling
+const ReconcileAdjustmentDescription = "Reconciliation balance adjustment"
+
+// Reconciliation is an account checked against a bank statement, it locks the cleared transactions up to the statement date
+type Reconciliation struct {
+	Id        int64 `json:"id"`
+	AccountId int64 `json:"account_id"`
+	// the statement balance is at the end of this day
+	StatementDate    time.Time `json:"statement_date"`
+	StatementBalance Money     `json:"statement_balance"`
+	// transaction recording the difference between the statement and the cleared balance, 0 when they matched
+	AdjustmentId int64     `json:"adjustment_id,omitempty"`
+	Adjustment   Money     `json:"adjustment"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ReconcileSummary is the account at the statement date, the cleared balance should match the statement
+type ReconcileSummary struct {
+	Account       Account   `json:"account"`
+	StatementDate time.Time `json:"statement_date"`
+	// balance at the end of the statement date
+	Balance Money `json:"balance"`
+	// balance without the uncleared transactions up to the statement date
+	ClearedBalance Money `json:"cleared_balance"`
+	// unreconciled transactions up to the statement date, newest first
+	Transactions []Transaction `json:"transactions"`
+	// nil until the account is reconciled for the first time
+	LastReconciliation *Reconciliation `json:"last_reconciliation"`
+}
+
+// Difference is the adjustment reconciling against the statement balance records
+func (s ReconcileSummary) Difference(statementBalance Money) (Money, error) {
+	return statementBalance.Sub(s.ClearedBalance)
+}
+
+// statementEnd is the start of the day after the statement date, the statement covers transactions before it
+func statementEnd(statementDate time.Time) time.Time {
+	return statementDate.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+}
+
+func GetReconciliations[T DatabaseInterface](db T, userId int64, accountId int64) ([]Reconciliation, error) {
+	reconciliations := []Reconciliation{}
+
+	account, err := GetAccountById(db, userId, accountId)
+	if err != nil {
+		return nil, fmt.Errorf("account %v of user %v: %w", accountId, userId, err)
+	}
+
+	rows, err := db.Query(
+		`
+		select
+			reconciliations.id, reconciliations.account_id, reconciliations.statement_date, reconciliations.statement_balance,
+			reconciliations.adjustment_id, coalesce(transactions.amount, 0), reconciliations.created_at
+		from reconciliations
+		left join transactions on transactions.id = reconciliations.adjustment_id
+		where reconciliations.user_id = ? and reconciliations.account_id = ?
+		order by datetime(reconciliations.statement_date) desc, reconciliations.id desc
+		`,
+		userId, accountId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch reconciliations failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Reconciliation
+		var statementDate, createdAt string
+		var balance, adjustment int64
+		var adjustmentId sql.NullInt64
+
+		if err := rows.Scan(&r.Id, &r.AccountId, &statementDate, &balance, &adjustmentId, &adjustment, &createdAt); err != nil {
+			return nil, fmt.Errorf("fetch reconciliations row failed: %v", err)
+		}
+
+		if r.StatementDate, err = ParseDbDatetime(statementDate); err != nil {
+			return nil, err
+		}
+		if r.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+			return nil, err
+		}
+
+		r.StatementBalance = NewMoney(balance, CurrencyExponent(account.Currency))
+		r.AdjustmentId = adjustmentId.Int64
+		r.Adjustment = NewMoney(adjustment, CurrencyExponent(account.Currency))
+		reconciliations = append(reconciliations, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during reconciliations iteration: %v", err)
+	}
+
+	return reconciliations, nil
+}
+
+// GetReconcileSummary computes the balances of the account at the end of the statement date
+// and lists the transactions to check against the statement
+func GetReconcileSummary[T DatabaseInterface](db T, userId int64, accountId int64, statementDate time.Time) (ReconcileSummary, error) {
+	summary := ReconcileSummary{StatementDate: statementDate.UTC().Truncate(24 * time.Hour)}
+
+	account, err := GetAccountById(db, userId, accountId)
+	if err != nil {
+		return summary, fmt.Errorf("account %v of user %v: %w", accountId, userId, err)
+	}
+	summary.Account = account
+
+	end := statementEnd(statementDate)
+
+	if summary.Balance, err = GetAccountBalance(db, userId, accountId, end); err != nil {
+		return summary, err
+	}
+
+	transactions, err := GetTransactions(db, userId, TransactionFilter{
+		AccountId:    accountId,
+		Unreconciled: true,
+		DateRange:    DateRange{DateEnd: end},
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Transactions = transactions
+	if summary.Transactions == nil {
+		summary.Transactions = []Transaction{}
+	}
+
+	summary.ClearedBalance = summary.Balance
+	for _, t := range transactions {
+		if !t.Cleared {
+			if summary.ClearedBalance, err = summary.ClearedBalance.Sub(t.Amount); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	reconciliations, err := GetReconciliations(db, userId, accountId)
+	if err != nil {
+		return summary, err
+	}
+
+	if len(reconciliations) > 0 {
+		summary.LastReconciliation = &reconciliations[0]
+	}
+
+	return summary, nil
+}
+
+// SetTransactionCleared marks the transaction as seen on a bank statement or not, reconciled transactions stay cleared
+func SetTransactionCleared[T DatabaseInterface](db T, userId int64, transactionId int64, cleared bool) (Transaction, error) {
+	transaction, err := GetTransactionById(db, userId, transactionId)
+	if err != nil {
+		return transaction, err
+	}
+
+	if transaction.ReconciliationId != 0 {
+		return transaction, fmt.Errorf("%w: transaction %v", ErrReconciled, transactionId)
+	}
+
+	if _, err := db.Exec(
+		"update transactions set cleared = ? where id = ? and user_id = ?",
+		cleared, transactionId, userId,
+	); err != nil {
+		return transaction, fmt.Errorf("failed to update cleared of transaction %v: %v", transactionId, err)
+	}
+
+	transaction.Cleared = cleared
+	return transaction, nil
+}
+
+// ReconcileAccount records the statement, the difference between the statement and the cleared balance
+// is added as a cleared transaction of the category at the end of the statement date,
+// all the cleared transactions up to the statement date get reconciled and locked
+func ReconcileAccount(
+	db *sql.DB,
+	userId int64,
+	accountId int64,
+	statementDate time.Time,
+	statementBalance Money,
+	category Category,
+) (Reconciliation, error) {
+	reconciliation := Reconciliation{AccountId: accountId, StatementDate: statementDate.UTC().Truncate(24 * time.Hour)}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return reconciliation, err
+	}
+	defer tx.Rollback()
+
+	summary, err := GetReconcileSummary(tx, userId, accountId, statementDate)
+	if err != nil {
+		return reconciliation, err
+	}
+
+	if last := summary.LastReconciliation; last != nil && !reconciliation.StatementDate.After(last.StatementDate) {
+		return reconciliation, fmt.Errorf(
+			"%w: the account is already reconciled up to %v",
+			ErrInvalidReconciliation, last.StatementDate.Format(time.DateOnly),
+		)
+	}
+
+	if reconciliation.StatementBalance, err = statementBalance.Rescale(CurrencyExponent(summary.Account.Currency)); err != nil {
+		return reconciliation, fmt.Errorf("%w: statement balance: %v", ErrInvalidReconciliation, err)
+	}
+
+	end := statementEnd(statementDate)
+	reconciliation.Adjustment = ZeroMoney(summary.Account.Currency)
+	reconciliation.CreatedAt = time.Now().UTC()
+
+	difference, err := summary.Difference(reconciliation.StatementBalance)
+	if err != nil {
+		return reconciliation, fmt.Errorf("%w: statement balance: %v", ErrInvalidReconciliation, err)
+	}
+
+	// the adjustment is created before the reconciliation, which locks its date
+	if !difference.IsZero() {
+		if category.Id == 0 {
+			return reconciliation, fmt.Errorf(
+				"%w: choose a category for the adjustment of %v %v",
+				ErrInvalidReconciliation, difference, summary.Account.Currency,
+			)
+		}
+
+		adjustment, err := CreateTransactionWithRecalc(
+			tx, userId, summary.Account, difference, category, end.Add(-time.Second), ReconcileAdjustmentDescription,
+		)
+		if err != nil {
+			return reconciliation, err
+		}
+
+		if _, err := tx.Exec("update transactions set cleared = 1 where id = ?", adjustment.Id); err != nil {
+			return reconciliation, fmt.Errorf("failed to clear adjustment transaction %v: %v", adjustment.Id, err)
+		}
+
+		reconciliation.AdjustmentId = adjustment.Id
+		reconciliation.Adjustment = adjustment.Amount
+	}
+
+	result, err := tx.Exec(
+		`
+		insert into reconciliations (user_id, account_id, statement_date, statement_balance, adjustment_id, created_at)
+		values (?, ?, ?, ?, ?, ?)
+		`,
+		userId, accountId, reconciliation.StatementDate.Format(DATETIME_DB_LAYOUT), reconciliation.StatementBalance.Minor,
+		nullableId(reconciliation.AdjustmentId), reconciliation.CreatedAt.Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return reconciliation, fmt.Errorf("failed to create reconciliation of account %v: %v", accountId, err)
+	}
+
+	if reconciliation.Id, err = result.LastInsertId(); err != nil {
+		return reconciliation, fmt.Errorf("failed to get last inserted reconciliation id: %v", err)
+	}
+
+	if _, err := tx.Exec(
+		`
+		update transactions set reconciliation_id = ?
+		where user_id = ? and account_id = ? and cleared and reconciliation_id is null and datetime(created_at) < datetime(?)
+		`,
+		reconciliation.Id, userId, accountId, end.Format(DATETIME_DB_LAYOUT),
+	); err != nil {
+		return reconciliation, fmt.Errorf("failed to reconcile transactions of account %v: %v", accountId, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return reconciliation, err
+	}
+
+	return reconciliation, nil
+}
+
+// checkTransferUnreconciled fails when a leg of the transfer is reconciled, the transfer can't be changed then
+func checkTransferUnreconciled[T DatabaseInterface](db T, userId int64, transferId int64) error {
+	var reconciled int64
+
+	row := db.QueryRow(
+		"select count(*) from transactions where transfer_id = ? and user_id = ? and reconciliation_id is not null",
+		transferId, userId,
+	)
+	if err := row.Scan(&reconciled); err != nil {
+		return fmt.Errorf("failed to check reconciled legs of transfer %v: %v", transferId, err)
+	}
+
+	if reconciled > 0 {
+		return fmt.Errorf("%w: a leg of transfer %v", ErrReconciled, transferId)
+	}
+
+	return nil
+}
+
+// reconciledUntil is the last statement date of the account, zero before its first reconciliation
+func reconciledUntil[T DatabaseInterface](db T, userId int64, accountId int64) (time.Time, error) {
+	var statementDate string
+
+	row := db.QueryRow(
+		`
+		select statement_date from reconciliations
+		where account_id = ? and user_id = ?
+		order by datetime(statement_date) desc limit 1
+		`,
+		accountId, userId,
+	)
+	if err := row.Scan(&statementDate); errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to fetch last reconciliation of account %v: %v", accountId, err)
+	}
+
+	return ParseDbDatetime(statementDate)
+}
+
+// checkDateUnreconciled fails when the date is on or before the last statement date of the account,
+// a transaction there would change the reconciled balances
+func checkDateUnreconciled[T DatabaseInterface](db T, userId int64, accountId int64, at time.Time) error {
+	until, err := reconciledUntil(db, userId, accountId)
+	if err != nil {
+		return err
+	}
+
+	if !until.IsZero() && at.Before(statementEnd(until)) {
+		return fmt.Errorf("%w: account %v is reconciled up to %v", ErrReconciled, accountId, until.Format(time.DateOnly))
+	}
+
+	return nil
+}
+
+// checkMoveUnreconciled fails when the transaction moves to another account or date within a reconciled period,
+// unreconciled transactions already there keep their place and stay editable
+func checkMoveUnreconciled[T DatabaseInterface](db T, userId int64, transaction Transaction) error {
+	var accountId int64
+	var createdAt string
+
+	row := db.QueryRow("select account_id, created_at from transactions where id = ? and user_id = ?", transaction.Id, userId)
+	if err := row.Scan(&accountId, &createdAt); err != nil {
+		return fmt.Errorf("failed to fetch transaction %v: %w", transaction.Id, err)
+	}
+
+	stored, err := ParseDbDatetime(createdAt)
+	if err != nil {
+		return err
+	}
+
+	if accountId == transaction.Account.Id && stored.Equal(transaction.CreatedAt.Truncate(time.Second)) {
+		return nil
+	}
+
+	return checkDateUnreconciled(db, userId, transaction.Account.Id, transaction.CreatedAt)
+}
@@ -0,0 +1,370 @@
+package greed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconcileAccount(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	finance := testCategory(t, db, user.Id, "💰 Finance")
+
+	account := testAccount(t, db, user.Id, "USD", "1000", opened)
+	groceries := testTransaction(t, db, user.Id, account, "-100", food, opened.AddDate(0, 0, 5), "groceries")
+	testTransaction(t, db, user.Id, account, "-40", food, opened.AddDate(0, 0, 6), "not on the statement yet")
+	later := testTransaction(t, db, user.Id, account, "-10", food, opened.AddDate(0, 1, 1), "after the statement")
+
+	for _, id := range []int64{groceries.Id, later.Id} {
+		if _, err := SetTransactionCleared(db, user.Id, id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	statementDate := opened.AddDate(0, 0, 30)
+
+	summary, err := GetReconcileSummary(db, user.Id, account.Id, statementDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Balance.String() != "860.00" || summary.ClearedBalance.String() != "900.00" || len(summary.Transactions) != 2 {
+		t.Errorf("summary balance %v, cleared %v, %v transactions, want 860.00, 900.00, 2", summary.Balance, summary.ClearedBalance, len(summary.Transactions))
+	}
+
+	if _, err := ReconcileAccount(db, user.Id, account.Id, statementDate, mustParseMoney(t, "895", "USD"), Category{}); !errors.Is(err, ErrInvalidReconciliation) {
+		t.Errorf("reconcile with a difference and no category = %v, want %v", err, ErrInvalidReconciliation)
+	}
+
+	reconciliation, err := ReconcileAccount(db, user.Id, account.Id, statementDate, mustParseMoney(t, "895", "USD"), finance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reconciliation.AdjustmentId == 0 || reconciliation.Adjustment.String() != "-5.00" {
+		t.Errorf("adjustment %v of %v, want -5.00", reconciliation.AdjustmentId, reconciliation.Adjustment)
+	}
+
+	summary, err = GetReconcileSummary(db, user.Id, account.Id, statementDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.ClearedBalance.String() != "895.00" || len(summary.Transactions) != 1 || summary.LastReconciliation == nil {
+		t.Errorf("summary after reconciling: cleared %v, %v transactions, last %v", summary.ClearedBalance, len(summary.Transactions), summary.LastReconciliation)
+	}
+
+	if _, err := ReconcileAccount(db, user.Id, account.Id, statementDate.AddDate(0, 0, -1), mustParseMoney(t, "895", "USD"), finance); !errors.Is(err, ErrInvalidReconciliation) {
+		t.Errorf("reconcile before the last statement = %v, want %v", err, ErrInvalidReconciliation)
+	}
+	if _, err := ReconcileAccount(db, user.Id, account.Id, statementDate.Add(time.Hour), mustParseMoney(t, "895", "USD"), finance); !errors.Is(err, ErrInvalidReconciliation) {
+		t.Errorf("reconcile on the day of the last statement = %v, want %v", err, ErrInvalidReconciliation)
+	}
+}
+
+func TestReconciledTransactionsAreLocked(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	beauty := testCategory(t, db, user.Id, "💆 Beauty and Health")
+
+	account := testAccount(t, db, user.Id, "USD", "1000", opened)
+	locked := testTransaction(t, db, user.Id, account, "-100", food, opened.AddDate(0, 0, 5), "groceries")
+	open := testTransaction(t, db, user.Id, account, "-40", food, opened.AddDate(0, 0, 6), "pharmacy")
+
+	if _, err := SetTransactionTags(db, user.Id, locked.Id, []string{"weekly"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetTransactionCleared(db, user.Id, locked.Id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReconcileAccount(db, user.Id, account.Id, opened.AddDate(0, 0, 30), mustParseMoney(t, "900", "USD"), Category{}); err != nil {
+		t.Fatal(err)
+	}
+
+	locked, err := GetTransactionById(db, user.Id, locked.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked.ReconciliationId == 0 {
+		t.Fatalf("cleared transaction isn't reconciled")
+	}
+
+	splits := []Split{
+		{Category: food, Amount: mustParseMoney(t, "-60", "USD")},
+		{Category: beauty, Amount: mustParseMoney(t, "-40", "USD")},
+	}
+
+	changes := []struct {
+		name   string
+		change func(id int64) error
+	}{
+		{"update", func(id int64) error {
+			transaction, err := GetTransactionById(db, user.Id, id)
+			if err != nil {
+				return err
+			}
+			transaction.Description = "changed"
+			_, err = UpdateTransactionWithRecalc(db, user.Id, transaction)
+			return err
+		}},
+		{"delete", func(id int64) error { return DeleteTransactionWithRecalc(db, user.Id, id) }},
+		{"unclear", func(id int64) error { _, err := SetTransactionCleared(db, user.Id, id, false); return err }},
+		{"tag", func(id int64) error {
+			_, err := SetTransactionTags(db, user.Id, id, []string{"weekly", "food"})
+			return err
+		}},
+		{"untag", func(id int64) error { _, err := SetTransactionTags(db, user.Id, id, nil); return err }},
+		{"split", func(id int64) error { _, err := SetTransactionSplits(db, user.Id, id, splits); return err }},
+		{"unsplit", func(id int64) error { _, err := SetTransactionSplits(db, user.Id, id, nil); return err }},
+	}
+
+	for _, c := range changes {
+		if err := c.change(locked.Id); !errors.Is(err, ErrReconciled) {
+			t.Errorf("%v of a reconciled transaction = %v, want %v", c.name, err, ErrReconciled)
+		}
+	}
+
+	unchanged, err := GetTransactionById(db, user.Id, locked.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.Description != "groceries" || len(unchanged.Tags) != 1 || len(unchanged.Splits) != 0 || !unchanged.Cleared {
+		t.Errorf("reconciled transaction changed: %+v", unchanged)
+	}
+
+	// the uncleared transaction isn't reconciled and stays editable
+	if _, err := SetTransactionTags(db, user.Id, open.Id, []string{"health"}); err != nil {
+		t.Errorf("tag of an unreconciled transaction: %v", err)
+	}
+	if _, err := SetTransactionSplits(db, user.Id, open.Id, []Split{
+		{Category: food, Amount: mustParseMoney(t, "-10", "USD")},
+		{Category: beauty, Amount: mustParseMoney(t, "-30", "USD")},
+	}); err != nil {
+		t.Errorf("split of an unreconciled transaction: %v", err)
+	}
+}
+
+func TestReconciledTransferIsLocked(t *testing.T) {
+	db, user := newTestDb(t)
+
+	category, err := GetTransferCategory(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checking := testAccount(t, db, user.Id, "USD", "1000", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	savings := testAccount(t, db, user.Id, "EUR", "0", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	transfer, err := CreateTransfer(db, user.Id, Transfer{
+		FromAccount: checking,
+		ToAccount:   savings,
+		FromAmount:  mustParseMoney(t, "100", "USD"),
+		ToAmount:    mustParseMoney(t, "92.5", "EUR"),
+		Category:    category,
+		CreatedAt:   day,
+		Description: "savings",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the debit leg is reconciled, that locks the whole transfer
+	if _, err := SetTransactionCleared(db, user.Id, transfer.FromTransactionId, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReconcileAccount(db, user.Id, checking.Id, day.AddDate(0, 0, 30), mustParseMoney(t, "900", "USD"), Category{}); err != nil {
+		t.Fatal(err)
+	}
+
+	transfer.FromAmount = mustParseMoney(t, "50", "USD")
+	transfer.ToAmount = mustParseMoney(t, "46.25", "EUR")
+	if _, err := UpdateTransfer(db, user.Id, transfer); !errors.Is(err, ErrReconciled) {
+		t.Errorf("update of a reconciled transfer = %v, want %v", err, ErrReconciled)
+	}
+	if err := DeleteTransfer(db, user.Id, transfer.Id); !errors.Is(err, ErrReconciled) {
+		t.Errorf("delete of a reconciled transfer = %v, want %v", err, ErrReconciled)
+	}
+
+	for id, amount := range map[int64]string{checking.Id: "900.00", savings.Id: "92.50"} {
+		account, err := GetAccountById(db, user.Id, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.Amount.String() != amount {
+			t.Errorf("balance of %v = %v, want %v", account.Name, account.Amount, amount)
+		}
+	}
+}
+
+func TestReconciledPeriodIsLocked(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	statementDate := opened.AddDate(0, 0, 30)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+
+	account := testAccount(t, db, user.Id, "USD", "1000", opened)
+	other := testAccount(t, db, user.Id, "EUR", "0", opened)
+	groceries := testTransaction(t, db, user.Id, account, "-100", food, opened.AddDate(0, 0, 5), "groceries")
+	// not cleared, it stays unreconciled within the period
+	pending := testTransaction(t, db, user.Id, account, "-40", food, opened.AddDate(0, 0, 6), "groceries again")
+	later := testTransaction(t, db, user.Id, account, "-10", food, statementDate.AddDate(0, 0, 2), "groceries later")
+
+	if _, err := SetTransactionCleared(db, user.Id, groceries.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReconcileAccount(db, user.Id, account.Id, statementDate, mustParseMoney(t, "900", "USD"), Category{}); err != nil {
+		t.Fatal(err)
+	}
+
+	creates := []struct {
+		at     time.Time
+		locked bool
+	}{
+		{opened.AddDate(0, 0, 10), true},
+		{statementDate, true},
+		{statementDate.Add(23 * time.Hour), true},
+		{statementDate.AddDate(0, 0, 1), false},
+	}
+
+	for _, c := range creates {
+		_, err := CreateTransactionWithRecalc(db, user.Id, account, mustParseMoney(t, "-1", "USD"), food, c.at, "coffee")
+		if c.locked && !errors.Is(err, ErrReconciled) {
+			t.Errorf("create at %v = %v, want %v", c.at, err, ErrReconciled)
+		}
+		if !c.locked && err != nil {
+			t.Errorf("create at %v: %v", c.at, err)
+		}
+	}
+
+	// other accounts aren't locked
+	if _, err := CreateTransactionWithRecalc(db, user.Id, other, mustParseMoney(t, "-1", "EUR"), food, opened.AddDate(0, 0, 10), "coffee"); err != nil {
+		t.Errorf("create in another account: %v", err)
+	}
+
+	update := func(transaction Transaction, change func(t *Transaction)) error {
+		transaction, err := GetTransactionById(db, user.Id, transaction.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		change(&transaction)
+		_, err = UpdateTransactionWithRecalc(db, user.Id, transaction)
+		return err
+	}
+
+	moves := []struct {
+		name        string
+		transaction Transaction
+		change      func(t *Transaction)
+		locked      bool
+	}{
+		{"move into the period", later, func(t *Transaction) { t.CreatedAt = opened.AddDate(0, 0, 20) }, true},
+		{"move within the period", pending, func(t *Transaction) { t.CreatedAt = opened.AddDate(0, 0, 7) }, true},
+		{"edit within the period", pending, func(t *Transaction) { t.Description = "groceries and more" }, false},
+		{"move into the period of another account", Transaction{Id: pending.Id}, func(tr *Transaction) {
+			tr.Account, tr.Amount = other, mustParseMoney(t, "-40", "EUR")
+		}, false},
+		{"move back into the reconciled account", Transaction{Id: pending.Id}, func(tr *Transaction) {
+			tr.Account, tr.Amount = account, mustParseMoney(t, "-40", "USD")
+		}, true},
+		{"move after the period", later, func(t *Transaction) { t.CreatedAt = statementDate.AddDate(0, 0, 3) }, false},
+	}
+
+	for _, m := range moves {
+		err := update(m.transaction, m.change)
+		if m.locked && !errors.Is(err, ErrReconciled) {
+			t.Errorf("%v = %v, want %v", m.name, err, ErrReconciled)
+		}
+		if !m.locked && err != nil {
+			t.Errorf("%v: %v", m.name, err)
+		}
+	}
+
+	stored, err := GetAccountById(db, user.Id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := stored
+	renamed.Name = "Checking"
+	if _, err := UpdateAccount(db, user.Id, renamed); err != nil {
+		t.Errorf("rename of a reconciled account: %v", err)
+	}
+
+	reopened := renamed
+	reopened.OpeningAmount = mustParseMoney(t, "1100", "USD")
+	if _, err := UpdateAccount(db, user.Id, reopened); !errors.Is(err, ErrReconciled) {
+		t.Errorf("change of the opening amount = %v, want %v", err, ErrReconciled)
+	}
+
+	reopened = renamed
+	reopened.OpeningDate = opened.AddDate(0, 0, -1)
+	if _, err := UpdateAccount(db, user.Id, reopened); !errors.Is(err, ErrReconciled) {
+		t.Errorf("change of the opening date = %v, want %v", err, ErrReconciled)
+	}
+
+	// reconciled transactions keep their payee
+	payee, err := CreatePayee(db, user.Id, Payee{Name: "Groceries"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTransactionPayee(db, user.Id, groceries.Id, payee.Id); !errors.Is(err, ErrReconciled) {
+		t.Errorf("payee of a reconciled transaction = %v, want %v", err, ErrReconciled)
+	}
+	if err := SetTransactionPayee(db, user.Id, later.Id, payee.Id); err != nil {
+		t.Errorf("payee of an unreconciled transaction: %v", err)
+	}
+
+	if _, err := LinkPayees(db, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if linked, err := GetTransactionById(db, user.Id, groceries.Id); err != nil || linked.Payee != nil {
+		t.Errorf("reconciled transaction linked to %+v, %v", linked.Payee, err)
+	}
+}
+
+func TestRecurringSkipsReconciledPeriod(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	account := testAccount(t, db, user.Id, "USD", "100", opened)
+
+	r, err := CreateRecurringTransaction(db, user.Id, RecurringTransaction{
+		Account:     account,
+		Category:    food,
+		Amount:      mustParseMoney(t, "-10", "USD"),
+		Description: "Gym",
+		Frequency:   RecurringMonthly,
+		Every:       1,
+		StartsAt:    opened.AddDate(0, 0, 14),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reconciled while the scheduler was down
+	if _, err := ReconcileAccount(db, user.Id, account.Id, opened.AddDate(0, 0, 30), mustParseMoney(t, "100", "USD"), Category{}); err != nil {
+		t.Fatal(err)
+	}
+
+	posted, err := PostRecurringTransaction(db, user.Id, r.Id, opened.AddDate(0, 1, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posted != 1 {
+		t.Errorf("posted %v occurrences, want only the one after the statement", posted)
+	}
+
+	stored, err := GetAccountById(db, user.Id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Amount.String() != "90.00" {
+		t.Errorf("balance = %v, want 90.00", stored.Amount)
+	}
+}
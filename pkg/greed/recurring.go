@@ -0,0 +1,692 @@
+package greed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+var ErrInvalidRecurring = errors.New("invalid recurring transaction")
+
+type RecurringFrequency string
+
+const (
+	RecurringDaily   RecurringFrequency = "daily"
+	RecurringWeekly  RecurringFrequency = "weekly"
+	RecurringMonthly RecurringFrequency = "monthly"
+	RecurringYearly  RecurringFrequency = "yearly"
+)
+
+var RecurringFrequencies = []RecurringFrequency{RecurringDaily, RecurringWeekly, RecurringMonthly, RecurringYearly}
+
+// RecurringTransaction is a template of a transaction posted on a schedule
+type RecurringTransaction struct {
+	Id       int64    `json:"id"`
+	Account  Account  `json:"account"`
+	Category Category `json:"category"`
+	// negative for expenses
+	Amount      Money              `json:"amount"`
+	Description string             `json:"description"`
+	Frequency   RecurringFrequency `json:"frequency"`
+	// the schedule repeats every N days, weeks, months or years
+	Every int `json:"every"`
+	// first occurrence, the next ones keep its time of day and offset,
+	// monthly and yearly ones fall on its day clamped to the length of the month
+	StartsAt time.Time `json:"starts_at"`
+	// no occurrences after it, repeats forever if nil
+	EndsAt *time.Time `json:"ends_at"`
+	// next occurrence to post, nil once the schedule has ended
+	NextAt    *time.Time `json:"next_at"`
+	Paused    bool       `json:"paused"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (r *RecurringTransaction) ToJson() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *RecurringTransaction) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, r)
+}
+
+// RecurringOccurrence is an upcoming occurrence of a recurring transaction
+type RecurringOccurrence struct {
+	At      time.Time `json:"at"`
+	Skipped bool      `json:"skipped"`
+}
+
+var recurringUnits = map[RecurringFrequency]string{
+	RecurringDaily:   "days",
+	RecurringWeekly:  "weeks",
+	RecurringMonthly: "months",
+	RecurringYearly:  "years",
+}
+
+// Schedule describes the frequency like "monthly" or "every 2 weeks"
+func (r RecurringTransaction) Schedule() string {
+	if r.Every == 1 {
+		return string(r.Frequency)
+	}
+	return fmt.Sprintf("every %v %v", r.Every, recurringUnits[r.Frequency])
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// Occurrence returns the k-th occurrence of the schedule, the first one is 0
+func (r RecurringTransaction) Occurrence(k int) time.Time {
+	start := r.StartsAt
+	hour, min, sec := start.Clock()
+
+	switch r.Frequency {
+	case RecurringDaily:
+		return start.AddDate(0, 0, k*r.Every)
+	case RecurringWeekly:
+		return start.AddDate(0, 0, 7*k*r.Every)
+	case RecurringYearly:
+		year := start.Year() + k*r.Every
+		day := start.Day()
+		if n := daysIn(year, start.Month()); day > n {
+			day = n
+		}
+		return time.Date(year, start.Month(), day, hour, min, sec, 0, start.Location())
+	default:
+		months := int(start.Month()) - 1 + k*r.Every
+		year, month := start.Year()+months/12, time.Month(months%12+1)
+		day := start.Day()
+		if n := daysIn(year, month); day > n {
+			day = n
+		}
+		return time.Date(year, month, day, hour, min, sec, 0, start.Location())
+	}
+}
+
+// occurrenceIndex is the index of an occurrence before t to start searching from
+func (r RecurringTransaction) occurrenceIndex(t time.Time) int {
+	t = t.In(r.StartsAt.Location())
+
+	var units int
+	switch r.Frequency {
+	case RecurringDaily:
+		units = int(t.Sub(r.StartsAt).Hours() / 24)
+	case RecurringWeekly:
+		units = int(t.Sub(r.StartsAt).Hours() / (24 * 7))
+	case RecurringYearly:
+		units = t.Year() - r.StartsAt.Year()
+	default:
+		units = (t.Year()-r.StartsAt.Year())*12 + int(t.Month()) - int(r.StartsAt.Month())
+	}
+
+	if k := units/r.Every - 1; k > 0 {
+		return k
+	}
+	return 0
+}
+
+// NextOccurrence returns the first occurrence at or after from, false if the schedule ends before it
+func (r RecurringTransaction) NextOccurrence(from time.Time) (time.Time, bool) {
+	for k := r.occurrenceIndex(from); ; k++ {
+		at := r.Occurrence(k)
+		if at.Before(from) {
+			continue
+		}
+
+		if r.EndsAt != nil && at.After(*r.EndsAt) {
+			return time.Time{}, false
+		}
+		return at, true
+	}
+}
+
+// validate checks the schedule and rescales the amount to the account currency
+func (r *RecurringTransaction) validate() error {
+	validFrequency := false
+	for _, f := range RecurringFrequencies {
+		validFrequency = validFrequency || r.Frequency == f
+	}
+	if !validFrequency {
+		return fmt.Errorf("%w: unknown frequency %q", ErrInvalidRecurring, r.Frequency)
+	}
+
+	if r.Every < 1 {
+		return fmt.Errorf("%w: every has to be at least 1", ErrInvalidRecurring)
+	}
+
+	if r.StartsAt.IsZero() {
+		return fmt.Errorf("%w: start is required", ErrInvalidRecurring)
+	}
+
+	if r.EndsAt != nil && r.EndsAt.Before(r.StartsAt) {
+		return fmt.Errorf("%w: end is before the start", ErrInvalidRecurring)
+	}
+
+	amount, err := r.Amount.Rescale(CurrencyExponent(r.Account.Currency))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecurring, err)
+	}
+
+	if amount.IsZero() {
+		return fmt.Errorf("%w: amount can't be zero", ErrInvalidRecurring)
+	}
+
+	r.Amount = amount
+	return nil
+}
+
+func formatNullableDatetime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(DATETIME_DB_LAYOUT)
+}
+
+func parseNullableDatetime(x sql.NullString) (*time.Time, error) {
+	if !x.Valid {
+		return nil, nil
+	}
+
+	parsed, err := ParseDbDatetime(x.String)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+const recurringSelect = `
+	select
+		recurring_transactions.id,
+		accounts.id, accounts.name, accounts.currency,
+		categories.id, categories.name,
+		recurring_transactions.amount, recurring_transactions.description,
+		recurring_transactions.frequency, recurring_transactions.every,
+		recurring_transactions.starts_at, recurring_transactions.ends_at, recurring_transactions.next_at,
+		recurring_transactions.paused, recurring_transactions.created_at
+	from recurring_transactions
+	join accounts on accounts.id = recurring_transactions.account_id
+	join categories on categories.id = recurring_transactions.category_id
+`
+
+func scanRecurringTransaction(row rowScanner) (RecurringTransaction, error) {
+	var r RecurringTransaction
+	var amount int64
+	var startsAt, createdAt string
+	var endsAt, nextAt sql.NullString
+
+	if err := row.Scan(
+		&r.Id,
+		&r.Account.Id, &r.Account.Name, &r.Account.Currency,
+		&r.Category.Id, &r.Category.Name,
+		&amount, &r.Description,
+		&r.Frequency, &r.Every,
+		&startsAt, &endsAt, &nextAt,
+		&r.Paused, &createdAt,
+	); err != nil {
+		return r, err
+	}
+
+	r.Amount = NewMoney(amount, CurrencyExponent(r.Account.Currency))
+
+	var err error
+	if r.StartsAt, err = ParseDbDatetime(startsAt); err != nil {
+		return r, err
+	}
+
+	if r.EndsAt, err = parseNullableDatetime(endsAt); err != nil {
+		return r, err
+	}
+
+	if r.NextAt, err = parseNullableDatetime(nextAt); err != nil {
+		return r, err
+	}
+
+	if r.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+func GetRecurringTransactions[T DatabaseInterface](db T, userId int64) ([]RecurringTransaction, error) {
+	var result []RecurringTransaction
+
+	rows, err := db.Query(
+		recurringSelect+`
+		where recurring_transactions.user_id = ?
+		order by recurring_transactions.next_at is null, datetime(recurring_transactions.next_at), recurring_transactions.id
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch recurring transactions failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRecurringTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("fetch recurring transactions row failed: %v", err)
+		}
+		result = append(result, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during recurring transactions iteration: %v", err)
+	}
+
+	return result, nil
+}
+
+func GetRecurringTransactionById[T DatabaseInterface](db T, userId int64, id int64) (RecurringTransaction, error) {
+	row := db.QueryRow(recurringSelect+"where recurring_transactions.id = ? and recurring_transactions.user_id = ?", id, userId)
+
+	r, err := scanRecurringTransaction(row)
+	if err != nil {
+		return r, fmt.Errorf("fetch recurring transaction %v failed: %w", id, err)
+	}
+
+	return r, nil
+}
+
+// CreateRecurringTransaction stores the template, occurrences from StartsAt on are posted by the scheduler,
+// the ones already in the past included
+func CreateRecurringTransaction[T DatabaseInterface](db T, userId int64, r RecurringTransaction) (RecurringTransaction, error) {
+	account, err := GetAccountById(db, userId, r.Account.Id)
+	if err != nil {
+		return r, fmt.Errorf("account %v of user %v: %w", r.Account.Id, userId, err)
+	}
+	r.Account = account
+
+	if err := r.validate(); err != nil {
+		return r, err
+	}
+
+	if _, err := GetCategoryById(db, userId, r.Category.Id); err != nil {
+		return r, fmt.Errorf("category %v of user %v: %w", r.Category.Id, userId, err)
+	}
+
+	r.NextAt = nil
+	if next, ok := r.NextOccurrence(r.StartsAt); ok {
+		r.NextAt = &next
+	}
+
+	result, err := db.Exec(
+		`
+		insert into recurring_transactions (
+			user_id, account_id, category_id, amount, description, frequency, every,
+			starts_at, ends_at, next_at, paused, created_at
+		)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		userId, r.Account.Id, r.Category.Id, r.Amount.Minor, r.Description, r.Frequency, r.Every,
+		r.StartsAt.Format(DATETIME_DB_LAYOUT), formatNullableDatetime(r.EndsAt), formatNullableDatetime(r.NextAt),
+		r.Paused, time.Now().UTC().Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return r, fmt.Errorf("failed to create recurring transaction: %v", err)
+	}
+
+	if r.Id, err = result.LastInsertId(); err != nil {
+		return r, fmt.Errorf("failed to get last inserted recurring transaction id: %v", err)
+	}
+
+	return GetRecurringTransactionById(db, userId, r.Id)
+}
+
+// UpdateRecurringTransaction changes the template of the upcoming occurrences, the posted ones stay as they are,
+// skipped occurrences are forgotten if the schedule changes
+func UpdateRecurringTransaction[T DatabaseInterface](db T, userId int64, r RecurringTransaction, now time.Time) (RecurringTransaction, error) {
+	old, err := GetRecurringTransactionById(db, userId, r.Id)
+	if err != nil {
+		return r, err
+	}
+
+	account, err := GetAccountById(db, userId, r.Account.Id)
+	if err != nil {
+		return r, fmt.Errorf("account %v of user %v: %w", r.Account.Id, userId, err)
+	}
+	r.Account = account
+
+	if err := r.validate(); err != nil {
+		return r, err
+	}
+
+	if _, err := GetCategoryById(db, userId, r.Category.Id); err != nil {
+		return r, fmt.Errorf("category %v of user %v: %w", r.Category.Id, userId, err)
+	}
+
+	// occurrences before the current next one have been posted already
+	from := now
+	if old.NextAt != nil {
+		from = *old.NextAt
+	}
+
+	r.NextAt = nil
+	if next, ok := r.NextOccurrence(from); ok {
+		r.NextAt = &next
+	}
+
+	if r.Frequency != old.Frequency || r.Every != old.Every || !r.StartsAt.Equal(old.StartsAt) {
+		if _, err := db.Exec("delete from recurring_skips where recurring_id = ?", r.Id); err != nil {
+			return r, fmt.Errorf("failed to delete skips of recurring transaction %v: %v", r.Id, err)
+		}
+	}
+
+	if _, err := db.Exec(
+		`
+		update recurring_transactions set
+			account_id = ?, category_id = ?, amount = ?, description = ?, frequency = ?, every = ?,
+			starts_at = ?, ends_at = ?, next_at = ?
+		where id = ? and user_id = ?
+		`,
+		r.Account.Id, r.Category.Id, r.Amount.Minor, r.Description, r.Frequency, r.Every,
+		r.StartsAt.Format(DATETIME_DB_LAYOUT), formatNullableDatetime(r.EndsAt), formatNullableDatetime(r.NextAt),
+		r.Id, userId,
+	); err != nil {
+		return r, fmt.Errorf("failed to update recurring transaction %v: %v", r.Id, err)
+	}
+
+	return GetRecurringTransactionById(db, userId, r.Id)
+}
+
+// DeleteRecurringTransaction stops the schedule, transactions posted by it are kept
+func DeleteRecurringTransaction[T DatabaseInterface](db T, userId int64, id int64) error {
+	if _, err := GetRecurringTransactionById(db, userId, id); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("delete from recurring_skips where recurring_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete skips of recurring transaction %v: %v", id, err)
+	}
+
+	if _, err := db.Exec("delete from recurring_transactions where id = ? and user_id = ?", id, userId); err != nil {
+		return fmt.Errorf("failed to delete recurring transaction %v: %v", id, err)
+	}
+
+	return nil
+}
+
+// SetRecurringTransactionPaused pauses or resumes the schedule,
+// occurrences that fell into the pause are not posted after resuming
+func SetRecurringTransactionPaused[T DatabaseInterface](db T, userId int64, id int64, paused bool, now time.Time) (RecurringTransaction, error) {
+	r, err := GetRecurringTransactionById(db, userId, id)
+	if err != nil {
+		return r, err
+	}
+
+	if r.Paused == paused {
+		return r, nil
+	}
+
+	if !paused && r.NextAt != nil {
+		r.NextAt = nil
+		if next, ok := r.NextOccurrence(now); ok {
+			r.NextAt = &next
+		}
+	}
+
+	if _, err := db.Exec(
+		"update recurring_transactions set paused = ?, next_at = ? where id = ? and user_id = ?",
+		paused, formatNullableDatetime(r.NextAt), id, userId,
+	); err != nil {
+		return r, fmt.Errorf("failed to pause recurring transaction %v: %v", id, err)
+	}
+
+	return GetRecurringTransactionById(db, userId, id)
+}
+
+// SkipRecurringOccurrence marks an upcoming occurrence not to be posted, skip false posts it again
+func SkipRecurringOccurrence[T DatabaseInterface](db T, userId int64, id int64, at time.Time, skip bool) error {
+	r, err := GetRecurringTransactionById(db, userId, id)
+	if err != nil {
+		return err
+	}
+
+	if r.NextAt == nil || at.Before(*r.NextAt) {
+		return fmt.Errorf("%w: %v is not an upcoming occurrence", ErrInvalidRecurring, at.Format(time.RFC3339))
+	}
+
+	if occurrence, ok := r.NextOccurrence(at); !ok || !occurrence.Equal(at) {
+		return fmt.Errorf("%w: %v is not an occurrence of the schedule", ErrInvalidRecurring, at.Format(time.RFC3339))
+	}
+
+	if skip {
+		_, err = db.Exec(
+			"insert or ignore into recurring_skips (recurring_id, occurs_at) values (?, ?)",
+			id, at.UTC().Format(DATETIME_DB_LAYOUT),
+		)
+	} else {
+		_, err = db.Exec(
+			"delete from recurring_skips where recurring_id = ? and datetime(occurs_at) = datetime(?)",
+			id, at.UTC().Format(DATETIME_DB_LAYOUT),
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to skip occurrence %v of recurring transaction %v: %v", at, id, err)
+	}
+
+	return nil
+}
+
+func getRecurringSkips[T DatabaseInterface](db T, id int64) ([]time.Time, error) {
+	var skips []time.Time
+
+	rows, err := db.Query("select occurs_at from recurring_skips where recurring_id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("fetch skips of recurring transaction %v failed: %v", id, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var occursAt string
+		if err := rows.Scan(&occursAt); err != nil {
+			return nil, fmt.Errorf("fetch skips row failed: %v", err)
+		}
+
+		parsed, err := ParseDbDatetime(occursAt)
+		if err != nil {
+			return nil, err
+		}
+		skips = append(skips, parsed)
+	}
+
+	return skips, rows.Err()
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, x := range times {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUpcomingOccurrences returns up to n occurrences from the next one on, skipped ones included
+func GetUpcomingOccurrences[T DatabaseInterface](db T, userId int64, r RecurringTransaction, n int) ([]RecurringOccurrence, error) {
+	result := []RecurringOccurrence{}
+
+	skips, err := getRecurringSkips(db, r.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	next, ok := time.Time{}, r.NextAt != nil
+	if ok {
+		next = *r.NextAt
+	}
+
+	for ; ok && len(result) < n; next, ok = r.NextOccurrence(next.Add(time.Second)) {
+		result = append(result, RecurringOccurrence{At: next, Skipped: containsTime(skips, next)})
+	}
+
+	return result, nil
+}
+
+// postRecurringTransaction posts the occurrences due by now and moves next_at past them,
+// skipped occurrences are dropped along with their skip and so are the ones within a reconciled period
+func postRecurringTransaction[T DatabaseInterface](db T, userId int64, r RecurringTransaction, now time.Time) (int, error) {
+	if r.Paused || r.NextAt == nil || r.NextAt.After(now) {
+		return 0, nil
+	}
+
+	skips, err := getRecurringSkips(db, r.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	next, ok := *r.NextAt, true
+
+	for ; ok && !next.After(now); next, ok = r.NextOccurrence(next.Add(time.Second)) {
+		if containsTime(skips, next) {
+			if _, err := db.Exec(
+				"delete from recurring_skips where recurring_id = ? and datetime(occurs_at) = datetime(?)",
+				r.Id, next.UTC().Format(DATETIME_DB_LAYOUT),
+			); err != nil {
+				return posted, fmt.Errorf("failed to drop skip of recurring transaction %v: %v", r.Id, err)
+			}
+			continue
+		}
+
+		// the category of the schedule stays, the rules rewrite the description and add tags
+		t, err := CategorizeTransaction(db, userId, Transaction{
+			Account: r.Account, Amount: r.Amount, Category: r.Category, CreatedAt: next, Description: r.Description,
+		})
+		if err != nil {
+			return posted, fmt.Errorf("failed to apply rules to recurring transaction %v: %w", r.Id, err)
+		}
+
+		transaction, err := CreateTransactionWithRecalc(db, userId, t.Account, t.Amount, t.Category, next, t.Description)
+		if errors.Is(err, ErrReconciled) {
+			continue
+		} else if err != nil {
+			return posted, fmt.Errorf("failed to post recurring transaction %v at %v: %w", r.Id, next, err)
+		}
+
+		if len(t.Tags) > 0 {
+			names := make([]string, 0, len(t.Tags))
+			for _, tag := range t.Tags {
+				names = append(names, tag.Name)
+			}
+			if _, err := SetTransactionTags(db, userId, transaction.Id, names); err != nil {
+				return posted, fmt.Errorf("failed to tag recurring transaction %v at %v: %w", r.Id, next, err)
+			}
+		}
+		posted++
+	}
+
+	var nextAt *time.Time
+	if ok {
+		nextAt = &next
+	}
+
+	if _, err := db.Exec(
+		"update recurring_transactions set next_at = ? where id = ? and user_id = ?",
+		formatNullableDatetime(nextAt), r.Id, userId,
+	); err != nil {
+		return posted, fmt.Errorf("failed to move recurring transaction %v to the next occurrence: %v", r.Id, err)
+	}
+
+	return posted, nil
+}
+
+// PostRecurringTransaction posts the due occurrences of one recurring transaction in a single db transaction,
+// so an interrupted run never posts an occurrence twice
+func PostRecurringTransaction(db *sql.DB, userId int64, id int64, now time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	r, err := GetRecurringTransactionById(tx, userId, id)
+	if err != nil {
+		return 0, err
+	}
+
+	posted, err := postRecurringTransaction(tx, userId, r, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return posted, nil
+}
+
+// PostDueRecurringTransactions posts the occurrences due by now of every user,
+// a failing recurring transaction doesn't stop the others
+func PostDueRecurringTransactions(db *sql.DB, now time.Time) (int, error) {
+	type due struct{ id, userId int64 }
+	var dues []due
+
+	rows, err := db.Query(
+		`
+		select id, user_id from recurring_transactions
+		where paused = 0 and next_at is not null and datetime(next_at) <= datetime(?)
+		`,
+		now.UTC().Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("fetch due recurring transactions failed: %v", err)
+	}
+
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.userId); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("fetch due recurring transactions row failed: %v", err)
+		}
+		dues = append(dues, d)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error during due recurring transactions iteration: %v", err)
+	}
+
+	total := 0
+	var errs []error
+
+	for _, d := range dues {
+		posted, err := PostRecurringTransaction(db, d.userId, d.id, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		total += posted
+	}
+
+	return total, errors.Join(errs...)
+}
+
+// RunRecurringScheduler posts due occurrences right away, catching up on the ones missed while the app was down,
+// and then every interval until the context is done
+func RunRecurringScheduler(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		posted, err := PostDueRecurringTransactions(db, time.Now().UTC())
+		if err != nil {
+			log.Errorf("Posting recurring transactions failed: %v", err)
+		}
+		if posted > 0 {
+			log.Printf("Posted %v recurring transactions", posted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package greed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecurringOccurrence(t *testing.T) {
+	at := func(x string) time.Time {
+		parsed, err := time.Parse(time.DateTime, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	cases := []struct {
+		frequency   RecurringFrequency
+		every       int
+		startsAt    string
+		occurrences []string
+	}{
+		{RecurringDaily, 3, "2024-02-27 08:00:00", []string{"2024-02-27 08:00:00", "2024-03-01 08:00:00", "2024-03-04 08:00:00"}},
+		{RecurringWeekly, 2, "2024-12-25 08:00:00", []string{"2024-12-25 08:00:00", "2025-01-08 08:00:00", "2025-01-22 08:00:00"}},
+		// the day is clamped to the length of each month, not carried over from the previous one
+		{RecurringMonthly, 1, "2024-01-31 12:30:00", []string{
+			"2024-01-31 12:30:00", "2024-02-29 12:30:00", "2024-03-31 12:30:00", "2024-04-30 12:30:00",
+		}},
+		{RecurringMonthly, 5, "2023-10-31 00:00:00", []string{"2023-10-31 00:00:00", "2024-03-31 00:00:00", "2024-08-31 00:00:00", "2025-01-31 00:00:00"}},
+		{RecurringYearly, 1, "2024-02-29 09:00:00", []string{
+			"2024-02-29 09:00:00", "2025-02-28 09:00:00", "2026-02-28 09:00:00", "2027-02-28 09:00:00", "2028-02-29 09:00:00",
+		}},
+	}
+
+	for _, c := range cases {
+		r := RecurringTransaction{Frequency: c.frequency, Every: c.every, StartsAt: at(c.startsAt)}
+
+		for k, occurrence := range c.occurrences {
+			if got := r.Occurrence(k); !got.Equal(at(occurrence)) {
+				t.Errorf("%v occurrence %v from %v = %v, want %v", r.Schedule(), k, c.startsAt, got, occurrence)
+			}
+		}
+	}
+
+	// the next occurrences keep the offset of the start
+	zone := time.FixedZone("CET", 3600)
+	r := RecurringTransaction{Frequency: RecurringMonthly, Every: 1, StartsAt: time.Date(2026, 3, 1, 9, 0, 0, 0, zone)}
+	if got := r.Occurrence(1); !got.Equal(time.Date(2026, 4, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("occurrence after the start in %v = %v", zone, got.UTC())
+	}
+}
+
+func TestRecurringNextOccurrence(t *testing.T) {
+	endsAt := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	r := RecurringTransaction{
+		Frequency: RecurringMonthly,
+		Every:     1,
+		StartsAt:  time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+		EndsAt:    &endsAt,
+	}
+
+	cases := []struct {
+		from time.Time
+		next time.Time
+		ok   bool
+	}{
+		{time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), r.StartsAt, true},
+		{r.StartsAt, r.StartsAt, true},
+		{r.StartsAt.Add(time.Second), time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 4, 30, 12, 0, 1, 0, time.UTC), time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), true},
+		// the june occurrence falls after the end
+		{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		next, ok := r.NextOccurrence(c.from)
+		if ok != c.ok || !next.Equal(c.next) {
+			t.Errorf("next occurrence from %v = %v %v, want %v %v", c.from, next, ok, c.next, c.ok)
+		}
+	}
+}
+
+func TestPostDueRecurringTransactions(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	account := testAccount(t, db, user.Id, "USD", "100", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	r, err := CreateRecurringTransaction(db, user.Id, RecurringTransaction{
+		Account:     account,
+		Category:    food,
+		Amount:      mustParseMoney(t, "-10", "USD"),
+		Description: "Gym",
+		Frequency:   RecurringMonthly,
+		Every:       1,
+		StartsAt:    time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(now time.Time, want int, nextAt time.Time, balance string) {
+		t.Helper()
+
+		posted, err := PostDueRecurringTransactions(db, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if posted != want {
+			t.Errorf("posted %v occurrences by %v, want %v", posted, now, want)
+		}
+
+		if r, err = GetRecurringTransactionById(db, user.Id, r.Id); err != nil {
+			t.Fatal(err)
+		}
+		if r.NextAt == nil || !r.NextAt.Equal(nextAt) {
+			t.Errorf("next occurrence after %v = %v, want %v", now, r.NextAt, nextAt)
+		}
+
+		stored, err := GetAccountById(db, user.Id, account.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Amount.String() != balance {
+			t.Errorf("balance after posting by %v = %v, want %v", now, stored.Amount, balance)
+		}
+	}
+
+	// the missed occurrences of january, february and march are caught up at once
+	post(time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC), 3, time.Date(2026, 4, 30, 12, 0, 0, 0, time.UTC), "70.00")
+	post(time.Date(2026, 4, 16, 0, 0, 0, 0, time.UTC), 0, time.Date(2026, 4, 30, 12, 0, 0, 0, time.UTC), "70.00")
+
+	if err := SkipRecurringOccurrence(db, user.Id, r.Id, time.Date(2026, 4, 30, 12, 0, 0, 0, time.UTC), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SkipRecurringOccurrence(db, user.Id, r.Id, time.Date(2026, 5, 30, 12, 0, 0, 0, time.UTC), true); !errors.Is(err, ErrInvalidRecurring) {
+		t.Errorf("skip of a day off the schedule = %v, want %v", err, ErrInvalidRecurring)
+	}
+
+	upcoming, err := GetUpcomingOccurrences(db, user.Id, r, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upcoming) != 2 || !upcoming[0].Skipped || upcoming[1].Skipped || !upcoming[1].At.Equal(time.Date(2026, 5, 31, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("upcoming occurrences = %+v", upcoming)
+	}
+
+	// the skipped april occurrence isn't posted
+	post(time.Date(2026, 5, 31, 12, 0, 0, 0, time.UTC), 1, time.Date(2026, 6, 30, 12, 0, 0, 0, time.UTC), "60.00")
+
+	if _, err := SetRecurringTransactionPaused(db, user.Id, r.Id, true, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	post(time.Date(2026, 8, 15, 0, 0, 0, 0, time.UTC), 0, time.Date(2026, 6, 30, 12, 0, 0, 0, time.UTC), "60.00")
+
+	// occurrences that fell into the pause are dropped
+	if _, err := SetRecurringTransactionPaused(db, user.Id, r.Id, false, time.Date(2026, 8, 15, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	post(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), 1, time.Date(2026, 9, 30, 12, 0, 0, 0, time.UTC), "50.00")
+}
+
+func TestPostRecurringTransactionAppliesRules(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	fun := testCategory(t, db, user.Id, "🎮 Fun")
+	account := testAccount(t, db, user.Id, "USD", "100", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	if _, err := CreateRule(db, user.Id, Rule{
+		Name: "Gym", DescriptionContains: "gym", Category: &fun, Tags: []string{"sport"}, Description: "Gym membership",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := CreateRecurringTransaction(db, user.Id, RecurringTransaction{
+		Account:     account,
+		Category:    food,
+		Amount:      mustParseMoney(t, "-10", "USD"),
+		Description: "GYM 0131",
+		Frequency:   RecurringMonthly,
+		Every:       1,
+		StartsAt:    time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if posted, err := PostRecurringTransaction(db, user.Id, r.Id, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil || posted != 1 {
+		t.Fatalf("posted %v, %v, want 1", posted, err)
+	}
+
+	transactions, err := GetTransactions(db, user.Id, TransactionFilterDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("transactions = %+v, want 1", transactions)
+	}
+
+	// the category of the schedule wins over the one of the rule
+	posted := transactions[0]
+	if posted.Category.Id != food.Id || posted.Description != "Gym membership" || len(posted.Tags) != 1 || posted.Tags[0].Name != "sport" {
+		t.Errorf("posted transaction = %v %q %+v, want food, the rule's description and tag", posted.Category.Name, posted.Description, posted.Tags)
+	}
+}
@@ -0,0 +1,699 @@
+package greed
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Rule categorises the transactions matching all of its conditions, empty conditions match anything
+type Rule struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+	// rules run in this order, the first matching rule setting a field wins
+	Position int `json:"position"`
+	// the description contains it ignoring case
+	DescriptionContains string `json:"description_contains"`
+	DescriptionRegex    string `json:"description_regex"`
+	// nil for any account
+	Account *Account `json:"account"`
+	// inclusive bounds compared in the currency of the transaction, expenses are negative
+	AmountMin *Money `json:"amount_min"`
+	AmountMax *Money `json:"amount_max"`
+	// days of the transaction date, empty for any day
+	Weekdays []time.Weekday `json:"weekdays"`
+	// nil keeps the category
+	Category *Category `json:"category"`
+	// added to the tags of the transaction
+	Tags []string `json:"tags"`
+	// replaces the description, empty keeps it
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	regex *regexp.Regexp
+}
+
+func (r *Rule) ToJson() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *Rule) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, r)
+}
+
+// validate checks that the rule has a condition and an action, compiles the regex and normalizes the tags
+func (r *Rule) validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidRule)
+	}
+
+	r.DescriptionContains = strings.TrimSpace(r.DescriptionContains)
+	r.DescriptionRegex = strings.TrimSpace(r.DescriptionRegex)
+	r.Description = strings.TrimSpace(r.Description)
+
+	if r.DescriptionContains == "" && r.DescriptionRegex == "" && r.Account == nil &&
+		r.AmountMin == nil && r.AmountMax == nil && len(r.Weekdays) == 0 {
+		return fmt.Errorf("%w: %v has no conditions", ErrInvalidRule, r.Name)
+	}
+
+	tags, err := NormalizeTagNames(r.Tags)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	r.Tags = tags
+
+	if r.Category == nil && len(r.Tags) == 0 && r.Description == "" {
+		return fmt.Errorf("%w: %v sets neither a category, tags nor a description", ErrInvalidRule, r.Name)
+	}
+
+	if err := r.compile(); err != nil {
+		return err
+	}
+
+	if r.AmountMin != nil && r.AmountMax != nil && r.AmountMin.Cmp(*r.AmountMax) > 0 {
+		return fmt.Errorf("%w: minimal amount %v is over the maximal %v", ErrInvalidRule, r.AmountMin, r.AmountMax)
+	}
+
+	for _, d := range r.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("%w: unknown weekday %v", ErrInvalidRule, int(d))
+		}
+	}
+
+	return nil
+}
+
+// compile prepares the regex condition, matching ignores case
+func (r *Rule) compile() error {
+	r.regex = nil
+	if r.DescriptionRegex == "" {
+		return nil
+	}
+
+	regex, err := regexp.Compile("(?i)" + r.DescriptionRegex)
+	if err != nil {
+		return fmt.Errorf("%w: invalid regex %q: %v", ErrInvalidRule, r.DescriptionRegex, err)
+	}
+
+	r.regex = regex
+	return nil
+}
+
+// Matches tells if the transaction meets all the conditions of the rule
+func (r Rule) Matches(t Transaction) bool {
+	if r.DescriptionContains != "" && !strings.Contains(strings.ToLower(t.Description), strings.ToLower(r.DescriptionContains)) {
+		return false
+	}
+
+	if r.regex != nil && !r.regex.MatchString(t.Description) {
+		return false
+	}
+
+	if r.Account != nil && r.Account.Id != t.Account.Id {
+		return false
+	}
+
+	if r.AmountMin != nil && t.Amount.Cmp(*r.AmountMin) < 0 {
+		return false
+	}
+
+	if r.AmountMax != nil && t.Amount.Cmp(*r.AmountMax) > 0 {
+		return false
+	}
+
+	if len(r.Weekdays) > 0 {
+		matches := false
+		for _, d := range r.Weekdays {
+			matches = matches || t.CreatedAt.Weekday() == d
+		}
+		if !matches {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RuleResult is what the matching rules set on a transaction
+type RuleResult struct {
+	// nil when no matching rule sets a category
+	Category *Category `json:"category"`
+	Tags     []string  `json:"tags"`
+	// empty when no matching rule sets a description
+	Description string `json:"description"`
+	// ids of the matching rules
+	RuleIds []int64 `json:"rule_ids"`
+}
+
+// ApplyRules runs the rules in order over the transaction, the first matching rule setting
+// the category or the description wins, the tags of all matching rules are added
+func ApplyRules(rules []Rule, t Transaction) RuleResult {
+	var result RuleResult
+	seen := map[string]bool{}
+
+	for _, r := range rules {
+		if !r.Matches(t) {
+			continue
+		}
+
+		result.RuleIds = append(result.RuleIds, r.Id)
+
+		if result.Category == nil && r.Category != nil {
+			category := *r.Category
+			result.Category = &category
+		}
+
+		if result.Description == "" {
+			result.Description = r.Description
+		}
+
+		for _, tag := range r.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				result.Tags = append(result.Tags, tag)
+			}
+		}
+	}
+
+	return result
+}
+
+// weekdaysMask packs the weekdays into bits 1 << weekday
+func weekdaysMask(weekdays []time.Weekday) int {
+	mask := 0
+	for _, d := range weekdays {
+		mask |= 1 << uint(d)
+	}
+	return mask
+}
+
+func maskWeekdays(mask int) []time.Weekday {
+	weekdays := []time.Weekday{}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if mask&(1<<uint(d)) != 0 {
+			weekdays = append(weekdays, d)
+		}
+	}
+	return weekdays
+}
+
+func nullableMoney(m *Money) any {
+	if m == nil {
+		return nil
+	}
+	return m.String()
+}
+
+func scanMoney(x sql.NullString) (*Money, error) {
+	if !x.Valid {
+		return nil, nil
+	}
+
+	m, err := parseMoneyExact(x.String)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+const ruleSelect = `
+	select
+		rules.id, rules.name, rules.position, rules.description_contains, rules.description_regex,
+		accounts.id, accounts.name, accounts.currency, rules.amount_min, rules.amount_max, rules.weekdays,
+		categories.id, categories.name, rules.tags, rules.set_description, rules.created_at
+	from rules
+	left join accounts on accounts.id = rules.account_id
+	left join categories on categories.id = rules.category_id
+`
+
+func scanRule(row rowScanner) (Rule, error) {
+	var r Rule
+	var accountId, categoryId sql.NullInt64
+	var accountName, accountCurrency, categoryName, amountMin, amountMax sql.NullString
+	var weekdays int
+	var tags, createdAt string
+
+	if err := row.Scan(
+		&r.Id, &r.Name, &r.Position, &r.DescriptionContains, &r.DescriptionRegex,
+		&accountId, &accountName, &accountCurrency, &amountMin, &amountMax, &weekdays,
+		&categoryId, &categoryName, &tags, &r.Description, &createdAt,
+	); err != nil {
+		return r, err
+	}
+
+	if accountId.Valid {
+		r.Account = &Account{Id: accountId.Int64, Name: accountName.String, Currency: accountCurrency.String}
+	}
+
+	if categoryId.Valid {
+		r.Category = &Category{Id: categoryId.Int64, Name: categoryName.String}
+	}
+
+	var err error
+	if r.AmountMin, err = scanMoney(amountMin); err != nil {
+		return r, err
+	}
+	if r.AmountMax, err = scanMoney(amountMax); err != nil {
+		return r, err
+	}
+
+	r.Weekdays = maskWeekdays(weekdays)
+
+	r.Tags = []string{}
+	if tags != "" {
+		r.Tags = strings.Split(tags, ",")
+	}
+
+	if r.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+		return r, err
+	}
+
+	if err := r.compile(); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// GetRules returns the rules of the user in the order they run
+func GetRules[T DatabaseInterface](db T, userId int64) ([]Rule, error) {
+	var rules []Rule
+
+	rows, err := db.Query(ruleSelect+"where rules.user_id = ? order by rules.position, rules.id", userId)
+	if err != nil {
+		return nil, fmt.Errorf("fetch rules failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("fetch rules row failed: %v", err)
+		}
+		rules = append(rules, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rules iteration: %v", err)
+	}
+
+	return rules, nil
+}
+
+func GetRuleById[T DatabaseInterface](db T, userId int64, id int64) (Rule, error) {
+	row := db.QueryRow(ruleSelect+"where rules.id = ? and rules.user_id = ?", id, userId)
+
+	r, err := scanRule(row)
+	if err != nil {
+		return r, fmt.Errorf("fetch rule %v failed: %w", id, err)
+	}
+
+	return r, nil
+}
+
+// checkRuleOwnership makes sure that account and category of the rule belong to the user
+func checkRuleOwnership[T DatabaseInterface](db T, userId int64, r Rule) error {
+	if r.Account != nil {
+		if _, err := GetAccountById(db, userId, r.Account.Id); err != nil {
+			return fmt.Errorf("account %v of user %v: %w", r.Account.Id, userId, err)
+		}
+	}
+
+	if r.Category != nil {
+		if _, err := GetCategoryById(db, userId, r.Category.Id); err != nil {
+			return fmt.Errorf("category %v of user %v: %w", r.Category.Id, userId, err)
+		}
+	}
+
+	return nil
+}
+
+func ruleAccountId(r Rule) any {
+	if r.Account == nil {
+		return nil
+	}
+	return r.Account.Id
+}
+
+func ruleCategoryId(r Rule) any {
+	if r.Category == nil {
+		return nil
+	}
+	return r.Category.Id
+}
+
+// CreateRule adds the rule after the existing ones unless it has a position
+func CreateRule[T DatabaseInterface](db T, userId int64, rule Rule) (Rule, error) {
+	if err := rule.validate(); err != nil {
+		return rule, err
+	}
+
+	if err := checkRuleOwnership(db, userId, rule); err != nil {
+		return rule, err
+	}
+
+	if rule.Position <= 0 {
+		if err := db.QueryRow(
+			"select coalesce(max(position), 0) + 1 from rules where user_id = ?", userId,
+		).Scan(&rule.Position); err != nil {
+			return rule, fmt.Errorf("failed to get next rule position: %v", err)
+		}
+	}
+
+	rule.CreatedAt = time.Now().UTC()
+
+	result, err := db.Exec(
+		`
+		insert into rules (
+			user_id, name, position, description_contains, description_regex, account_id, amount_min, amount_max,
+			weekdays, category_id, tags, set_description, created_at
+		)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		userId, rule.Name, rule.Position, rule.DescriptionContains, rule.DescriptionRegex, ruleAccountId(rule),
+		nullableMoney(rule.AmountMin), nullableMoney(rule.AmountMax), weekdaysMask(rule.Weekdays),
+		ruleCategoryId(rule), strings.Join(rule.Tags, ","), rule.Description, rule.CreatedAt.Format(DATETIME_DB_LAYOUT),
+	)
+	if err != nil {
+		return rule, fmt.Errorf("failed to create rule %v: %v", rule.Name, err)
+	}
+
+	if rule.Id, err = result.LastInsertId(); err != nil {
+		return rule, fmt.Errorf("failed to get last inserted rule id: %v", err)
+	}
+
+	return GetRuleById(db, userId, rule.Id)
+}
+
+func UpdateRule[T DatabaseInterface](db T, userId int64, rule Rule) (Rule, error) {
+	if err := rule.validate(); err != nil {
+		return rule, err
+	}
+
+	if err := checkRuleOwnership(db, userId, rule); err != nil {
+		return rule, err
+	}
+
+	old, err := GetRuleById(db, userId, rule.Id)
+	if err != nil {
+		return rule, err
+	}
+
+	if rule.Position <= 0 {
+		rule.Position = old.Position
+	}
+
+	if _, err := db.Exec(
+		`
+		update rules set
+			name = ?, position = ?, description_contains = ?, description_regex = ?, account_id = ?,
+			amount_min = ?, amount_max = ?, weekdays = ?, category_id = ?, tags = ?, set_description = ?
+		where id = ? and user_id = ?
+		`,
+		rule.Name, rule.Position, rule.DescriptionContains, rule.DescriptionRegex, ruleAccountId(rule),
+		nullableMoney(rule.AmountMin), nullableMoney(rule.AmountMax), weekdaysMask(rule.Weekdays),
+		ruleCategoryId(rule), strings.Join(rule.Tags, ","), rule.Description,
+		rule.Id, userId,
+	); err != nil {
+		return rule, fmt.Errorf("failed to update rule %v: %v", rule.Id, err)
+	}
+
+	return GetRuleById(db, userId, rule.Id)
+}
+
+func DeleteRule[T DatabaseInterface](db T, userId int64, ruleId int64) error {
+	if _, err := GetRuleById(db, userId, ruleId); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("delete from rules where id = ? and user_id = ?", ruleId, userId); err != nil {
+		return fmt.Errorf("failed to delete rule %v: %v", ruleId, err)
+	}
+
+	return nil
+}
+
+// CategorizeTransaction applies the rules of the user to a transaction about to be created:
+// the category is set unless the transaction has one, the description is rewritten and the tags are added
+func CategorizeTransaction[T DatabaseInterface](db T, userId int64, t Transaction) (Transaction, error) {
+	rules, err := GetRules(db, userId)
+	if err != nil {
+		return t, err
+	}
+
+	result := ApplyRules(rules, t)
+
+	if t.Category.Id == 0 && result.Category != nil {
+		t.Category = *result.Category
+	}
+
+	if result.Description != "" {
+		t.Description = result.Description
+	}
+
+	for _, name := range result.Tags {
+		if !hasTag(t.Tags, name) {
+			t.Tags = append(t.Tags, Tag{Name: name})
+		}
+	}
+
+	return t, nil
+}
+
+// categorizeImportRows applies the rules to the rows, a category taken from the statement is kept
+func categorizeImportRows[T DatabaseInterface](db T, userId int64, account Account, rows []ImportRow) error {
+	rules, err := GetRules(db, userId)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if row.Error != "" {
+			continue
+		}
+
+		result := ApplyRules(rules, Transaction{
+			Account:     account,
+			Amount:      row.Amount,
+			CreatedAt:   row.CreatedAt,
+			Description: row.Description,
+		})
+
+		if !row.categorized && result.Category != nil {
+			rows[i].Category = *result.Category
+		}
+
+		if result.Description != "" {
+			rows[i].Description = result.Description
+		}
+
+		rows[i].Tags = result.Tags
+	}
+
+	return nil
+}
+
+func hasTag(tags []Tag, name string) bool {
+	for _, t := range tags {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleChange is what re-applying the rules changes on an existing transaction
+type RuleChange struct {
+	Transaction Transaction `json:"transaction"`
+	// nil when the category stays
+	Category *Category `json:"category"`
+	// empty when the description stays
+	Description string `json:"description"`
+	// tags the transaction gets on top of its own
+	AddedTags []string `json:"added_tags"`
+	RuleIds   []int64  `json:"rule_ids"`
+}
+
+// getRuleTransactions returns the transactions the rules may change: everything but transfer legs and reconciled ones
+func getRuleTransactions[T DatabaseInterface](db T, userId int64) ([]Transaction, error) {
+	var transactions []Transaction
+
+	rows, err := db.Query(
+		`
+		select
+			transactions.id, accounts.id, accounts.name, accounts.currency, transactions.amount,
+			categories.id, categories.name, transactions.created_at, transactions.description
+		from transactions
+		join accounts on accounts.id = transactions.account_id
+		left join categories on categories.id = transactions.category_id
+		where transactions.user_id = ? and transactions.transfer_id is null and transactions.reconciliation_id is null
+		order by transactions.created_at desc, transactions.id desc
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch rule transactions failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Transaction
+		var amount int64
+		var categoryId sql.NullInt64
+		var categoryName sql.NullString
+		var createdAt string
+
+		if err := rows.Scan(
+			&t.Id, &t.Account.Id, &t.Account.Name, &t.Account.Currency, &amount,
+			&categoryId, &categoryName, &createdAt, &t.Description,
+		); err != nil {
+			return nil, fmt.Errorf("fetch rule transactions row failed: %v", err)
+		}
+
+		t.Amount = NewMoney(amount, CurrencyExponent(t.Account.Currency))
+		if categoryId.Valid {
+			t.Category = Category{Id: categoryId.Int64, Name: categoryName.String}
+		}
+
+		if t.CreatedAt, err = ParseDbDatetime(createdAt); err != nil {
+			return nil, err
+		}
+
+		transactions = append(transactions, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rule transactions iteration: %v", err)
+	}
+
+	if err := fillTransactionsTags(db, userId, transactions); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// PreviewRules lists what re-applying the rules (or the single rule when ruleId isn't 0) would change,
+// the matching rules overwrite the category and the description and add their tags,
+// transfer legs and reconciled transactions are left alone
+func PreviewRules[T DatabaseInterface](db T, userId int64, ruleId int64) ([]RuleChange, error) {
+	changes := []RuleChange{}
+
+	var rules []Rule
+	if ruleId != 0 {
+		rule, err := GetRuleById(db, userId, ruleId)
+		if err != nil {
+			return nil, err
+		}
+		rules = []Rule{rule}
+	} else {
+		var err error
+		if rules, err = GetRules(db, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rules) == 0 {
+		return changes, nil
+	}
+
+	transactions, err := getRuleTransactions(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range transactions {
+		result := ApplyRules(rules, t)
+		if len(result.RuleIds) == 0 {
+			continue
+		}
+
+		change := RuleChange{Transaction: t, RuleIds: result.RuleIds, AddedTags: []string{}}
+
+		if result.Category != nil && result.Category.Id != t.Category.Id {
+			change.Category = result.Category
+		}
+
+		if result.Description != "" && result.Description != t.Description {
+			change.Description = result.Description
+		}
+
+		for _, name := range result.Tags {
+			if !hasTag(t.Tags, name) {
+				change.AddedTags = append(change.AddedTags, name)
+			}
+		}
+
+		if change.Category != nil || change.Description != "" || len(change.AddedTags) > 0 {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+// ReapplyRules applies the changes listed by PreviewRules in one db transaction
+func ReapplyRules(db *sql.DB, userId int64, ruleId int64) ([]RuleChange, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changes, err := PreviewRules(tx, userId, ruleId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		t := change.Transaction
+
+		if change.Category != nil {
+			if _, err := tx.Exec(
+				"update transactions set category_id = ? where id = ? and user_id = ?",
+				change.Category.Id, t.Id, userId,
+			); err != nil {
+				return nil, fmt.Errorf("failed to categorize transaction %v: %v", t.Id, err)
+			}
+		}
+
+		if change.Description != "" {
+			if _, err := tx.Exec(
+				"update transactions set description = ? where id = ? and user_id = ?",
+				change.Description, t.Id, userId,
+			); err != nil {
+				return nil, fmt.Errorf("failed to rewrite description of transaction %v: %v", t.Id, err)
+			}
+		}
+
+		if len(change.AddedTags) > 0 {
+			names := append([]string{}, change.AddedTags...)
+			for _, tag := range t.Tags {
+				names = append(names, tag.Name)
+			}
+
+			if _, err := SetTransactionTags(tx, userId, t.Id, names); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// rewritten descriptions may match payees now
+	if _, err := LinkPayees(tx, userId); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
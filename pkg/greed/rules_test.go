@@ -0,0 +1,232 @@
+package greed
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func moneyRef(m Money) *Money {
+	return &m
+}
+
+func TestWeekdaysMask(t *testing.T) {
+	cases := []struct {
+		weekdays []time.Weekday
+		mask     int
+	}{
+		{[]time.Weekday{}, 0},
+		{[]time.Weekday{time.Sunday}, 1},
+		{[]time.Weekday{time.Saturday}, 64},
+		{[]time.Weekday{time.Sunday, time.Saturday}, 65},
+		{[]time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, 62},
+	}
+
+	for _, c := range cases {
+		if mask := weekdaysMask(c.weekdays); mask != c.mask {
+			t.Errorf("mask of %v = %v, want %v", c.weekdays, mask, c.mask)
+		}
+		if weekdays := maskWeekdays(c.mask); !reflect.DeepEqual(weekdays, c.weekdays) {
+			t.Errorf("weekdays of mask %v = %v, want %v", c.mask, weekdays, c.weekdays)
+		}
+	}
+
+	// repeated days don't carry into the next bit
+	if mask := weekdaysMask([]time.Weekday{time.Monday, time.Monday}); mask != 2 {
+		t.Errorf("mask of a repeated monday = %v, want 2", mask)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	category := &Category{Id: 1}
+
+	cases := []struct {
+		name string
+		rule Rule
+	}{
+		{"no name", Rule{Name: " ", DescriptionContains: "shop", Category: category}},
+		{"no conditions", Rule{Name: "all", Category: category}},
+		{"no action", Rule{Name: "shop", DescriptionContains: "shop"}},
+		{"invalid regex", Rule{Name: "shop", DescriptionRegex: "shop(", Category: category}},
+		{"min over max", Rule{Name: "shop", AmountMin: moneyRef(NewMoney(-100, 2)), AmountMax: moneyRef(NewMoney(-2, 0)), Category: category}},
+		{"unknown weekday", Rule{Name: "shop", Weekdays: []time.Weekday{7}, Category: category}},
+		{"invalid tag", Rule{Name: "shop", DescriptionContains: "shop", Tags: []string{"a,b"}}},
+	}
+
+	for _, c := range cases {
+		if err := c.rule.validate(); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("%v: validate = %v, want %v", c.name, err, ErrInvalidRule)
+		}
+	}
+
+	rule := Rule{Name: " shop ", DescriptionRegex: " ^corner ", Tags: []string{"Food", "food"}}
+	if err := rule.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Name != "shop" || rule.DescriptionRegex != "^corner" || strings.Join(rule.Tags, ",") != "food" || rule.regex == nil {
+		t.Errorf("validated rule = %+v", rule)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	checking := Account{Id: 1, Currency: "USD"}
+	savings := Account{Id: 2, Currency: "USD"}
+
+	// 2026-03-14 is a saturday
+	saturday := time.Date(2026, 3, 14, 23, 30, 0, 0, time.UTC)
+	transaction := Transaction{Account: checking, Amount: NewMoney(-4250, 2), CreatedAt: saturday, Description: "CORNER SHOP #12"}
+
+	cases := []struct {
+		name    string
+		rule    Rule
+		matches bool
+	}{
+		{"contains ignoring case", Rule{DescriptionContains: "corner shop"}, true},
+		{"doesn't contain", Rule{DescriptionContains: "supermarket"}, false},
+		{"regex ignoring case", Rule{DescriptionRegex: `^corner shop #\d+$`}, true},
+		{"regex mismatch", Rule{DescriptionRegex: `^shop`}, false},
+		{"account", Rule{Account: &checking}, true},
+		{"other account", Rule{Account: &savings}, false},
+		{"inclusive min", Rule{AmountMin: moneyRef(NewMoney(-425, 1))}, true},
+		{"min of another exponent", Rule{AmountMin: moneyRef(NewMoney(-42, 0))}, false},
+		{"inclusive max", Rule{AmountMax: moneyRef(NewMoney(-4250, 2))}, true},
+		{"max below", Rule{AmountMax: moneyRef(NewMoney(-50, 0))}, false},
+		{"range", Rule{AmountMin: moneyRef(NewMoney(-50, 0)), AmountMax: moneyRef(NewMoney(0, 0))}, true},
+		{"weekend", Rule{Weekdays: []time.Weekday{time.Sunday, time.Saturday}}, true},
+		{"weekdays", Rule{Weekdays: maskWeekdays(62)}, false},
+		{"all conditions", Rule{DescriptionContains: "shop", Account: &checking, AmountMax: moneyRef(NewMoney(0, 0)), Weekdays: []time.Weekday{time.Saturday}}, true},
+		{"one condition fails", Rule{DescriptionContains: "shop", Account: &checking, Weekdays: []time.Weekday{time.Friday}}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.rule.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if matches := c.rule.Matches(transaction); matches != c.matches {
+			t.Errorf("%v: matches = %v, want %v", c.name, matches, c.matches)
+		}
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	food := Category{Id: 1, Name: "Food"}
+	fun := Category{Id: 2, Name: "Fun"}
+
+	rules := []Rule{
+		{Id: 1, DescriptionContains: "cinema", Category: &fun, Tags: []string{"weekend"}},
+		{Id: 2, DescriptionContains: "popcorn", Category: &food, Tags: []string{"snacks", "weekend"}, Description: "Popcorn"},
+		{Id: 3, DescriptionContains: "cinema", Tags: []string{"movies"}, Description: "Cinema"},
+	}
+
+	result := ApplyRules(rules, Transaction{Description: "Cinema popcorn"})
+
+	// the first matching rule setting a field wins, the tags of every matching rule are added once
+	if result.Category == nil || result.Category.Id != fun.Id || result.Description != "Popcorn" {
+		t.Errorf("result category %v, description %q, want %v, Popcorn", result.Category, result.Description, fun)
+	}
+	if strings.Join(result.Tags, ",") != "weekend,snacks,movies" || !reflect.DeepEqual(result.RuleIds, []int64{1, 2, 3}) {
+		t.Errorf("result tags %v of rules %v", result.Tags, result.RuleIds)
+	}
+
+	if result := ApplyRules(rules, Transaction{Description: "Groceries"}); result.Category != nil || len(result.RuleIds) != 0 {
+		t.Errorf("rules applied to a transaction none of them matches: %+v", result)
+	}
+}
+
+func TestPreviewAndReapplyRules(t *testing.T) {
+	db, user := newTestDb(t)
+
+	opened := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	beauty := testCategory(t, db, user.Id, "💆 Beauty and Health")
+	account := testAccount(t, db, user.Id, "USD", "1000", opened)
+
+	// 2026-03-01 is a sunday, 2026-03-07 a saturday
+	pharmacy := testTransaction(t, db, user.Id, account, "-20", food, time.Date(2026, 3, 7, 10, 0, 0, 0, time.UTC), "pharmacy 24")
+	weekday := testTransaction(t, db, user.Id, account, "-20", food, time.Date(2026, 3, 9, 10, 0, 0, 0, time.UTC), "pharmacy 24")
+	reconciled := testTransaction(t, db, user.Id, account, "-20", food, time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC), "pharmacy 24")
+	testTransaction(t, db, user.Id, account, "-5", food, time.Date(2026, 3, 7, 11, 0, 0, 0, time.UTC), "bakery")
+
+	if _, err := SetTransactionCleared(db, user.Id, reconciled.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReconcileAccount(db, user.Id, account.Id, opened.AddDate(0, 0, 2), mustParseMoney(t, "980", "USD"), Category{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := CreateRule(db, user.Id, Rule{
+		Name:             "Weekend pharmacy",
+		DescriptionRegex: `^pharmacy\b`,
+		Account:          &account,
+		AmountMax:        moneyRef(NewMoney(0, 0)),
+		Weekdays:         []time.Weekday{time.Saturday, time.Sunday},
+		Category:         &beauty,
+		Tags:             []string{"health"},
+		Description:      "Pharmacy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the weekdays are stored as a mask and read back in order
+	if !reflect.DeepEqual(rule.Weekdays, []time.Weekday{time.Sunday, time.Saturday}) || rule.Position != 1 {
+		t.Errorf("stored rule weekdays %v at position %v", rule.Weekdays, rule.Position)
+	}
+
+	if _, err := CreateRule(db, user.Id, Rule{Name: "Other account", Account: &Account{Id: account.Id + 1}, Category: &beauty}); err == nil {
+		t.Errorf("rule for an unknown account created")
+	}
+
+	changes, err := PreviewRules(db, user.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the weekday purchase doesn't match and the reconciled one is left alone
+	if len(changes) != 1 || changes[0].Transaction.Id != pharmacy.Id {
+		t.Fatalf("preview changes = %+v, want transaction %v", changes, pharmacy.Id)
+	}
+	change := changes[0]
+	if change.Category == nil || change.Category.Id != beauty.Id || change.Description != "Pharmacy" ||
+		strings.Join(change.AddedTags, ",") != "health" || !reflect.DeepEqual(change.RuleIds, []int64{rule.Id}) {
+		t.Errorf("preview change = %+v", change)
+	}
+
+	// the preview doesn't store anything
+	unchanged, err := GetTransactionById(db, user.Id, pharmacy.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.Category.Id != food.Id || unchanged.Description != "pharmacy 24" {
+		t.Errorf("preview changed the transaction: %+v", unchanged)
+	}
+
+	if _, err := ReapplyRules(db, user.Id, rule.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := GetTransactionById(db, user.Id, pharmacy.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Category.Id != beauty.Id || changed.Description != "Pharmacy" || len(changed.Tags) != 1 || changed.Tags[0].Name != "health" {
+		t.Errorf("reapplied rule changed the transaction into %+v", changed)
+	}
+
+	for _, id := range []int64{weekday.Id, reconciled.Id} {
+		other, err := GetTransactionById(db, user.Id, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Category.Id != food.Id || other.Description != "pharmacy 24" {
+			t.Errorf("reapplied rule changed transaction %v into %+v", id, other)
+		}
+	}
+
+	// reapplying is idempotent, the rewritten description still matches the regex
+	if changes, err := PreviewRules(db, user.Id, rule.Id); err != nil || len(changes) != 0 {
+		t.Errorf("preview after reapplying = %+v, %v", changes, err)
+	}
+}
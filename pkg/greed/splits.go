@@ -0,0 +1,234 @@
+package greed
+
+import (
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrInvalidSplit = errors.New("invalid split")
+
+// Split is a part of the transaction amount in its own category
+type Split struct {
+	Id       int64    `json:"id"`
+	Category Category `json:"category"`
+	Amount   Money    `json:"amount"`
+	Memo     string   `json:"memo"`
+}
+
+// transactionLines has a row per split line of split transactions and a row per other transaction,
+// it replaces the transactions table in stats so that amounts are counted by the categories of the lines
+const transactionLines = `(
+	select
+		transactions.id,
+		transactions.user_id,
+		transactions.account_id,
+		transactions.created_at,
+		transactions.transfer_id,
+		coalesce(transaction_splits.category_id, transactions.category_id) as category_id,
+		coalesce(transaction_splits.amount, transactions.amount) as amount
+	from transactions
+	left join transaction_splits on transaction_splits.transaction_id = transactions.id
+) as transactions`
+
+// SplitsRemainder is the part of the amount not covered by the splits
+func SplitsRemainder(amount Money, splits []Split) (Money, error) {
+	remainder := amount
+	for _, s := range splits {
+		var err error
+		if remainder, err = remainder.Sub(s.Amount); err != nil {
+			return remainder, err
+		}
+	}
+	return remainder, nil
+}
+
+// ValidateSplits checks that the splits cover the amount exactly and rescales them to the currency,
+// no splits means the transaction isn't split
+func ValidateSplits(amount Money, currency string, splits []Split) ([]Split, error) {
+	if len(splits) == 0 {
+		return nil, nil
+	}
+
+	if len(splits) == 1 {
+		return nil, fmt.Errorf("%w: a split needs at least 2 lines", ErrInvalidSplit)
+	}
+
+	exponent := CurrencyExponent(currency)
+
+	amount, err := amount.Rescale(exponent)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSplit, err)
+	}
+
+	result := make([]Split, 0, len(splits))
+
+	for i, s := range splits {
+		if s.Category.Id == 0 {
+			return nil, fmt.Errorf("%w: line %v has no category", ErrInvalidSplit, i+1)
+		}
+
+		if s.Amount, err = s.Amount.Rescale(exponent); err != nil {
+			return nil, fmt.Errorf("%w: line %v: %v", ErrInvalidSplit, i+1, err)
+		}
+
+		if s.Amount.IsZero() {
+			return nil, fmt.Errorf("%w: line %v has no amount", ErrInvalidSplit, i+1)
+		}
+
+		result = append(result, s)
+	}
+
+	remainder, err := SplitsRemainder(amount, result)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSplit, err)
+	}
+
+	if !remainder.IsZero() {
+		return nil, fmt.Errorf("%w: lines don't add up to %v, %v remains", ErrInvalidSplit, amount.String(), remainder.String())
+	}
+
+	return result, nil
+}
+
+// getTransactionsSplits returns the split lines of the transactions by transaction id
+func getTransactionsSplits[T DatabaseInterface](db T, userId int64, transactionIds []int64) (map[int64][]Split, error) {
+	result := map[int64][]Split{}
+	if len(transactionIds) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sq.
+		Select(
+			"transaction_splits.transaction_id",
+			"transaction_splits.id",
+			"categories.id",
+			"categories.name",
+			"transaction_splits.amount",
+			"accounts.currency",
+			"transaction_splits.memo",
+		).
+		From("transaction_splits").
+		Join("transactions on transactions.id = transaction_splits.transaction_id").
+		Join("accounts on accounts.id = transactions.account_id").
+		Join("categories on categories.id = transaction_splits.category_id").
+		Where(sq.Eq{"transactions.user_id": userId, "transaction_splits.transaction_id": transactionIds}).
+		OrderBy("transaction_splits.id asc").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("fetch transactions splits failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionId int64
+		var s Split
+		var amount int64
+		var currency string
+
+		if err := rows.Scan(&transactionId, &s.Id, &s.Category.Id, &s.Category.Name, &amount, &currency, &s.Memo); err != nil {
+			return nil, fmt.Errorf("fetch transactions splits row failed: %v", err)
+		}
+
+		s.Amount = NewMoney(amount, CurrencyExponent(currency))
+		result[transactionId] = append(result[transactionId], s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during transactions splits iteration: %v", err)
+	}
+
+	return result, nil
+}
+
+// fillTransactionsSplits sets Splits of the transactions, empty instead of nil for the ones not split
+func fillTransactionsSplits[T DatabaseInterface](db T, userId int64, transactions []Transaction) error {
+	ids := make([]int64, 0, len(transactions))
+	for _, t := range transactions {
+		ids = append(ids, t.Id)
+	}
+
+	splits, err := getTransactionsSplits(db, userId, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range transactions {
+		transactions[i].Splits = splits[transactions[i].Id]
+		if transactions[i].Splits == nil {
+			transactions[i].Splits = []Split{}
+		}
+	}
+
+	return nil
+}
+
+// deleteTransactionSplits drops the split lines of the user's transaction
+func deleteTransactionSplits[T DatabaseInterface](db T, userId int64, transactionId int64) error {
+	if _, err := db.Exec(
+		`
+		delete from transaction_splits
+		where transaction_id in (select id from transactions where id = ? and user_id = ?)
+		`,
+		transactionId, userId,
+	); err != nil {
+		return fmt.Errorf("failed to delete splits of transaction %v: %v", transactionId, err)
+	}
+	return nil
+}
+
+// SetTransactionSplits replaces the split lines of the transaction, they have to add up to its amount,
+// no splits turn it back into a single category transaction, splits of reconciled transactions are locked
+func SetTransactionSplits[T DatabaseInterface](db T, userId int64, transactionId int64, splits []Split) ([]Split, error) {
+	transaction, err := GetTransactionById(db, userId, transactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if transaction.TransferId != 0 && len(splits) > 0 {
+		return nil, fmt.Errorf("%w: transaction %v, transfer %v", ErrTransferLeg, transaction.Id, transaction.TransferId)
+	}
+
+	if transaction.ReconciliationId != 0 {
+		return nil, fmt.Errorf("%w: transaction %v", ErrReconciled, transaction.Id)
+	}
+
+	splits, err = ValidateSplits(transaction.Amount, transaction.Account.Currency, splits)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := deleteTransactionSplits(db, userId, transactionId); err != nil {
+		return nil, err
+	}
+
+	result := []Split{}
+
+	for _, s := range splits {
+		if s.Category, err = GetCategoryById(db, userId, s.Category.Id); err != nil {
+			return nil, fmt.Errorf("category %v of user %v: %w", s.Category.Id, userId, err)
+		}
+
+		inserted, err := db.Exec(
+			"insert into transaction_splits (transaction_id, category_id, amount, memo) values (?, ?, ?, ?)",
+			transactionId, s.Category.Id, s.Amount.Minor, s.Memo,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split transaction %v: %v", transactionId, err)
+		}
+
+		if s.Id, err = inserted.LastInsertId(); err != nil {
+			return nil, fmt.Errorf("failed to get last inserted split id of transaction %v: %v", transactionId, err)
+		}
+
+		result = append(result, s)
+	}
+
+	return result, nil
+}
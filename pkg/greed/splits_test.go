@@ -0,0 +1,149 @@
+package greed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateSplits(t *testing.T) {
+	food := Category{Id: 1}
+	beauty := Category{Id: 2}
+
+	cases := []struct {
+		name     string
+		amount   Money
+		currency string
+		splits   []Split
+		valid    bool
+	}{
+		{"not split", NewMoney(-100, 0), "USD", nil, true},
+		{"single line", NewMoney(-100, 0), "USD", []Split{{Category: food, Amount: NewMoney(-100, 0)}}, false},
+		{"exact", NewMoney(-100, 0), "USD", []Split{{Category: food, Amount: NewMoney(-605, 1)}, {Category: beauty, Amount: NewMoney(-3950, 2)}}, true},
+		{"sum mismatch", NewMoney(-100, 0), "USD", []Split{{Category: food, Amount: NewMoney(-60, 0)}, {Category: beauty, Amount: NewMoney(-3999, 2)}}, false},
+		{"no category", NewMoney(-100, 0), "USD", []Split{{Category: food, Amount: NewMoney(-60, 0)}, {Amount: NewMoney(-40, 0)}}, false},
+		{"no amount", NewMoney(-100, 0), "USD", []Split{{Category: food, Amount: NewMoney(-100, 0)}, {Category: beauty, Amount: NewMoney(0, 2)}}, false},
+		// yen have no minor units
+		{"inexact in the currency", NewMoney(-1000, 0), "JPY", []Split{{Category: food, Amount: NewMoney(-5005, 1)}, {Category: beauty, Amount: NewMoney(-4995, 1)}}, false},
+		{"exact in the currency", NewMoney(-1000, 0), "JPY", []Split{{Category: food, Amount: NewMoney(-5000, 1)}, {Category: beauty, Amount: NewMoney(-500, 0)}}, true},
+	}
+
+	for _, c := range cases {
+		splits, err := ValidateSplits(c.amount, c.currency, c.splits)
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("%v: validate = %v, want valid %v", c.name, err, c.valid)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidSplit) {
+			t.Errorf("%v: validate = %v, want %v", c.name, err, ErrInvalidSplit)
+		}
+
+		for _, s := range splits {
+			if s.Amount.Exponent != CurrencyExponent(c.currency) {
+				t.Errorf("%v: line %v isn't rescaled to %v", c.name, s.Amount, c.currency)
+			}
+		}
+	}
+}
+
+func TestSplitCategoryStats(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	beauty := testCategory(t, db, user.Id, "💆 Beauty and Health")
+	account := testAccount(t, db, user.Id, "USD", "1000", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	createdAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	transaction := testTransaction(t, db, user.Id, account, "-100", food, createdAt, "supermarket")
+
+	spent := func(category Category) string {
+		t.Helper()
+
+		stats, err := GetExpensesByCategory(db, user.Id, DateRange{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, currency := range stats {
+			for _, cs := range currency.Second {
+				if cs.Category.Id == category.Id {
+					return cs.Value.Amount.String()
+				}
+			}
+		}
+		return ""
+	}
+
+	if _, err := SetTransactionSplits(db, user.Id, transaction.Id, []Split{
+		{Category: food, Amount: mustParseMoney(t, "-60", "USD")},
+		{Category: beauty, Amount: mustParseMoney(t, "-40", "USD"), Memo: "shampoo"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	split, err := GetTransactionById(db, user.Id, transaction.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split.Splits) != 2 || split.Splits[1].Category.Name != beauty.Name || split.Splits[1].Memo != "shampoo" {
+		t.Errorf("stored splits = %+v", split.Splits)
+	}
+
+	// every line counts in its own category
+	if food, beauty := spent(food), spent(beauty); food != "60.00" || beauty != "40.00" {
+		t.Errorf("spent on food %v, on beauty %v, want 60.00, 40.00", food, beauty)
+	}
+
+	budget, err := CreateBudget(db, user.Id, Budget{Category: beauty, Currency: "USD", Period: BudgetMonthly, Amount: mustParseMoney(t, "50", "USD")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, err := GetBudgetProgress(db, user.Id, budget, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Spent.String() != "40.00" {
+		t.Errorf("spent of the beauty budget = %v, want 40.00", progress.Spent)
+	}
+
+	converted, err := GetConvertedStats(db, user.Id, "USD", DateRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.CashFlow.Value.Amount.String() != "-100.00" || len(converted.CategoriesSpent) != 2 || converted.CategoriesSpent[1].Category.Id != beauty.Id {
+		t.Errorf("converted stats = %+v", converted)
+	}
+
+	if _, err := SetTransactionSplits(db, user.Id, transaction.Id, []Split{
+		{Category: food, Amount: mustParseMoney(t, "-60", "USD")},
+		{Category: beauty, Amount: mustParseMoney(t, "-30", "USD")},
+	}); !errors.Is(err, ErrInvalidSplit) {
+		t.Errorf("splits not adding up = %v, want %v", err, ErrInvalidSplit)
+	}
+
+	// no splits put the whole amount back into the category of the transaction
+	if _, err := SetTransactionSplits(db, user.Id, transaction.Id, nil); err != nil {
+		t.Fatal(err)
+	}
+	if food, beauty := spent(food), spent(beauty); food != "100.00" || beauty != "" {
+		t.Errorf("spent after unsplitting on food %v, on beauty %v, want 100.00 and nothing", food, beauty)
+	}
+
+	transfer, err := CreateTransfer(db, user.Id, Transfer{
+		FromAccount: account,
+		ToAccount:   testAccount(t, db, user.Id, "EUR", "0", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)),
+		FromAmount:  mustParseMoney(t, "10", "USD"),
+		ToAmount:    mustParseMoney(t, "9", "EUR"),
+		Category:    food,
+		CreatedAt:   createdAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetTransactionSplits(db, user.Id, transfer.FromTransactionId, []Split{
+		{Category: food, Amount: mustParseMoney(t, "-5", "USD")},
+		{Category: beauty, Amount: mustParseMoney(t, "-5", "USD")},
+	}); !errors.Is(err, ErrTransferLeg) {
+		t.Errorf("split of a transfer leg = %v, want %v", err, ErrTransferLeg)
+	}
+}
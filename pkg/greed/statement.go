@@ -0,0 +1,362 @@
+package greed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type StatementFormat string
+
+const (
+	StatementCsv     StatementFormat = "csv"
+	StatementOfx     StatementFormat = "ofx"
+	StatementCamt053 StatementFormat = "camt.053"
+)
+
+// StatementEntry is a booked transaction of a bank statement file
+type StatementEntry struct {
+	// FITID of OFX, AcctSvcrRef of camt.053, empty if the bank doesn't provide one
+	ExternalId string
+	PostedAt   time.Time
+	// decimal, negative for debits
+	Amount      string
+	Currency    string
+	Description string
+}
+
+// DetectStatementFormat tells OFX/QFX and camt.053 files apart from csv exports
+func DetectStatementFormat(data []byte) StatementFormat {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	upper := bytes.ToUpper(head)
+
+	switch {
+	case bytes.Contains(upper, []byte("OFXHEADER")), bytes.Contains(upper, []byte("<OFX>")):
+		return StatementOfx
+	case bytes.Contains(upper, []byte("CAMT.053")), bytes.Contains(upper, []byte("<BKTOCSTMRSTMT")):
+		return StatementCamt053
+	default:
+		return StatementCsv
+	}
+}
+
+// ParseStatement parses OFX/QFX or camt.053 statement entries
+func ParseStatement(format StatementFormat, r io.Reader) ([]StatementEntry, error) {
+	switch format {
+	case StatementOfx:
+		return ParseOfxStatement(r)
+	case StatementCamt053:
+		return ParseCamt053Statement(r)
+	default:
+		return nil, fmt.Errorf("%w: unsupported statement format %q", ErrInvalidImport, format)
+	}
+}
+
+// matches both SGML (OFX 1.x, no closing tags for values) and XML (OFX 2.x) elements
+var ofxTagRe = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+// parseOfxDate parses datetimes like 20260310, 20260310120000 or 20260310120000.000[-5:EST]
+func parseOfxDate(x string) (time.Time, error) {
+	s, tz, _ := strings.Cut(strings.TrimSpace(x), "[")
+	s, _, _ = strings.Cut(s, ".")
+
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(s)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid ofx date %q", x)
+	}
+
+	location := time.UTC
+	if tz != "" {
+		offset, _, _ := strings.Cut(strings.TrimSuffix(tz, "]"), ":")
+		hours, err := strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid ofx date %q: %v", x, err)
+		}
+		location = time.FixedZone("", int(hours*3600))
+	}
+
+	parsed, err := time.ParseInLocation(layout, s, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid ofx date %q", x)
+	}
+
+	return parsed.UTC(), nil
+}
+
+// ParseOfxStatement reads STMTTRN entries of bank and credit card statements
+func ParseOfxStatement(r io.Reader) ([]StatementEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ofx: %v", err)
+	}
+
+	start := bytes.Index(bytes.ToUpper(data), []byte("<OFX>"))
+	if start < 0 {
+		return nil, fmt.Errorf("%w: no OFX element", ErrInvalidImport)
+	}
+
+	var entries []StatementEntry
+	var current map[string]string
+	currency := ""
+
+	for _, m := range ofxTagRe.FindAllSubmatch(data[start:], -1) {
+		closing := len(m[1]) > 0
+		tag := strings.ToUpper(string(m[2]))
+		value := strings.TrimSpace(html.UnescapeString(string(m[3])))
+
+		switch {
+		case tag == "STMTTRN" && !closing:
+			current = map[string]string{}
+		case tag == "STMTTRN" && current != nil:
+			entry, err := ofxEntry(current, len(entries)+1)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+			current = nil
+		case closing || value == "":
+			continue
+		case tag == "CURDEF":
+			currency = value
+		case current != nil:
+			current[tag] = value
+		}
+	}
+
+	for i := range entries {
+		entries[i].Currency = currency
+	}
+
+	return entries, nil
+}
+
+func ofxEntry(fields map[string]string, n int) (StatementEntry, error) {
+	postedAt, err := parseOfxDate(fields["DTPOSTED"])
+	if err != nil {
+		return StatementEntry{}, fmt.Errorf("%w: transaction #%v: %v", ErrInvalidImport, n, err)
+	}
+
+	description := fields["NAME"]
+	if memo := fields["MEMO"]; memo != "" && memo != description {
+		description = strings.TrimSpace(description + " " + memo)
+	}
+
+	amount := fields["TRNAMT"]
+	if !strings.Contains(amount, ".") {
+		amount = strings.Replace(amount, ",", ".", 1)
+	}
+
+	return StatementEntry{
+		ExternalId:  fields["FITID"],
+		PostedAt:    postedAt,
+		Amount:      amount,
+		Description: description,
+	}, nil
+}
+
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+// camtStatus is plain text in camt.053.001.02 and a code element in later versions
+type camtStatus struct {
+	Text string `xml:",chardata"`
+	Code string `xml:"Cd"`
+}
+
+type camtEntry struct {
+	Reference         string                   `xml:"NtryRef"`
+	Amount            camtAmount               `xml:"Amt"`
+	CreditDebit       string                   `xml:"CdtDbtInd"`
+	Status            camtStatus               `xml:"Sts"`
+	BookingDate       string                   `xml:"BookgDt>Dt"`
+	BookingDateTime   string                   `xml:"BookgDt>DtTm"`
+	ServicerReference string                   `xml:"AcctSvcrRef"`
+	AdditionalInfo    string                   `xml:"AddtlNtryInf"`
+	Details           []camtTransactionDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camtTransactionDetails struct {
+	ServicerReference string   `xml:"Refs>AcctSvcrRef"`
+	Creditor          string   `xml:"RltdPties>Cdtr>Nm"`
+	CreditorParty     string   `xml:"RltdPties>Cdtr>Pty>Nm"`
+	Debtor            string   `xml:"RltdPties>Dbtr>Nm"`
+	DebtorParty       string   `xml:"RltdPties>Dbtr>Pty>Nm"`
+	Remittance        []string `xml:"RmtInf>Ustrd"`
+}
+
+func parseCamtDate(e camtEntry) (time.Time, error) {
+	if e.BookingDate != "" {
+		return time.Parse(time.DateOnly, strings.TrimSpace(e.BookingDate))
+	}
+
+	s := strings.TrimSpace(e.BookingDateTime)
+	if parsed, err := time.Parse(time.RFC3339, s); err == nil {
+		return parsed.UTC(), nil
+	}
+
+	return time.Parse("2006-01-02T15:04:05", s)
+}
+
+// description combines the counterparty with the remittance information
+func (e camtEntry) description() string {
+	var parts []string
+	info := e.AdditionalInfo
+
+	if len(e.Details) > 0 {
+		d := e.Details[0]
+
+		counterparty := firstNonEmpty(d.Creditor, d.CreditorParty)
+		if e.CreditDebit == "CRDT" {
+			counterparty = firstNonEmpty(d.Debtor, d.DebtorParty)
+		}
+		parts = append(parts, counterparty)
+
+		if len(d.Remittance) > 0 {
+			info = strings.Join(d.Remittance, " ")
+		}
+	}
+
+	parts = append(parts, info)
+
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+func (e camtEntry) externalId() string {
+	detailsReference := ""
+	if len(e.Details) > 0 {
+		detailsReference = e.Details[0].ServicerReference
+	}
+
+	return firstNonEmpty(e.ServicerReference, detailsReference, e.Reference)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// ParseCamt053Statement reads booked entries of ISO 20022 camt.053 statements, pending ones are skipped
+func ParseCamt053Statement(r io.Reader) ([]StatementEntry, error) {
+	var document camtDocument
+	if err := xml.NewDecoder(r).Decode(&document); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse camt.053: %v", ErrInvalidImport, err)
+	}
+
+	if len(document.Statements) == 0 {
+		return nil, fmt.Errorf("%w: no statements in camt.053", ErrInvalidImport)
+	}
+
+	var entries []StatementEntry
+
+	for _, statement := range document.Statements {
+		for _, e := range statement.Entries {
+			if status := firstNonEmpty(e.Status.Code, e.Status.Text); status != "" && status != "BOOK" {
+				continue
+			}
+
+			postedAt, err := parseCamtDate(e)
+			if err != nil {
+				return nil, fmt.Errorf("%w: entry #%v: invalid booking date: %v", ErrInvalidImport, len(entries)+1, err)
+			}
+
+			amount := strings.TrimSpace(e.Amount.Value)
+			if e.CreditDebit == "DBIT" {
+				amount = "-" + amount
+			}
+
+			entries = append(entries, StatementEntry{
+				ExternalId:  e.externalId(),
+				PostedAt:    postedAt,
+				Amount:      amount,
+				Currency:    e.Amount.Currency,
+				Description: e.description(),
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// StatementRows turns statement entries into import rows of the account
+func StatementRows(entries []StatementEntry, account Account, category Category) []ImportRow {
+	rows := make([]ImportRow, 0, len(entries))
+
+	for i, e := range entries {
+		row := ImportRow{
+			Line:        i + 1,
+			ExternalId:  e.ExternalId,
+			CreatedAt:   e.PostedAt,
+			Description: e.Description,
+			Category:    category,
+		}
+
+		amount, err := ParseCurrencyMoney(e.Amount, account.Currency)
+		if err != nil {
+			row.Error = err.Error()
+		}
+		row.Amount = amount
+
+		if e.Currency != "" && !strings.EqualFold(e.Currency, account.Currency) {
+			row.Error = fmt.Sprintf("currency %v doesn't match account currency %v", e.Currency, account.Currency)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// PreviewStatementImport converts the entries into rows, applies the rules and marks the duplicates without storing anything
+func PreviewStatementImport[T DatabaseInterface](
+	db T,
+	userId int64,
+	accountId int64,
+	categoryId int64,
+	entries []StatementEntry,
+) (Account, []ImportRow, error) {
+	account, err := GetAccountById(db, userId, accountId)
+	if err != nil {
+		return account, nil, fmt.Errorf("account %v of user %v: %w", accountId, userId, err)
+	}
+
+	category, err := GetCategoryById(db, userId, categoryId)
+	if err != nil {
+		return account, nil, fmt.Errorf("%w: default category %v not found", ErrInvalidImport, categoryId)
+	}
+
+	rows := StatementRows(entries, account, category)
+
+	if err := categorizeImportRows(db, userId, account, rows); err != nil {
+		return account, nil, err
+	}
+
+	if err := MarkDuplicates(db, userId, account.Id, rows); err != nil {
+		return account, nil, err
+	}
+
+	return account, rows, nil
+}
@@ -0,0 +1,249 @@
+package greed
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOfxSgml = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20260310120000.000[-5:EST]
+<TRNAMT>-42.10
+<FITID>2026031001
+<NAME>AT&amp;T
+<MEMO>Phone bill
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20260311
+<TRNAMT>1500,00
+<FITID>2026031102
+<NAME>Payroll
+<MEMO>Payroll
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const testOfxXml = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220"?>
+<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+<CURDEF>EUR</CURDEF>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>202603121830</DTPOSTED><TRNAMT>-9.99</TRNAMT><FITID>cc-1</FITID><NAME>Streaming</NAME></STMTTRN>
+</BANKTRANLIST>
+</CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>
+`
+
+const testCamt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.08">
+<BkToCstmrStmt><Stmt>
+<Ntry>
+  <NtryRef>ref-1</NtryRef>
+  <Amt Ccy="EUR">850.00</Amt>
+  <CdtDbtInd>DBIT</CdtDbtInd>
+  <Sts><Cd>BOOK</Cd></Sts>
+  <BookgDt><Dt>2026-03-01</Dt></BookgDt>
+  <AcctSvcrRef>svc-1</AcctSvcrRef>
+  <NtryDtls><TxDtls>
+    <RltdPties><Cdtr><Pty><Nm>Landlord  Ltd</Nm></Pty></Cdtr></RltdPties>
+    <RmtInf><Ustrd>Rent</Ustrd><Ustrd>March</Ustrd></RmtInf>
+  </TxDtls></NtryDtls>
+</Ntry>
+<Ntry>
+  <Amt Ccy="EUR">2000.00</Amt>
+  <CdtDbtInd>CRDT</CdtDbtInd>
+  <Sts>BOOK</Sts>
+  <BookgDt><DtTm>2026-03-02T08:15:00+01:00</DtTm></BookgDt>
+  <AddtlNtryInf>Salary</AddtlNtryInf>
+  <NtryDtls><TxDtls>
+    <Refs><AcctSvcrRef>svc-2</AcctSvcrRef></Refs>
+    <RltdPties><Dbtr><Nm>Employer</Nm></Dbtr><Cdtr><Nm>Me</Nm></Cdtr></RltdPties>
+  </TxDtls></NtryDtls>
+</Ntry>
+<Ntry>
+  <Amt Ccy="EUR">5.00</Amt>
+  <CdtDbtInd>DBIT</CdtDbtInd>
+  <Sts><Cd>PDNG</Cd></Sts>
+  <BookgDt><Dt>2026-03-03</Dt></BookgDt>
+</Ntry>
+</Stmt></BkToCstmrStmt>
+</Document>
+`
+
+func TestDetectStatementFormat(t *testing.T) {
+	cases := []struct {
+		data   string
+		format StatementFormat
+	}{
+		{testOfxSgml, StatementOfx},
+		{testOfxXml, StatementOfx},
+		{testCamt053, StatementCamt053},
+		{"date,amount,description\n2026-03-10,-1.00,ofx\n", StatementCsv},
+	}
+
+	for _, c := range cases {
+		if format := DetectStatementFormat([]byte(c.data)); format != c.format {
+			t.Errorf("format of %.30q = %v, want %v", c.data, format, c.format)
+		}
+	}
+}
+
+func TestParseOfxStatement(t *testing.T) {
+	entries, err := ParseStatement(StatementOfx, strings.NewReader(testOfxSgml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []StatementEntry{
+		{"2026031001", time.Date(2026, 3, 10, 17, 0, 0, 0, time.UTC), "-42.10", "USD", "AT&T Phone bill"},
+		{"2026031102", time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC), "1500.00", "USD", "Payroll"},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("parsed %v entries, want %v", len(entries), len(want))
+	}
+	for i, e := range entries {
+		if e != want[i] {
+			t.Errorf("entry %v = %+v, want %+v", i, e, want[i])
+		}
+	}
+
+	entries, err = ParseOfxStatement(strings.NewReader(testOfxXml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	card := StatementEntry{"cc-1", time.Date(2026, 3, 12, 18, 30, 0, 0, time.UTC), "-9.99", "EUR", "Streaming"}
+	if len(entries) != 1 || entries[0] != card {
+		t.Errorf("credit card entries = %+v, want %+v", entries, card)
+	}
+
+	invalid := []string{
+		"date,amount\n",
+		"<OFX><STMTTRN><DTPOSTED>2026-03-10<TRNAMT>1</STMTTRN></OFX>",
+		"<OFX><STMTTRN><DTPOSTED>20260310[x:EST]<TRNAMT>1</STMTTRN></OFX>",
+	}
+
+	for _, data := range invalid {
+		if _, err := ParseOfxStatement(strings.NewReader(data)); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("ofx %q = %v, want %v", data, err, ErrInvalidImport)
+		}
+	}
+}
+
+func TestParseCamt053Statement(t *testing.T) {
+	entries, err := ParseStatement(StatementCamt053, strings.NewReader(testCamt053))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending entry is skipped
+	want := []StatementEntry{
+		{"svc-1", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), "-850.00", "EUR", "Landlord Ltd Rent March"},
+		{"svc-2", time.Date(2026, 3, 2, 7, 15, 0, 0, time.UTC), "2000.00", "EUR", "Employer Salary"},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("parsed %v entries, want %v", len(entries), len(want))
+	}
+	for i, e := range entries {
+		if e != want[i] {
+			t.Errorf("entry %v = %+v, want %+v", i, e, want[i])
+		}
+	}
+
+	invalid := []string{
+		"<Document><BkToCstmrStmt>",
+		"<Document></Document>",
+		"<Document><BkToCstmrStmt><Stmt><Ntry><Amt Ccy=\"EUR\">1</Amt><BookgDt><Dt>01.03.2026</Dt></BookgDt></Ntry></Stmt></BkToCstmrStmt></Document>",
+	}
+
+	for _, data := range invalid {
+		if _, err := ParseCamt053Statement(strings.NewReader(data)); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("camt.053 %q = %v, want %v", data, err, ErrInvalidImport)
+		}
+	}
+
+	if _, err := ParseStatement(StatementCsv, strings.NewReader(testCamt053)); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("csv parsed as a statement file = %v, want %v", err, ErrInvalidImport)
+	}
+}
+
+func TestStatementRows(t *testing.T) {
+	account := Account{Id: 1, Currency: "EUR"}
+	category := Category{Id: 1, Name: "🍖 Food and drinks"}
+
+	rows := StatementRows([]StatementEntry{
+		{"a", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), "-12.50", "eur", "Lunch"},
+		{"b", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), "-12.50", "USD", "Lunch abroad"},
+		{"c", time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC), "-0.001", "", "Rounding"},
+	}, account, category)
+
+	if len(rows) != 3 {
+		t.Fatalf("%v rows, want 3", len(rows))
+	}
+
+	if rows[0].Line != 1 || rows[0].ExternalId != "a" || rows[0].Amount.String() != "-12.50" || rows[0].Category.Id != 1 || rows[0].Error != "" {
+		t.Errorf("first row = %+v", rows[0])
+	}
+
+	if rows[1].Error == "" {
+		t.Errorf("entry in another currency is valid")
+	}
+
+	if rows[2].Error == "" {
+		t.Errorf("inexact amount is valid")
+	}
+}
+
+func TestPreviewStatementImport(t *testing.T) {
+	db, user := newTestDb(t)
+
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	account := testAccount(t, db, user.Id, "USD", "0", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	entries, err := ParseOfxStatement(strings.NewReader(testOfxSgml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, rows, err := PreviewStatementImport(db, user.Id, account.Id, food.Id, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imported, err := ImportTransactions(db, user.Id, account.Id, rows); err != nil || imported != 2 {
+		t.Fatalf("imported %v entries, %v, want 2", imported, err)
+	}
+
+	// the bank ids make the re-import of the same file a no-op
+	_, rows, err = PreviewStatementImport(db, user.Id, account.Id, food.Id, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if !row.Duplicate {
+			t.Errorf("entry %v isn't a duplicate after the import", row.ExternalId)
+		}
+	}
+
+	if imported, err := ImportTransactions(db, user.Id, account.Id, rows); err != nil || imported != 0 {
+		t.Errorf("re-imported %v entries, %v, want 0", imported, err)
+	}
+
+	if _, _, err := PreviewStatementImport(db, user.Id, account.Id, 0, entries); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("preview without a default category = %v, want %v", err, ErrInvalidImport)
+	}
+}
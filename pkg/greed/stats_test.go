@@ -0,0 +1,67 @@
+package greed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpensesStats(t *testing.T) {
+	db, user := newTestDb(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	account := testAccount(t, db, user.Id, "USD", "1000", now.AddDate(0, -1, 0))
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	health := testCategory(t, db, user.Id, "💆 Beauty and Health")
+	finance := testCategory(t, db, user.Id, "💰 Finance")
+
+	trip := testTransaction(t, db, user.Id, account, "-120.40", food, now.Add(-3*time.Hour), "dinner")
+	testTransaction(t, db, user.Id, account, "-200", food, now.Add(-2*time.Hour), "groceries")
+	testTransaction(t, db, user.Id, account, "-100", health, now.Add(-1*time.Hour), "pharmacy")
+	testTransaction(t, db, user.Id, account, "2500", finance, now.Add(-4*time.Hour), "salary")
+
+	if _, err := SetTransactionTags(db, user.Id, trip.Id, []string{"trip"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dateRange := DateRange{now.AddDate(0, 0, -1), now.AddDate(0, 0, 1)}
+
+	categoriesSpent, err := GetExpensesByCategory(db, user.Id, dateRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spent := map[string]string{}
+	for _, pair := range categoriesSpent {
+		for _, cs := range pair.Second {
+			spent[pair.First+" "+cs.Category.Name] = cs.Value.Amount.String()
+		}
+	}
+
+	want := map[string]string{"USD " + food.Name: "320.40", "USD " + health.Name: "100.00"}
+	if len(spent) != len(want) {
+		t.Errorf("categories spent = %v, want %v", spent, want)
+	}
+	for key, amount := range want {
+		if spent[key] != amount {
+			t.Errorf("spent on %v = %v, want %v", key, spent[key], amount)
+		}
+	}
+
+	tagsSpent, err := GetExpensesByTag(db, user.Id, dateRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tagsSpent) != 1 || len(tagsSpent[0].Second) != 1 || tagsSpent[0].Second[0].Value.Amount.String() != "120.40" {
+		t.Errorf("tags spent = %+v, want trip 120.40 USD", tagsSpent)
+	}
+
+	cashFlow, err := GetCashFlow(db, user.Id, dateRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cashFlow) != 1 || cashFlow[0].Value.Amount.String() != "2079.60" || !cashFlow[0].Positive {
+		t.Errorf("cash flow = %+v, want +2079.60 USD", cashFlow)
+	}
+}
@@ -0,0 +1,227 @@
+package greed
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type SuggestionSource string
+
+const (
+	SuggestedByRule    SuggestionSource = "rule"
+	SuggestedByHistory SuggestionSource = "history"
+)
+
+// CategorySuggestion is a likely category of a new transaction
+type CategorySuggestion struct {
+	Category Category `json:"category"`
+	// probability of the category between 0 and 1, 1 for categories set by a rule
+	Confidence float64          `json:"confidence"`
+	Source     SuggestionSource `json:"source"`
+}
+
+// descriptionTokens splits the description into lowercase words, numbers like dates and card digits are dropped
+func descriptionTokens(description string) []string {
+	var tokens []string
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) < 2 || strings.IndexFunc(word, unicode.IsLetter) < 0 {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+
+	return tokens
+}
+
+// transactionFeatures describes the transaction for the category model: the words of the description,
+// the account and the sign and number of digits of the amount
+func transactionFeatures(t Transaction) []string {
+	var features []string
+
+	for _, token := range descriptionTokens(t.Description) {
+		features = append(features, "word:"+token)
+	}
+
+	if t.Account.Id != 0 {
+		features = append(features, fmt.Sprintf("account:%v", t.Account.Id))
+	}
+
+	if !t.Amount.IsZero() {
+		digits := len(t.Amount.Abs().String()) - int(t.Amount.Exponent)
+		if t.Amount.Exponent > 0 {
+			// the decimal point
+			digits--
+		}
+		features = append(features, fmt.Sprintf("amount:%v:%v", t.Amount.Sign(), digits))
+	}
+
+	return features
+}
+
+// categoryModel is a naive Bayes classifier of the category by the transaction features
+type categoryModel struct {
+	// transactions per category
+	documents map[int64]int
+	// occurrences of the features per category
+	features map[int64]map[string]int
+	// occurrences of all the features per category
+	totals     map[int64]int
+	vocabulary map[string]bool
+	count      int
+}
+
+func newCategoryModel() *categoryModel {
+	return &categoryModel{
+		documents:  map[int64]int{},
+		features:   map[int64]map[string]int{},
+		totals:     map[int64]int{},
+		vocabulary: map[string]bool{},
+	}
+}
+
+func (m *categoryModel) add(categoryId int64, features []string) {
+	m.count++
+	m.documents[categoryId]++
+
+	if m.features[categoryId] == nil {
+		m.features[categoryId] = map[string]int{}
+	}
+
+	for _, f := range features {
+		m.features[categoryId][f]++
+		m.totals[categoryId]++
+		m.vocabulary[f] = true
+	}
+}
+
+// predict returns the probability of each category given the features,
+// nil when none of the features was seen in the history
+func (m *categoryModel) predict(features []string) map[int64]float64 {
+	known := false
+	for _, f := range features {
+		known = known || m.vocabulary[f]
+	}
+	if !known {
+		return nil
+	}
+
+	// log probabilities with add-one smoothing
+	scores := map[int64]float64{}
+	best := math.Inf(-1)
+	for categoryId, documents := range m.documents {
+		score := math.Log(float64(documents) / float64(m.count))
+		for _, f := range features {
+			score += math.Log(float64(m.features[categoryId][f]+1) / float64(m.totals[categoryId]+len(m.vocabulary)))
+		}
+		scores[categoryId] = score
+		best = math.Max(best, score)
+	}
+
+	sum := 0.0
+	for categoryId, score := range scores {
+		scores[categoryId] = math.Exp(score - best)
+		sum += scores[categoryId]
+	}
+	for categoryId := range scores {
+		scores[categoryId] /= sum
+	}
+
+	return scores
+}
+
+// trainCategoryModel learns the categories of the user's transactions, transfer legs are left out
+func trainCategoryModel[T DatabaseInterface](db T, userId int64) (*categoryModel, error) {
+	model := newCategoryModel()
+
+	rows, err := db.Query(
+		`
+		select transactions.account_id, accounts.currency, transactions.amount, transactions.category_id, transactions.description
+		from transactions
+		join accounts on accounts.id = transactions.account_id
+		where transactions.user_id = ? and transactions.transfer_id is null and transactions.category_id is not null
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch category history failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Transaction
+		var amount int64
+
+		if err := rows.Scan(&t.Account.Id, &t.Account.Currency, &amount, &t.Category.Id, &t.Description); err != nil {
+			return nil, fmt.Errorf("fetch category history row failed: %v", err)
+		}
+
+		t.Amount = NewMoney(amount, CurrencyExponent(t.Account.Currency))
+		model.add(t.Category.Id, transactionFeatures(t))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during category history iteration: %v", err)
+	}
+
+	return model, nil
+}
+
+// SuggestCategories lists up to limit likely categories of a new transaction, most likely first:
+// the category set by the rules if any, then the categories of similar past transactions
+// by description, account and amount, archived categories aren't suggested.
+// The model is trained from the history on every call
+func SuggestCategories[T DatabaseInterface](db T, userId int64, t Transaction, limit int) ([]CategorySuggestion, error) {
+	suggestions := []CategorySuggestion{}
+
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	active := map[int64]Category{}
+	for _, c := range ActiveCategories(categories) {
+		active[c.Id] = c
+	}
+
+	t.Category = Category{}
+	categorized, err := CategorizeTransaction(db, userId, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := active[categorized.Category.Id]; ok {
+		suggestions = append(suggestions, CategorySuggestion{Category: c, Confidence: 1, Source: SuggestedByRule})
+	}
+
+	model, err := trainCategoryModel(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []CategorySuggestion
+	for categoryId, p := range model.predict(transactionFeatures(t)) {
+		if c, ok := active[categoryId]; ok && categoryId != categorized.Category.Id {
+			history = append(history, CategorySuggestion{Category: c, Confidence: p, Source: SuggestedByHistory})
+		}
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].Confidence != history[j].Confidence {
+			return history[i].Confidence > history[j].Confidence
+		}
+		return history[i].Category.Id < history[j].Category.Id
+	})
+
+	suggestions = append(suggestions, history...)
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
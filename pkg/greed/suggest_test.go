@@ -0,0 +1,111 @@
+package greed
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDescriptionTokens(t *testing.T) {
+	tokens := descriptionTokens("CARD 1234 Coffee-Shop 03/12 a Café")
+
+	want := []string{"card", "coffee", "shop", "café"}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens = %q, want %q", tokens, want)
+	}
+}
+
+func TestSuggestCategories(t *testing.T) {
+	db, user := newTestDb(t)
+
+	createdAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	food := testCategory(t, db, user.Id, "🍖 Food and drinks")
+	beauty := testCategory(t, db, user.Id, "💆 Beauty and Health")
+	fun := testCategory(t, db, user.Id, "🎮 Fun")
+	account := testAccount(t, db, user.Id, "USD", "1000", createdAt.AddDate(0, 0, -1))
+
+	testTransaction(t, db, user.Id, account, "-4.50", food, createdAt, "Coffee shop")
+	testTransaction(t, db, user.Id, account, "-5", food, createdAt, "Coffee shop")
+	testTransaction(t, db, user.Id, account, "-12", food, createdAt, "Lunch")
+	testTransaction(t, db, user.Id, account, "-20", beauty, createdAt, "Pharmacy")
+	testTransaction(t, db, user.Id, account, "-60", fun, createdAt, "Coffee shop cup")
+
+	categories := func(suggestions []CategorySuggestion) []int64 {
+		ids := []int64{}
+		for _, s := range suggestions {
+			ids = append(ids, s.Category.Id)
+		}
+		return ids
+	}
+
+	coffee := Transaction{Account: account, Amount: mustParseMoney(t, "-4", "USD"), Description: "COFFEE SHOP 0312"}
+
+	suggestions, err := SuggestCategories(db, user.Id, coffee, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) == 0 || suggestions[0].Category.Id != food.Id || suggestions[0].Source != SuggestedByHistory {
+		t.Fatalf("suggestions for coffee = %+v, want food first", suggestions)
+	}
+	// the most likely first and all of them add up to one
+	sum := 0.0
+	for i, s := range suggestions {
+		if i > 0 && s.Confidence > suggestions[i-1].Confidence {
+			t.Errorf("suggestions aren't ranked by confidence: %+v", suggestions)
+		}
+		sum += s.Confidence
+	}
+	if sum < 0.999 || sum > 1.001 {
+		t.Errorf("confidences add up to %v, want 1", sum)
+	}
+
+	suggestions, err = SuggestCategories(db, user.Id, coffee, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := categories(suggestions); !reflect.DeepEqual(ids, []int64{food.Id}) {
+		t.Errorf("limited suggestions = %v, want %v", ids, []int64{food.Id})
+	}
+
+	// nothing in common with the history
+	suggestions, err = SuggestCategories(db, user.Id, Transaction{Description: "zzz"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 0 {
+		t.Errorf("suggestions for an unknown description = %+v", suggestions)
+	}
+
+	// a matching rule goes first and its category isn't repeated from the history
+	if _, err := CreateRule(db, user.Id, Rule{Name: "Cups", DescriptionContains: "coffee", Category: &fun}); err != nil {
+		t.Fatal(err)
+	}
+
+	suggestions, err = SuggestCategories(db, user.Id, coffee, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) < 2 || suggestions[0].Category.Id != fun.Id || suggestions[0].Source != SuggestedByRule || suggestions[0].Confidence != 1 {
+		t.Fatalf("suggestions with a rule = %+v, want fun by the rule first", suggestions)
+	}
+	for _, s := range suggestions[1:] {
+		if s.Category.Id == fun.Id {
+			t.Errorf("category of the rule is suggested twice: %+v", suggestions)
+		}
+	}
+
+	// archived categories aren't suggested
+	if archived, err := DeleteCategory(db, user.Id, beauty.Id); err != nil || !archived {
+		t.Fatalf("archive = %v, %v", archived, err)
+	}
+
+	suggestions, err = SuggestCategories(db, user.Id, Transaction{Account: account, Description: "Pharmacy"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range suggestions {
+		if s.Category.Id == beauty.Id {
+			t.Errorf("archived category is suggested: %+v", suggestions)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package greed
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrInvalidTag = errors.New("invalid tag")
+
+// longest tag name in runes
+const MaxTagLength = 64
+
+type Tag struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type TagSpent struct {
+	Tag   Tag            `json:"tag"`
+	Value CurrencyAmount `json:"value"`
+}
+
+// NormalizeTagName lowercases the name and joins its words with dashes
+func NormalizeTagName(name string) (string, error) {
+	normalized := strings.ToLower(strings.Join(strings.Fields(name), "-"))
+	normalized = strings.TrimPrefix(normalized, "#")
+
+	if normalized == "" {
+		return "", fmt.Errorf("%w: empty name", ErrInvalidTag)
+	}
+
+	if len([]rune(normalized)) > MaxTagLength {
+		return "", fmt.Errorf("%w: %q is longer than %v characters", ErrInvalidTag, normalized, MaxTagLength)
+	}
+
+	if strings.IndexFunc(normalized, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) >= 0 {
+		return "", fmt.Errorf("%w: %q can't contain commas or control characters", ErrInvalidTag, normalized)
+	}
+
+	return normalized, nil
+}
+
+// ParseTagNames reads a comma separated list of tags, empty items are dropped
+func ParseTagNames(list string) ([]string, error) {
+	var names []string
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) != "" {
+			names = append(names, item)
+		}
+	}
+	return NormalizeTagNames(names)
+}
+
+// NormalizeTagNames normalizes every name and drops the repeats
+func NormalizeTagNames(names []string) ([]string, error) {
+	var result []string
+	seen := map[string]bool{}
+
+	for _, name := range names {
+		name, err := NormalizeTagName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+
+	return result, nil
+}
+
+// TagNames joins the tag names back into a comma separated list
+func TagNames(tags []Tag) string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func GetTags[T DatabaseInterface](db T, userId int64) ([]Tag, error) {
+	tags := []Tag{}
+
+	rows, err := db.Query("select id, name from tags where user_id = ? order by name asc", userId)
+	if err != nil {
+		return nil, fmt.Errorf("fetch tags failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Id, &t.Name); err != nil {
+			return nil, fmt.Errorf("fetch tags row failed: %v", err)
+		}
+		tags = append(tags, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during tags iteration: %v", err)
+	}
+
+	return tags, nil
+}
+
+// getTransactionsTags returns the tags of the transactions by transaction id
+func getTransactionsTags[T DatabaseInterface](db T, userId int64, transactionIds []int64) (map[int64][]Tag, error) {
+	result := map[int64][]Tag{}
+	if len(transactionIds) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sq.
+		Select("transaction_tags.transaction_id", "tags.id", "tags.name").
+		From("transaction_tags").
+		Join("tags on tags.id = transaction_tags.tag_id").
+		Where(sq.Eq{"tags.user_id": userId, "transaction_tags.transaction_id": transactionIds}).
+		OrderBy("tags.name asc").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("fetch transactions tags failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionId int64
+		var t Tag
+		if err := rows.Scan(&transactionId, &t.Id, &t.Name); err != nil {
+			return nil, fmt.Errorf("fetch transactions tags row failed: %v", err)
+		}
+		result[transactionId] = append(result[transactionId], t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during transactions tags iteration: %v", err)
+	}
+
+	return result, nil
+}
+
+// fillTransactionsTags sets Tags of the transactions, empty instead of nil for untagged ones
+func fillTransactionsTags[T DatabaseInterface](db T, userId int64, transactions []Transaction) error {
+	ids := make([]int64, 0, len(transactions))
+	for _, t := range transactions {
+		ids = append(ids, t.Id)
+	}
+
+	tags, err := getTransactionsTags(db, userId, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range transactions {
+		transactions[i].Tags = tags[transactions[i].Id]
+		if transactions[i].Tags == nil {
+			transactions[i].Tags = []Tag{}
+		}
+	}
+
+	return nil
+}
+
+// deleteUnusedTags drops the tags of the user no transaction is labeled with anymore
+func deleteUnusedTags[T DatabaseInterface](db T, userId int64) error {
+	if _, err := db.Exec(
+		"delete from tags where user_id = ? and id not in (select tag_id from transaction_tags)",
+		userId,
+	); err != nil {
+		return fmt.Errorf("failed to delete unused tags of user %v: %v", userId, err)
+	}
+	return nil
+}
+
+// SetTransactionTags replaces the tags of the transaction, tags are created on first use
+// and dropped once no transaction has them, tags of reconciled transactions are locked
+func SetTransactionTags[T DatabaseInterface](db T, userId int64, transactionId int64, names []string) ([]Tag, error) {
+	transaction, err := GetTransactionById(db, userId, transactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if transaction.ReconciliationId != 0 {
+		return nil, fmt.Errorf("%w: transaction %v", ErrReconciled, transaction.Id)
+	}
+
+	if _, err := db.Exec("delete from transaction_tags where transaction_id = ?", transactionId); err != nil {
+		return nil, fmt.Errorf("failed to clear tags of transaction %v: %v", transactionId, err)
+	}
+
+	tags := []Tag{}
+
+	for _, name := range names {
+		name, err := NormalizeTagName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := db.Exec("insert or ignore into tags (user_id, name) values (?, ?)", userId, name); err != nil {
+			return nil, fmt.Errorf("failed to create tag %q: %v", name, err)
+		}
+
+		t := Tag{Name: name}
+		if err := db.QueryRow("select id from tags where user_id = ? and name = ?", userId, name).Scan(&t.Id); err != nil {
+			return nil, fmt.Errorf("fetch tag %q failed: %v", name, err)
+		}
+
+		if _, err := db.Exec(
+			"insert or ignore into transaction_tags (transaction_id, tag_id) values (?, ?)",
+			transactionId, t.Id,
+		); err != nil {
+			return nil, fmt.Errorf("failed to tag transaction %v with %q: %v", transactionId, name, err)
+		}
+
+		tags = append(tags, t)
+	}
+
+	if err := deleteUnusedTags(db, userId); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// taggedWithAll restricts a transactions query to the ones labeled with every tag
+func taggedWithAll(userId int64, names []string) sq.Sqlizer {
+	return sq.Expr(
+		`transactions.id in (
+			select transaction_tags.transaction_id from transaction_tags
+			join tags on tags.id = transaction_tags.tag_id
+			where tags.user_id = ? and tags.name in (`+sq.Placeholders(len(names))+`)
+			group by transaction_tags.transaction_id
+			having count(distinct tags.id) = ?
+		)`,
+		append(append([]any{userId}, toAnySlice(names)...), len(names))...,
+	)
+}
+
+func toAnySlice[T any](values []T) []any {
+	result := make([]any, 0, len(values))
+	for _, v := range values {
+		result = append(result, v)
+	}
+	return result
+}
+
+// GetExpensesByTag sums expenses by tag grouped by currency like GetExpensesByCategory,
+// a transaction with several tags counts towards each of them
+func GetExpensesByTag[T DatabaseInterface](db T, userId int64, dateRange DateRange) ([]Pair[string, []TagSpent], error) {
+	var result []Pair[string, []TagSpent]
+
+	query := sq.
+		Select(
+			"tags.id",
+			"tags.name",
+			"sum(abs(transactions.amount)) as total_amount",
+			"accounts.currency as currency",
+		).
+		From("transactions").
+		Join("transaction_tags on transaction_tags.transaction_id = transactions.id").
+		Join("tags on tags.id = transaction_tags.tag_id").
+		Join("accounts on accounts.id = transactions.account_id").
+		Where(sq.Eq{"transactions.user_id": userId}).
+		Where(sq.Lt{"transactions.amount": 0}).
+		Where(sq.Eq{"transactions.transfer_id": nil})
+
+	if !dateRange.DateStart.IsZero() {
+		query = query.Where(sq.GtOrEq{"datetime(transactions.created_at)": dateRange.DateStart.UTC()})
+	}
+
+	if !dateRange.DateEnd.IsZero() {
+		// DateRange.DateEnd is exclusive
+		query = query.Where(sq.Lt{"datetime(transactions.created_at)": dateRange.DateEnd.UTC()})
+	}
+
+	query = query.
+		GroupBy("tags.id", "currency").
+		OrderBy("currency asc", "total_amount desc")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("fetch tags total spent failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ts TagSpent
+		var amount int64
+
+		if err := rows.Scan(&ts.Tag.Id, &ts.Tag.Name, &amount, &ts.Value.Currency); err != nil {
+			return nil, fmt.Errorf("fetch tags spent row failed: %v", err)
+		}
+
+		ts.Value.Amount = NewMoney(amount, CurrencyExponent(ts.Value.Currency))
+
+		if len(result) == 0 || result[len(result)-1].First != ts.Value.Currency {
+			result = append(result, Pair[string, []TagSpent]{First: ts.Value.Currency})
+		}
+		last := &result[len(result)-1]
+		last.Second = append(last.Second, ts)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during tags spent iteration: %v", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,359 @@
+package greed
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidTransfer = errors.New("invalid transfer")
+
+// name of the seeded category used for transfers by default
+const ExchangeCategoryName = "💱 Exchange"
+
+// number of fractional digits of the realised exchange rate
+const TransferRatePrecision = 6
+
+// Transfer moves money between two accounts of the same user,
+// stored as a debit transaction on FromAccount and a credit transaction on ToAccount
+type Transfer struct {
+	Id          int64   `json:"id"`
+	FromAccount Account `json:"from_account"`
+	ToAccount   Account `json:"to_account"`
+	// positive amount debited from FromAccount
+	FromAmount Money `json:"from_amount"`
+	// positive amount credited to ToAccount
+	ToAmount          Money     `json:"to_amount"`
+	Category          Category  `json:"category"`
+	CreatedAt         time.Time `json:"created_at"`
+	Description       string    `json:"description"`
+	FromTransactionId int64     `json:"from_transaction_id"`
+	ToTransactionId   int64     `json:"to_transaction_id"`
+}
+
+// Rate is the realised exchange rate: units of ToAccount currency per unit of FromAccount currency
+func (t Transfer) Rate() string {
+	return t.ToAmount.Ratio(t.FromAmount, TransferRatePrecision)
+}
+
+func (t Transfer) MarshalJSON() ([]byte, error) {
+	type transfer Transfer
+	return json.Marshal(struct {
+		transfer
+		Rate string `json:"rate"`
+	}{transfer(t), t.Rate()})
+}
+
+func (t *Transfer) ToJson() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (t *Transfer) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, t)
+}
+
+// validate checks the accounts and rescales the amounts to their currencies
+func (t *Transfer) validate() error {
+	if t.FromAccount.Id == t.ToAccount.Id {
+		return fmt.Errorf("%w: source and destination accounts are the same", ErrInvalidTransfer)
+	}
+
+	fromAmount, err := t.FromAmount.Rescale(CurrencyExponent(t.FromAccount.Currency))
+	if err != nil {
+		return fmt.Errorf("invalid source amount of transfer: %w", err)
+	}
+
+	toAmount, err := t.ToAmount.Rescale(CurrencyExponent(t.ToAccount.Currency))
+	if err != nil {
+		return fmt.Errorf("invalid destination amount of transfer: %w", err)
+	}
+
+	if fromAmount.Sign() <= 0 || toAmount.Sign() <= 0 {
+		return fmt.Errorf("%w: amounts must be positive", ErrInvalidTransfer)
+	}
+
+	if t.FromAccount.Currency == t.ToAccount.Currency && fromAmount.Cmp(toAmount) != 0 {
+		return fmt.Errorf("%w: amounts must be equal for accounts in the same currency", ErrInvalidTransfer)
+	}
+
+	t.FromAmount = fromAmount
+	t.ToAmount = toAmount
+	return nil
+}
+
+// GetTransferCategory returns the category used for transfers by default,
+// the exchange category if the user has one, otherwise the first category
+func GetTransferCategory[T DatabaseInterface](db T, userId int64) (Category, error) {
+	var c Category
+
+	row := db.QueryRow(
+		"select id, name from categories where user_id = ? order by name = ? desc, id asc limit 1",
+		userId, ExchangeCategoryName,
+	)
+	if err := row.Scan(&c.Id, &c.Name); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+const transferSelect = `
+	select
+		transfers.id,
+		debit.id, debit.amount,
+		from_accounts.id, from_accounts.name, from_accounts.currency,
+		credit.id, credit.amount,
+		to_accounts.id, to_accounts.name, to_accounts.currency,
+		categories.id, categories.name,
+		debit.created_at,
+		debit.description
+	from transfers
+	join transactions debit on debit.transfer_id = transfers.id and debit.amount < 0
+	join transactions credit on credit.transfer_id = transfers.id and credit.amount > 0
+	join accounts from_accounts on from_accounts.id = debit.account_id
+	join accounts to_accounts on to_accounts.id = credit.account_id
+	left join categories on categories.id = debit.category_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTransfer(row rowScanner) (Transfer, error) {
+	var t Transfer
+	var fromAmount, toAmount int64
+	var categoryId sql.NullInt64
+	var categoryName sql.NullString
+	var createdAt string
+
+	if err := row.Scan(
+		&t.Id,
+		&t.FromTransactionId, &fromAmount,
+		&t.FromAccount.Id, &t.FromAccount.Name, &t.FromAccount.Currency,
+		&t.ToTransactionId, &toAmount,
+		&t.ToAccount.Id, &t.ToAccount.Name, &t.ToAccount.Currency,
+		&categoryId, &categoryName,
+		&createdAt,
+		&t.Description,
+	); err != nil {
+		return t, err
+	}
+
+	// debit leg is stored negative
+	t.FromAmount = NewMoney(fromAmount, CurrencyExponent(t.FromAccount.Currency)).Neg()
+	t.ToAmount = NewMoney(toAmount, CurrencyExponent(t.ToAccount.Currency))
+
+	if categoryId.Valid {
+		t.Category = Category{Id: categoryId.Int64, Name: categoryName.String}
+	}
+
+	parsedCreatedAt, err := ParseDbDatetime(createdAt)
+	if err != nil {
+		return t, err
+	}
+
+	t.CreatedAt = parsedCreatedAt
+	return t, nil
+}
+
+func GetTransfers[T DatabaseInterface](db T, userId int64) ([]Transfer, error) {
+	var transfers []Transfer
+
+	rows, err := db.Query(
+		transferSelect+"where transfers.user_id = ? order by datetime(debit.created_at) desc",
+		userId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetch transfers failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("fetch transfers row failed: %v", err)
+		}
+		transfers = append(transfers, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during transfers iteration: %v", err)
+	}
+
+	return transfers, nil
+}
+
+func CountTransfers[T DatabaseInterface](db T, userId int64) (int64, error) {
+	var count int64
+
+	row := db.QueryRow("select count(*) from transfers where user_id = ?", userId)
+
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func GetTransferById[T DatabaseInterface](db T, userId int64, id int64) (Transfer, error) {
+	row := db.QueryRow(transferSelect+"where transfers.id = ? and transfers.user_id = ?", id, userId)
+
+	t, err := scanTransfer(row)
+	if err != nil {
+		return t, fmt.Errorf("fetch transfer %v failed: %w", id, err)
+	}
+
+	return t, nil
+}
+
+// CreateTransfer creates both legs of the transfer and updates the account balances atomically
+func CreateTransfer(db *sql.DB, userId int64, transfer Transfer) (Transfer, error) {
+	if err := transfer.validate(); err != nil {
+		return transfer, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return transfer, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("insert into transfers (user_id) values (?)", userId)
+	if err != nil {
+		return transfer, fmt.Errorf("failed to create transfer %v: %v", transfer, err)
+	}
+
+	transfer.Id, err = result.LastInsertId()
+	if err != nil {
+		return transfer, fmt.Errorf("failed to get last inserted transfer id %v: %v", transfer, err)
+	}
+
+	debit, err := CreateTransaction(tx, userId, transfer.FromAccount, transfer.FromAmount.Neg(), transfer.Category, transfer.CreatedAt, transfer.Description)
+	if err != nil {
+		return transfer, err
+	}
+
+	credit, err := CreateTransaction(tx, userId, transfer.ToAccount, transfer.ToAmount, transfer.Category, transfer.CreatedAt, transfer.Description)
+	if err != nil {
+		return transfer, err
+	}
+
+	// legs of transfers have no payee
+	if _, err := tx.Exec(
+		"update transactions set transfer_id = ?, payee_id = null where id in (?, ?) and user_id = ?",
+		transfer.Id, debit.Id, credit.Id, userId,
+	); err != nil {
+		return transfer, fmt.Errorf("failed to link transactions of transfer %v: %v", transfer.Id, err)
+	}
+
+	if err := refreshAccountBalances(tx, userId, transfer.FromAccount.Id, transfer.ToAccount.Id); err != nil {
+		return transfer, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return transfer, err
+	}
+
+	transfer.FromTransactionId = debit.Id
+	transfer.ToTransactionId = credit.Id
+
+	return transfer, nil
+}
+
+// UpdateTransfer updates both legs of the transfer and recalculates the affected account balances
+func UpdateTransfer(db *sql.DB, userId int64, transfer Transfer) (Transfer, error) {
+	if err := transfer.validate(); err != nil {
+		return transfer, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return transfer, err
+	}
+	defer tx.Rollback()
+
+	old, err := GetTransferById(tx, userId, transfer.Id)
+	if err != nil {
+		return transfer, err
+	}
+
+	if err := checkTransferUnreconciled(tx, userId, transfer.Id); err != nil {
+		return transfer, err
+	}
+
+	transfer.FromTransactionId = old.FromTransactionId
+	transfer.ToTransactionId = old.ToTransactionId
+
+	legs := []Transaction{
+		{Id: old.FromTransactionId, Account: transfer.FromAccount, Amount: transfer.FromAmount.Neg()},
+		{Id: old.ToTransactionId, Account: transfer.ToAccount, Amount: transfer.ToAmount},
+	}
+
+	for _, leg := range legs {
+		leg.Category = transfer.Category
+		leg.CreatedAt = transfer.CreatedAt
+		leg.Description = transfer.Description
+
+		if _, err := UpdateTransaction(tx, userId, leg); err != nil {
+			return transfer, err
+		}
+	}
+
+	// the legs may have moved to other accounts
+	if err := refreshAccountBalances(
+		tx, userId, old.FromAccount.Id, old.ToAccount.Id, transfer.FromAccount.Id, transfer.ToAccount.Id,
+	); err != nil {
+		return transfer, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return transfer, err
+	}
+
+	return transfer, nil
+}
+
+// DeleteTransfer deletes both legs of the transfer and reverts the account balances
+func DeleteTransfer(db *sql.DB, userId int64, transferId int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	transfer, err := GetTransferById(tx, userId, transferId)
+	if err != nil {
+		return err
+	}
+
+	if err := checkTransferUnreconciled(tx, userId, transferId); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"delete from transaction_tags where transaction_id in (select id from transactions where transfer_id = ? and user_id = ?)",
+		transferId, userId,
+	); err != nil {
+		return fmt.Errorf("failed to delete tags of transfer %v: %v", transferId, err)
+	}
+
+	if _, err := tx.Exec("delete from transactions where transfer_id = ? and user_id = ?", transferId, userId); err != nil {
+		return fmt.Errorf("failed to delete transactions of transfer %v: %v", transferId, err)
+	}
+
+	if _, err := tx.Exec("delete from transfers where id = ? and user_id = ?", transferId, userId); err != nil {
+		return fmt.Errorf("failed to delete transfer %v: %v", transferId, err)
+	}
+
+	if err := refreshAccountBalances(tx, userId, transfer.FromAccount.Id, transfer.ToAccount.Id); err != nil {
+		return err
+	}
+
+	if err := deleteUnusedTags(tx, userId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,108 @@
+package greed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransfers(t *testing.T) {
+	db, user := newTestDb(t)
+
+	category, err := GetTransferCategory(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checking := testAccount(t, db, user.Id, "USD", "1000", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	savings := testAccount(t, db, user.Id, "EUR", "0", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	cash, err := CreateAccount(db, user.Id, "Cash", NewMoney(0, 0), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), "USD", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balances := func(want map[int64]string) {
+		t.Helper()
+
+		for id, amount := range want {
+			account, err := GetAccountById(db, user.Id, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if account.Amount.String() != amount {
+				t.Errorf("balance of %v = %v, want %v", account.Name, account.Amount, amount)
+			}
+		}
+	}
+
+	invalid := []Transfer{
+		{FromAccount: checking, ToAccount: checking, FromAmount: NewMoney(1, 0), ToAmount: NewMoney(1, 0)},
+		{FromAccount: checking, ToAccount: cash, FromAmount: NewMoney(10, 0), ToAmount: NewMoney(9, 0)},
+		{FromAccount: checking, ToAccount: savings, FromAmount: NewMoney(-10, 0), ToAmount: NewMoney(9, 0)},
+	}
+
+	for _, transfer := range invalid {
+		transfer.Category = category
+		if _, err := CreateTransfer(db, user.Id, transfer); !errors.Is(err, ErrInvalidTransfer) {
+			t.Errorf("create %+v = %v, want %v", transfer, err, ErrInvalidTransfer)
+		}
+	}
+
+	transfer, err := CreateTransfer(db, user.Id, Transfer{
+		FromAccount: checking,
+		ToAccount:   savings,
+		FromAmount:  mustParseMoney(t, "100", "USD"),
+		ToAmount:    mustParseMoney(t, "92.5", "EUR"),
+		Category:    category,
+		CreatedAt:   time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Description: "savings",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Rate() != "0.925" {
+		t.Errorf("rate = %v, want 0.925", transfer.Rate())
+	}
+	balances(map[int64]string{checking.Id: "900.00", savings.Id: "92.50"})
+
+	stored, err := GetTransferById(db, user.Id, transfer.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.FromAccount.Id != checking.Id || stored.ToAccount.Id != savings.Id || stored.FromAmount.String() != "100.00" || stored.ToAmount.String() != "92.50" {
+		t.Errorf("stored transfer = %+v", stored)
+	}
+
+	// moving the credit leg to another account gives the amount back to the old one
+	transfer.ToAccount = cash
+	transfer.ToAmount = mustParseMoney(t, "100", "USD")
+	transfer.FromAmount = mustParseMoney(t, "100", "USD")
+	if _, err := UpdateTransfer(db, user.Id, transfer); err != nil {
+		t.Fatal(err)
+	}
+	balances(map[int64]string{checking.Id: "900.00", savings.Id: "0.00", cash.Id: "100.00"})
+
+	credit, err := GetTransactionById(db, user.Id, transfer.ToTransactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credit.Account.Id != cash.Id || credit.Amount.String() != "100.00" {
+		t.Errorf("credit leg after the update = %+v", credit)
+	}
+
+	transfer.FromAmount = mustParseMoney(t, "40", "USD")
+	transfer.ToAmount = mustParseMoney(t, "40", "USD")
+	if _, err := UpdateTransfer(db, user.Id, transfer); err != nil {
+		t.Fatal(err)
+	}
+	balances(map[int64]string{checking.Id: "960.00", cash.Id: "40.00"})
+
+	if err := DeleteTransfer(db, user.Id, transfer.Id); err != nil {
+		t.Fatal(err)
+	}
+	balances(map[int64]string{checking.Id: "1000.00", savings.Id: "0.00", cash.Id: "0.00"})
+
+	if _, err := GetTransactionById(db, user.Id, transfer.FromTransactionId); err == nil {
+		t.Errorf("debit leg of a deleted transfer still exists")
+	}
+}
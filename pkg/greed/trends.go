@@ -0,0 +1,473 @@
+package greed
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrInvalidTrend = errors.New("invalid trend")
+
+// TrendBucket is the length of the periods a trend sums the transactions by
+type TrendBucket string
+
+const (
+	BucketDay     TrendBucket = "day"
+	BucketWeek    TrendBucket = "week"
+	BucketMonth   TrendBucket = "month"
+	BucketQuarter TrendBucket = "quarter"
+	BucketYear    TrendBucket = "year"
+)
+
+var TrendBuckets = []TrendBucket{BucketDay, BucketWeek, BucketMonth, BucketQuarter, BucketYear}
+
+// longest series of a trend
+const MaxTrendBuckets = 400
+
+func ParseTrendBucket(x string) (TrendBucket, error) {
+	for _, b := range TrendBuckets {
+		if string(b) == x {
+			return b, nil
+		}
+	}
+	return "", fmt.Errorf("%w: unknown bucket %q", ErrInvalidTrend, x)
+}
+
+// Range returns the bucket containing at, weeks start on monday like budgets, DateEnd is exclusive
+func (b TrendBucket) Range(at time.Time) DateRange {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch b {
+	case BucketDay:
+		return DateRange{day, day.AddDate(0, 0, 1)}
+	case BucketWeek:
+		return BudgetWeekly.Range(at)
+	case BucketQuarter:
+		start := time.Date(day.Year(), day.Month()-(day.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+		return DateRange{start, start.AddDate(0, 3, 0)}
+	case BucketYear:
+		return BudgetYearly.Range(at)
+	default:
+		return BudgetMonthly.Range(at)
+	}
+}
+
+// Last returns the range of n buckets ending with the one containing at
+func (b TrendBucket) Last(n int, at time.Time) DateRange {
+	current := b.Range(at)
+	start := current.DateStart
+	for i := 1; i < n; i++ {
+		start = b.Range(start.Add(-time.Nanosecond)).DateStart
+	}
+	return DateRange{start, current.DateEnd}
+}
+
+// Split cuts the range into the buckets it overlaps, the first and the last one are cut to the range
+func (b TrendBucket) Split(dateRange DateRange) ([]DateRange, error) {
+	if dateRange.DateStart.IsZero() || dateRange.DateEnd.IsZero() {
+		return nil, fmt.Errorf("%w: the range needs a start and an end", ErrInvalidTrend)
+	}
+
+	end := dateRange.DateEnd.UTC()
+
+	var result []DateRange
+	for start := dateRange.DateStart.UTC(); start.Before(end); start = result[len(result)-1].DateEnd {
+		if len(result) == MaxTrendBuckets {
+			return nil, fmt.Errorf("%w: more than %v buckets, pick a longer bucket or a shorter range", ErrInvalidTrend, MaxTrendBuckets)
+		}
+
+		bucket := DateRange{start, b.Range(start).DateEnd}
+		if bucket.DateEnd.After(end) {
+			bucket.DateEnd = end
+		}
+		result = append(result, bucket)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: the range ends before it starts", ErrInvalidTrend)
+	}
+
+	return result, nil
+}
+
+// Label names the bucket starting at start
+func (b TrendBucket) Label(start time.Time) string {
+	start = start.UTC()
+
+	switch b {
+	case BucketDay:
+		return start.Format(DATE_INPUT_LAYOUT)
+	case BucketWeek:
+		return "week of " + b.Range(start).DateStart.Format(DATE_INPUT_LAYOUT)
+	case BucketQuarter:
+		return fmt.Sprintf("%v Q%v", start.Year(), (int(start.Month())-1)/3+1)
+	case BucketYear:
+		return strconv.Itoa(start.Year())
+	default:
+		return start.Format("Jan 2006")
+	}
+}
+
+// TrendPoint is the amount of one bucket, DateEnd is exclusive
+type TrendPoint struct {
+	DateStart time.Time `json:"date_start"`
+	DateEnd   time.Time `json:"date_end"`
+	Amount    Money     `json:"amount"`
+}
+
+type CashFlowPoint struct {
+	DateStart time.Time `json:"date_start"`
+	DateEnd   time.Time `json:"date_end"`
+	Income    Money     `json:"income"`
+	// positive
+	Expenses Money `json:"expenses"`
+	// income minus expenses
+	Net Money `json:"net"`
+}
+
+// CashFlowSeries is the cash flow of a currency per bucket, every bucket of the range has a point
+type CashFlowSeries struct {
+	Currency string          `json:"currency"`
+	Points   []CashFlowPoint `json:"points"`
+}
+
+// CategorySeries is the spending of a category in a currency per bucket, every bucket of the range has a point
+type CategorySeries struct {
+	Category Category `json:"category"`
+	Currency string   `json:"currency"`
+	// includes the subcategories
+	Total         Money            `json:"total"`
+	Points        []TrendPoint     `json:"points"`
+	Subcategories []CategorySeries `json:"subcategories,omitempty"`
+}
+
+// PercentChange returns how much to differs from from in percents rounded half away from zero,
+// false when from is zero
+func PercentChange(from Money, to Money) (int64, bool) {
+	if from.IsZero() {
+		return 0, false
+	}
+
+	change := new(big.Rat).Sub(to.rat(), from.rat())
+	change.Mul(change, big.NewRat(100, 1))
+	change.Quo(change, from.Abs().rat())
+
+	// |change| + 1/2 truncated, sign restored afterwards
+	abs := new(big.Rat).Abs(change)
+	abs.Add(abs, big.NewRat(1, 2))
+	percent := new(big.Int).Quo(abs.Num(), abs.Denom()).Int64()
+
+	if change.Sign() < 0 {
+		percent = -percent
+	}
+
+	return percent, true
+}
+
+// bucketIndex returns the bucket containing at, -1 when it's out of the buckets
+func bucketIndex(buckets []DateRange, at time.Time) int {
+	i := sort.Search(len(buckets), func(i int) bool {
+		return buckets[i].DateEnd.After(at)
+	})
+	if i == len(buckets) || at.Before(buckets[i].DateStart) {
+		return -1
+	}
+	return i
+}
+
+// GetCashFlowTrend sums income and expenses per bucket of the range and currency, transfers are not counted
+func GetCashFlowTrend[T DatabaseInterface](db T, userId int64, bucket TrendBucket, dateRange DateRange) ([]CashFlowSeries, error) {
+	buckets, err := bucket.Split(dateRange)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := getStatsTransactions(db, userId, dateRange)
+	if err != nil {
+		return nil, err
+	}
+
+	byCurrency := map[string]*CashFlowSeries{}
+
+	for _, t := range transactions {
+		i := bucketIndex(buckets, t.createdAt)
+		if i < 0 {
+			continue
+		}
+
+		series, ok := byCurrency[t.currency]
+		if !ok {
+			series = &CashFlowSeries{Currency: t.currency}
+			for _, b := range buckets {
+				zero := ZeroMoney(t.currency)
+				series.Points = append(series.Points, CashFlowPoint{
+					DateStart: b.DateStart, DateEnd: b.DateEnd, Income: zero, Expenses: zero, Net: zero,
+				})
+			}
+			byCurrency[t.currency] = series
+		}
+
+		point := &series.Points[i]
+		if t.amount.Sign() >= 0 {
+			point.Income, err = point.Income.Add(t.amount)
+		} else {
+			point.Expenses, err = point.Expenses.Add(t.amount.Abs())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if point.Net, err = point.Net.Add(t.amount); err != nil {
+			return nil, err
+		}
+	}
+
+	result := []CashFlowSeries{}
+	for _, series := range byCurrency {
+		result = append(result, *series)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Currency < result[j].Currency
+	})
+
+	return result, nil
+}
+
+// GetExpensesByCategoryTrend sums the expenses per bucket of the range, category and currency like GetExpensesByCategory,
+// subcategories are rolled up into their parents
+func GetExpensesByCategoryTrend[T DatabaseInterface](db T, userId int64, bucket TrendBucket, dateRange DateRange) ([]Pair[string, []CategorySeries], error) {
+	buckets, err := bucket.Split(dateRange)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := getStatsTransactions(db, userId, dateRange)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := GetCategories(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := categoryParents(categories)
+
+	byId := map[int64]Category{}
+	for _, c := range categories {
+		byId[c.Id] = c
+	}
+
+	type seriesKey struct {
+		currency   string
+		categoryId int64
+	}
+
+	allSeries := map[seriesKey]*CategorySeries{}
+
+	for _, t := range transactions {
+		if t.amount.Sign() >= 0 {
+			continue
+		}
+
+		i := bucketIndex(buckets, t.createdAt)
+		if i < 0 {
+			continue
+		}
+
+		for _, id := range categoryAncestors(parents, t.category.Id) {
+			key := seriesKey{t.currency, id}
+
+			series, ok := allSeries[key]
+			if !ok {
+				category, known := byId[id]
+				if !known {
+					category = t.category
+				}
+
+				series = &CategorySeries{Category: category, Currency: t.currency, Total: ZeroMoney(t.currency)}
+				for _, b := range buckets {
+					series.Points = append(series.Points, TrendPoint{
+						DateStart: b.DateStart, DateEnd: b.DateEnd, Amount: ZeroMoney(t.currency),
+					})
+				}
+				allSeries[key] = series
+			}
+
+			if series.Total, err = series.Total.Add(t.amount.Abs()); err != nil {
+				return nil, err
+			}
+			if series.Points[i].Amount, err = series.Points[i].Amount.Add(t.amount.Abs()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	children := map[seriesKey][]seriesKey{}
+	roots := map[string][]seriesKey{}
+	for key := range allSeries {
+		if parent, ok := parents[key.categoryId]; ok {
+			parentKey := seriesKey{key.currency, parent}
+			children[parentKey] = append(children[parentKey], key)
+		} else {
+			roots[key.currency] = append(roots[key.currency], key)
+		}
+	}
+
+	var nest func(keys []seriesKey) []CategorySeries
+	nest = func(keys []seriesKey) []CategorySeries {
+		var result []CategorySeries
+		for _, key := range keys {
+			series := *allSeries[key]
+			series.Subcategories = nest(children[key])
+			result = append(result, series)
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			if cmp := result[i].Total.Cmp(result[j].Total); cmp != 0 {
+				return cmp > 0
+			}
+			return result[i].Category.Id < result[j].Category.Id
+		})
+		return result
+	}
+
+	var currencies []string
+	for currency := range roots {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var result []Pair[string, []CategorySeries]
+	for _, currency := range currencies {
+		result = append(result, Pair[string, []CategorySeries]{First: currency, Second: nest(roots[currency])})
+	}
+
+	return result, nil
+}
+
+// AccountBalanceSeries is the balance of an account per bucket, the balance right before the end of each bucket
+type AccountBalanceSeries struct {
+	Account Account      `json:"account"`
+	Points  []TrendPoint `json:"points"`
+}
+
+// GetBalanceTrend follows the balance of every account through the buckets of the range,
+// transfers count since they move money between the accounts
+func GetBalanceTrend[T DatabaseInterface](db T, userId int64, bucket TrendBucket, dateRange DateRange) ([]AccountBalanceSeries, error) {
+	buckets, err := bucket.Split(dateRange)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := GetAccounts(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := buckets[0].DateStart, buckets[len(buckets)-1].DateEnd
+
+	sql, args, err := sq.
+		Select("transactions.amount", "transactions.account_id", "transactions.created_at").
+		From("transactions").
+		Where(sq.Eq{"transactions.user_id": userId}).
+		Where(sq.GtOrEq{"datetime(transactions.created_at)": start}).
+		// DateRange.DateEnd is exclusive
+		Where(sq.Lt{"datetime(transactions.created_at)": end}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("fetch balance trend failed: %v", err)
+	}
+	defer rows.Close()
+
+	// minor units moved per account and bucket
+	moved := map[int64][]int64{}
+
+	for rows.Next() {
+		var amount, accountId int64
+		var createdAt string
+
+		if err := rows.Scan(&amount, &accountId, &createdAt); err != nil {
+			return nil, fmt.Errorf("fetch balance trend row failed: %v", err)
+		}
+
+		at, err := ParseDbDatetime(createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if i := bucketIndex(buckets, at); i >= 0 {
+			if moved[accountId] == nil {
+				moved[accountId] = make([]int64, len(buckets))
+			}
+			moved[accountId][i] += amount
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during balance trend iteration: %v", err)
+	}
+
+	result := []AccountBalanceSeries{}
+
+	for _, a := range accounts {
+		balance, err := GetAccountBalance(db, userId, a.Id, start)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := CurrencyExponent(a.Currency)
+
+		// the opening amount counts from the opening date on, or from the first of the transactions dated before it
+		openedAt, err := accountOpenedAt(db, a)
+		if err != nil {
+			return nil, err
+		}
+		opened := bucketIndex(buckets, openedAt)
+
+		series := AccountBalanceSeries{Account: a}
+		for i, b := range buckets {
+			if i == opened {
+				if balance, err = balance.Add(a.OpeningAmount); err != nil {
+					return nil, err
+				}
+			}
+			if moved[a.Id] != nil {
+				if balance, err = balance.Add(NewMoney(moved[a.Id][i], exponent)); err != nil {
+					return nil, err
+				}
+			}
+
+			series.Points = append(series.Points, TrendPoint{DateStart: b.DateStart, DateEnd: b.DateEnd, Amount: balance})
+		}
+
+		result = append(result, series)
+	}
+
+	return result, nil
+}
+
+// most points ChartBucket gives a chart
+const MaxChartPoints = 62
+
+// ChartBucket is the shortest bucket splitting the range into at most MaxChartPoints
+func ChartBucket(dateRange DateRange) TrendBucket {
+	for _, b := range TrendBuckets {
+		if buckets, err := b.Split(dateRange); err == nil && len(buckets) <= MaxChartPoints {
+			return b
+		}
+	}
+	return BucketYear
+}
@@ -0,0 +1,115 @@
+package greed
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParseDate(x string) time.Time {
+	t, err := time.Parse(DATE_INPUT_LAYOUT, x)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestTrendBucketSplit(t *testing.T) {
+	cases := []struct {
+		bucket TrendBucket
+		from   string
+		to     string
+		starts []string
+	}{
+		{BucketDay, "2024-02-28", "2024-03-02", []string{"2024-02-28", "2024-02-29", "2024-03-01"}},
+		// weeks start on monday, the first one is cut to the range
+		{BucketWeek, "2024-03-06", "2024-03-19", []string{"2024-03-06", "2024-03-11", "2024-03-18"}},
+		{BucketMonth, "2024-01-01", "2024-04-01", []string{"2024-01-01", "2024-02-01", "2024-03-01"}},
+		{BucketQuarter, "2024-02-15", "2025-01-01", []string{"2024-02-15", "2024-04-01", "2024-07-01", "2024-10-01"}},
+		{BucketYear, "2023-06-01", "2024-06-01", []string{"2023-06-01", "2024-01-01"}},
+	}
+
+	for _, c := range cases {
+		buckets, err := c.bucket.Split(DateRange{mustParseDate(c.from), mustParseDate(c.to)})
+		if err != nil {
+			t.Fatalf("%v split: %v", c.bucket, err)
+		}
+
+		if len(buckets) != len(c.starts) {
+			t.Fatalf("%v split into %v buckets, want %v", c.bucket, len(buckets), len(c.starts))
+		}
+
+		for i, b := range buckets {
+			if !b.DateStart.Equal(mustParseDate(c.starts[i])) {
+				t.Errorf("%v bucket %v starts at %v, want %v", c.bucket, i, b.DateStart, c.starts[i])
+			}
+		}
+
+		if last := buckets[len(buckets)-1].DateEnd; !last.Equal(mustParseDate(c.to)) {
+			t.Errorf("%v last bucket ends at %v, want %v", c.bucket, last, c.to)
+		}
+	}
+
+	if _, err := BucketDay.Split(DateRange{mustParseDate("2020-01-01"), mustParseDate("2024-01-01")}); err == nil {
+		t.Errorf("split into more than %v buckets passed", MaxTrendBuckets)
+	}
+
+	if _, err := BucketDay.Split(DateRange{mustParseDate("2024-01-02"), mustParseDate("2024-01-01")}); err == nil {
+		t.Errorf("split of an empty range passed")
+	}
+}
+
+func TestTrendBucketLast(t *testing.T) {
+	at := mustParseDate("2024-05-20").Add(15 * time.Hour)
+
+	last := BucketQuarter.Last(3, at)
+	if !last.DateStart.Equal(mustParseDate("2023-10-01")) || !last.DateEnd.Equal(mustParseDate("2024-07-01")) {
+		t.Errorf("last 3 quarters = %v - %v", last.DateStart, last.DateEnd)
+	}
+
+	last = BucketMonth.Last(2, at)
+	if !last.DateStart.Equal(mustParseDate("2024-04-01")) || !last.DateEnd.Equal(mustParseDate("2024-06-01")) {
+		t.Errorf("last 2 months = %v - %v", last.DateStart, last.DateEnd)
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	cases := []struct {
+		from    Money
+		to      Money
+		percent int64
+		ok      bool
+	}{
+		{NewMoney(10000, 2), NewMoney(11550, 2), 16, true},
+		{NewMoney(10000, 2), NewMoney(5000, 2), -50, true},
+		{NewMoney(300, 2), NewMoney(100, 2), -67, true},
+		{NewMoney(100, 0), NewMoney(10000, 2), 0, true},
+		{ZeroMoney("USD"), NewMoney(100, 2), 0, false},
+	}
+
+	for _, c := range cases {
+		percent, ok := PercentChange(c.from, c.to)
+		if percent != c.percent || ok != c.ok {
+			t.Errorf("PercentChange(%v, %v) = %v %v, want %v %v", c.from, c.to, percent, ok, c.percent, c.ok)
+		}
+	}
+}
+
+func TestChartBucket(t *testing.T) {
+	cases := []struct {
+		from   string
+		to     string
+		bucket TrendBucket
+	}{
+		{"2024-05-01", "2024-05-31", BucketDay},
+		{"2024-01-01", "2024-10-17", BucketWeek},
+		{"2020-01-01", "2024-10-17", BucketMonth},
+		{"2012-01-01", "2024-10-17", BucketQuarter},
+		{"2000-01-01", "2024-10-17", BucketYear},
+	}
+
+	for _, c := range cases {
+		if bucket := ChartBucket(DateRange{mustParseDate(c.from), mustParseDate(c.to)}); bucket != c.bucket {
+			t.Errorf("ChartBucket(%v - %v) = %v, want %v", c.from, c.to, bucket, c.bucket)
+		}
+	}
+}
@@ -1,13 +1,14 @@
 package greed
 
-import "math/big"
-
-func ParseBigFloat(x string) (*big.Float, error) {
-	parsedX, _, err := big.ParseFloat(x, 10, 53, big.ToNearestEven)
-	return parsedX, err
-}
+import "time"
 
 type Pair[T, V any] struct {
 	First  T
 	Second V
 }
+
+// ParseDbDatetime parses datetime column values, the driver may hand them back
+// either as stored (DATETIME_DB_LAYOUT) or re-formatted as RFC3339 with "Z" for UTC
+func ParseDbDatetime(x string) (time.Time, error) {
+	return time.Parse(time.RFC3339, x)
+}
@@ -2,30 +2,758 @@ package server
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"supersolik/greed/pkg/greed"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
 )
 
+type ApiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type ApiErrorResponse struct {
+	Error ApiError `json:"error"`
+}
+
+// apiErrors renders every error returned by the /v1 handlers as ApiErrorResponse
+func apiErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil {
+			return nil
+		}
+
+		status := http.StatusInternalServerError
+		message := http.StatusText(status)
+
+		var httpErr *echo.HTTPError
+		switch {
+		case errors.As(err, &httpErr):
+			status = httpErr.Code
+			message = fmt.Sprint(httpErr.Message)
+		case errors.Is(err, sql.ErrNoRows):
+			status = http.StatusNotFound
+			message = "not found"
+		case errors.Is(err, greed.ErrInexactMoney), errors.Is(err, greed.ErrMoneyOverflow), errors.Is(err, greed.ErrInvalidTransfer),
+			errors.Is(err, greed.ErrInvalidRate), errors.Is(err, greed.ErrUnsupportedCurrency),
+			errors.Is(err, greed.ErrInvalidImport), errors.Is(err, greed.ErrInvalidExport),
+			errors.Is(err, greed.ErrInvalidBudget), errors.Is(err, greed.ErrInvalidRecurring),
+			errors.Is(err, greed.ErrInvalidTag), errors.Is(err, greed.ErrInvalidSplit),
+			errors.Is(err, greed.ErrInvalidCategory), errors.Is(err, greed.ErrInvalidQuery),
+			errors.Is(err, greed.ErrInvalidReconciliation), errors.Is(err, greed.ErrInvalidRule),
+			errors.Is(err, greed.ErrInvalidPayee), errors.Is(err, greed.ErrInvalidTrend):
+			status = http.StatusBadRequest
+			message = err.Error()
+		case errors.Is(err, greed.ErrTransferLeg), errors.Is(err, greed.ErrUserHasData),
+			errors.Is(err, greed.ErrReconciled), errors.Is(err, greed.ErrAccountNotEmpty):
+			status = http.StatusConflict
+			message = err.Error()
+		}
+
+		if status >= http.StatusInternalServerError {
+			log.Errorf("api request %v %v failed: %v", c.Request().Method, c.Path(), err)
+		}
+
+		return c.JSON(status, ApiErrorResponse{Error: ApiError{Status: status, Message: message}})
+	}
+}
+
+func parseIdParam(c echo.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid id: %v", c.Param("id")))
+	}
+	return id, nil
+}
+
+func bindJson(c echo.Context, v greed.Jsonable) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+
+	if err := v.FromJson(body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+	}
+
+	return nil
+}
+
+type AccountPayload struct {
+	Name string `json:"name"`
+	// current balance, changing it moves the opening amount
+	Amount greed.Money `json:"amount"`
+	// nil leaves the opening amount to the amount
+	OpeningAmount *greed.Money `json:"opening_amount"`
+	// now by default
+	OpeningDate *time.Time `json:"opening_date"`
+	Currency    string     `json:"currency"`
+	Description string     `json:"description"`
+}
+
+func (p *AccountPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *AccountPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+func (p *AccountPayload) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+
+	if !greed.IsSupportedCurrency(p.Currency) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unsupported currency: %v", p.Currency))
+	}
+
+	amount, err := p.Amount.Rescale(greed.CurrencyExponent(p.Currency))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid amount for %v: %v", p.Currency, err))
+	}
+	p.Amount = amount
+
+	if p.OpeningAmount != nil {
+		openingAmount, err := p.OpeningAmount.Rescale(greed.CurrencyExponent(p.Currency))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid opening_amount for %v: %v", p.Currency, err))
+		}
+		p.OpeningAmount = &openingAmount
+	}
+
+	return nil
+}
+
+// BalanceResponse is the balance of the account right before at
+type BalanceResponse struct {
+	AccountId int64       `json:"account_id"`
+	At        time.Time   `json:"at"`
+	Balance   greed.Money `json:"balance"`
+}
+
+type TransactionPayload struct {
+	AccountId   int64       `json:"account_id"`
+	CategoryId  int64       `json:"category_id"`
+	Amount      greed.Money `json:"amount"`
+	CreatedAt   *time.Time  `json:"created_at"`
+	Description string      `json:"description"`
+	// nil keeps the current tags
+	Tags *[]string `json:"tags"`
+	// nil keeps the current splits, empty list removes them
+	Splits *[]SplitPayload `json:"splits"`
+	// nil links a new transaction to the payee matching the description and keeps the current one, 0 for no payee
+	PayeeId *int64 `json:"payee_id"`
+}
+
+type SplitPayload struct {
+	CategoryId int64       `json:"category_id"`
+	Amount     greed.Money `json:"amount"`
+	Memo       string      `json:"memo"`
+}
+
+func (p *TransactionPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *TransactionPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+// toTransaction resolves account and category of the payload and validates the amount,
+// the category stays empty when the payload leaves it out
+func (p *TransactionPayload) toTransaction(db *sql.DB, userId int64) (greed.Transaction, error) {
+	var t greed.Transaction
+
+	// a split transaction may leave its main category to the first line
+	if p.CategoryId == 0 && p.Splits != nil && len(*p.Splits) > 0 {
+		p.CategoryId = (*p.Splits)[0].CategoryId
+	}
+
+	account, err := greed.GetAccountById(db, userId, p.AccountId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("account %v doesn't exist", p.AccountId))
+	} else if err != nil {
+		return t, err
+	}
+
+	if p.CategoryId != 0 {
+		category, err := greed.GetCategoryById(db, userId, p.CategoryId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return t, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("category %v doesn't exist", p.CategoryId))
+		} else if err != nil {
+			return t, err
+		}
+		t.Category = category
+	}
+
+	amount, err := p.Amount.Rescale(greed.CurrencyExponent(account.Currency))
+	if err != nil {
+		return t, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid amount for %v: %v", account.Currency, err))
+	}
+
+	t.Account = account
+	t.Amount = amount
+	t.Description = p.Description
+
+	if p.CreatedAt != nil {
+		t.CreatedAt = *p.CreatedAt
+	} else {
+		t.CreatedAt = time.Now().UTC()
+	}
+
+	if p.PayeeId != nil && *p.PayeeId != 0 {
+		payee, err := greed.GetPayeeById(db, userId, *p.PayeeId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return t, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("payee %v doesn't exist", *p.PayeeId))
+		} else if err != nil {
+			return t, err
+		}
+		t.Payee = &payee
+	}
+
+	if p.Tags != nil {
+		tags, err := greed.NormalizeTagNames(*p.Tags)
+		if err != nil {
+			return t, err
+		}
+
+		for _, name := range tags {
+			t.Tags = append(t.Tags, greed.Tag{Name: name})
+		}
+	}
+
+	if p.Splits != nil {
+		for _, sp := range *p.Splits {
+			category, err := greed.GetCategoryById(db, userId, sp.CategoryId)
+			if errors.Is(err, sql.ErrNoRows) {
+				return t, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("category %v doesn't exist", sp.CategoryId))
+			} else if err != nil {
+				return t, err
+			}
+
+			t.Splits = append(t.Splits, greed.Split{Category: category, Amount: sp.Amount, Memo: sp.Memo})
+		}
+	}
+
+	return t, nil
+}
+
+// applyPayloadTags replaces the tags of the transaction when the payload or the rules have them
+func (p *TransactionPayload) applyPayloadTags(tx *sql.Tx, userId int64, t greed.Transaction) error {
+	if p.Tags == nil && len(t.Tags) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(t.Tags))
+	for _, tag := range t.Tags {
+		names = append(names, tag.Name)
+	}
+
+	_, err := greed.SetTransactionTags(tx, userId, t.Id, names)
+	return err
+}
+
+type TransactionsPage struct {
+	Transactions []greed.Transaction `json:"transactions"`
+	// link to the next page, empty on the last page
+	NextPage string `json:"next_page,omitempty"`
+	// cursor param of the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
 func createApiEndpoints(e *echo.Echo, db *sql.DB) {
-	api := e.Group("/v1")
+	public := e.Group("/v1", apiErrors)
+	createApiLoginEndpoints(public, db)
+
+	api := public.Group("", tokenAuth(db))
+	createApiTokenEndpoints(api, db)
+	createApiTransferEndpoints(api, db)
+	createApiRateEndpoints(api, db)
+	createApiImportEndpoints(api, db)
+	createApiExportEndpoints(api, db)
+	createApiBudgetEndpoints(api, db)
+	createApiRecurringEndpoints(api, db)
+	createApiTagEndpoints(api, db)
+	createApiCategoryEndpoints(api, db)
+	createApiReconcileEndpoints(api, db)
+	createApiRuleEndpoints(api, db)
+	createApiPayeeEndpoints(api, db)
+	createApiTrendEndpoints(api, db)
+
+	api.GET("/accounts", func(c echo.Context) error {
+		accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+
+		if err != nil {
+			return err
+		}
+
+		if accounts == nil {
+			accounts = []greed.Account{}
+		}
+
+		return c.JSON(http.StatusOK, accounts)
+	})
+
+	api.GET("/accounts/:id", func(c echo.Context) error {
+		accountId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		account, err := greed.GetAccountById(db, currentUser(c).Id, accountId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, account)
+	})
+
+	// ?at= takes a datetime or a date, a date means its end
+	api.GET("/accounts/:id/balance", func(c echo.Context) error {
+		accountId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		at := time.Now().UTC()
+		if param := c.QueryParam("at"); param != "" {
+			if at, err = time.Parse(time.RFC3339, param); err != nil {
+				date, err := time.Parse(greed.DATE_INPUT_LAYOUT, param)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid at: %v", param))
+				}
+				at = date.AddDate(0, 0, 1)
+			}
+		}
+
+		balance, err := greed.GetAccountBalance(db, currentUser(c).Id, accountId, at)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, BalanceResponse{AccountId: accountId, At: at, Balance: balance})
+	})
+
+	api.POST("/accounts", func(c echo.Context) error {
+		var payload AccountPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		if err := payload.validate(); err != nil {
+			return err
+		}
+
+		openingAmount, openingDate := payload.Amount, time.Now().UTC()
+		if payload.OpeningAmount != nil {
+			openingAmount = *payload.OpeningAmount
+		}
+		if payload.OpeningDate != nil {
+			openingDate = *payload.OpeningDate
+		}
+
+		account, err := greed.CreateAccount(
+			db, currentUser(c).Id, payload.Name, openingAmount, openingDate, payload.Currency, payload.Description,
+		)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, account)
+	})
+
+	api.PUT("/accounts/:id", func(c echo.Context) error {
+		accountId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		account, err := greed.GetAccountById(db, currentUser(c).Id, accountId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		payload := AccountPayload{
+			Name:        account.Name,
+			Amount:      account.Amount,
+			OpeningDate: &account.OpeningDate,
+			Currency:    account.Currency,
+			Description: account.Description,
+		}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		if payload.Currency != account.Currency {
+			return echo.NewHTTPError(http.StatusBadRequest, "account currency can't be changed")
+		}
+
+		if err := payload.validate(); err != nil {
+			return err
+		}
+
+		account.Name = payload.Name
+		// a new balance moves the opening amount by the same difference
+		difference, err := payload.Amount.Sub(account.Amount)
+		if err == nil {
+			account.OpeningAmount, err = account.OpeningAmount.Add(difference)
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid amount: %v", err))
+		}
+		if payload.OpeningAmount != nil {
+			account.OpeningAmount = *payload.OpeningAmount
+		}
+		if payload.OpeningDate != nil {
+			account.OpeningDate = *payload.OpeningDate
+		}
+		account.Description = payload.Description
+
+		if _, err := greed.UpdateAccount(db, currentUser(c).Id, account); err != nil {
+			return err
+		}
+
+		// the balance follows the opening amount
+		if account, err = greed.GetAccountById(db, currentUser(c).Id, accountId); err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, account)
+	})
+
+	api.DELETE("/accounts/:id", func(c echo.Context) error {
+		accountId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.GetAccountById(db, currentUser(c).Id, accountId); err != nil {
+			return err
+		}
+
+		if err := greed.DeleteAccount(db, currentUser(c).Id, accountId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	api.GET("/transactions", func(c echo.Context) error {
+		filter, err := parseTransactionFilter(c)
+		if err != nil {
+			return err
+		}
+
+		transactions, err := greed.GetTransactions(db, currentUser(c).Id, filter)
+		if err != nil {
+			return err
+		}
+
+		page := TransactionsPage{Transactions: transactions}
+
+		if page.Transactions == nil {
+			page.Transactions = []greed.Transaction{}
+		}
+
+		if filter.PageSize > 0 && len(transactions) == int(filter.PageSize) {
+			next := filter.NextPage(transactions[len(transactions)-1])
+			page.NextPage = fmt.Sprintf("/v1/transactions%v", next.BuildQueryParams())
+			page.NextCursor = next.Cursor.String()
+		}
+
+		return c.JSON(http.StatusOK, page)
+	})
+
+	api.GET("/transactions/:id", func(c echo.Context) error {
+		transactionId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		transaction, err := greed.GetTransactionById(db, currentUser(c).Id, transactionId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, transaction)
+	})
+
+	api.POST("/transactions", func(c echo.Context) error {
+		var payload TransactionPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		t, err := payload.toTransaction(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		// the rules fill in the category when the payload leaves it out
+		if t, err = greed.CategorizeTransaction(db, currentUser(c).Id, t); err != nil {
+			return err
+		}
+
+		if t.Category.Id == 0 {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "category_id is missing and no rule matches the transaction")
+		}
+
+		if _, err := greed.ValidateSplits(t.Amount, t.Account.Currency, t.Splits); err != nil {
+			return err
+		}
+
+		// the transaction is created with its tags, payee and splits or not at all
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		transaction, err := greed.CreateTransactionWithRecalc(tx, currentUser(c).Id, t.Account, t.Amount, t.Category, t.CreatedAt, t.Description)
+		if err != nil {
+			return err
+		}
+
+		t.Id = transaction.Id
+		if err := payload.applyPayloadTags(tx, currentUser(c).Id, t); err != nil {
+			return err
+		}
+
+		if payload.PayeeId != nil {
+			if err := greed.SetTransactionPayee(tx, currentUser(c).Id, t.Id, *payload.PayeeId); err != nil {
+				return err
+			}
+		}
+
+		if len(t.Splits) > 0 {
+			if _, err := greed.SetTransactionSplits(tx, currentUser(c).Id, t.Id, t.Splits); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		transaction, err = greed.GetTransactionById(db, currentUser(c).Id, transaction.Id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, transaction)
+	})
+
+	api.GET("/transactions/suggest", func(c echo.Context) error {
+		t := greed.Transaction{Description: c.QueryParam("description"), CreatedAt: time.Now().UTC()}
+
+		if accountId := c.QueryParam("account_id"); accountId != "" {
+			id, err := strconv.ParseInt(accountId, 10, 64)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid account_id: %v", accountId))
+			}
+
+			if t.Account, err = greed.GetAccountById(db, currentUser(c).Id, id); errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("account %v doesn't exist", id))
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if amount := c.QueryParam("amount"); amount != "" {
+			var err error
+			if t.Account.Id != 0 {
+				t.Amount, err = greed.ParseCurrencyMoney(amount, t.Account.Currency)
+			} else {
+				t.Amount, err = greed.ParseExactMoney(amount)
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid amount: %v", err))
+			}
+		}
+
+		if createdAt := c.QueryParam("created_at"); createdAt != "" {
+			var err error
+			if t.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid created_at: %v", createdAt))
+			}
+		}
+
+		limit := 3
+		if l := c.QueryParam("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid limit: %v", l))
+			}
+		}
+
+		suggestions, err := greed.SuggestCategories(db, currentUser(c).Id, t, limit)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, suggestions)
+	})
+
+	api.PUT("/transactions/:id", func(c echo.Context) error {
+		transactionId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		old, err := greed.GetTransactionById(db, currentUser(c).Id, transactionId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		var payeeId int64
+		if old.Payee != nil {
+			payeeId = old.Payee.Id
+		}
+		payload := TransactionPayload{
+			AccountId:   old.Account.Id,
+			CategoryId:  old.Category.Id,
+			Amount:      old.Amount,
+			CreatedAt:   &old.CreatedAt,
+			Description: old.Description,
+			PayeeId:     &payeeId,
+		}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		t, err := payload.toTransaction(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if t.Category.Id == 0 {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "category_id is missing")
+		}
+
+		t.Id = transactionId
+
+		// the kept splits still have to add up to the new amount
+		if payload.Splits == nil {
+			t.Splits = old.Splits
+		}
+
+		if _, err := greed.ValidateSplits(t.Amount, t.Account.Currency, t.Splits); err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := greed.UpdateTransactionWithRecalc(tx, currentUser(c).Id, t); err != nil {
+			return err
+		}
+
+		if _, err := greed.SetTransactionSplits(tx, currentUser(c).Id, transactionId, t.Splits); err != nil {
+			return err
+		}
+
+		if err := payload.applyPayloadTags(tx, currentUser(c).Id, t); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
 
-	api.GET("/categories", func(c echo.Context) error {
-		categories, err := greed.GetCategories(db)
+		transaction, err := greed.GetTransactionById(db, currentUser(c).Id, transactionId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, transaction)
+	})
+
+	api.DELETE("/transactions/:id", func(c echo.Context) error {
+		transactionId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteTransactionWithRecalc(db, currentUser(c).Id, transactionId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	api.GET("/stats/balance", func(c echo.Context) error {
+		balance, err := greed.GetBalance(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if balance == nil {
+			balance = []greed.CurrencyAmount{}
+		}
 
+		return c.JSON(http.StatusOK, balance)
+	})
+
+	api.GET("/stats/cashflow", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		cashFlow, err := greed.GetCashFlow(db, currentUser(c).Id, dateRange)
+		if err != nil {
+			return err
+		}
+
+		if cashFlow == nil {
+			cashFlow = []greed.CashFlow{}
+		}
+
+		return c.JSON(http.StatusOK, cashFlow)
+	})
+
+	api.GET("/stats/categories", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		groupedCategoriesSpent, err := greed.GetExpensesByCategory(db, currentUser(c).Id, dateRange)
 		if err != nil {
 			return err
 		}
 
-		return c.JSON(http.StatusOK, categories)
+		// every item carries its currency, so grouping isn't needed in json
+		categoriesSpent := []greed.CategorySpent{}
+		for _, pair := range groupedCategoriesSpent {
+			categoriesSpent = append(categoriesSpent, pair.Second...)
+		}
+
+		return c.JSON(http.StatusOK, categoriesSpent)
 	})
 }
 
 func BuildApi(db *sql.DB) *echo.Echo {
 	e := echo.New()
 	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
 
 	createApiEndpoints(e, db)
 
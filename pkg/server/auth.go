@@ -0,0 +1,460 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const SessionCookieName = "greed_session"
+const SessionTTL = 30 * 24 * time.Hour
+const ApiTokenPrefix = "greed_"
+
+const userContextKey = "user"
+
+const minPasswordLength = 8
+
+// bcrypt ignores everything after 72 bytes
+const maxPasswordLength = 72
+
+// compared against when the user doesn't exist, so that login takes the same time
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// newToken returns random token and its sha256 digest, only the digest is stored
+func newToken(prefix string) (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token := prefix + base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+func currentUser(c echo.Context) greed.User {
+	user, _ := c.Get(userContextKey).(greed.User)
+	return user
+}
+
+func signupAllowed(db *sql.DB) (bool, error) {
+	if os.Getenv("GREED_ALLOW_SIGNUP") == "true" {
+		return true, nil
+	}
+
+	// the first user can always sign up
+	count, err := greed.CountUsers(db)
+	if err != nil {
+		return false, err
+	}
+
+	return count == 0, nil
+}
+
+func validateCredentials(username string, password string) error {
+	if strings.TrimSpace(username) == "" {
+		return errors.New("username is required")
+	}
+
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be %v to %v characters long", minPasswordLength, maxPasswordLength)
+	}
+
+	return nil
+}
+
+// authenticate checks the password, returns ok=false for unknown user or wrong password
+func authenticate(db *sql.DB, username string, password string) (greed.User, bool, error) {
+	user, err := greed.GetUserByUsername(db, username)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return user, false, nil
+	} else if err != nil {
+		return user, false, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return user, false, nil
+	}
+
+	return user, true, nil
+}
+
+func startSession(c echo.Context, db *sql.DB, user greed.User) error {
+	token, tokenHash, err := newToken("")
+	if err != nil {
+		return err
+	}
+
+	session, err := greed.CreateSession(db, user.Id, tokenHash, SessionTTL)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// sessionUser resolves the user from the session cookie, ok=false when there is no valid session
+func sessionUser(c echo.Context, db *sql.DB) (greed.User, bool, error) {
+	cookie, err := c.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return greed.User{}, false, nil
+	}
+
+	user, err := greed.GetSessionUser(db, hashToken(cookie.Value))
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, greed.ErrSessionExpired):
+		return user, false, nil
+	case err != nil:
+		return user, false, err
+	}
+
+	return user, true, nil
+}
+
+func redirect(c echo.Context, url string) error {
+	if c.Request().Header.Get("HX-Request") == "true" {
+		// htmx follows the header instead of swapping the response
+		c.Response().Header().Set("HX-Redirect", url)
+		return c.NoContent(http.StatusOK)
+	}
+
+	return c.Redirect(http.StatusSeeOther, url)
+}
+
+// sessionAuth lets through only requests with a valid session cookie, others go to the login page
+func sessionAuth(db *sql.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok, err := sessionUser(c, db)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				return redirect(c, "/login")
+			}
+
+			c.Set(userContextKey, user)
+			return next(c)
+		}
+	}
+}
+
+// tokenAuth lets through requests with a valid bearer token or a session cookie
+func tokenAuth(db *sql.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authorization := c.Request().Header.Get(echo.HeaderAuthorization)
+
+			if authorization != "" {
+				scheme, token, found := strings.Cut(authorization, " ")
+
+				if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+					return echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization header")
+				}
+
+				user, err := greed.GetApiTokenUser(db, hashToken(strings.TrimSpace(token)))
+
+				switch {
+				case errors.Is(err, sql.ErrNoRows), errors.Is(err, greed.ErrTokenRevoked):
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+					return echo.NewHTTPError(http.StatusUnauthorized, "invalid api token")
+				case err != nil:
+					return err
+				}
+
+				c.Set(userContextKey, user)
+				return next(c)
+			}
+
+			user, ok, err := sessionUser(c, db)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+			}
+
+			c.Set(userContextKey, user)
+			return next(c)
+		}
+	}
+}
+
+func createAuthEndpoints(e *echo.Echo, db *sql.DB) {
+	e.GET("/login", func(c echo.Context) error {
+		if _, ok, err := sessionUser(c, db); err != nil {
+			return err
+		} else if ok {
+			return redirect(c, "/")
+		}
+
+		allowed, err := signupAllowed(db)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.LoginContent("", allowed)))
+	})
+
+	e.POST("/login", func(c echo.Context) error {
+		user, ok, err := authenticate(db, c.FormValue("username"), c.FormValue("password"))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			allowed, err := signupAllowed(db)
+			if err != nil {
+				return err
+			}
+
+			c.Response().WriteHeader(http.StatusUnauthorized)
+			return renderTempl(c, views.Page(views.LoginContent("wrong username or password", allowed)))
+		}
+
+		if err := startSession(c, db, user); err != nil {
+			return err
+		}
+
+		log.Printf("User %v logged in", user.Username)
+
+		return redirect(c, "/")
+	})
+
+	e.GET("/signup", func(c echo.Context) error {
+		allowed, err := signupAllowed(db)
+		if err != nil {
+			return err
+		}
+
+		if !allowed {
+			return redirect(c, "/login")
+		}
+
+		return renderTempl(c, views.Page(views.SignupContent("")))
+	})
+
+	e.POST("/signup", func(c echo.Context) error {
+		allowed, err := signupAllowed(db)
+		if err != nil {
+			return err
+		}
+
+		if !allowed {
+			return echo.NewHTTPError(http.StatusForbidden, "signup is disabled")
+		}
+
+		username := strings.TrimSpace(c.FormValue("username"))
+		password := c.FormValue("password")
+
+		if err := validateCredentials(username, password); err != nil {
+			c.Response().WriteHeader(http.StatusBadRequest)
+			return renderTempl(c, views.Page(views.SignupContent(err.Error())))
+		}
+
+		if _, err := greed.GetUserByUsername(db, username); err == nil {
+			c.Response().WriteHeader(http.StatusConflict)
+			return renderTempl(c, views.Page(views.SignupContent("username is taken")))
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		passwordHash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		user, err := greed.SignupUser(db, username, passwordHash)
+		if err != nil {
+			return err
+		}
+
+		if err := startSession(c, db, user); err != nil {
+			return err
+		}
+
+		return redirect(c, "/")
+	})
+
+	e.POST("/logout", func(c echo.Context) error {
+		if cookie, err := c.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+			if err := greed.DeleteSession(db, hashToken(cookie.Value)); err != nil {
+				return err
+			}
+		}
+
+		c.SetCookie(&http.Cookie{
+			Name:     SessionCookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   c.Scheme() == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		return redirect(c, "/login")
+	})
+}
+
+type LoginPayload struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	TokenName string `json:"token_name"`
+}
+
+func (p *LoginPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *LoginPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+type ApiTokenPayload struct {
+	Name string `json:"name"`
+}
+
+func (p *ApiTokenPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *ApiTokenPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+// token value is returned only once, when it is created
+type CreatedApiToken struct {
+	Token    string         `json:"token"`
+	ApiToken greed.ApiToken `json:"api_token"`
+}
+
+func issueApiToken(db *sql.DB, user greed.User, name string) (CreatedApiToken, error) {
+	if strings.TrimSpace(name) == "" {
+		name = fmt.Sprintf("token %v", time.Now().UTC().Format(greed.DATETIME_INPUT_LAYOUT))
+	}
+
+	token, tokenHash, err := newToken(ApiTokenPrefix)
+	if err != nil {
+		return CreatedApiToken{}, err
+	}
+
+	apiToken, err := greed.CreateApiToken(db, user.Id, name, tokenHash)
+	if err != nil {
+		return CreatedApiToken{}, err
+	}
+
+	return CreatedApiToken{Token: token, ApiToken: apiToken}, nil
+}
+
+// createApiLoginEndpoints registers endpoints which don't require authentication
+func createApiLoginEndpoints(api *echo.Group, db *sql.DB) {
+	api.POST("/login", func(c echo.Context) error {
+		var payload LoginPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		user, ok, err := authenticate(db, payload.Username, payload.Password)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "wrong username or password")
+		}
+
+		created, err := issueApiToken(db, user, payload.TokenName)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, created)
+	})
+}
+
+func createApiTokenEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/tokens", func(c echo.Context) error {
+		tokens, err := greed.GetApiTokens(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if tokens == nil {
+			tokens = []greed.ApiToken{}
+		}
+
+		return c.JSON(http.StatusOK, tokens)
+	})
+
+	api.POST("/tokens", func(c echo.Context) error {
+		var payload ApiTokenPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		created, err := issueApiToken(db, currentUser(c), payload.Name)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, created)
+	})
+
+	api.DELETE("/tokens/:id", func(c echo.Context) error {
+		tokenId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.RevokeApiToken(db, currentUser(c).Id, tokenId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+}
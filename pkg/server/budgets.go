@@ -0,0 +1,367 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// budgetError turns validation errors into bad requests for the web forms
+func budgetError(err error) error {
+	if errors.Is(err, greed.ErrInvalidBudget) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// parseBudgetForm reads the budget from the BudgetForm inputs
+func parseBudgetForm(c echo.Context, db *sql.DB, userId int64) (greed.Budget, error) {
+	var b greed.Budget
+
+	categoryId, err := parseFormId(c, "category")
+	if err != nil {
+		return b, err
+	}
+
+	if b.Category, err = greed.GetCategoryById(db, userId, categoryId); err != nil {
+		return b, err
+	}
+
+	b.Currency = c.FormValue("currency")
+	b.Period = greed.BudgetPeriod(c.FormValue("period"))
+	b.Rollover = c.FormValue("rollover") == "true"
+
+	if b.Amount, err = greed.ParseCurrencyMoney(c.FormValue("amount"), b.Currency); err != nil {
+		return b, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return b, nil
+}
+
+func createBudgetEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/budgets", func(c echo.Context) error {
+		budgets, err := greed.GetBudgets(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		progress, err := greed.GetBudgetsProgress(db, currentUser(c).Id, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.BudgetsContent(budgets, progress)))
+	})
+
+	app.GET("/budgets/content", func(c echo.Context) error {
+		budgets, err := greed.GetBudgets(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Budgets(budgets))
+	})
+
+	app.GET("/budgets/count", func(c echo.Context) error {
+		budgets, err := greed.GetBudgets(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return c.String(http.StatusOK, strconv.Itoa(len(budgets)))
+	})
+
+	app.GET("/budgets/new", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+		categories = greed.ActiveCategories(categories)
+
+		if len(categories) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "create a category first")
+		}
+
+		currency := currentUser(c).ReportingCurrency
+
+		b := greed.Budget{
+			Category: categories[0],
+			Currency: currency,
+			Period:   greed.BudgetMonthly,
+			Amount:   greed.ZeroMoney(currency),
+		}
+
+		return renderTempl(c, views.BudgetForm(b, categories, true))
+	})
+
+	app.GET("/budgets/:id", func(c echo.Context) error {
+		budgetId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		budget, err := greed.GetBudgetById(db, currentUser(c).Id, budgetId)
+		if err != nil {
+			return err
+		}
+
+		if c.QueryParam("edit") == "true" {
+			categories, err := greed.GetCategories(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			return renderTempl(c, views.BudgetForm(budget, greed.ActiveCategories(categories, budget.Category.Id), false))
+		}
+
+		return renderTempl(c, views.Budget(budget))
+	})
+
+	app.POST("/budgets", func(c echo.Context) error {
+		b, err := parseBudgetForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.CreateBudget(db, currentUser(c).Id, b); err != nil {
+			return budgetError(err)
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	app.PUT("/budgets/:id", func(c echo.Context) error {
+		budgetId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		b, err := parseBudgetForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		b.Id = budgetId
+
+		budget, err := greed.UpdateBudget(db, currentUser(c).Id, b)
+		if err != nil {
+			return budgetError(err)
+		}
+
+		return renderTempl(c, views.Budget(budget))
+	})
+
+	app.DELETE("/budgets/:id", func(c echo.Context) error {
+		budgetId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteBudget(db, currentUser(c).Id, budgetId); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecountAnchor())
+	})
+}
+
+type BudgetPayload struct {
+	CategoryId int64              `json:"category_id"`
+	Currency   string             `json:"currency"`
+	Period     greed.BudgetPeriod `json:"period"`
+	Amount     greed.Money        `json:"amount"`
+	Rollover   bool               `json:"rollover"`
+}
+
+func (p *BudgetPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *BudgetPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+// toBudget resolves the category of the payload, the period defaults to a month
+func (p *BudgetPayload) toBudget(db *sql.DB, userId int64) (greed.Budget, error) {
+	b := greed.Budget{
+		Currency: p.Currency,
+		Period:   p.Period,
+		Amount:   p.Amount,
+		Rollover: p.Rollover,
+	}
+
+	if b.Period == "" {
+		b.Period = greed.BudgetMonthly
+	}
+
+	category, err := greed.GetCategoryById(db, userId, p.CategoryId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return b, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("category %v doesn't exist", p.CategoryId))
+	} else if err != nil {
+		return b, err
+	}
+
+	b.Category = category
+	return b, nil
+}
+
+// parseProgressAt reads the optional ?at= date or datetime the progress is computed at, now by default
+func parseProgressAt(c echo.Context) (time.Time, error) {
+	at := c.QueryParam("at")
+	if at == "" {
+		return time.Now().UTC(), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if parsed, err := time.Parse(layout, at); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid at: %v", at))
+}
+
+func createApiBudgetEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/budgets", func(c echo.Context) error {
+		budgets, err := greed.GetBudgets(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if budgets == nil {
+			budgets = []greed.Budget{}
+		}
+
+		return c.JSON(http.StatusOK, budgets)
+	})
+
+	api.GET("/budgets/progress", func(c echo.Context) error {
+		at, err := parseProgressAt(c)
+		if err != nil {
+			return err
+		}
+
+		progress, err := greed.GetBudgetsProgress(db, currentUser(c).Id, at)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, progress)
+	})
+
+	api.GET("/budgets/:id", func(c echo.Context) error {
+		budgetId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		budget, err := greed.GetBudgetById(db, currentUser(c).Id, budgetId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, budget)
+	})
+
+	api.GET("/budgets/:id/progress", func(c echo.Context) error {
+		budgetId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		at, err := parseProgressAt(c)
+		if err != nil {
+			return err
+		}
+
+		budget, err := greed.GetBudgetById(db, currentUser(c).Id, budgetId)
+		if err != nil {
+			return err
+		}
+
+		progress, err := greed.GetBudgetProgress(db, currentUser(c).Id, budget, at)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, progress)
+	})
+
+	api.POST("/budgets", func(c echo.Context) error {
+		var payload BudgetPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		b, err := payload.toBudget(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		budget, err := greed.CreateBudget(db, currentUser(c).Id, b)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, budget)
+	})
+
+	api.PUT("/budgets/:id", func(c echo.Context) error {
+		budgetId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		old, err := greed.GetBudgetById(db, currentUser(c).Id, budgetId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		payload := BudgetPayload{
+			CategoryId: old.Category.Id,
+			Currency:   old.Currency,
+			Period:     old.Period,
+			Amount:     old.Amount,
+			Rollover:   old.Rollover,
+		}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		b, err := payload.toBudget(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		b.Id = budgetId
+
+		budget, err := greed.UpdateBudget(db, currentUser(c).Id, b)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, budget)
+	})
+
+	api.DELETE("/budgets/:id", func(c echo.Context) error {
+		budgetId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteBudget(db, currentUser(c).Id, budgetId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+
+	"github.com/labstack/echo/v4"
+)
+
+// categoryError turns validation errors into bad requests for the web forms
+func categoryError(err error) error {
+	if errors.Is(err, greed.ErrInvalidCategory) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// parseCategoryForm reads the category from the CategoryForm inputs
+func parseCategoryForm(c echo.Context) (greed.Category, error) {
+	category := greed.Category{
+		Name:     c.FormValue("name"),
+		Archived: c.FormValue("archived") == "true",
+	}
+
+	parentId, err := parseFormId(c, "parent")
+	if err != nil {
+		return category, err
+	}
+
+	category.ParentId = parentId
+	return category, nil
+}
+
+// renderCategory renders the row of the category indented by its depth in the tree
+func renderCategory(c echo.Context, db *sql.DB, categoryId int64) error {
+	categories, err := greed.GetCategories(db, currentUser(c).Id)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range greed.CategoryTree(categories) {
+		if item.Category.Id == categoryId {
+			return renderTempl(c, views.Category(item))
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func createCategoryEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/categories", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.CategoriesContent(categories)))
+	})
+
+	app.GET("/categories/content", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Categories(categories))
+	})
+
+	app.GET("/categories/count", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return c.String(http.StatusOK, strconv.Itoa(len(categories)))
+	})
+
+	app.GET("/categories/new", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.CategoryForm(greed.Category{}, greed.ActiveCategories(categories), true))
+	})
+
+	app.GET("/categories/:id", func(c echo.Context) error {
+		categoryId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		category, err := greed.GetCategoryById(db, currentUser(c).Id, categoryId)
+		if err != nil {
+			return err
+		}
+
+		if c.QueryParam("edit") == "true" {
+			categories, err := greed.GetCategories(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			return renderTempl(c, views.CategoryForm(category, greed.ActiveCategories(categories, category.ParentId), false))
+		}
+
+		return renderCategory(c, db, categoryId)
+	})
+
+	app.GET("/categories/:id/merge", func(c echo.Context) error {
+		categoryId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		category, err := greed.GetCategoryById(db, currentUser(c).Id, categoryId)
+		if err != nil {
+			return err
+		}
+
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.CategoryMergeForm(category, greed.ActiveCategories(categories)))
+	})
+
+	app.POST("/categories", func(c echo.Context) error {
+		category, err := parseCategoryForm(c)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.CreateCategory(db, currentUser(c).Id, category); err != nil {
+			return categoryError(err)
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	// moving a category reorders the tree so the whole list is refreshed
+	app.PUT("/categories/:id", func(c echo.Context) error {
+		categoryId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		category, err := parseCategoryForm(c)
+		if err != nil {
+			return err
+		}
+
+		category.Id = categoryId
+
+		if _, err := greed.UpdateCategory(db, currentUser(c).Id, category); err != nil {
+			return categoryError(err)
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	app.POST("/categories/:id/merge", func(c echo.Context) error {
+		categoryId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		intoId, err := parseFormId(c, "into")
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.MergeCategory(db, currentUser(c).Id, categoryId, intoId); err != nil {
+			return categoryError(err)
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	app.DELETE("/categories/:id", func(c echo.Context) error {
+		categoryId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.DeleteCategory(db, currentUser(c).Id, categoryId); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+}
+
+type CategoryPayload struct {
+	Name string `json:"name"`
+	// 0 for top level categories
+	ParentId int64 `json:"parent_id"`
+	Archived bool  `json:"archived"`
+}
+
+func (p *CategoryPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *CategoryPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+func (p *CategoryPayload) toCategory() greed.Category {
+	return greed.Category{Name: p.Name, ParentId: p.ParentId, Archived: p.Archived}
+}
+
+type CategoryMergePayload struct {
+	IntoId int64 `json:"into_id"`
+}
+
+func (p *CategoryMergePayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *CategoryMergePayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+func createApiCategoryEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/categories", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, categories)
+	})
+
+	api.GET("/categories/:id", func(c echo.Context) error {
+		categoryId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		category, err := greed.GetCategoryById(db, currentUser(c).Id, categoryId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, category)
+	})
+
+	api.POST("/categories", func(c echo.Context) error {
+		var payload CategoryPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		category, err := greed.CreateCategory(db, currentUser(c).Id, payload.toCategory())
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, category)
+	})
+
+	api.PUT("/categories/:id", func(c echo.Context) error {
+		categoryId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		old, err := greed.GetCategoryById(db, currentUser(c).Id, categoryId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		payload := CategoryPayload{Name: old.Name, ParentId: old.ParentId, Archived: old.Archived}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		category := payload.toCategory()
+		category.Id = categoryId
+
+		if category, err = greed.UpdateCategory(db, currentUser(c).Id, category); err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, category)
+	})
+
+	// a used category is archived instead of deleted and returned
+	api.DELETE("/categories/:id", func(c echo.Context) error {
+		categoryId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		archived, err := greed.DeleteCategory(db, currentUser(c).Id, categoryId)
+		if err != nil {
+			return err
+		}
+
+		if !archived {
+			return c.NoContent(http.StatusNoContent)
+		}
+
+		category, err := greed.GetCategoryById(db, currentUser(c).Id, categoryId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, category)
+	})
+
+	api.POST("/categories/:id/merge", func(c echo.Context) error {
+		categoryId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		var payload CategoryMergePayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		if _, err := greed.GetCategoryById(db, currentUser(c).Id, payload.IntoId); errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("category %v doesn't exist", payload.IntoId))
+		} else if err != nil {
+			return err
+		}
+
+		category, err := greed.MergeCategory(db, currentUser(c).Id, categoryId, payload.IntoId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, category)
+	})
+}
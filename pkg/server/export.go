@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"supersolik/greed/pkg/greed"
+
+	"github.com/labstack/echo/v4"
+)
+
+type RestoredExport struct {
+	Accounts     int `json:"accounts"`
+	Categories   int `json:"categories"`
+	Transactions int `json:"transactions"`
+	Budgets      int `json:"budgets"`
+	Recurring    int `json:"recurring"`
+}
+
+func createApiExportEndpoints(api *echo.Group, db *sql.DB) {
+	// ?format=zip for a zip of csv files, json otherwise
+	api.GET("/export", func(c echo.Context) error {
+		export, err := greed.GetExport(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		var buffer bytes.Buffer
+		filename := fmt.Sprintf("greed-%v", export.ExportedAt.Format("20060102-150405"))
+
+		switch c.QueryParam("format") {
+		case "", "json":
+			if err := greed.WriteExportJson(&buffer, export); err != nil {
+				return err
+			}
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%v.json", filename))
+			return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, buffer.Bytes())
+		case "zip":
+			if err := greed.WriteExportZip(&buffer, export); err != nil {
+				return err
+			}
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%v.zip", filename))
+			return c.Blob(http.StatusOK, "application/zip", buffer.Bytes())
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown format: %v", c.QueryParam("format")))
+		}
+	})
+
+	// the body is a json or zip export, ?replace=true drops the current data of the user
+	api.POST("/export/restore", func(c echo.Context) error {
+		data, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+
+		export, err := greed.ReadExport(data)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.RestoreExport(db, currentUser(c).Id, export, c.QueryParam("replace") == "true"); err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, RestoredExport{
+			Accounts:     len(export.Accounts),
+			Categories:   len(export.Categories),
+			Transactions: len(export.Transactions),
+			Budgets:      len(export.Budgets),
+			Recurring:    len(export.Recurring),
+		})
+	})
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+
+	"github.com/labstack/echo/v4"
+)
+
+// readImportStatement takes the uploaded file on the first preview and the statement carried by the form afterwards
+func readImportStatement(c echo.Context) (string, error) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.FormValue("statement"), nil
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// parseImportForm reads the statement and the column mapping of the import forms,
+// csv mapping is guessed from the header until the user picks the columns
+func parseImportForm(c echo.Context, db *sql.DB, userId int64) (views.ImportPreviewArgs, error) {
+	var args views.ImportPreviewArgs
+	var err error
+
+	if args.Accounts, err = greed.GetAccounts(db, userId); err != nil {
+		return args, err
+	}
+
+	if args.Categories, err = greed.GetCategories(db, userId); err != nil {
+		return args, err
+	}
+	args.Categories = greed.ActiveCategories(args.Categories)
+
+	accountId, err := parseFormId(c, "account")
+	if err != nil {
+		return args, err
+	}
+
+	if args.Account, err = greed.GetAccountById(db, userId, accountId); err != nil {
+		return args, err
+	}
+
+	defaultCategoryId, err := parseFormId(c, "default_category")
+	if err != nil {
+		return args, err
+	}
+
+	if args.Statement, err = readImportStatement(c); err != nil {
+		return args, err
+	}
+
+	args.Mapping.DefaultCategoryId = defaultCategoryId
+	args.Format = greed.DetectStatementFormat([]byte(args.Statement))
+
+	if args.Format != greed.StatementCsv {
+		entries, err := greed.ParseStatement(args.Format, strings.NewReader(args.Statement))
+		if err != nil {
+			return args, err
+		}
+
+		_, args.Rows, err = greed.PreviewStatementImport(db, userId, args.Account.Id, defaultCategoryId, entries)
+		return args, err
+	}
+
+	statement, err := greed.ReadCsvStatement(strings.NewReader(args.Statement))
+	if err != nil {
+		return args, err
+	}
+
+	args.Header = statement.Header
+
+	if c.FormValue("map_date") == "" {
+		args.Mapping = greed.GuessImportMapping(statement.Header)
+	} else {
+		args.Mapping = greed.ImportMapping{
+			Date:         c.FormValue("map_date"),
+			Amount:       c.FormValue("map_amount"),
+			Description:  c.FormValue("map_description"),
+			Category:     c.FormValue("map_category"),
+			ExternalId:   c.FormValue("map_external_id"),
+			DateLayout:   c.FormValue("date_layout"),
+			DecimalComma: c.FormValue("decimal_comma") == "true",
+		}
+	}
+	args.Mapping.DefaultCategoryId = defaultCategoryId
+
+	_, args.Rows, err = greed.PreviewCsvImport(db, userId, args.Account.Id, statement, args.Mapping)
+	return args, err
+}
+
+func renderImportPage(c echo.Context, db *sql.DB, errorMessage string) error {
+	accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+	if err != nil {
+		return err
+	}
+
+	categories, err := greed.GetCategories(db, currentUser(c).Id)
+	if err != nil {
+		return err
+	}
+
+	if len(accounts) == 0 && errorMessage == "" {
+		errorMessage = "create an account to import transactions into first"
+	}
+
+	return renderTempl(c, views.Page(views.ImportContent(accounts, greed.ActiveCategories(categories), errorMessage)))
+}
+
+func createImportEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/import", func(c echo.Context) error {
+		return renderImportPage(c, db, "")
+	})
+
+	app.POST("/import/preview", func(c echo.Context) error {
+		args, err := parseImportForm(c, db, currentUser(c).Id)
+		switch {
+		case errors.Is(err, greed.ErrInvalidImport) && args.Header == nil:
+			return renderImportPage(c, db, err.Error())
+		case errors.Is(err, greed.ErrInvalidImport):
+			return renderTempl(c, views.Page(views.ImportPreviewContent(args, err.Error())))
+		case err != nil:
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.ImportPreviewContent(args, "")))
+	})
+
+	// imports only the rows checked in the preview, duplicates are unchecked by default
+	app.POST("/import", func(c echo.Context) error {
+		args, err := parseImportForm(c, db, currentUser(c).Id)
+		switch {
+		case errors.Is(err, greed.ErrInvalidImport) && args.Header == nil:
+			return renderImportPage(c, db, err.Error())
+		case errors.Is(err, greed.ErrInvalidImport):
+			return renderTempl(c, views.Page(views.ImportPreviewContent(args, err.Error())))
+		case err != nil:
+			return err
+		}
+
+		if err := c.Request().ParseForm(); err != nil {
+			return err
+		}
+
+		selected := map[string]bool{}
+		for _, line := range c.Request().Form["row"] {
+			selected[line] = true
+		}
+
+		var rows []greed.ImportRow
+		for _, row := range args.Rows {
+			if selected[strconv.Itoa(row.Line)] {
+				rows = append(rows, row)
+			}
+		}
+
+		if len(rows) == 0 {
+			return renderTempl(c, views.Page(views.ImportPreviewContent(args, "nothing is selected to import")))
+		}
+
+		if _, err := greed.ImportTransactions(db, currentUser(c).Id, args.Account.Id, rows); errors.Is(err, greed.ErrInvalidImport) {
+			return renderTempl(c, views.Page(views.ImportPreviewContent(args, err.Error())))
+		} else if err != nil {
+			return err
+		}
+
+		return redirect(c, "/transactions")
+	})
+}
+
+// ImportPayload is a csv export with an optional mapping, guessed from the header if omitted,
+// or an OFX/QFX or camt.053 statement, the format is detected if omitted
+type ImportPayload struct {
+	AccountId         int64                 `json:"account_id"`
+	Csv               string                `json:"csv"`
+	Statement         string                `json:"statement"`
+	Format            greed.StatementFormat `json:"format"`
+	Mapping           *greed.ImportMapping  `json:"mapping"`
+	DefaultCategoryId int64                 `json:"default_category_id"`
+	IncludeDuplicates bool                  `json:"include_duplicates"`
+}
+
+func (p *ImportPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *ImportPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+type ImportPreview struct {
+	Account greed.Account       `json:"account"`
+	Mapping greed.ImportMapping `json:"mapping"`
+	Rows    []greed.ImportRow   `json:"rows"`
+}
+
+type ImportedTransactions struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+}
+
+func previewImportPayload(c echo.Context, db *sql.DB) (ImportPayload, ImportPreview, error) {
+	var payload ImportPayload
+	var preview ImportPreview
+
+	if err := bindJson(c, &payload); err != nil {
+		return payload, preview, err
+	}
+
+	if payload.Statement != "" {
+		format := payload.Format
+		if format == "" {
+			format = greed.DetectStatementFormat([]byte(payload.Statement))
+		}
+
+		entries, err := greed.ParseStatement(format, strings.NewReader(payload.Statement))
+		if err != nil {
+			return payload, preview, err
+		}
+
+		preview.Mapping.DefaultCategoryId = payload.DefaultCategoryId
+		preview.Account, preview.Rows, err = greed.PreviewStatementImport(db, currentUser(c).Id, payload.AccountId, payload.DefaultCategoryId, entries)
+		return payload, preview, err
+	}
+
+	statement, err := greed.ReadCsvStatement(strings.NewReader(payload.Csv))
+	if err != nil {
+		return payload, preview, err
+	}
+
+	if payload.Mapping != nil {
+		preview.Mapping = *payload.Mapping
+	} else {
+		preview.Mapping = greed.GuessImportMapping(statement.Header)
+	}
+
+	if payload.DefaultCategoryId != 0 {
+		preview.Mapping.DefaultCategoryId = payload.DefaultCategoryId
+	}
+
+	if preview.Mapping.DefaultCategoryId == 0 {
+		return payload, preview, echo.NewHTTPError(http.StatusBadRequest, "default_category_id is required")
+	}
+
+	preview.Account, preview.Rows, err = greed.PreviewCsvImport(db, currentUser(c).Id, payload.AccountId, statement, preview.Mapping)
+	return payload, preview, err
+}
+
+func createApiImportEndpoints(api *echo.Group, db *sql.DB) {
+	// parsed rows with duplicates and errors marked, nothing is stored
+	api.POST("/import/preview", func(c echo.Context) error {
+		_, preview, err := previewImportPayload(c, db)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, preview)
+	})
+
+	// fails if any row has an error, duplicates are skipped unless include_duplicates is set,
+	// rows with an already imported external id are skipped anyway
+	api.POST("/import", func(c echo.Context) error {
+		payload, preview, err := previewImportPayload(c, db)
+		if err != nil {
+			return err
+		}
+
+		var result ImportedTransactions
+		var rows []greed.ImportRow
+
+		for _, row := range preview.Rows {
+			if row.Duplicate {
+				result.Duplicates++
+				if !payload.IncludeDuplicates {
+					continue
+				}
+			}
+			rows = append(rows, row)
+		}
+
+		if result.Imported, err = greed.ImportTransactions(db, currentUser(c).Id, preview.Account.Id, rows); err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, result)
+	})
+}
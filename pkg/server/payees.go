@@ -0,0 +1,281 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+
+	"github.com/labstack/echo/v4"
+)
+
+// payees suggested while typing in the transaction form
+const payeeOptionsLimit = 10
+
+// payeeError turns validation errors into bad requests for the web forms
+func payeeError(err error) error {
+	if errors.Is(err, greed.ErrInvalidPayee) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// parsePayeeForm reads the payee from the PayeeForm inputs, the aliases are comma separated
+func parsePayeeForm(c echo.Context) greed.Payee {
+	return greed.Payee{
+		Name:    c.FormValue("name"),
+		Aliases: strings.Split(c.FormValue("aliases"), ","),
+	}
+}
+
+// formPayee reads the payee input of the transaction form: a name picks the payee or creates it,
+// an empty input leaves the payee to the description
+func formPayee(c echo.Context, tx *sql.Tx, userId int64, description string) (*greed.Payee, error) {
+	name := strings.TrimSpace(c.FormValue("payee"))
+	if name == "" {
+		return greed.ResolvePayee(tx, userId, description)
+	}
+
+	payee, err := greed.GetOrCreatePayee(tx, userId, name)
+	if err != nil {
+		return nil, payeeError(err)
+	}
+
+	return &payee, nil
+}
+
+func createPayeeEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/payees", func(c echo.Context) error {
+		payees, err := greed.GetPayees(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.PayeesContent(payees)))
+	})
+
+	app.GET("/payees/content", func(c echo.Context) error {
+		payees, err := greed.GetPayees(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Payees(payees))
+	})
+
+	app.GET("/payees/count", func(c echo.Context) error {
+		payees, err := greed.GetPayees(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return c.String(http.StatusOK, strconv.Itoa(len(payees)))
+	})
+
+	app.GET("/payees/new", func(c echo.Context) error {
+		return renderTempl(c, views.PayeeForm(greed.Payee{}, true))
+	})
+
+	app.GET("/payees/options", func(c echo.Context) error {
+		payees, err := greed.SearchPayees(db, currentUser(c).Id, c.QueryParam("payee"), payeeOptionsLimit)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.PayeeOptions(payees))
+	})
+
+	app.GET("/payees/:id", func(c echo.Context) error {
+		payeeId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		payee, err := greed.GetPayeeById(db, currentUser(c).Id, payeeId)
+		if err != nil {
+			return err
+		}
+
+		if c.QueryParam("edit") == "true" {
+			return renderTempl(c, views.PayeeForm(payee, false))
+		}
+
+		return renderTempl(c, views.Payee(payee))
+	})
+
+	app.POST("/payees", func(c echo.Context) error {
+		if _, err := greed.CreatePayee(db, currentUser(c).Id, parsePayeeForm(c)); err != nil {
+			return payeeError(err)
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	app.PUT("/payees/:id", func(c echo.Context) error {
+		payeeId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		p := parsePayeeForm(c)
+		p.Id = payeeId
+
+		payee, err := greed.UpdatePayee(db, currentUser(c).Id, p)
+		if err != nil {
+			return payeeError(err)
+		}
+
+		return renderTempl(c, views.Payee(payee))
+	})
+
+	app.DELETE("/payees/:id", func(c echo.Context) error {
+		payeeId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeletePayee(db, currentUser(c).Id, payeeId); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecountAnchor())
+	})
+
+	app.GET("/stats/payees", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		payeesSpent, err := greed.GetExpensesByPayee(db, currentUser(c).Id, dateRange)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.PayeesExpenses(payeesSpent))
+	})
+}
+
+type PayeePayload struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+func (p *PayeePayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *PayeePayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+func createApiPayeeEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/payees", func(c echo.Context) error {
+		limit := 0
+		if l := c.QueryParam("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid limit: %v", l))
+			}
+		}
+
+		// every payee matches an empty search
+		payees, err := greed.SearchPayees(db, currentUser(c).Id, c.QueryParam("search"), limit)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, payees)
+	})
+
+	api.GET("/payees/:id", func(c echo.Context) error {
+		payeeId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		payee, err := greed.GetPayeeById(db, currentUser(c).Id, payeeId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, payee)
+	})
+
+	api.POST("/payees", func(c echo.Context) error {
+		var payload PayeePayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		payee, err := greed.CreatePayee(db, currentUser(c).Id, greed.Payee{Name: payload.Name, Aliases: payload.Aliases})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, payee)
+	})
+
+	api.PUT("/payees/:id", func(c echo.Context) error {
+		payeeId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		old, err := greed.GetPayeeById(db, currentUser(c).Id, payeeId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		payload := PayeePayload{Name: old.Name, Aliases: old.Aliases}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		payee, err := greed.UpdatePayee(db, currentUser(c).Id, greed.Payee{Id: payeeId, Name: payload.Name, Aliases: payload.Aliases})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, payee)
+	})
+
+	api.DELETE("/payees/:id", func(c echo.Context) error {
+		payeeId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeletePayee(db, currentUser(c).Id, payeeId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	api.GET("/stats/payees", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		groupedPayeesSpent, err := greed.GetExpensesByPayee(db, currentUser(c).Id, dateRange)
+		if err != nil {
+			return err
+		}
+
+		// every item carries its currency like /stats/categories
+		payeesSpent := []greed.PayeeSpent{}
+		for _, pair := range groupedPayeesSpent {
+			payeesSpent = append(payeesSpent, pair.Second...)
+		}
+
+		return c.JSON(http.StatusOK, payeesSpent)
+	})
+}
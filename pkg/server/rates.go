@@ -0,0 +1,246 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// importRates stores the rates of the provider in a single db transaction
+func importRates(db *sql.DB, userId int64, provider greed.RateProvider) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count, err := greed.ImportRates(tx, userId, provider)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+func renderRatesPage(c echo.Context, db *sql.DB, errorMessage string) error {
+	rates, err := greed.GetExchangeRates(db, currentUser(c).Id)
+	if err != nil {
+		return err
+	}
+
+	return renderTempl(c, views.Page(views.RatesContent(rates, currentUser(c).ReportingCurrency, errorMessage)))
+}
+
+// isUserError tells apart invalid input from internal failures
+func isUserError(err error) bool {
+	return errors.Is(err, greed.ErrInvalidRate) || errors.Is(err, greed.ErrUnsupportedCurrency)
+}
+
+func createRateEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/rates", func(c echo.Context) error {
+		return renderRatesPage(c, db, "")
+	})
+
+	app.POST("/rates", func(c echo.Context) error {
+		validFrom, err := time.Parse(time.DateOnly, c.FormValue("date"))
+		if err != nil {
+			return renderRatesPage(c, db, fmt.Sprintf("invalid date: %v", c.FormValue("date")))
+		}
+
+		provider := greed.ManualRateProvider{Rates: []greed.ExchangeRate{{
+			Base:      c.FormValue("base"),
+			Quote:     c.FormValue("quote"),
+			Rate:      c.FormValue("rate"),
+			ValidFrom: validFrom,
+		}}}
+
+		if _, err := importRates(db, currentUser(c).Id, provider); isUserError(err) {
+			return renderRatesPage(c, db, err.Error())
+		} else if err != nil {
+			return err
+		}
+
+		return redirect(c, "/rates")
+	})
+
+	app.POST("/rates/import", func(c echo.Context) error {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return renderRatesPage(c, db, "choose a csv file to import")
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := importRates(db, currentUser(c).Id, greed.CsvRateProvider{Reader: file}); isUserError(err) {
+			return renderRatesPage(c, db, err.Error())
+		} else if err != nil {
+			return err
+		}
+
+		return redirect(c, "/rates")
+	})
+
+	app.DELETE("/rates/:id", func(c echo.Context) error {
+		rateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteExchangeRate(db, currentUser(c).Id, rateId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	})
+
+	app.PUT("/settings/reporting_currency", func(c echo.Context) error {
+		currency := c.FormValue("reporting_currency")
+
+		if err := greed.SetReportingCurrency(db, currentUser(c).Id, currency); err != nil {
+			if errors.Is(err, greed.ErrUnsupportedCurrency) {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	})
+}
+
+type SettingsPayload struct {
+	ReportingCurrency string `json:"reporting_currency"`
+}
+
+func (p *SettingsPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *SettingsPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+type ExchangeRatesPayload struct {
+	Rates []greed.ExchangeRate `json:"rates"`
+}
+
+func (p *ExchangeRatesPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *ExchangeRatesPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+type ImportedRates struct {
+	Imported int `json:"imported"`
+}
+
+func createApiRateEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/settings", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, SettingsPayload{ReportingCurrency: currentUser(c).ReportingCurrency})
+	})
+
+	api.PUT("/settings", func(c echo.Context) error {
+		payload := SettingsPayload{ReportingCurrency: currentUser(c).ReportingCurrency}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		if err := greed.SetReportingCurrency(db, currentUser(c).Id, payload.ReportingCurrency); err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, payload)
+	})
+
+	api.GET("/rates", func(c echo.Context) error {
+		rates, err := greed.GetExchangeRates(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if rates == nil {
+			rates = []greed.ExchangeRate{}
+		}
+
+		return c.JSON(http.StatusOK, rates)
+	})
+
+	// manually entered rates: {"rates": [{"base", "quote", "rate", "valid_from"}]}
+	api.POST("/rates", func(c echo.Context) error {
+		var payload ExchangeRatesPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		// a missing or misspelled rates field reads as no rates
+		if len(payload.Rates) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "rates are required")
+		}
+
+		count, err := importRates(db, currentUser(c).Id, greed.ManualRateProvider{Rates: payload.Rates})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, ImportedRates{Imported: count})
+	})
+
+	// csv file as the request body, see greed.CsvRateProvider for the format
+	api.POST("/rates/import", func(c echo.Context) error {
+		count, err := importRates(db, currentUser(c).Id, greed.CsvRateProvider{Reader: c.Request().Body})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, ImportedRates{Imported: count})
+	})
+
+	api.DELETE("/rates/:id", func(c echo.Context) error {
+		rateId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteExchangeRate(db, currentUser(c).Id, rateId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	api.GET("/stats/converted", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		currency := c.QueryParam("currency")
+		if currency == "" {
+			currency = currentUser(c).ReportingCurrency
+		}
+
+		if !greed.IsSupportedCurrency(currency) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unsupported currency: %v", currency))
+		}
+
+		stats, err := greed.GetConvertedStats(db, currentUser(c).Id, currency, dateRange)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, stats)
+	})
+}
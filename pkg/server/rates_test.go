@@ -0,0 +1,75 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	schema "supersolik/greed/migrations"
+	"supersolik/greed/pkg/greed"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCreateRates(t *testing.T) {
+	db, err := sql.Open("libsql", "file://"+filepath.Join(t.TempDir(), "greed.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := greed.LoadMigrations(schema.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := greed.MigrateUp(db, migrations, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := greed.CreateUser(db, "alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	api := e.Group("/v1", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(userContextKey, user)
+			return next(c)
+		}
+	})
+	createApiRateEndpoints(api, db)
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"rates": []}`, http.StatusBadRequest},
+		// misspelled field
+		{`{"rate": [{"base": "EUR", "quote": "USD", "rate": "1.1", "valid_from": "2026-01-01T00:00:00Z"}]}`, http.StatusBadRequest},
+		{`{"rates": [{"base": "EUR", "quote": "USD", "rate": "1.1", "valid_from": "2026-01-01T00:00:00Z"}]}`, http.StatusCreated},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/v1/rates", strings.NewReader(c.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != c.status {
+			t.Errorf("post %v = %v %v, want %v", c.body, rec.Code, rec.Body, c.status)
+		}
+	}
+
+	rates, err := greed.GetExchangeRates(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 1 {
+		t.Errorf("stored rates = %+v, want 1", rates)
+	}
+}
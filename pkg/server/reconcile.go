@@ -0,0 +1,272 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// parseReconcileForm reads the statement date, balance and adjustment category of the reconcile form,
+// the date defaults to today, an invalid balance is reported in args.Error
+func parseReconcileForm(c echo.Context, db *sql.DB, accountId int64) (views.ReconcileArgs, error) {
+	var args views.ReconcileArgs
+	userId := currentUser(c).Id
+
+	statementDate := time.Now().UTC()
+	if date := c.FormValue("date"); date != "" {
+		parsed, err := time.Parse(greed.DATE_INPUT_LAYOUT, date)
+		if err != nil {
+			return args, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid date: %v", date))
+		}
+		statementDate = parsed
+	}
+
+	summary, err := greed.GetReconcileSummary(db, userId, accountId, statementDate)
+	if err != nil {
+		return args, err
+	}
+	args.Summary = summary
+
+	if balance := c.FormValue("balance"); balance != "" {
+		parsed, err := greed.ParseCurrencyMoney(balance, summary.Account.Currency)
+		if err != nil {
+			args.Error = fmt.Sprintf("invalid statement balance: %v", balance)
+		} else if difference, err := summary.Difference(parsed); err != nil {
+			args.Error = fmt.Sprintf("invalid statement balance: %v", err)
+		} else {
+			args.StatementBalance = &parsed
+			args.Difference = &difference
+		}
+	}
+
+	categories, err := greed.GetCategories(db, userId)
+	if err != nil {
+		return args, err
+	}
+	args.Categories = greed.ActiveCategories(categories)
+
+	if category := c.FormValue("category"); category != "" {
+		if args.CategoryId, err = strconv.ParseInt(category, 10, 64); err != nil {
+			return args, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid category: %v", category))
+		}
+	}
+
+	return args, nil
+}
+
+func createReconcileEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/accounts/:id/reconcile", func(c echo.Context) error {
+		accountId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		args, err := parseReconcileForm(c, db, accountId)
+		if err != nil {
+			return err
+		}
+
+		if args.Reconciliations, err = greed.GetReconciliations(db, currentUser(c).Id, accountId); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.ReconcileContent(args)))
+	})
+
+	app.GET("/accounts/:id/reconcile/summary", func(c echo.Context) error {
+		accountId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		args, err := parseReconcileForm(c, db, accountId)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.ReconcileSummary(args))
+	})
+
+	// errors are shown next to the button, the page is reloaded with the history after reconciling
+	app.POST("/accounts/:id/reconcile", func(c echo.Context) error {
+		accountId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		args, err := parseReconcileForm(c, db, accountId)
+		if err != nil {
+			return err
+		}
+
+		if args.StatementBalance == nil && args.Error == "" {
+			args.Error = "enter the statement balance"
+		}
+		if args.Error != "" {
+			return renderTempl(c, views.FormError(args.Error))
+		}
+
+		if _, err := greed.ReconcileAccount(
+			db, currentUser(c).Id, accountId, args.Summary.StatementDate, *args.StatementBalance, greed.Category{Id: args.CategoryId},
+		); errors.Is(err, greed.ErrInvalidReconciliation) {
+			return renderTempl(c, views.FormError(err.Error()))
+		} else if err != nil {
+			return err
+		}
+
+		return redirect(c, fmt.Sprintf("/accounts/%v/reconcile?date=%v", accountId, args.Summary.StatementDate.Format(time.DateOnly)))
+	})
+
+	app.PUT("/transactions/:id/cleared", func(c echo.Context) error {
+		transactionId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.SetTransactionCleared(db, currentUser(c).Id, transactionId, c.FormValue("cleared") == "true"); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+}
+
+type ClearedPayload struct {
+	Cleared bool `json:"cleared"`
+}
+
+func (p *ClearedPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *ClearedPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+type ReconcilePayload struct {
+	// YYYY-MM-DD, the balance is at the end of the day
+	StatementDate    string      `json:"statement_date"`
+	StatementBalance greed.Money `json:"statement_balance"`
+	// required when the cleared balance differs from the statement
+	CategoryId int64 `json:"category_id"`
+}
+
+func (p *ReconcilePayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *ReconcilePayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+// ReconcileSummaryResponse is the summary with the difference to the statement balance when it's given
+type ReconcileSummaryResponse struct {
+	greed.ReconcileSummary
+	StatementBalance *greed.Money `json:"statement_balance,omitempty"`
+	Difference       *greed.Money `json:"difference,omitempty"`
+}
+
+func createApiReconcileEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/accounts/:id/reconcile", func(c echo.Context) error {
+		accountId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		date := c.QueryParam("date")
+		statementDate, err := time.Parse(greed.DATE_INPUT_LAYOUT, date)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid date: %v", date))
+		}
+
+		summary, err := greed.GetReconcileSummary(db, currentUser(c).Id, accountId, statementDate)
+		if err != nil {
+			return err
+		}
+
+		response := ReconcileSummaryResponse{ReconcileSummary: summary}
+
+		if balance := c.QueryParam("balance"); balance != "" {
+			statementBalance, err := greed.ParseCurrencyMoney(balance, summary.Account.Currency)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid balance: %v", balance))
+			}
+
+			difference, err := summary.Difference(statementBalance)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid balance: %v", err))
+			}
+			response.StatementBalance = &statementBalance
+			response.Difference = &difference
+		}
+
+		return c.JSON(http.StatusOK, response)
+	})
+
+	api.GET("/accounts/:id/reconciliations", func(c echo.Context) error {
+		accountId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		reconciliations, err := greed.GetReconciliations(db, currentUser(c).Id, accountId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, reconciliations)
+	})
+
+	api.POST("/accounts/:id/reconciliations", func(c echo.Context) error {
+		accountId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		var payload ReconcilePayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		statementDate, err := time.Parse(greed.DATE_INPUT_LAYOUT, payload.StatementDate)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid statement_date: %v", payload.StatementDate))
+		}
+
+		reconciliation, err := greed.ReconcileAccount(
+			db, currentUser(c).Id, accountId, statementDate, payload.StatementBalance, greed.Category{Id: payload.CategoryId},
+		)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, reconciliation)
+	})
+
+	api.PUT("/transactions/:id/cleared", func(c echo.Context) error {
+		transactionId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		var payload ClearedPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		transaction, err := greed.SetTransactionCleared(db, currentUser(c).Id, transactionId, payload.Cleared)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, transaction)
+	})
+}
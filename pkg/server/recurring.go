@@ -0,0 +1,582 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// number of upcoming occurrences listed for skipping
+const upcomingOccurrences = 6
+
+// recurringError turns validation errors into bad requests for the web forms
+func recurringError(err error) error {
+	if errors.Is(err, greed.ErrInvalidRecurring) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// parseRecurringForm reads the recurring transaction from the RecurringTransactionForm inputs,
+// the end date is inclusive in the timezone of the start
+func parseRecurringForm(c echo.Context, db *sql.DB, userId int64) (greed.RecurringTransaction, error) {
+	var r greed.RecurringTransaction
+
+	startsAt, err := parseFormDateTime(c)
+	if err != nil {
+		return r, err
+	}
+	r.StartsAt = startsAt
+
+	if ends := strings.TrimSpace(c.FormValue("ends")); ends != "" {
+		endDate, err := time.ParseInLocation(time.DateOnly, ends, startsAt.Location())
+		if err != nil {
+			return r, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid end date: %v", ends))
+		}
+		endsAt := endDate.AddDate(0, 0, 1).Add(-time.Second)
+		r.EndsAt = &endsAt
+	}
+
+	accountId, err := parseFormId(c, "account")
+	if err != nil {
+		return r, err
+	}
+
+	if r.Account, err = greed.GetAccountById(db, userId, accountId); err != nil {
+		return r, err
+	}
+
+	categoryId, err := parseFormId(c, "category")
+	if err != nil {
+		return r, err
+	}
+
+	if r.Category, err = greed.GetCategoryById(db, userId, categoryId); err != nil {
+		return r, err
+	}
+
+	if r.Amount, err = greed.ParseCurrencyMoney(c.FormValue("amount"), r.Account.Currency); err != nil {
+		return r, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if r.Every, err = strconv.Atoi(c.FormValue("every")); err != nil {
+		return r, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid every: %v", c.FormValue("every")))
+	}
+
+	r.Frequency = greed.RecurringFrequency(c.FormValue("frequency"))
+	r.Description = c.FormValue("description")
+
+	return r, nil
+}
+
+// postRecurring posts the occurrences already due so a schedule starting in the past shows up right away
+func postRecurring(db *sql.DB, userId int64, id int64) (greed.RecurringTransaction, error) {
+	if _, err := greed.PostRecurringTransaction(db, userId, id, time.Now().UTC()); err != nil {
+		return greed.RecurringTransaction{}, err
+	}
+
+	return greed.GetRecurringTransactionById(db, userId, id)
+}
+
+func createRecurringEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/recurring", func(c echo.Context) error {
+		recurring, err := greed.GetRecurringTransactions(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.RecurringTransactionsContent(recurring)))
+	})
+
+	app.GET("/recurring/content", func(c echo.Context) error {
+		recurring, err := greed.GetRecurringTransactions(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecurringTransactions(recurring))
+	})
+
+	app.GET("/recurring/count", func(c echo.Context) error {
+		recurring, err := greed.GetRecurringTransactions(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return c.String(http.StatusOK, strconv.Itoa(len(recurring)))
+	})
+
+	app.GET("/recurring/new", func(c echo.Context) error {
+		accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+		categories = greed.ActiveCategories(categories)
+
+		if len(accounts) == 0 || len(categories) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "create an account first")
+		}
+
+		r := greed.RecurringTransaction{
+			Account:   accounts[0],
+			Category:  categories[0],
+			Amount:    greed.ZeroMoney(accounts[0].Currency),
+			Frequency: greed.RecurringMonthly,
+			Every:     1,
+			StartsAt:  time.Now().UTC(),
+		}
+
+		return renderTempl(c, views.RecurringTransactionForm(r, accounts, categories, true))
+	})
+
+	app.GET("/recurring/:id", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		r, err := greed.GetRecurringTransactionById(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		if c.QueryParam("edit") == "true" {
+			accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			categories, err := greed.GetCategories(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			return renderTempl(c, views.RecurringTransactionForm(r, accounts, greed.ActiveCategories(categories, r.Category.Id), false))
+		}
+
+		return renderTempl(c, views.RecurringTransaction(r))
+	})
+
+	app.GET("/recurring/:id/upcoming", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		r, err := greed.GetRecurringTransactionById(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		occurrences, err := greed.GetUpcomingOccurrences(db, currentUser(c).Id, r, upcomingOccurrences)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecurringUpcoming(r, occurrences))
+	})
+
+	app.POST("/recurring", func(c echo.Context) error {
+		r, err := parseRecurringForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		created, err := greed.CreateRecurringTransaction(db, currentUser(c).Id, r)
+		if err != nil {
+			return recurringError(err)
+		}
+
+		if _, err := postRecurring(db, currentUser(c).Id, created.Id); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	app.PUT("/recurring/:id", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		r, err := parseRecurringForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		r.Id = recurringId
+
+		if _, err := greed.UpdateRecurringTransaction(db, currentUser(c).Id, r, time.Now().UTC()); err != nil {
+			return recurringError(err)
+		}
+
+		updated, err := postRecurring(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecurringTransaction(updated))
+	})
+
+	app.POST("/recurring/:id/pause", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		r, err := greed.SetRecurringTransactionPaused(db, currentUser(c).Id, recurringId, true, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecurringTransaction(r))
+	})
+
+	app.POST("/recurring/:id/resume", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.SetRecurringTransactionPaused(db, currentUser(c).Id, recurringId, false, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		r, err := postRecurring(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecurringTransaction(r))
+	})
+
+	app.POST("/recurring/:id/skip", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		at, err := time.Parse(time.RFC3339, c.FormValue("at"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid at: %v", c.FormValue("at")))
+		}
+
+		if err := greed.SkipRecurringOccurrence(db, currentUser(c).Id, recurringId, at, c.FormValue("skip") == "true"); err != nil {
+			return recurringError(err)
+		}
+
+		r, err := greed.GetRecurringTransactionById(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		occurrences, err := greed.GetUpcomingOccurrences(db, currentUser(c).Id, r, upcomingOccurrences)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecurringUpcoming(r, occurrences))
+	})
+
+	app.DELETE("/recurring/:id", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteRecurringTransaction(db, currentUser(c).Id, recurringId); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecountAnchor())
+	})
+}
+
+type RecurringPayload struct {
+	AccountId   int64                    `json:"account_id"`
+	CategoryId  int64                    `json:"category_id"`
+	Amount      greed.Money              `json:"amount"`
+	Description string                   `json:"description"`
+	Frequency   greed.RecurringFrequency `json:"frequency"`
+	Every       int                      `json:"every"`
+	// now if omitted
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+}
+
+func (p *RecurringPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *RecurringPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+// toRecurring resolves the account and category of the payload
+func (p *RecurringPayload) toRecurring(db *sql.DB, userId int64) (greed.RecurringTransaction, error) {
+	r := greed.RecurringTransaction{
+		Amount:      p.Amount,
+		Description: p.Description,
+		Frequency:   p.Frequency,
+		Every:       p.Every,
+		EndsAt:      p.EndsAt,
+	}
+
+	if r.Every == 0 {
+		r.Every = 1
+	}
+
+	if p.StartsAt != nil {
+		r.StartsAt = *p.StartsAt
+	} else {
+		r.StartsAt = time.Now().UTC()
+	}
+
+	var err error
+	if r.Account, err = payloadAccount(db, userId, p.AccountId); err != nil {
+		return r, err
+	}
+
+	r.Category, err = greed.GetCategoryById(db, userId, p.CategoryId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("category %v doesn't exist", p.CategoryId))
+	}
+
+	return r, err
+}
+
+type SkipPayload struct {
+	At time.Time `json:"at"`
+}
+
+func (p *SkipPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *SkipPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+func createApiRecurringEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/recurring", func(c echo.Context) error {
+		recurring, err := greed.GetRecurringTransactions(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if recurring == nil {
+			recurring = []greed.RecurringTransaction{}
+		}
+
+		return c.JSON(http.StatusOK, recurring)
+	})
+
+	api.GET("/recurring/:id", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		r, err := greed.GetRecurringTransactionById(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, r)
+	})
+
+	// ?n= upcoming occurrences, 10 by default
+	api.GET("/recurring/:id/occurrences", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		n := 10
+		if c.QueryParam("n") != "" {
+			if n, err = strconv.Atoi(c.QueryParam("n")); err != nil || n < 1 || n > 1000 {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid n: %v", c.QueryParam("n")))
+			}
+		}
+
+		r, err := greed.GetRecurringTransactionById(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		occurrences, err := greed.GetUpcomingOccurrences(db, currentUser(c).Id, r, n)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, occurrences)
+	})
+
+	// occurrences due already, starts_at in the past included, are posted right away
+	api.POST("/recurring", func(c echo.Context) error {
+		var payload RecurringPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		r, err := payload.toRecurring(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		created, err := greed.CreateRecurringTransaction(db, currentUser(c).Id, r)
+		if err != nil {
+			return err
+		}
+
+		posted, err := postRecurring(db, currentUser(c).Id, created.Id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, posted)
+	})
+
+	api.PUT("/recurring/:id", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		old, err := greed.GetRecurringTransactionById(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		payload := RecurringPayload{
+			AccountId:   old.Account.Id,
+			CategoryId:  old.Category.Id,
+			Amount:      old.Amount,
+			Description: old.Description,
+			Frequency:   old.Frequency,
+			Every:       old.Every,
+			StartsAt:    &old.StartsAt,
+			EndsAt:      old.EndsAt,
+		}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		r, err := payload.toRecurring(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		r.Id = recurringId
+
+		if _, err := greed.UpdateRecurringTransaction(db, currentUser(c).Id, r, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		updated, err := postRecurring(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, updated)
+	})
+
+	api.POST("/recurring/:id/pause", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		r, err := greed.SetRecurringTransactionPaused(db, currentUser(c).Id, recurringId, true, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, r)
+	})
+
+	// occurrences that fell into the pause are not posted
+	api.POST("/recurring/:id/resume", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.SetRecurringTransactionPaused(db, currentUser(c).Id, recurringId, false, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		r, err := postRecurring(db, currentUser(c).Id, recurringId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, r)
+	})
+
+	api.POST("/recurring/:id/skip", func(c echo.Context) error {
+		return skipOccurrence(c, db, true)
+	})
+
+	api.POST("/recurring/:id/unskip", func(c echo.Context) error {
+		return skipOccurrence(c, db, false)
+	})
+
+	api.DELETE("/recurring/:id", func(c echo.Context) error {
+		recurringId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteRecurringTransaction(db, currentUser(c).Id, recurringId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// skipOccurrence marks the occurrence of the SkipPayload and responds with the upcoming occurrences
+func skipOccurrence(c echo.Context, db *sql.DB, skip bool) error {
+	recurringId, err := parseIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	var payload SkipPayload
+	if err := bindJson(c, &payload); err != nil {
+		return err
+	}
+
+	if err := greed.SkipRecurringOccurrence(db, currentUser(c).Id, recurringId, payload.At, skip); err != nil {
+		return err
+	}
+
+	r, err := greed.GetRecurringTransactionById(db, currentUser(c).Id, recurringId)
+	if err != nil {
+		return err
+	}
+
+	occurrences, err := greed.GetUpcomingOccurrences(db, currentUser(c).Id, r, 10)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, occurrences)
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ruleError turns validation errors into bad requests for the web forms
+func ruleError(err error) error {
+	if errors.Is(err, greed.ErrInvalidRule) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// parseRuleAmount reads an optional amount bound, empty for no bound
+func parseRuleAmount(c echo.Context, name string) (*greed.Money, error) {
+	value := strings.TrimSpace(c.FormValue(name))
+	if value == "" {
+		return nil, nil
+	}
+
+	amount, err := greed.ParseExactMoney(value)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %v: %v", name, err))
+	}
+
+	return &amount, nil
+}
+
+// parseRuleForm reads the rule from the RuleForm inputs
+func parseRuleForm(c echo.Context, db *sql.DB, userId int64) (greed.Rule, error) {
+	r := greed.Rule{
+		Name:                c.FormValue("name"),
+		DescriptionContains: c.FormValue("description_contains"),
+		DescriptionRegex:    c.FormValue("description_regex"),
+		Description:         c.FormValue("description"),
+	}
+
+	if position := strings.TrimSpace(c.FormValue("position")); position != "" {
+		var err error
+		if r.Position, err = strconv.Atoi(position); err != nil {
+			return r, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid position: %v", position))
+		}
+	}
+
+	if c.FormValue("account") != "" {
+		accountId, err := parseFormId(c, "account")
+		if err != nil {
+			return r, err
+		}
+
+		account, err := greed.GetAccountById(db, userId, accountId)
+		if err != nil {
+			return r, err
+		}
+		r.Account = &account
+	}
+
+	if c.FormValue("category") != "" {
+		categoryId, err := parseFormId(c, "category")
+		if err != nil {
+			return r, err
+		}
+
+		category, err := greed.GetCategoryById(db, userId, categoryId)
+		if err != nil {
+			return r, err
+		}
+		r.Category = &category
+	}
+
+	var err error
+	if r.AmountMin, err = parseRuleAmount(c, "amount_min"); err != nil {
+		return r, err
+	}
+	if r.AmountMax, err = parseRuleAmount(c, "amount_max"); err != nil {
+		return r, err
+	}
+
+	formValues, err := c.FormParams()
+	if err != nil {
+		return r, err
+	}
+
+	for _, day := range formValues["weekdays"] {
+		d, err := strconv.Atoi(day)
+		if err != nil {
+			return r, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid weekday: %v", day))
+		}
+		r.Weekdays = append(r.Weekdays, time.Weekday(d))
+	}
+
+	if r.Tags, err = greed.ParseTagNames(c.FormValue("tags")); err != nil {
+		return r, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return r, nil
+}
+
+// parseRuleIdQuery reads the optional ?rule_id= limiting re-applying to one rule, 0 for all the rules
+func parseRuleIdQuery(c echo.Context) (int64, error) {
+	ruleId := c.QueryParam("rule_id")
+	if ruleId == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(ruleId, 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid rule_id: %v", ruleId))
+	}
+
+	return id, nil
+}
+
+func createRuleEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/rules", func(c echo.Context) error {
+		rules, err := greed.GetRules(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.RulesContent(rules)))
+	})
+
+	app.GET("/rules/content", func(c echo.Context) error {
+		rules, err := greed.GetRules(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Rules(rules))
+	})
+
+	app.GET("/rules/count", func(c echo.Context) error {
+		rules, err := greed.GetRules(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return c.String(http.StatusOK, strconv.Itoa(len(rules)))
+	})
+
+	app.GET("/rules/new", func(c echo.Context) error {
+		accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RuleForm(greed.Rule{}, accounts, greed.ActiveCategories(categories), true))
+	})
+
+	app.GET("/rules/preview", func(c echo.Context) error {
+		ruleId, err := parseRuleIdQuery(c)
+		if err != nil {
+			return err
+		}
+
+		changes, err := greed.PreviewRules(db, currentUser(c).Id, ruleId)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RulesPreview(changes, ruleId))
+	})
+
+	app.POST("/rules/apply", func(c echo.Context) error {
+		ruleId, err := parseRuleIdQuery(c)
+		if err != nil {
+			return err
+		}
+
+		changes, err := greed.ReapplyRules(db, currentUser(c).Id, ruleId)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RulesApplied(changes))
+	})
+
+	app.GET("/rules/:id", func(c echo.Context) error {
+		ruleId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		rule, err := greed.GetRuleById(db, currentUser(c).Id, ruleId)
+		if err != nil {
+			return err
+		}
+
+		if c.QueryParam("edit") == "true" {
+			accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			categories, err := greed.GetCategories(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			var keep []int64
+			if rule.Category != nil {
+				keep = append(keep, rule.Category.Id)
+			}
+
+			return renderTempl(c, views.RuleForm(rule, accounts, greed.ActiveCategories(categories, keep...), false))
+		}
+
+		return renderTempl(c, views.Rule(rule))
+	})
+
+	app.POST("/rules", func(c echo.Context) error {
+		r, err := parseRuleForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.CreateRule(db, currentUser(c).Id, r); err != nil {
+			return ruleError(err)
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	app.PUT("/rules/:id", func(c echo.Context) error {
+		ruleId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		r, err := parseRuleForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		r.Id = ruleId
+
+		rule, err := greed.UpdateRule(db, currentUser(c).Id, r)
+		if err != nil {
+			return ruleError(err)
+		}
+
+		return renderTempl(c, views.Rule(rule))
+	})
+
+	app.DELETE("/rules/:id", func(c echo.Context) error {
+		ruleId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteRule(db, currentUser(c).Id, ruleId); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecountAnchor())
+	})
+}
+
+type RulePayload struct {
+	Name string `json:"name"`
+	// 0 puts a new rule after the existing ones
+	Position            int    `json:"position"`
+	DescriptionContains string `json:"description_contains"`
+	DescriptionRegex    string `json:"description_regex"`
+	// 0 for any account
+	AccountId int64          `json:"account_id"`
+	AmountMin *greed.Money   `json:"amount_min"`
+	AmountMax *greed.Money   `json:"amount_max"`
+	Weekdays  []time.Weekday `json:"weekdays"`
+	// 0 keeps the category
+	CategoryId  int64    `json:"category_id"`
+	Tags        []string `json:"tags"`
+	Description string   `json:"description"`
+}
+
+func (p *RulePayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *RulePayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+// toRule resolves account and category of the payload
+func (p *RulePayload) toRule(db *sql.DB, userId int64) (greed.Rule, error) {
+	r := greed.Rule{
+		Name:                p.Name,
+		Position:            p.Position,
+		DescriptionContains: p.DescriptionContains,
+		DescriptionRegex:    p.DescriptionRegex,
+		AmountMin:           p.AmountMin,
+		AmountMax:           p.AmountMax,
+		Weekdays:            p.Weekdays,
+		Tags:                p.Tags,
+		Description:         p.Description,
+	}
+
+	if p.AccountId != 0 {
+		account, err := greed.GetAccountById(db, userId, p.AccountId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return r, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("account %v doesn't exist", p.AccountId))
+		} else if err != nil {
+			return r, err
+		}
+		r.Account = &account
+	}
+
+	if p.CategoryId != 0 {
+		category, err := greed.GetCategoryById(db, userId, p.CategoryId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return r, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("category %v doesn't exist", p.CategoryId))
+		} else if err != nil {
+			return r, err
+		}
+		r.Category = &category
+	}
+
+	return r, nil
+}
+
+func createApiRuleEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/rules", func(c echo.Context) error {
+		rules, err := greed.GetRules(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if rules == nil {
+			rules = []greed.Rule{}
+		}
+
+		return c.JSON(http.StatusOK, rules)
+	})
+
+	api.GET("/rules/preview", func(c echo.Context) error {
+		ruleId, err := parseRuleIdQuery(c)
+		if err != nil {
+			return err
+		}
+
+		changes, err := greed.PreviewRules(db, currentUser(c).Id, ruleId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, changes)
+	})
+
+	api.POST("/rules/apply", func(c echo.Context) error {
+		ruleId, err := parseRuleIdQuery(c)
+		if err != nil {
+			return err
+		}
+
+		changes, err := greed.ReapplyRules(db, currentUser(c).Id, ruleId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, changes)
+	})
+
+	api.GET("/rules/:id", func(c echo.Context) error {
+		ruleId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		rule, err := greed.GetRuleById(db, currentUser(c).Id, ruleId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, rule)
+	})
+
+	api.POST("/rules", func(c echo.Context) error {
+		var payload RulePayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		r, err := payload.toRule(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		rule, err := greed.CreateRule(db, currentUser(c).Id, r)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, rule)
+	})
+
+	api.PUT("/rules/:id", func(c echo.Context) error {
+		ruleId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		old, err := greed.GetRuleById(db, currentUser(c).Id, ruleId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		payload := RulePayload{
+			Name:                old.Name,
+			Position:            old.Position,
+			DescriptionContains: old.DescriptionContains,
+			DescriptionRegex:    old.DescriptionRegex,
+			AmountMin:           old.AmountMin,
+			AmountMax:           old.AmountMax,
+			Weekdays:            old.Weekdays,
+			Tags:                old.Tags,
+			Description:         old.Description,
+		}
+		if old.Account != nil {
+			payload.AccountId = old.Account.Id
+		}
+		if old.Category != nil {
+			payload.CategoryId = old.Category.Id
+		}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		r, err := payload.toRule(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		r.Id = ruleId
+
+		rule, err := greed.UpdateRule(db, currentUser(c).Id, r)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, rule)
+	})
+
+	api.DELETE("/rules/:id", func(c echo.Context) error {
+		ruleId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteRule(db, currentUser(c).Id, ruleId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+}
@@ -3,8 +3,8 @@ package server
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"math/big"
 	"net/http"
 	"strconv"
 	"strings"
@@ -22,8 +22,123 @@ func renderTempl(c echo.Context, t templ.Component) error {
 	return t.Render(context.Background(), c.Response().Writer)
 }
 
+// parseDateRange reads date_start and date_end (inclusive) query params
+func parseDateRange(c echo.Context) (greed.DateRange, error) {
+	dateStart := c.QueryParam("date_start")
+	dateEnd := c.QueryParam("date_end")
+
+	var dateRange greed.DateRange
+
+	if dateStart != "" {
+		parsedDateStart, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateStart)
+		if err != nil {
+			return dateRange, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid date_start: %v", dateStart))
+		}
+		dateRange.DateStart = parsedDateStart.UTC()
+	}
+
+	if dateEnd != "" {
+		parsedDateEnd, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateEnd)
+		if err != nil {
+			return dateRange, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid date_end: %v", dateEnd))
+		}
+		// end date is exclusive in sql, so we need to add 1 day to include the end date itself
+		dateRange.DateEnd = parsedDateEnd.AddDate(0, 0, 1).UTC()
+	}
+
+	return dateRange, nil
+}
+
+// parseOpeningDate reads the opening_date input of the account form, now when it's empty
+func parseOpeningDate(c echo.Context) (time.Time, error) {
+	openingDate := c.FormValue("opening_date")
+	if openingDate == "" {
+		return time.Now().UTC(), nil
+	}
+
+	parsed, err := time.Parse(greed.DATE_INPUT_LAYOUT, openingDate)
+	if err != nil {
+		return parsed, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid opening_date: %v", openingDate))
+	}
+
+	return parsed, nil
+}
+
+// searchError shows query errors next to the search box and keeps the listed transactions
+func searchError(c echo.Context, err error) error {
+	if errors.Is(err, greed.ErrInvalidQuery) {
+		c.Response().Header().Set("HX-Retarget", "#search-error")
+		c.Response().Header().Set("HX-Reswap", "innerHTML")
+		return renderTempl(c, views.FormError(err.Error()))
+	}
+	return err
+}
+
+// parseTransactionFilter reads the transactions filter from query params
+func parseTransactionFilter(c echo.Context) (greed.TransactionFilter, error) {
+	var filter greed.TransactionFilter
+
+	cursorParam := c.QueryParam("cursor")
+	pageSizeParam := c.QueryParam("size")
+	search := c.QueryParam("search")
+	filterExpense := c.QueryParam("expense") == "true"
+	filterIncome := c.QueryParam("income") == "true"
+
+	if filterExpense != filterIncome {
+		// either one of them provided  (both not empty, both not true)
+		filter.FilterIncome = filterIncome
+		filter.FilterExpense = filterExpense
+	}
+
+	// parse cursor, the first page has none
+	if cursorParam != "" {
+		cursor, err := greed.ParseTransactionCursor(cursorParam)
+
+		if err != nil {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		filter.Cursor = &cursor
+	}
+
+	// parse page size
+	if pageSizeParam != "" {
+		pageSize, err := strconv.ParseUint(pageSizeParam, 10, 64)
+
+		if err != nil {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid size: %v", pageSizeParam))
+		}
+
+		filter.PageSize = pageSize
+	} else {
+		filter.PageSize = greed.DefaultPageSize
+	}
+
+	if search != "" {
+		filter.Search = search
+	}
+
+	dateRange, err := parseDateRange(c)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.DateRange = dateRange
+
+	tags, err := greed.ParseTagNames(c.QueryParam("tags"))
+	if err != nil {
+		return filter, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	filter.Tags = tags
+
+	return filter, nil
+}
+
 func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
-	e.GET("/", func(c echo.Context) error {
+	app := e.Group("", sessionAuth(db))
+
+	app.GET("/", func(c echo.Context) error {
 		var stats greed.Stats
 		defaultRangeType := greed.Last30Days
 		defaultDateRange, err := greed.GetDateRange(defaultRangeType)
@@ -31,91 +146,126 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 			return err
 		}
 
-		if categoriesSpent, err := greed.GetExpensesByCategory(db, defaultDateRange); err != nil {
+		if categoriesSpent, err := greed.GetExpensesByCategory(db, currentUser(c).Id, defaultDateRange); err != nil {
 			return err
 		} else {
 			stats.CategoriesSpent = categoriesSpent
 		}
 
-		if cashFlow, err := greed.GetCashFlow(db, defaultDateRange); err != nil {
+		if tagsSpent, err := greed.GetExpensesByTag(db, currentUser(c).Id, defaultDateRange); err != nil {
+			return err
+		} else {
+			stats.TagsSpent = tagsSpent
+		}
+
+		if payeesSpent, err := greed.GetExpensesByPayee(db, currentUser(c).Id, defaultDateRange); err != nil {
+			return err
+		} else {
+			stats.PayeesSpent = payeesSpent
+		}
+
+		if cashFlow, err := greed.GetCashFlow(db, currentUser(c).Id, defaultDateRange); err != nil {
 			return err
 		} else {
 			stats.CashFlow = cashFlow
 		}
 
-		if balance, err := greed.GetBalance(db); err != nil {
+		trendRange := greed.BucketMonth.Last(statsTrendPeriods, time.Now().UTC())
+
+		if cashFlowTrend, err := greed.GetCashFlowTrend(db, currentUser(c).Id, greed.BucketMonth, trendRange); err != nil {
 			return err
 		} else {
-			stats.Balance = balance
+			stats.CashFlowTrend = cashFlowTrend
 		}
 
-		return renderTempl(c, views.Page(views.StatsContent(stats, defaultRangeType)))
-	})
+		if categoriesTrend, err := greed.GetExpensesByCategoryTrend(db, currentUser(c).Id, greed.BucketMonth, trendRange); err != nil {
+			return err
+		} else {
+			stats.CategoriesTrend = categoriesTrend
+		}
 
-	e.GET("/stats/categories", func(c echo.Context) error {
-		dateStart := c.QueryParam("date_start")
-		dateEnd := c.QueryParam("date_end")
+		chartDateRange := chartRange(defaultDateRange)
 
-		var dateRange greed.DateRange
+		if cashFlowByMonth, err := greed.GetCashFlowTrend(db, currentUser(c).Id, greed.BucketMonth, chartDateRange); err != nil {
+			return err
+		} else {
+			stats.CashFlowByMonth = cashFlowByMonth
+		}
 
-		if dateStart != "" {
-			parsedDateStart, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateStart)
-			if err != nil {
-				return err
-			}
-			dateRange.DateStart = parsedDateStart.UTC()
+		stats.BalanceBucket = greed.ChartBucket(chartDateRange)
+
+		if balanceTrend, err := greed.GetBalanceTrend(db, currentUser(c).Id, stats.BalanceBucket, chartDateRange); err != nil {
+			return err
+		} else {
+			stats.BalanceTrend = balanceTrend
 		}
 
-		if dateEnd != "" {
-			parsedDateEnd, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateEnd)
-			if err != nil {
-				return err
-			}
-			// end date is exclusive in sql, so we need to add 1 day to include the end date itself
-			dateRange.DateEnd = parsedDateEnd.AddDate(0, 0, 1).UTC()
+		if balance, err := greed.GetBalance(db, currentUser(c).Id); err != nil {
+			return err
+		} else {
+			stats.Balance = balance
 		}
 
-		categoriesSpent, err := greed.GetExpensesByCategory(db, dateRange)
+		if converted, err := greed.GetConvertedStats(db, currentUser(c).Id, currentUser(c).ReportingCurrency, defaultDateRange); err != nil {
+			return err
+		} else {
+			stats.Converted = converted
+		}
 
-		if err != nil {
+		if budgets, err := greed.GetBudgetsProgress(db, currentUser(c).Id, time.Now().UTC()); err != nil {
 			return err
+		} else {
+			stats.Budgets = budgets
 		}
 
-		return renderTempl(c, views.CategoriesExpenses(categoriesSpent))
+		return renderTempl(c, views.Page(views.StatsContent(stats, defaultRangeType)))
 	})
 
-	e.GET("/stats/cashflow", func(c echo.Context) error {
-		dateStart := c.QueryParam("date_start")
-		dateEnd := c.QueryParam("date_end")
+	app.GET("/stats/categories", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
 
-		var dateRange greed.DateRange
+		categoriesSpent, err := greed.GetExpensesByCategory(db, currentUser(c).Id, dateRange)
 
-		if dateStart != "" {
-			parsedDateStart, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateStart)
-			if err != nil {
-				return err
-			}
-			dateRange.DateStart = parsedDateStart.UTC()
+		if err != nil {
+			return err
 		}
 
-		if dateEnd != "" {
-			parsedDateEnd, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateEnd)
-			if err != nil {
-				return err
-			}
-			// end date is exclusive in sql, so we need to add 1 day to include the end date itself
-			dateRange.DateEnd = parsedDateEnd.AddDate(0, 0, 1).UTC()
+		converted, err := greed.GetConvertedStats(db, currentUser(c).Id, currentUser(c).ReportingCurrency, dateRange)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.CategoriesExpenses(categoriesSpent, converted.CategoriesSpent, currentUser(c).ReportingCurrency))
+	})
+
+	app.GET("/stats/cashflow", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		converted, err := greed.GetConvertedStats(db, currentUser(c).Id, currentUser(c).ReportingCurrency, dateRange)
+		if err != nil {
+			return err
+		}
+
+		cashFlowByMonth, err := greed.GetCashFlowTrend(db, currentUser(c).Id, greed.BucketMonth, chartRange(dateRange))
+		if err != nil {
+			return trendError(err)
 		}
 
-		if cashFlow, err := greed.GetCashFlow(db, dateRange); err != nil {
+		if cashFlow, err := greed.GetCashFlow(db, currentUser(c).Id, dateRange); err != nil {
 			return err
 		} else {
-			return renderTempl(c, views.CashFlow(cashFlow))
+			return renderTempl(c, views.CashFlow(cashFlow, converted.CashFlow, cashFlowByMonth))
 		}
 	})
 
-	e.GET("/accounts", func(c echo.Context) error {
-		accounts, err := greed.GetAccounts(db)
+	app.GET("/accounts", func(c echo.Context) error {
+		accounts, err := greed.GetAccounts(db, currentUser(c).Id)
 
 		if err != nil {
 			return err
@@ -126,8 +276,8 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 		))
 	})
 
-	e.GET("/accounts/count", func(c echo.Context) error {
-		count, err := greed.CountAccounts(db)
+	app.GET("/accounts/count", func(c echo.Context) error {
+		count, err := greed.CountAccounts(db, currentUser(c).Id)
 
 		if err != nil {
 			return err
@@ -136,7 +286,7 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 		return c.String(http.StatusOK, strconv.FormatInt(count, 10))
 	})
 
-	e.GET("/accounts/:id", func(c echo.Context) error {
+	app.GET("/accounts/:id", func(c echo.Context) error {
 		accountId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 
 		if err != nil {
@@ -153,7 +303,7 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 			edit = false
 		}
 
-		account, err := greed.GetAccountById(db, accountId)
+		account, err := greed.GetAccountById(db, currentUser(c).Id, accountId)
 
 		if err != nil {
 			return err
@@ -166,59 +316,84 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 		return renderTempl(c, views.Account(account))
 	})
 
-	e.POST("/accounts", func(c echo.Context) error {
+	app.POST("/accounts", func(c echo.Context) error {
 		accountName := c.FormValue("account_name")
 		currency := c.FormValue("currency")
 		description := c.FormValue("description")
-		parsedAmount, err := greed.ParseBigFloat(c.FormValue("amount"))
+		parsedAmount, err := greed.ParseCurrencyMoney(c.FormValue("opening_amount"), currency)
 
 		if err != nil {
 			return err
 		}
 
-		if account, err := greed.CreateAccount(db, accountName, parsedAmount, currency, description); err != nil {
+		openingDate, err := parseOpeningDate(c)
+
+		if err != nil {
+			return err
+		}
+
+		if account, err := greed.CreateAccount(db, currentUser(c).Id, accountName, parsedAmount, openingDate, currency, description); err != nil {
 			return err
 		} else {
 			return renderTempl(c, views.Account(account))
 		}
 	})
 
-	e.PUT("/accounts/:id", func(c echo.Context) error {
+	app.PUT("/accounts/:id", func(c echo.Context) error {
 		accountId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 
 		if err != nil {
 			return err
 		}
 
-		account, err := greed.GetAccountById(db, accountId)
+		account, err := greed.GetAccountById(db, currentUser(c).Id, accountId)
+
+		if err != nil {
+			return err
+		}
 
-		parsedAmount, err := greed.ParseBigFloat(c.FormValue("amount"))
+		parsedAmount, err := greed.ParseCurrencyMoney(c.FormValue("opening_amount"), account.Currency)
+
+		if err != nil {
+			return err
+		}
+
+		openingDate, err := parseOpeningDate(c)
 
 		if err != nil {
 			return err
 		}
 
 		account.Name = c.FormValue("account_name")
-		account.Amount = parsedAmount
+		account.OpeningAmount = parsedAmount
+		// the form edits the day only, the stored time of day stays
+		if openingDate.Format(time.DateOnly) != account.OpeningDate.UTC().Format(time.DateOnly) {
+			account.OpeningDate = openingDate
+		}
 		account.Description = c.FormValue("description")
 
-		_, err = greed.UpdateAccount(db, account)
+		_, err = greed.UpdateAccount(db, currentUser(c).Id, account)
 
 		if err != nil {
 			return err
 		}
 
+		// the balance follows the opening amount
+		if account, err = greed.GetAccountById(db, currentUser(c).Id, accountId); err != nil {
+			return err
+		}
+
 		return renderTempl(c, views.Account(account))
 	})
 
-	e.DELETE("/accounts/:id", func(c echo.Context) error {
+	app.DELETE("/accounts/:id", func(c echo.Context) error {
 		accountId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 
 		if err != nil {
 			return err
 		}
 
-		err = greed.DeleteAccount(db, accountId)
+		err = greed.DeleteAccount(db, currentUser(c).Id, accountId)
 
 		if err != nil {
 			return err
@@ -227,87 +402,29 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 		return renderTempl(c, views.RecountAnchor())
 	})
 
-	e.GET("/accounts/new", func(c echo.Context) error {
+	app.GET("/accounts/new", func(c echo.Context) error {
 		return renderTempl(c, views.AccountForm(greed.Account{}, true))
 	})
 
-	e.GET("/transactions/content", func(c echo.Context) error {
-		var filter greed.TransactionFilter
-
-		pageParam := c.QueryParam("page")
-		pageSizeParam := c.QueryParam("size")
-		search := c.QueryParam("search")
-		dateStart := c.QueryParam("date_start")
-		dateEnd := c.QueryParam("date_end")
-		filterExpense := c.QueryParam("expense") == "true"
-		filterIncome := c.QueryParam("income") == "true"
-
-		if filterExpense != filterIncome {
-			// either one of them provided  (both not empty, both not true)
-			filter.FilterIncome = filterIncome
-			filter.FilterExpense = filterExpense
-		}
-
-		// parse page
-		if pageParam != "" {
-			page, err := strconv.ParseUint(pageParam, 10, 64)
-
-			if err != nil {
-				return err
-			}
-
-			filter.Page = page
-		} else {
-			filter.Page = 0
-		}
-
-		// parse page size
-		if pageSizeParam != "" {
-			pageSize, err := strconv.ParseUint(pageSizeParam, 10, 64)
-
-			if err != nil {
-				return err
-			}
-
-			filter.PageSize = pageSize
-		} else {
-			filter.PageSize = greed.DefaultPageSize
-		}
-
-		if search != "" {
-			filter.Search = search
-		}
-
-		if dateStart != "" {
-			parsedDateStart, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateStart)
-			if err != nil {
-				return err
-			}
-			filter.DateRange.DateStart = parsedDateStart.UTC()
-		}
-
-		if dateEnd != "" {
-			parsedDateEnd, err := time.Parse(greed.DATE_INPUT_LAYOUT, dateEnd)
-			if err != nil {
-				return err
-			}
-			// end date is exclusive in sql, so we need to add 1 day to include the end date itself
-			filter.DateRange.DateEnd = parsedDateEnd.AddDate(0, 0, 1).UTC()
+	app.GET("/transactions/content", func(c echo.Context) error {
+		filter, err := parseTransactionFilter(c)
+		if err != nil {
+			return err
 		}
 
-		transactions, err := greed.GetTransactions(db, filter)
+		transactions, err := greed.GetTransactions(db, currentUser(c).Id, filter)
 
 		if err != nil {
-			return err
+			return searchError(c, err)
 		}
 
 		return renderTempl(c, views.Transactions(transactions, filter))
 	})
 
-	e.GET("/transactions", func(c echo.Context) error {
+	app.GET("/transactions", func(c echo.Context) error {
 		initFilter := greed.TransactionFilterDefault()
 
-		transactions, err := greed.GetTransactions(db, initFilter)
+		transactions, err := greed.GetTransactions(db, currentUser(c).Id, initFilter)
 
 		if err != nil {
 			return err
@@ -318,8 +435,8 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 		))
 	})
 
-	e.GET("/transactions/count", func(c echo.Context) error {
-		count, err := greed.CountTransactions(db)
+	app.GET("/transactions/count", func(c echo.Context) error {
+		count, err := greed.CountTransactions(db, currentUser(c).Id)
 
 		if err != nil {
 			return err
@@ -328,7 +445,7 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 		return c.String(http.StatusOK, strconv.FormatInt(count, 10))
 	})
 
-	e.POST("/transactions", func(c echo.Context) error {
+	app.POST("/transactions", func(c echo.Context) error {
 		formValues, err := c.FormParams()
 
 		if err != nil {
@@ -363,13 +480,6 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 
 		log.Printf("Parsed  time: %v\n", createdAt)
 
-		amount := c.FormValue("amount")
-		parsedAmount, _, err := big.ParseFloat(amount, 10, 53, big.ToNearestEven)
-
-		if err != nil {
-			return err
-		}
-
 		accountData := strings.Split(c.FormValue("account"), ";")
 		if len(accountData) != 2 {
 			log.Printf("[ERROR] failed to parse account data: %s", c.FormValue("account"))
@@ -387,28 +497,107 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 			return err
 		}
 
+		account, err := greed.GetAccountById(db, currentUser(c).Id, accountId)
+		if err != nil {
+			return err
+		}
+
+		parsedAmount, err := greed.ParseCurrencyMoney(c.FormValue("amount"), account.Currency)
+		if err != nil {
+			return err
+		}
+
 		categoryId, err := strconv.ParseInt(categoryData[0], 10, 64)
 		if err != nil {
 			return err
 		}
 
-		description := c.FormValue("description")
+		tags, err := greed.ParseTagNames(c.FormValue("tags"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		// the rules fill in the category when it's left to ~auto
+		t := greed.Transaction{
+			Account:     account,
+			Amount:      parsedAmount,
+			Category:    greed.Category{Id: categoryId, Name: categoryData[1]},
+			CreatedAt:   createdAt,
+			Description: c.FormValue("description"),
+		}
+		for _, name := range tags {
+			t.Tags = append(t.Tags, greed.Tag{Name: name})
+		}
+
+		if t, err = greed.CategorizeTransaction(db, currentUser(c).Id, t); err != nil {
+			return err
+		}
+
+		if t.Category.Id == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "no rule matches the transaction, pick a category")
+		}
+
+		splits, err := parseSplitsForm(c, account.Currency)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.ValidateSplits(parsedAmount, account.Currency, splits); err != nil {
+			return splitError(err)
+		}
+
+		// the transaction is created with its payee, tags and splits or not at all
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if t.Payee, err = formPayee(c, tx, currentUser(c).Id, t.Description); err != nil {
+			return err
+		}
+
+		transaction, err := greed.CreateTransactionWithRecalc(
+			tx,
+			currentUser(c).Id,
+			t.Account,
+			t.Amount,
+			t.Category,
+			t.CreatedAt,
+			t.Description,
+		)
+		if err != nil {
+			return err
+		}
+
+		tags = tags[:0]
+		for _, tag := range t.Tags {
+			tags = append(tags, tag.Name)
+		}
+
+		if _, err := greed.SetTransactionTags(tx, currentUser(c).Id, transaction.Id, tags); err != nil {
+			return err
+		}
+
+		if _, err := greed.SetTransactionSplits(tx, currentUser(c).Id, transaction.Id, splits); err != nil {
+			return splitError(err)
+		}
+
+		// a typed in payee replaces the one CreateTransaction matched by the description
+		if t.Payee != nil {
+			if err := greed.SetTransactionPayee(tx, currentUser(c).Id, transaction.Id, t.Payee.Id); err != nil {
+				return err
+			}
+		}
 
-		if _, err := greed.CreateTransactionWithRecalc(
-			db,
-			greed.Account{Id: accountId, Name: accountData[1]},
-			parsedAmount,
-			greed.Category{Id: categoryId, Name: categoryData[1]},
-			createdAt,
-			description,
-		); err != nil {
+		if err := tx.Commit(); err != nil {
 			return err
 		}
 
 		return renderTempl(c, views.RefreshAnchor())
 	})
 
-	e.GET("/transactions/:id", func(c echo.Context) error {
+	app.GET("/transactions/:id", func(c echo.Context) error {
 		transactionId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 
 		if err != nil {
@@ -425,71 +614,124 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 			edit = false
 		}
 
-		transaction, err := greed.GetTransactionById(db, transactionId)
+		transaction, err := greed.GetTransactionById(db, currentUser(c).Id, transactionId)
 
 		if err != nil {
 			return err
 		}
 
 		if edit {
-			accounts, err := greed.GetAccounts(db)
+			accounts, err := greed.GetAccounts(db, currentUser(c).Id)
 			if err != nil {
 				return nil
 			}
 
-			categories, err := greed.GetCategories(db)
+			categories, err := greed.GetCategories(db, currentUser(c).Id)
 			if err != nil {
 				return nil
 			}
 
+			// archived categories stay selectable for the transaction already using them
+			keep := []int64{transaction.Category.Id}
+			for _, s := range transaction.Splits {
+				keep = append(keep, s.Category.Id)
+			}
+			categories = greed.ActiveCategories(categories, keep...)
+
 			return renderTempl(c, views.TransactionForm(transaction, accounts, categories, false))
 		}
 		return renderTempl(c, views.Transaction(transaction, templ.Attributes{}))
 	})
 
-	e.GET("/transactions/new", func(c echo.Context) error {
-		accounts, err := greed.GetAccounts(db)
+	app.GET("/transactions/new", func(c echo.Context) error {
+		accounts, err := greed.GetAccounts(db, currentUser(c).Id)
 		if err != nil {
 			return nil
 		}
 
-		categories, err := greed.GetCategories(db)
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
 		if err != nil {
 			return nil
 		}
+		categories = greed.ActiveCategories(categories)
+
+		if len(accounts) == 0 || len(categories) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "create an account first")
+		}
 
+		// the category is left to the rules
 		t := greed.Transaction{
-			Category:  categories[0],
 			Account:   accounts[0],
-			Amount:    big.NewFloat(0.0),
+			Amount:    greed.ZeroMoney(accounts[0].Currency),
 			CreatedAt: time.Now().UTC(),
 		}
 
 		return renderTempl(c, views.TransactionForm(t, accounts, categories, true))
 	})
 
-	e.DELETE("/transactions/:id", func(c echo.Context) error {
+	app.GET("/transactions/suggest", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+		categories = greed.ActiveCategories(categories)
+
+		// a category picked by hand instead of the suggested one stays
+		selectedId, _ := strconv.ParseInt(strings.Split(c.FormValue("category"), ";")[0], 10, 64)
+		suggestedId, _ := strconv.ParseInt(c.FormValue("suggested"), 10, 64)
+		if selectedId != 0 && selectedId != suggestedId {
+			return renderTempl(c, views.TransactionCategorySelect(selectedId, categories, true, nil))
+		}
+
+		// the form is still being typed, invalid fields are left out
+		t := greed.Transaction{Description: c.FormValue("description"), CreatedAt: time.Now().UTC()}
+		if location, err := time.LoadLocation(c.FormValue("tz")); err == nil {
+			inputDateTime := fmt.Sprintf("%s %s", c.FormValue("date"), c.FormValue("time"))
+			if createdAt, err := time.ParseInLocation(greed.DATETIME_INPUT_LAYOUT, inputDateTime, location); err == nil {
+				t.CreatedAt = createdAt
+			}
+		}
+		if accountId, err := strconv.ParseInt(strings.Split(c.FormValue("account"), ";")[0], 10, 64); err == nil {
+			if t.Account, err = greed.GetAccountById(db, currentUser(c).Id, accountId); err != nil {
+				return err
+			}
+			t.Amount, _ = greed.ParseCurrencyMoney(c.FormValue("amount"), t.Account.Currency)
+		}
+
+		suggestions, err := greed.SuggestCategories(db, currentUser(c).Id, t, 1)
+		if err != nil {
+			return err
+		}
+
+		if len(suggestions) == 0 {
+			return renderTempl(c, views.TransactionCategorySelect(0, categories, true, nil))
+		}
+
+		return renderTempl(c, views.TransactionCategorySelect(0, categories, true, &suggestions[0]))
+	})
+
+	app.DELETE("/transactions/:id", func(c echo.Context) error {
 		transactionId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 
 		if err != nil {
 			return err
 		}
 
-		if err = greed.DeleteTransactionWithRecalc(db, transactionId); err != nil {
+		if err = greed.DeleteTransactionWithRecalc(db, currentUser(c).Id, transactionId); err != nil {
 			return err
 		}
 
 		return renderTempl(c, views.RecountAnchor())
 	})
 
-	e.PUT("/transactions/:id", func(c echo.Context) error {
+	app.PUT("/transactions/:id", func(c echo.Context) error {
 		transactionId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 
 		if err != nil {
 			return err
 		}
 
-		transaction, err := greed.GetTransactionById(db, transactionId)
+		transaction, err := greed.GetTransactionById(db, currentUser(c).Id, transactionId)
 
 		formValues, err := c.FormParams()
 
@@ -525,13 +767,6 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 
 		log.Printf("Parsed new time: %v\n", newCreatedAt)
 
-		amount := c.FormValue("amount")
-		parsedAmount, _, err := big.ParseFloat(amount, 10, 53, big.ToNearestEven)
-
-		if err != nil {
-			return err
-		}
-
 		newAccountData := strings.Split(c.FormValue("account"), ";")
 		if len(newAccountData) != 2 {
 			log.Printf("[ERROR] failed to parse account data: %s", c.FormValue("account"))
@@ -549,6 +784,16 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 			return err
 		}
 
+		newAccount, err := greed.GetAccountById(db, currentUser(c).Id, newAccountId)
+		if err != nil {
+			return err
+		}
+
+		parsedAmount, err := greed.ParseCurrencyMoney(c.FormValue("amount"), newAccount.Currency)
+		if err != nil {
+			return err
+		}
+
 		newCategoryId, err := strconv.ParseInt(newCategoryData[0], 10, 64)
 		if err != nil {
 			return err
@@ -556,20 +801,70 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 
 		newDescription := c.FormValue("description")
 
+		newTags, err := greed.ParseTagNames(c.FormValue("tags"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		newSplits, err := parseSplitsForm(c, newAccount.Currency)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.ValidateSplits(parsedAmount, newAccount.Currency, newSplits); err != nil {
+			return splitError(err)
+		}
+
 		transaction.Amount = parsedAmount
 		transaction.Description = newDescription
-		transaction.Account = greed.Account{Id: newAccountId, Name: newAccountData[1]}
+		transaction.Account = newAccount
 		transaction.Category = greed.Category{Id: newCategoryId, Name: newCategoryData[1]}
 		transaction.CreatedAt = newCreatedAt
 
-		if _, err := greed.UpdateTransactionWithRecalc(db, transaction); err != nil {
+		// the transaction is updated with its payee, tags and splits or not at all
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if transaction.Payee, err = formPayee(c, tx, currentUser(c).Id, newDescription); err != nil {
+			return err
+		}
+
+		if _, err := greed.UpdateTransactionWithRecalc(tx, currentUser(c).Id, transaction); err != nil {
+			return err
+		}
+
+		if transaction.Tags, err = greed.SetTransactionTags(tx, currentUser(c).Id, transaction.Id, newTags); err != nil {
+			return err
+		}
+
+		if transaction.Splits, err = greed.SetTransactionSplits(tx, currentUser(c).Id, transaction.Id, newSplits); err != nil {
+			return splitError(err)
+		}
+
+		if err := tx.Commit(); err != nil {
 			return err
 		}
 
 		return renderTempl(c, views.Transaction(transaction, templ.Attributes{}))
 	})
 
-	e.GET("/daterange/input", func(c echo.Context) error {
+	createTransferEndpoints(app, db)
+	createRateEndpoints(app, db)
+	createImportEndpoints(app, db)
+	createBudgetEndpoints(app, db)
+	createRecurringEndpoints(app, db)
+	createTagEndpoints(app, db)
+	createSplitEndpoints(app, db)
+	createCategoryEndpoints(app, db)
+	createReconcileEndpoints(app, db)
+	createRuleEndpoints(app, db)
+	createPayeeEndpoints(app, db)
+	createTrendEndpoints(app, db)
+
+	app.GET("/daterange/input", func(c echo.Context) error {
 		rangeType := greed.DateRangeType(c.QueryParam("date_range_type"))
 
 		if dateRange, err := greed.GetDateRange(rangeType); err != nil {
@@ -583,8 +878,11 @@ func createWebAppEndpoints(e *echo.Echo, db *sql.DB) {
 func BuildWebApp(db *sql.DB) *echo.Echo {
 	e := echo.New()
 	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
 
+	createAuthEndpoints(e, db)
 	createWebAppEndpoints(e, db)
+	createApiEndpoints(e, db)
 
 	return e
 }
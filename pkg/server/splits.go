@@ -0,0 +1,135 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+
+	"github.com/labstack/echo/v4"
+)
+
+// splitError turns validation errors into bad requests for the web forms
+func splitError(err error) error {
+	if errors.Is(err, greed.ErrInvalidSplit) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// parseSplitsForm reads the lines of the SplitsEditor, amounts are in the currency of the transaction account
+func parseSplitsForm(c echo.Context, currency string) ([]greed.Split, error) {
+	formValues, err := c.FormParams()
+	if err != nil {
+		return nil, err
+	}
+
+	categories := formValues["split_category"]
+	amounts := formValues["split_amount"]
+	memos := formValues["split_memo"]
+
+	if len(amounts) != len(categories) || len(memos) != len(categories) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "incomplete split lines")
+	}
+
+	var splits []greed.Split
+
+	for i := range categories {
+		categoryId, err := strconv.ParseInt(categories[i], 10, 64)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("split line %v: invalid category %v", i+1, categories[i]))
+		}
+
+		amount, err := greed.ParseCurrencyMoney(amounts[i], currency)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("split line %v: invalid amount %v", i+1, amounts[i]))
+		}
+
+		splits = append(splits, greed.Split{Category: greed.Category{Id: categoryId}, Amount: amount, Memo: strings.TrimSpace(memos[i])})
+	}
+
+	return splits, nil
+}
+
+// formTransactionAmount reads account and amount of the TransactionForm
+func formTransactionAmount(c echo.Context, db *sql.DB, userId int64) (greed.Account, greed.Money, error) {
+	accountData := strings.Split(c.FormValue("account"), ";")
+
+	accountId, err := strconv.ParseInt(accountData[0], 10, 64)
+	if err != nil {
+		return greed.Account{}, greed.Money{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid account: %v", c.FormValue("account")))
+	}
+
+	account, err := greed.GetAccountById(db, userId, accountId)
+	if err != nil {
+		return account, greed.Money{}, err
+	}
+
+	amount, err := greed.ParseCurrencyMoney(c.FormValue("amount"), account.Currency)
+	if err != nil {
+		return account, amount, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid amount: %v", c.FormValue("amount")))
+	}
+
+	return account, amount, nil
+}
+
+func createSplitEndpoints(app *echo.Group, db *sql.DB) {
+	// a new line takes what the other lines leave of the amount
+	app.GET("/transactions/splits/new", func(c echo.Context) error {
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		split := greed.Split{}
+
+		if categoryId, err := strconv.ParseInt(strings.Split(c.FormValue("category"), ";")[0], 10, 64); err == nil {
+			split.Category.Id = categoryId
+		}
+		categories = greed.ActiveCategories(categories, split.Category.Id)
+
+		if account, amount, err := formTransactionAmount(c, db, currentUser(c).Id); err == nil {
+			split.Amount = greed.ZeroMoney(account.Currency)
+
+			if splits, err := parseSplitsForm(c, account.Currency); err == nil {
+				if remainder, err := greed.SplitsRemainder(amount, splits); err == nil {
+					split.Amount = remainder
+				}
+			}
+		}
+
+		return renderTempl(c, views.SplitLine(split, categories))
+	})
+
+	app.POST("/transactions/splits/remainder", func(c echo.Context) error {
+		// invalid inputs are shown in place of the remainder instead of failing the request
+		showError := func(err error) error {
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				return renderTempl(c, views.SplitRemainder(greed.Money{}, 0, fmt.Sprint(httpErr.Message)))
+			}
+			return err
+		}
+
+		account, amount, err := formTransactionAmount(c, db, currentUser(c).Id)
+		if err != nil {
+			return showError(err)
+		}
+
+		splits, err := parseSplitsForm(c, account.Currency)
+		if err != nil {
+			return showError(err)
+		}
+
+		remainder, err := greed.SplitsRemainder(amount, splits)
+		if err != nil {
+			return renderTempl(c, views.SplitRemainder(greed.Money{}, 0, err.Error()))
+		}
+
+		return renderTempl(c, views.SplitRemainder(remainder, len(splits), ""))
+	})
+}
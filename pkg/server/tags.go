@@ -0,0 +1,57 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+
+	"github.com/labstack/echo/v4"
+)
+
+func createTagEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/stats/tags", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		tagsSpent, err := greed.GetExpensesByTag(db, currentUser(c).Id, dateRange)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.TagsExpenses(tagsSpent))
+	})
+}
+
+func createApiTagEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/tags", func(c echo.Context) error {
+		tags, err := greed.GetTags(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, tags)
+	})
+
+	api.GET("/stats/tags", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		groupedTagsSpent, err := greed.GetExpensesByTag(db, currentUser(c).Id, dateRange)
+		if err != nil {
+			return err
+		}
+
+		// every item carries its currency like /stats/categories
+		tagsSpent := []greed.TagSpent{}
+		for _, pair := range groupedTagsSpent {
+			tagsSpent = append(tagsSpent, pair.Second...)
+		}
+
+		return c.JSON(http.StatusOK, tagsSpent)
+	})
+}
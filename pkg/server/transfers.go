@@ -0,0 +1,418 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// parseFormDateTime reads date, time and tz inputs of the DateTimePicker
+func parseFormDateTime(c echo.Context) (time.Time, error) {
+	location, err := time.LoadLocation(c.FormValue("tz"))
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid tz: %v", c.FormValue("tz")))
+	}
+
+	inputDateTime := fmt.Sprintf("%s %s", c.FormValue("date"), c.FormValue("time"))
+
+	parsed, err := time.ParseInLocation(greed.DATETIME_INPUT_LAYOUT, inputDateTime, location)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid date and time: %v", inputDateTime))
+	}
+
+	return parsed, nil
+}
+
+func parseFormId(c echo.Context, name string) (int64, error) {
+	id, err := strconv.ParseInt(c.FormValue(name), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %v: %v", name, c.FormValue(name)))
+	}
+	return id, nil
+}
+
+// parseTransferForm reads the transfer from the TransferForm inputs,
+// empty received amount means the same amount as sent
+func parseTransferForm(c echo.Context, db *sql.DB, userId int64) (greed.Transfer, error) {
+	var t greed.Transfer
+
+	createdAt, err := parseFormDateTime(c)
+	if err != nil {
+		return t, err
+	}
+
+	var accountIds [2]int64
+	for i, name := range []string{"from_account", "to_account"} {
+		if accountIds[i], err = parseFormId(c, name); err != nil {
+			return t, err
+		}
+	}
+
+	if t.FromAccount, err = greed.GetAccountById(db, userId, accountIds[0]); err != nil {
+		return t, err
+	}
+
+	if t.ToAccount, err = greed.GetAccountById(db, userId, accountIds[1]); err != nil {
+		return t, err
+	}
+
+	categoryId, err := parseFormId(c, "category")
+	if err != nil {
+		return t, err
+	}
+
+	if t.Category, err = greed.GetCategoryById(db, userId, categoryId); err != nil {
+		return t, err
+	}
+
+	if t.FromAmount, err = greed.ParseCurrencyMoney(c.FormValue("from_amount"), t.FromAccount.Currency); err != nil {
+		return t, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	toAmount := c.FormValue("to_amount")
+	if strings.TrimSpace(toAmount) == "" {
+		toAmount = t.FromAmount.String()
+	}
+
+	if t.ToAmount, err = greed.ParseCurrencyMoney(toAmount, t.ToAccount.Currency); err != nil {
+		return t, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	t.CreatedAt = createdAt
+	t.Description = c.FormValue("description")
+
+	return t, nil
+}
+
+func createTransferEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/transfers", func(c echo.Context) error {
+		transfers, err := greed.GetTransfers(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Page(views.TransfersContent(transfers)))
+	})
+
+	app.GET("/transfers/content", func(c echo.Context) error {
+		transfers, err := greed.GetTransfers(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Transfers(transfers))
+	})
+
+	app.GET("/transfers/count", func(c echo.Context) error {
+		count, err := greed.CountTransfers(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		return c.String(http.StatusOK, strconv.FormatInt(count, 10))
+	})
+
+	app.GET("/transfers/new", func(c echo.Context) error {
+		accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		categories, err := greed.GetCategories(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if len(accounts) < 2 || len(categories) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "create at least two accounts first")
+		}
+
+		category, err := greed.GetTransferCategory(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		t := greed.Transfer{
+			FromAccount: accounts[0],
+			ToAccount:   accounts[1],
+			FromAmount:  greed.ZeroMoney(accounts[0].Currency),
+			ToAmount:    greed.ZeroMoney(accounts[1].Currency),
+			Category:    category,
+			CreatedAt:   time.Now().UTC(),
+		}
+
+		return renderTempl(c, views.TransferForm(t, accounts, greed.ActiveCategories(categories, category.Id), true))
+	})
+
+	app.GET("/transfers/:id", func(c echo.Context) error {
+		transferId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		transfer, err := greed.GetTransferById(db, currentUser(c).Id, transferId)
+		if err != nil {
+			return err
+		}
+
+		if c.QueryParam("edit") == "true" {
+			accounts, err := greed.GetAccounts(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			categories, err := greed.GetCategories(db, currentUser(c).Id)
+			if err != nil {
+				return err
+			}
+
+			return renderTempl(c, views.TransferForm(transfer, accounts, greed.ActiveCategories(categories, transfer.Category.Id), false))
+		}
+
+		return renderTempl(c, views.Transfer(transfer))
+	})
+
+	app.POST("/transfers", func(c echo.Context) error {
+		t, err := parseTransferForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := greed.CreateTransfer(db, currentUser(c).Id, t); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RefreshAnchor())
+	})
+
+	app.PUT("/transfers/:id", func(c echo.Context) error {
+		transferId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		t, err := parseTransferForm(c, db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		t.Id = transferId
+
+		transfer, err := greed.UpdateTransfer(db, currentUser(c).Id, t)
+		if err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.Transfer(transfer))
+	})
+
+	app.DELETE("/transfers/:id", func(c echo.Context) error {
+		transferId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteTransfer(db, currentUser(c).Id, transferId); err != nil {
+			return err
+		}
+
+		return renderTempl(c, views.RecountAnchor())
+	})
+}
+
+type TransferPayload struct {
+	FromAccountId int64       `json:"from_account_id"`
+	ToAccountId   int64       `json:"to_account_id"`
+	FromAmount    greed.Money `json:"from_amount"`
+	// optional for accounts in the same currency
+	ToAmount    *greed.Money `json:"to_amount"`
+	CategoryId  int64        `json:"category_id"`
+	CreatedAt   *time.Time   `json:"created_at"`
+	Description string       `json:"description"`
+}
+
+func (p *TransferPayload) ToJson() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *TransferPayload) FromJson(jsonData []byte) error {
+	return json.Unmarshal(jsonData, p)
+}
+
+func payloadAccount(db *sql.DB, userId int64, accountId int64) (greed.Account, error) {
+	account, err := greed.GetAccountById(db, userId, accountId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("account %v doesn't exist", accountId))
+	}
+	return account, err
+}
+
+// toTransfer resolves accounts and category of the payload,
+// the exchange category is used when category_id is missing
+func (p *TransferPayload) toTransfer(db *sql.DB, userId int64) (greed.Transfer, error) {
+	var t greed.Transfer
+	var err error
+
+	if t.FromAccount, err = payloadAccount(db, userId, p.FromAccountId); err != nil {
+		return t, err
+	}
+
+	if t.ToAccount, err = payloadAccount(db, userId, p.ToAccountId); err != nil {
+		return t, err
+	}
+
+	if p.CategoryId == 0 {
+		t.Category, err = greed.GetTransferCategory(db, userId)
+	} else {
+		t.Category, err = greed.GetCategoryById(db, userId, p.CategoryId)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("category %v doesn't exist", p.CategoryId))
+	} else if err != nil {
+		return t, err
+	}
+
+	t.FromAmount = p.FromAmount
+
+	switch {
+	case p.ToAmount != nil:
+		t.ToAmount = *p.ToAmount
+	case t.FromAccount.Currency == t.ToAccount.Currency:
+		t.ToAmount = p.FromAmount
+	default:
+		return t, echo.NewHTTPError(http.StatusBadRequest, "to_amount is required for accounts in different currencies")
+	}
+
+	t.Description = p.Description
+
+	if p.CreatedAt != nil {
+		t.CreatedAt = *p.CreatedAt
+	} else {
+		t.CreatedAt = time.Now().UTC()
+	}
+
+	return t, nil
+}
+
+func createApiTransferEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/transfers", func(c echo.Context) error {
+		transfers, err := greed.GetTransfers(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		if transfers == nil {
+			transfers = []greed.Transfer{}
+		}
+
+		return c.JSON(http.StatusOK, transfers)
+	})
+
+	api.GET("/transfers/:id", func(c echo.Context) error {
+		transferId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		transfer, err := greed.GetTransferById(db, currentUser(c).Id, transferId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, transfer)
+	})
+
+	api.POST("/transfers", func(c echo.Context) error {
+		var payload TransferPayload
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		t, err := payload.toTransfer(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		created, err := greed.CreateTransfer(db, currentUser(c).Id, t)
+		if err != nil {
+			return err
+		}
+
+		// accounts are fetched again to include the updated balances
+		transfer, err := greed.GetTransferById(db, currentUser(c).Id, created.Id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, transfer)
+	})
+
+	api.PUT("/transfers/:id", func(c echo.Context) error {
+		transferId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		old, err := greed.GetTransferById(db, currentUser(c).Id, transferId)
+		if err != nil {
+			return err
+		}
+
+		// fields missing in the payload keep their current values
+		payload := TransferPayload{
+			FromAccountId: old.FromAccount.Id,
+			ToAccountId:   old.ToAccount.Id,
+			FromAmount:    old.FromAmount,
+			CategoryId:    old.Category.Id,
+			CreatedAt:     &old.CreatedAt,
+			Description:   old.Description,
+		}
+		// in the same currency the received amount follows the sent one
+		if old.FromAccount.Currency != old.ToAccount.Currency {
+			payload.ToAmount = &old.ToAmount
+		}
+		if err := bindJson(c, &payload); err != nil {
+			return err
+		}
+
+		t, err := payload.toTransfer(db, currentUser(c).Id)
+		if err != nil {
+			return err
+		}
+
+		t.Id = transferId
+
+		if _, err := greed.UpdateTransfer(db, currentUser(c).Id, t); err != nil {
+			return err
+		}
+
+		transfer, err := greed.GetTransferById(db, currentUser(c).Id, transferId)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, transfer)
+	})
+
+	api.DELETE("/transfers/:id", func(c echo.Context) error {
+		transferId, err := parseIdParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := greed.DeleteTransfer(db, currentUser(c).Id, transferId); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+}
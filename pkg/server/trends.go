@@ -0,0 +1,170 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"supersolik/greed/pkg/greed"
+	"supersolik/greed/pkg/views"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// buckets the api sums into when the range has no start
+const defaultTrendPeriods = 12
+
+// buckets the stats page shows, the last two are compared
+const statsTrendPeriods = 6
+
+// months charted when the date range of the stats page is open
+const chartPeriods = 12
+
+func trendError(err error) error {
+	if errors.Is(err, greed.ErrInvalidTrend) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// chartRange closes the date range picked on the stats page, an open one charts up to now from 12 months back
+func chartRange(dateRange greed.DateRange) greed.DateRange {
+	if dateRange.DateEnd.IsZero() {
+		dateRange.DateEnd = time.Now().UTC()
+	}
+
+	if dateRange.DateStart.IsZero() {
+		dateRange.DateStart = greed.BucketMonth.Last(chartPeriods, dateRange.DateEnd).DateStart
+	}
+
+	return dateRange
+}
+
+// parseTrend reads bucket (month by default), date_start, date_end and periods,
+// a range without an end ends with the current bucket, one without a start covers periods buckets
+func parseTrend(c echo.Context, defaultPeriods int) (greed.TrendBucket, greed.DateRange, error) {
+	bucket := greed.BucketMonth
+	if x := c.QueryParam("bucket"); x != "" {
+		parsed, err := greed.ParseTrendBucket(x)
+		if err != nil {
+			return bucket, greed.DateRange{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		bucket = parsed
+	}
+
+	periods := defaultPeriods
+	if x := c.QueryParam("periods"); x != "" {
+		parsed, err := strconv.Atoi(x)
+		if err != nil || parsed <= 0 || parsed > greed.MaxTrendBuckets {
+			return bucket, greed.DateRange{}, echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Sprintf("invalid periods: %v, expected 1 to %v", x, greed.MaxTrendBuckets),
+			)
+		}
+		periods = parsed
+	}
+
+	dateRange, err := parseDateRange(c)
+	if err != nil {
+		return bucket, dateRange, err
+	}
+
+	if dateRange.DateEnd.IsZero() {
+		dateRange.DateEnd = bucket.Range(time.Now().UTC()).DateEnd
+	}
+
+	if dateRange.DateStart.IsZero() {
+		dateRange.DateStart = bucket.Last(periods, dateRange.DateEnd.Add(-time.Nanosecond)).DateStart
+	}
+
+	return bucket, dateRange, nil
+}
+
+func createTrendEndpoints(app *echo.Group, db *sql.DB) {
+	app.GET("/stats/trends", func(c echo.Context) error {
+		bucket, dateRange, err := parseTrend(c, statsTrendPeriods)
+		if err != nil {
+			return err
+		}
+
+		cashFlowTrend, err := greed.GetCashFlowTrend(db, currentUser(c).Id, bucket, dateRange)
+		if err != nil {
+			return trendError(err)
+		}
+
+		categoriesTrend, err := greed.GetExpensesByCategoryTrend(db, currentUser(c).Id, bucket, dateRange)
+		if err != nil {
+			return trendError(err)
+		}
+
+		return renderTempl(c, views.Trends(bucket, cashFlowTrend, categoriesTrend))
+	})
+
+	app.GET("/stats/balance", func(c echo.Context) error {
+		dateRange, err := parseDateRange(c)
+		if err != nil {
+			return err
+		}
+
+		dateRange = chartRange(dateRange)
+		bucket := greed.ChartBucket(dateRange)
+
+		balanceTrend, err := greed.GetBalanceTrend(db, currentUser(c).Id, bucket, dateRange)
+		if err != nil {
+			return trendError(err)
+		}
+
+		return renderTempl(c, views.BalanceLines(balanceTrend, bucket))
+	})
+}
+
+func createApiTrendEndpoints(api *echo.Group, db *sql.DB) {
+	api.GET("/stats/trends/cashflow", func(c echo.Context) error {
+		bucket, dateRange, err := parseTrend(c, defaultTrendPeriods)
+		if err != nil {
+			return err
+		}
+
+		cashFlowTrend, err := greed.GetCashFlowTrend(db, currentUser(c).Id, bucket, dateRange)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, cashFlowTrend)
+	})
+
+	api.GET("/stats/trends/balance", func(c echo.Context) error {
+		bucket, dateRange, err := parseTrend(c, defaultTrendPeriods)
+		if err != nil {
+			return err
+		}
+
+		balanceTrend, err := greed.GetBalanceTrend(db, currentUser(c).Id, bucket, dateRange)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, balanceTrend)
+	})
+
+	api.GET("/stats/trends/categories", func(c echo.Context) error {
+		bucket, dateRange, err := parseTrend(c, defaultTrendPeriods)
+		if err != nil {
+			return err
+		}
+
+		groupedCategoriesTrend, err := greed.GetExpensesByCategoryTrend(db, currentUser(c).Id, bucket, dateRange)
+		if err != nil {
+			return err
+		}
+
+		// every series carries its currency like /stats/categories
+		categoriesTrend := []greed.CategorySeries{}
+		for _, pair := range groupedCategoriesTrend {
+			categoriesTrend = append(categoriesTrend, pair.Second...)
+		}
+
+		return c.JSON(http.StatusOK, categoriesTrend)
+	})
+}
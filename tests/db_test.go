@@ -2,7 +2,6 @@ package tests
 
 import (
 	"fmt"
-	"math/big"
 	"os"
 	"supersolik/greed/pkg/greed"
 	"time"
@@ -17,7 +16,9 @@ func test_db() {
 		os.Exit(1)
 	}
 
-	accounts, err := db.Accounts()
+	var userId int64 = 1
+
+	accounts, err := greed.GetAccounts(db, userId)
 
 	if err != nil {
 		fmt.Println(err)
@@ -31,7 +32,7 @@ func test_db() {
 
 	fmt.Println("---account non existing---")
 
-	a, err := db.AccountById(200)
+	a, err := greed.GetAccountById(db, userId, 200)
 	if err != nil {
 		fmt.Println(err)
 	} else {
@@ -40,7 +41,7 @@ func test_db() {
 
 	fmt.Println("---create account---")
 
-	a, err = db.CreateAccount("test account", big.NewFloat(420.69), "USD", "")
+	a, err = greed.CreateAccount(db, userId, "test account", greed.NewMoney(42069, 2), time.Now(), "USD", "")
 
 	if err != nil {
 		fmt.Println(err)
@@ -52,12 +53,12 @@ func test_db() {
 
 	a.Currency = "RSD"
 	a.Description = "some new description"
-	a.Amount = a.Amount.SetFloat64(69.420)
+	a.OpeningAmount = greed.NewMoney(6942, 2)
 	a.Name = "BRAND NEW NAME"
 
 	fmt.Printf("%v\n", a)
 
-	rowsUpdated, err := db.UpdateAccount(a)
+	rowsUpdated, err := greed.UpdateAccount(db, userId, a)
 
 	if err != nil {
 		fmt.Println(err)
@@ -67,7 +68,7 @@ func test_db() {
 
 	fmt.Println("---transactions---")
 
-	transactions, err := db.Transactions(greed.TransactionFilterDefault())
+	transactions, err := greed.GetTransactions(db, userId, greed.TransactionFilterDefault())
 
 	if err != nil {
 		fmt.Println(err)
@@ -79,7 +80,7 @@ func test_db() {
 
 	fmt.Println("---categories---")
 
-	categories, err := db.Categories()
+	categories, err := greed.GetCategories(db, userId)
 
 	if err != nil {
 		fmt.Println(err)
@@ -91,7 +92,7 @@ func test_db() {
 
 	fmt.Println("---account 1---")
 
-	a, err = db.AccountById(1)
+	a, err = greed.GetAccountById(db, userId, 1)
 	if err != nil {
 		fmt.Println(err)
 	} else {
@@ -100,7 +101,7 @@ func test_db() {
 
 	fmt.Println("---transaction 1---")
 
-	t, err := db.TransactionById(1)
+	t, err := greed.GetTransactionById(db, userId, 1)
 
 	if err != nil {
 		fmt.Println(err)
@@ -110,7 +111,7 @@ func test_db() {
 
 	fmt.Println("---transaction non existing---")
 
-	t, err = db.TransactionById(200)
+	t, err = greed.GetTransactionById(db, userId, 200)
 	if err != nil {
 		fmt.Println(err)
 	} else {
@@ -119,7 +120,7 @@ func test_db() {
 
 	fmt.Println("---create transaction---")
 
-	t, err = db.CreateTransaction(a, big.NewFloat(123.123), false, &categories[0], time.Now(), "some description")
+	t, err = greed.CreateTransaction(db, userId, a, greed.NewMoney(12312, 2), categories[0], time.Now(), "some description")
 
 	if err != nil {
 		fmt.Println(err)
@@ -129,13 +130,12 @@ func test_db() {
 
 	fmt.Println("---update transaction---")
 
-	t.Amount = t.Amount.SetFloat64(40404.500)
+	t.Amount = greed.NewMoney(-4040450, 2)
 	t.Description = "new value"
-	t.Category = &categories[1]
-	t.IsExpense = true
+	t.Category = categories[1]
 	t.Account = accounts[2]
 
-	rowsUpdated, err = db.UpdateTransaction(t)
+	rowsUpdated, err = greed.UpdateTransaction(db, userId, t)
 
 	if err != nil {
 		fmt.Println(err)